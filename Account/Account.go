@@ -17,15 +17,16 @@ specific language governing permissions and limitations
 under the License.
 */
 
-package main
+package account
 
 import (
-"errors"
 "fmt"
 "strconv"
 "encoding/json"
 "strings"
-"github.com/hyperledger/fabric/core/chaincode/shim"
+"github.com/hyperledger/fabric-chaincode-go/shim"
+pb "github.com/hyperledger/fabric-protos-go/peer"
+"github.com/mukutb/TCM/chaincode"
 )
 
 // ManageAccounts example simple Chaincode implementation
@@ -36,6 +37,7 @@ var AccountIndexStr = "_AccountIndex"				//name for the key/value that will stor
 var SecurityIndexStr = "_SecurityIndex"
 
 type Accounts struct{
+	chaincode.Record
 	AccountID string `json:"accountId"`
 	AccountName string `json:"accountName"`
 	AccountNumber string `json:"accountNumber"`
@@ -47,13 +49,14 @@ type Accounts struct{
 }
 
 type Securities struct{
+	chaincode.Record
 	SecurityId string `json:"securityId"`
 	AccountNumber string `json:"accountNumber"`
 	SecurityName string `json:"securityName"`
 	SecurityQuantity string `json:"securityQuantity"`
 	SecurityType string `json:"securityType"`
 	CollateralForm string `json:"collateralForm"`
-	Totalvalue string `json:"totalvalue"`
+	TotalValue string `json:"totalValue"`
 	ValuePercentage string `json:"valuePercentage"`
 	MTM string `json:"mtm"`
 	EffectivePercentage string `json:"effectivePercentage"`
@@ -61,27 +64,13 @@ type Securities struct{
 	Currency string `json:"currency"`
 }
 // ============================================================================================================================
-// Main - start the chaincode for Account management
-// ============================================================================================================================
-func main() {			
-	err := shim.Start(new(ManageAccounts))
-	if err != nil {
-		fmt.Printf("Error starting Account management chaincode: %s", err)
-	}
-}
-// ============================================================================================================================
-// Init - reset all the things
+// reset - reset all the things, used at instantiation and as the "init" function
 // ============================================================================================================================
-func (t *ManageAccounts) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+func (t *ManageAccounts) reset(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var msg string
 	var err error
 	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting ' ' as an argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "init", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting ' ' as an argument")
 	}
 	// Initialize the chaincode
 	msg = args[0]
@@ -96,75 +85,60 @@ func (t *ManageAccounts) Init(stub shim.ChaincodeStubInterface, function string,
 	if err != nil {
 		return nil, err
 	}
-	tosend := "{ \"message\" : \"ManageAccounts chaincode is deployed successfully.\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	err = chaincode.SendEvent(stub, "init", chaincode.Entities{}, "ManageAccounts chaincode is deployed successfully.", nil)
 	if err != nil {
 		return nil, err
 	} 
 	return nil, nil
 }
 // ============================================================================================================================
-// Run - Our entry Accountint for Invocations - [LEGACY] obc-peer 4/25/2016
-// ============================================================================================================================
-func (t *ManageAccounts) Run(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Println("run is running " + function)
-	return t.Invoke(stub, function, args)
-}
+// Init - called when the chaincode is instantiated or upgraded, an upgrade keeps the records of the ledger
 // ============================================================================================================================
-// Invoke - Our entry Accountint for Invocations
-// ============================================================================================================================
-func (t *ManageAccounts) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Println("invoke is running " + function)
-
-	// Handle different functions
-	if function == "init" {													//initialize the chaincode state, used as reset
-		return t.Init(stub, "init", args)
-	} else if function == "create_account" {											//create a new Account
-		return t.create_Account(stub, args)
-	}else if function == "update_account" {									
-		return t.update_Account(stub, args)
-	}else if function == "add_security" {									
-		return t.add_security(stub, args)
-	}else if function == "remove_securitiesFromAccount" {									
-		return t.remove_securitiesFromAccount(stub, args)
-	}else if function == "update_security" {									
-		return t.update_security(stub, args)
-	}else if function == "delete_security" {									
-		return t.delete_security(stub, args)
-	}
-	fmt.Println("invoke did not find func: " + function)
-	errMsg := "{ \"message\" : \"Received unknown function invocation\", \"code\" : \"503\"}"
-	err := stub.SetEvent("errEvent", []byte(errMsg))
+func (t *ManageAccounts) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	indexAsBytes, err := stub.GetState(AccountIndexStr)
 	if err != nil {
-		return nil, err
-	} 
-	return nil, nil			//error
+		return chaincode.Respond(nil, err)
+	}
+	if indexAsBytes != nil {
+		return shim.Success(nil)
+	}
+	return chaincode.Respond(t.reset(stub, args))
 }
 // ============================================================================================================================
-// Query - Our entry Accountint for Queries
-// ============================================================================================================================
-func (t *ManageAccounts) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Println("query is running " + function)
-
-	// Handle different functions
-	if function == "getAccount_byName" {													//Read a Account by name
-		return t.getAccount_byName(stub, args)
-	} else if function == "getAccount_byType" {													//Read a Account by Type
-		return t.getAccount_byType(stub, args)
-	} else if function == "getAccount_byNumber" {													//Read a Account by Number
-		return t.getAccount_byNumber(stub, args)
-	} else if function == "get_AllAccount" {													//Read all Accounts
-		return t.get_AllAccount(stub, args)
-	}else if function == "getSecurities_byAccount" {									//update a Account
-		return t.getSecurities_byAccount(stub, args)
-	}
-	fmt.Println("query did not find func: " + function)						//errors
-	errMsg := "{ \"message\" : \"Received unknown function query\", \"code\" : \"503\"}"
-	err := stub.SetEvent("errEvent", []byte(errMsg))
-	if err != nil {
-		return nil, err
-	} 
-	return nil, nil
+// Invoke - Our entry point for Invocations and Queries, functions are routed by name
+// ============================================================================================================================
+func (t *ManageAccounts) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return chaincode.Route(stub, payloadArgs, map[string]chaincode.Function{
+		"init": t.reset, //initialize the chaincode state, used as reset
+		"create_account": t.create_Account, //create a new Account
+		"update_account": t.update_Account,
+		"add_security": t.add_security,
+		"remove_securitiesFromAccount": t.remove_securitiesFromAccount,
+		"update_security": t.update_security,
+		"delete_security": t.delete_security,
+		"deposit_cash": t.deposit_cash, //add cash in a currency to an Account
+		"withdraw_cash": t.withdraw_cash, //take cash in a currency out of an Account
+		"apply_corporate_action": t.apply_corporate_action, //apply a corporate action to a held Security
+		"credit_security": t.credit_security, //add a settled quantity of a Security to an Account
+		"migrate_records": t.migrate_records, //upgrade stored records to the current schema version
+		"reconcile_accounts": t.reconcile_accounts, //compare accounts with their position records, optionally repair them
+		"bulk_load": t.bulk_load, //create accounts and add holdings of a chunk of onboarding rows
+		"reserve_securities": t.reserve_securities, //hold quantities of positions back for the allocation of a transaction
+		"reserve_allocations": t.reserve_allocations, //reserve collateral for the allocations of several transactions at once
+		"commit_reservations": t.commit_reservations, //the allocation of a transaction moved what it reserved
+		"release_reservations": t.release_reservations, //the allocation of a transaction no longer needs what it reserved
+		"expire_reservations": t.expire_reservations, //close the reservations that expired
+		"getAccount_byName": t.getAccount_byName, //Read a Account by name
+		"getAccount_byType": t.getAccount_byType, //Read a Account by Type
+		"getAccount_byNumber": t.getAccount_byNumber, //Read a Account by Number
+		"get_AllAccount": t.get_AllAccount, //Read all Accounts
+		"getSecurities_byAccount": t.getSecurities_byAccount, //update a Account
+		"getCashBalances_byAccount": t.getCashBalances_byAccount, //Read cash balances of an Account
+		"getAvailability_byAccount": t.getAvailability_byAccount, //Read reserved and available quantity of the positions of an Account
+		"getReservation_byTransactionID": t.getReservation_byTransactionID, //Read what a transaction reserved on an Account
+		"getResult_byID": chaincode.GetResult, //Read the result an invocation kept under the id its client chose
+	})
 }
 // ============================================================================================================================
 //  getAccount_byName- get details of all Account from chaincode state
@@ -175,12 +149,7 @@ func (t *ManageAccounts) getAccount_byName(stub shim.ChaincodeStubInterface, arg
 	fmt.Println("start getAccount_byName")
 	var err error
 	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 1\" \" as an argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "getAccount_byName", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 1")
 	}
 
 	_AccountName := args[0]
@@ -188,7 +157,7 @@ func (t *ManageAccounts) getAccount_byName(stub shim.ChaincodeStubInterface, arg
 
 	AccountAsBytes, err := stub.GetState(AccountIndexStr)
 	if err != nil {
-		return nil, errors.New("Failed to get Account index")
+		return nil, chaincode.SendError(stub, "getAccount_byName", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get Account index")
 	}
 	json.Unmarshal(AccountAsBytes, &AccountIndex)								//un stringify it aka JSON.parse()
 	jsonResp = "{"
@@ -197,7 +166,7 @@ func (t *ManageAccounts) getAccount_byName(stub shim.ChaincodeStubInterface, arg
 		valueAsBytes, err := stub.GetState(val)
 		if err != nil {
 			errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-			return nil, errors.New(errResp)
+			return nil, chaincode.SendError(stub, "getAccount_byName", chaincode.ErrUpstream, chaincode.Entities{}, errResp)
 		}
 		json.Unmarshal(valueAsBytes, &_tempJson)
 		fmt.Print("valueAsBytes : ")
@@ -215,12 +184,7 @@ func (t *ManageAccounts) getAccount_byName(stub shim.ChaincodeStubInterface, arg
 	fmt.Println("jsonResp : " + jsonResp)
 	if jsonResp == "{}" {
         fmt.Println("Account not found for  " + _AccountName)
-        jsonResp =  "{\"AccountName\" : \"" + _AccountName + "\", \"message\" : \"Account not found.\", \"code\" : \"503\"}"
-        errMsg:= "{ \"AccountName\" : \"" + _AccountName + "\", \"message\" : \"Account not found.\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-        	return nil, err
-        }
+        return nil, chaincode.SendError(stub, "getAccount_byName", chaincode.ErrNotFound, chaincode.Entities{}, "Account " + _AccountName + " not found.")
     }
     if strings.Contains(jsonResp,"},}"){
     	jsonResp = strings.Replace(jsonResp, "},}", "}}", -1)
@@ -238,12 +202,7 @@ func (t *ManageAccounts) getAccount_byType(stub shim.ChaincodeStubInterface, arg
 	fmt.Println("start getAccount_byType")
 	var err error
 	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 1\" \" as an argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "getAccount_byType", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 1")
 	}
 
 	_AccountType := args[0]
@@ -251,7 +210,7 @@ func (t *ManageAccounts) getAccount_byType(stub shim.ChaincodeStubInterface, arg
 
 	AccountAsBytes, err := stub.GetState(AccountIndexStr)
 	if err != nil {
-		return nil, errors.New("Failed to get Account index")
+		return nil, chaincode.SendError(stub, "getAccount_byType", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get Account index")
 	}
 	fmt.Print("AccountAsBytes : ")
 	fmt.Println(AccountAsBytes)
@@ -266,7 +225,7 @@ func (t *ManageAccounts) getAccount_byType(stub shim.ChaincodeStubInterface, arg
 		valueAsBytes, err := stub.GetState(val)
 		if err != nil {
 			errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-			return nil, errors.New(errResp)
+			return nil, chaincode.SendError(stub, "getAccount_byType", chaincode.ErrUpstream, chaincode.Entities{}, errResp)
 		}
 		json.Unmarshal(valueAsBytes, &_tempJson)
 		fmt.Print("valueAsBytes : ")
@@ -281,12 +240,7 @@ func (t *ManageAccounts) getAccount_byType(stub shim.ChaincodeStubInterface, arg
 	jsonResp = jsonResp + "}"
 	if jsonResp == "{}" {
         fmt.Println(_AccountType + " account not found")
-        jsonResp = "{ \"AccountType\" : \"" + _AccountType + "\", \"message\" : \"Account not found.\", \"code\" : \"503\"}"
-        errMsg:= "{ \"AccountType\" : \"" + _AccountType + "\", \"message\" : \"Account Not Found.\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-    	    return nil, err
-        }
+        return nil, chaincode.SendError(stub, "getAccount_byType", chaincode.ErrNotFound, chaincode.Entities{}, _AccountType + " account not found.")
     }
     if strings.Contains(jsonResp,"},}"){
     	jsonResp = strings.Replace(jsonResp, "},}", "}}", -1)
@@ -304,12 +258,7 @@ func (t *ManageAccounts) getAccount_byNumber(stub shim.ChaincodeStubInterface, a
 	fmt.Println("start getAccount_byNumber")
 	var err error
 	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 1\" \" as an argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "getAccount_byNumber", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 1")
 	}
 
 	_AccountNumber := args[0]
@@ -320,7 +269,7 @@ func (t *ManageAccounts) getAccount_byNumber(stub shim.ChaincodeStubInterface, a
 	valueAsBytes, err := stub.GetState(_AccountNumber)
 	if err != nil {
 		errResp = "{\"Error\":\"Failed to get state for " + _AccountNumber + "\"}"
-		return nil, errors.New(errResp)
+		return nil, chaincode.SendError(stub, "getAccount_byNumber", chaincode.ErrUpstream, chaincode.Entities{}, errResp)
 	}
 	json.Unmarshal(valueAsBytes, &_tempJson)
 	fmt.Print("valueAsBytes : ")
@@ -329,12 +278,7 @@ func (t *ManageAccounts) getAccount_byNumber(stub shim.ChaincodeStubInterface, a
 		jsonResp = jsonResp + "\""+ _AccountNumber + "\":" + string(valueAsBytes[:])
 	}else{
         fmt.Println(_AccountNumber + " not found")
-        jsonResp = jsonResp + "\"AccountNumber\" : \"" + _AccountNumber + "\",\"message\" : \"" + "Account not found.\", \"code\" : \"503\""
-        errMsg:= "{ \"AccountNumber\" : \"" + _AccountNumber + "\",\"message\" : \"" + "Account Not Found.\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
+        return nil, chaincode.SendError(stub, "getAccount_byNumber", chaincode.ErrNotFound, chaincode.Entities{AccountNumber: _AccountNumber}, "Account Not Found.")
     }
 	jsonResp = jsonResp + "}"
 	fmt.Println("jsonResp : " + jsonResp)
@@ -352,17 +296,12 @@ func (t *ManageAccounts) get_AllAccount(stub shim.ChaincodeStubInterface, args [
 	var AccountIndex []string
 	fmt.Println("start get_AllAccount")
 	var err error
-	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 1\" \" as an argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+	if len(args) > 1 {
+		return nil, chaincode.SendError(stub, "get_AllAccount", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting at most 1")
 	}
 	AccountAsBytes, err := stub.GetState(AccountIndexStr)
 	if err != nil {
-		return nil, errors.New("Failed to get Account index")
+		return nil, chaincode.SendError(stub, "get_AllAccount", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get Account index")
 	}
 	fmt.Print("AccountAsBytes : ")
 	fmt.Println(AccountAsBytes)
@@ -375,7 +314,7 @@ func (t *ManageAccounts) get_AllAccount(stub shim.ChaincodeStubInterface, args [
 		valueAsBytes, err := stub.GetState(val)
 		if err != nil {
 			errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-			return nil, errors.New(errResp)
+			return nil, chaincode.SendError(stub, "get_AllAccount", chaincode.ErrUpstream, chaincode.Entities{}, errResp)
 		}
 		fmt.Print("valueAsBytes : ")
 		fmt.Println(valueAsBytes)
@@ -401,23 +340,16 @@ func (t *ManageAccounts) update_Account(stub shim.ChaincodeStubInterface, args [
 	var err error
 	fmt.Println("Updating Account")
 	if len(args) != 8 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 8\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "update_Account", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 8")
+	}
+	if err = validateAccount(args); err != nil {
+		return nil, chaincode.SendInvalid(stub, "update_Account", chaincode.Entities{AccountNumber: args[2]}, err)
 	}
 	// set accountNumber
 	accountNumber := args[2]
 	AccountAsBytes, err := stub.GetState(accountNumber)									//get the Account for the specified AccountId from chaincode state
 	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to get state for " + accountNumber + "\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "update_Account", chaincode.ErrUpstream, chaincode.Entities{AccountNumber: accountNumber}, "Failed to get state for " + accountNumber)
 	}
 	fmt.Print("AccountAsBytes in update Account")
 	fmt.Println(AccountAsBytes);
@@ -436,32 +368,15 @@ func (t *ManageAccounts) update_Account(stub shim.ChaincodeStubInterface, args [
 		res.Securities				=args[7]
 
 	}else{
-		errMsg := "{ \"message\" : \""+ accountNumber+ " Not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "update_Account", chaincode.ErrNotFound, chaincode.Entities{AccountNumber: accountNumber}, accountNumber + " Not Found.")
 	}
 	
-	//build the Account json string manually
-	order := 	`{`+
-		`"accountId": "` + res.AccountID + `" ,`+
-		`"accountName": "` + res.AccountName + `" ,`+
-		`"accountNumber": "` + res.AccountNumber + `" ,`+
-		`"accountType": "` + res.AccountType + `" ,`+
-		`"totalValue": "` + res.TotalValue + `" ,`+
-		`"currency": "` + res.Currency + `" ,`+
-		`"pledger": "` + res.Pledger + `" ,`+
-		`"securities": "` + res.Securities + `" `+
-		`}`
-	err = stub.PutState(res.AccountNumber, []byte(order))									//store Account with id as key
+	err = chaincode.PutRecord(stub, res.AccountNumber, &res)									//store Account with id as key
 	if err != nil {
 		return nil, err
 	}
 
-	tosend := "{ \"AccountNumber\" : \"" + accountNumber + "\", \"message\" : \"Account updated succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	err = chaincode.SendEvent(stub, "update_Account", chaincode.Entities{AccountNumber: accountNumber}, "Account updated succcessfully", nil)
 	if err != nil {
 		return nil, err
 	} 
@@ -475,14 +390,12 @@ func (t *ManageAccounts) update_Account(stub shim.ChaincodeStubInterface, args [
 func (t *ManageAccounts) create_Account(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var err error
 	if len(args) != 8 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 8\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "create_Account", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 8")
 	}
 	fmt.Println("start create_Account")
+	if err = validateAccount(args); err != nil {
+		return nil, chaincode.SendInvalid(stub, "create_Account", chaincode.Entities{AccountNumber: args[2]}, err)
+	}
 
 	accountId				:=args[0]
 	accountName				:=args[1] 
@@ -495,7 +408,7 @@ func (t *ManageAccounts) create_Account(stub shim.ChaincodeStubInterface, args [
 	
 	AccountAsBytes, err := stub.GetState(accountNumber)
 	if err != nil {
-		return nil, errors.New("Failed to get Account " + accountNumber)
+		return nil, chaincode.SendError(stub, "create_Account", chaincode.ErrUpstream, chaincode.Entities{AccountNumber: accountNumber}, "Failed to get Account " + accountNumber)
 	}
 	fmt.Print("AccountAsBytes: ")
 	fmt.Println(AccountAsBytes)
@@ -506,34 +419,27 @@ func (t *ManageAccounts) create_Account(stub shim.ChaincodeStubInterface, args [
 	if res.AccountNumber == accountNumber{
 		fmt.Println("This Account already exists: " + accountNumber)
 		fmt.Println(res);
-		errMsg := "{ \"AccountNumber\" : \""+accountNumber+"\", \"message\" : \"This account already exists\", \"code\" : \"503\"}"
-		err := stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil				//all stop a Account by this name exists
+		return nil, chaincode.SendError(stub, "create_Account", chaincode.ErrConflict, chaincode.Entities{AccountNumber: accountNumber}, "This account already exists")
 	}
 	
-	//build the Account json string manually
-	order := 	`{`+
-		`"accountId": "` + accountId + `" ,`+
-		`"accountName": "` + accountName + `" ,`+
-		`"accountNumber": "` + accountNumber + `" ,`+
-		`"accountType": "` + accountType + `" ,`+
-		`"totalValue": "` + totalValue + `" ,`+
-		`"currency": "` + currency + `" ,`+
-		`"pledger": "` + pledger + `" ,`+
-		`"securities": "` + securities + `" `+
-		`}`
-	fmt.Println("order: " + order)
-	err = stub.PutState(accountNumber, []byte(order))									//store Account with AccountId as key
+	res = Accounts{
+		AccountID:     accountId,
+		AccountName:   accountName,
+		AccountNumber: accountNumber,
+		AccountType:   accountType,
+		TotalValue:    totalValue,
+		Currency:      currency,
+		Pledger:       pledger,
+		Securities:    securities,
+	}
+	err = chaincode.PutRecord(stub, accountNumber, &res)									//store Account with AccountId as key
 	if err != nil {
 		return nil, err
 	}
 	//get the Account index
 	AccountIndexAsBytes, err := stub.GetState(AccountIndexStr)
 	if err != nil {
-		return nil, errors.New("Failed to get Account index")
+		return nil, chaincode.SendError(stub, "create_Account", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get Account index")
 	}
 	var AccountIndex []string
 	fmt.Print("AccountIndexAsBytes: ")
@@ -553,8 +459,7 @@ func (t *ManageAccounts) create_Account(stub shim.ChaincodeStubInterface, args [
 		return nil, err
 	}
 
-	tosend := "{ \"AccountNumber\" : \""+accountNumber+"\", \"message\" : \"Account created succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	err = chaincode.SendEvent(stub, "create_Account", chaincode.Entities{AccountNumber: accountNumber}, "Account created succcessfully", nil)
 	if err != nil {
 		return nil, err
 	} 
@@ -568,14 +473,12 @@ func (t *ManageAccounts) create_Account(stub shim.ChaincodeStubInterface, args [
 func (t *ManageAccounts) add_security(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var err error
 	if len(args) !=  12{
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 12\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "add_security", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 12")
 	}
 	fmt.Println("start add_security")
+	if err = validateSecurity(args); err != nil {
+		return nil, chaincode.SendInvalid(stub, "add_security", chaincode.Entities{AccountNumber: args[1], SecurityID: args[0]}, err)
+	}
 	
 	_securityId				:= args[0]
 	_accountNumber 			:= args[1]
@@ -593,49 +496,45 @@ func (t *ManageAccounts) add_security(stub shim.ChaincodeStubInterface, args []s
 
 	SecurityAsBytes, err := stub.GetState(_accountNumber+"-"+_securityId)
 		if err != nil {
-			return nil, errors.New("Failed to get Security " + _accountNumber+"-"+_securityId)
+			return nil, chaincode.SendError(stub, "add_security", chaincode.ErrUpstream, chaincode.Entities{AccountNumber: _accountNumber}, "Failed to get Security " + _accountNumber+"-"+_securityId)
 		}
 	res := Securities{}
 	json.Unmarshal(SecurityAsBytes, &res)
+	// A position added again replaces the one held, its value is no longer part of the account
+	replacedValue, _ := strconv.ParseFloat(res.TotalValue, 64)
 
 	// NOTE:: This is not required as Securities can be added, hence remove check for already existing
 	/*if res.SecurityId == _securityId{
-		errMsg := "{ \"SecurityId\" : \""+_securityId+"\",\"message\" : \"This Security already exists\", \"code\" : \"503\"}"
-		err := stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil				//all stop a Account by this name exists
+		return nil, chaincode.SendError(stub, "add_security", chaincode.ErrConflict, chaincode.Entities{SecurityID: _securityId}, "This Security already exists")
 	}*/
 	
-	//build the Account json string manually
-	order := 	`{`+
-		`"securityId": "` + _securityId + `" ,`+
-		`"accountNumber": "` + _accountNumber + `" ,`+
-		`"securityName": "` + _securityName + `" ,`+
-		`"securityQuantity": "` + _securityQuantity + `" ,`+
-		`"securityType": "` + _securityType + `" ,`+
-		`"collateralForm": "` + _collateralForm + `" ,`+
-		`"totalvalue": "` + _totalValue + `" ,`+
-		`"valuePercentage": "` + _valuePercentage + `" ,`+
-		`"mtm": "` + _mtm + `" ,`+
-		`"effectivePercentage": "` + _effectivePercentage + `" ,`+
-		`"effectiveValueinUSD": "` + _effectiveValueinUSD + `" ,`+
-		`"currency": "` + _currency + `"`+
-		`}`
-	fmt.Println("order: " + order)
-	err = stub.PutState(_accountNumber+"-"+_securityId, []byte(order))									//store Account with AccountId as key
+	res = Securities{
+		SecurityId:          _securityId,
+		AccountNumber:       _accountNumber,
+		SecurityName:        _securityName,
+		SecurityQuantity:    _securityQuantity,
+		SecurityType:        _securityType,
+		CollateralForm:      _collateralForm,
+		TotalValue:          _totalValue,
+		ValuePercentage:     _valuePercentage,
+		MTM:                 _mtm,
+		EffectivePercentage: _effectivePercentage,
+		EffectiveValueinUSD: _effectiveValueinUSD,
+		Currency:            _currency,
+	}
+	err = chaincode.PutRecord(stub, _accountNumber+"-"+_securityId, &res)									//store Account with AccountId as key
 	if err != nil {
 		return nil, err
 	}
 	AccountAsBytes, err := stub.GetState(_accountNumber)
 	if err != nil {
-		return nil, errors.New("Failed to get account " + _accountNumber)
+		return nil, chaincode.SendError(stub, "add_security", chaincode.ErrUpstream, chaincode.Entities{AccountNumber: _accountNumber}, "Failed to get account " + _accountNumber)
 	}
 	//Adding Security to the account
 	res2 := Accounts{}
 	json.Unmarshal(AccountAsBytes, &res2)
 	fmt.Println(res2);
+	listed := false
 	if res2.AccountNumber == _accountNumber{
 		fmt.Println("Account found with AccountNumber : " + _accountNumber)
 		_SecuritySplit := strings.Split(res2.Securities, ",")
@@ -643,35 +542,28 @@ func (t *ManageAccounts) add_security(stub shim.ChaincodeStubInterface, args []s
 		fmt.Println(_SecuritySplit)
 		for i := range _SecuritySplit{
 			fmt.Println("_SecuritySplit[i]: " + _SecuritySplit[i])
-			fmt.Println("Security already exists")
-			/*if _SecuritySplit[i] == _accountNumber+"-"+_securityId {
-				errMsg := "{ \"SecurityId\" : \""+_accountNumber+"-"+_securityId+"\",\"message\" : \" SecurityId already exists in the account.\", \"code\" : \"503\"}"
-				err = stub.SetEvent("errEvent", []byte(errMsg))
-				if err != nil {
-					return nil, err
-				} 
-				return nil, nil
-			}*/
+			if _SecuritySplit[i] == _accountNumber+"-"+_securityId {
+				fmt.Println("Security already exists")
+				listed = true
+			}
 		}
 	}else{
-		errMsg := "{ \"message\" : \""+ _accountNumber+ " Not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "add_security", chaincode.ErrNotFound, chaincode.Entities{AccountNumber: _accountNumber}, _accountNumber + " Not Found.")
 	}
 	// Convert account's totalValue(String) to float
-	tempTotalValue1, errBool := strconv.ParseFloat(res2.TotalValue, 32)
+	tempTotalValue1, errBool := strconv.ParseFloat(res2.TotalValue, 64)
 	if errBool != nil {
 		fmt.Println(errBool)
 	}
 	// Convert security's totalvalue(String) to float
-	tempTotalvalue2, errBool := strconv.ParseFloat(_totalValue, 32)
+	tempTotalvalue2, errBool := strconv.ParseFloat(_totalValue, 64)
 	if errBool != nil {
 		fmt.Println(errBool)
 	}
-	if res2.Securities == " " || res2.Securities == "" {
+	if listed {
+		_tempTotal := tempTotalValue1 - replacedValue + tempTotalvalue2
+		res2.TotalValue = strconv.FormatFloat(_tempTotal, 'f', -1, 64)
+	}else if res2.Securities == " " || res2.Securities == "" {
 		res2.Securities = _accountNumber+"-"+_securityId;
 		_tempTotal := tempTotalValue1 + tempTotalvalue2
 		res2.TotalValue = strconv.FormatFloat(_tempTotal, 'f', -1, 64)
@@ -680,23 +572,11 @@ func (t *ManageAccounts) add_security(stub shim.ChaincodeStubInterface, args []s
 		_tempTotal := tempTotalValue1 + tempTotalvalue2
 		res2.TotalValue = strconv.FormatFloat(_tempTotal, 'f', -1, 64)
 	}
-	order2 := 	`{`+
-		`"accountId": "` + res2.AccountID + `" ,`+
-		`"accountName": "` + res2.AccountName + `" ,`+
-		`"accountNumber": "` + res2.AccountNumber + `" ,`+
-		`"accountType": "` + res2.AccountType + `" ,`+
-		`"totalValue": "` + res2.TotalValue + `" ,`+
-		`"currency": "` + res2.Currency + `" ,`+
-		`"pledger": "` + res2.Pledger + `" ,`+
-		`"securities": "` + res2.Securities + `" `+
-		`}`
-	fmt.Println("order2: " + order2)
-	err = stub.PutState(res2.AccountNumber, []byte(order2))									//store Account with id as key
+	err = chaincode.PutRecord(stub, res2.AccountNumber, &res2)									//store Account with id as key
 	if err != nil {
 		return nil, err
 	}
-	tosend := "{ \"SecurityId\" : \""+_accountNumber+"-"+_securityId+"\", \"message\" : \"Security updated succcesfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	err = chaincode.SendEvent(stub, "add_security", chaincode.Entities{AccountNumber: _accountNumber, SecurityID: _securityId}, "Security updated succcesfully", nil)
 	if err != nil {
 		return nil, err
 	} 
@@ -710,12 +590,7 @@ func (t *ManageAccounts) add_security(stub shim.ChaincodeStubInterface, args []s
 func (t *ManageAccounts) remove_securitiesFromAccount(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var err error
 	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 1\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "remove_securitiesFromAccount", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 1")
 	}
 	fmt.Println("start remove_securitiesFromAccount")
 
@@ -723,7 +598,7 @@ func (t *ManageAccounts) remove_securitiesFromAccount(stub shim.ChaincodeStubInt
 		
 	AccountAsBytes, err := stub.GetState(_accountNumber)
 	if err != nil {
-		return nil, errors.New("Failed to get Account " + _accountNumber)
+		return nil, chaincode.SendError(stub, "remove_securitiesFromAccount", chaincode.ErrUpstream, chaincode.Entities{AccountNumber: _accountNumber}, "Failed to get Account " + _accountNumber)
 	}
 	res := Accounts{}
 	res_Security := Securities{}
@@ -738,28 +613,23 @@ func (t *ManageAccounts) remove_securitiesFromAccount(stub shim.ChaincodeStubInt
 		//Get the total value of the securities
 		SecuritiesAsBytes, err := stub.GetState(_SecuritySplit[i])
 		if err != nil {
-			return nil, errors.New("Failed to get Security " + _SecuritySplit[i])
+			return nil, chaincode.SendError(stub, "remove_securitiesFromAccount", chaincode.ErrUpstream, chaincode.Entities{SecurityID: _SecuritySplit[i]}, "Failed to get Security " + _SecuritySplit[i])
 		}
 		json.Unmarshal(SecuritiesAsBytes, &res_Security)
-		valToBeRemoved, _ := strconv.ParseFloat(res_Security.Totalvalue, 64)
+		valToBeRemoved, _ := strconv.ParseFloat(res_Security.TotalValue, 64)
 		totalValueOfTheDeletedSecurities = totalValueOfTheDeletedSecurities - valToBeRemoved
 
 		//Got the info. now delete
 		err = stub.DelState(_SecuritySplit[i])													//remove the key from chaincode state
 		if err != nil {
-			errMsg := "{ \"security\" : \"" + _SecuritySplit[i] + "\", \"message\" : \"Failed to delete state\", \"code\" : \"503\"}"
-			err = stub.SetEvent("errEvent", []byte(errMsg))
-			if err != nil {
-				return nil, err
-			} 
-			return nil, nil
+			return nil, chaincode.SendError(stub, "remove_securitiesFromAccount", chaincode.ErrUpstream, chaincode.Entities{SecurityID: _SecuritySplit[i]}, "Failed to delete state")
 		}
 		_SecuritySplit = append(_SecuritySplit[:i], _SecuritySplit[i+1:]...)			//remove it
 		//fmt.Println(_SecuritySplit[:i])
 		//fmt.Println(_SecuritySplit[i+1])
 		fmt.Println(_SecuritySplit)
 		for x:= range _SecuritySplit{											//debug prints...
-			fmt.Println(string(x) + " - " + _SecuritySplit[x])
+			fmt.Println(strconv.Itoa(x) + " - " + _SecuritySplit[x])
 		}
 	}
 
@@ -769,25 +639,13 @@ func (t *ManageAccounts) remove_securitiesFromAccount(stub shim.ChaincodeStubInt
 	fmt.Println("totalValueOfTheDeletedSecurities::")
 	fmt.Println(totalValueOfTheDeletedSecurities)
 	
-	//build the Account json string manually
-	account_json := 	`{`+
-		`"accountId": "` + res.AccountID + `" ,`+
-		`"accountName": "` + res.AccountName + `" ,`+
-		`"accountNumber": "` + res.AccountNumber + `" ,`+
-		`"accountType": "` + res.AccountType + `" ,`+
-		`"totalValue": "` + strconv.FormatFloat(totalValueOfTheDeletedSecurities, 'f', 2, 64) + `" ,`+
-		`"currency": "` + res.Currency + `" ,`+
-		`"pledger": "` + res.Pledger + `" ,`+
-		`"securities": "`+ res.Securities +`" `+
-		`}`
-	fmt.Println("account_json: " + account_json)
-	err = stub.PutState(_accountNumber, []byte(account_json))									//store Account with _accountNumber as key
+	res.TotalValue = strconv.FormatFloat(totalValueOfTheDeletedSecurities, 'f', 2, 64)
+	err = chaincode.PutRecord(stub, _accountNumber, &res)									//store Account with _accountNumber as key
 	if err != nil {
 		return nil, err
 	}
 
-	tosend := "{ \"AccountNumber\" : \""+_accountNumber+"\", \"message\" : \"All securities deleted succcesfully!\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	err = chaincode.SendEvent(stub, "remove_securitiesFromAccount", chaincode.Entities{AccountNumber: _accountNumber}, "All securities deleted succcesfully!", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -802,12 +660,7 @@ func (t *ManageAccounts) getSecurities_byAccount(stub shim.ChaincodeStubInterfac
 	fmt.Println("start getSecurities_byAccount")
 	var err error
 	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting \"AccountNumber\" as an argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "getSecurities_byAccount", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'AccountNumber' as an argument")
 	}
 
 	_AccountNumber := args[0]
@@ -816,36 +669,43 @@ func (t *ManageAccounts) getSecurities_byAccount(stub shim.ChaincodeStubInterfac
 	var res = Accounts{}
 	AccountAsBytes, err := stub.GetState(_AccountNumber)
 	if err != nil {
-		return nil, errors.New("Failed to get Account index")
+		return nil, chaincode.SendError(stub, "getSecurities_byAccount", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get Account index")
 	}
 	json.Unmarshal(AccountAsBytes, &res)
 	fmt.Print("account details: ");
 	fmt.Println(res)
+	if res.AccountNumber != _AccountNumber {
+		return nil, chaincode.SendError(stub, "getSecurities_byAccount", chaincode.ErrNotFound, chaincode.Entities{AccountNumber: _AccountNumber}, "Account Not Found.")
+	}
 	_SecuritySplit := strings.Split(res.Securities, ",")
 	fmt.Print("_SecuritySplit: " )
 	fmt.Println(_SecuritySplit)
+	// An account without securities answers with an empty list
 	jsonResp = "["
 	for i := range _SecuritySplit{
 		fmt.Println("_SecuritySplit[i]: " + _SecuritySplit[i])
+		if strings.TrimSpace(_SecuritySplit[i]) == "" {
+			continue
+		}
 		valueAsBytes, err := stub.GetState(_SecuritySplit[i])
 		if err != nil {
 			errResp = "{\"Error\":\"Failed to get state for " + _SecuritySplit[i] + "\"}"
-			return nil, errors.New(errResp)
+			return nil, chaincode.SendError(stub, "getSecurities_byAccount", chaincode.ErrUpstream, chaincode.Entities{}, errResp)
+		}
+		if valueAsBytes == nil {
+			continue
 		}
 		json.Unmarshal(valueAsBytes, &_tempJson)
 		fmt.Print("_tempJson : ")
 		fmt.Println(_tempJson)
-		jsonResp = jsonResp + string(valueAsBytes[:])
-		if i < len(_SecuritySplit)-1 {
+		if jsonResp != "[" {
 			jsonResp = jsonResp + ","
 		}
+		jsonResp = jsonResp + string(valueAsBytes[:])
 	}
 	jsonResp = jsonResp + "]"
 	fmt.Print("jsonResp: ")
 	fmt.Println(jsonResp)
-	if jsonResp == "[\"\":]" || jsonResp == "[\" \":]" {
-		jsonResp = "{ \"AccountNumber\" : \"" + _AccountNumber + "\", \"message\" : \"No securities found.\", \"code\" : \"503\"}"
-	}
 	fmt.Println("end getSecurities_byAccount")
 	return []byte(jsonResp), nil
 }
@@ -856,24 +716,17 @@ func (t *ManageAccounts) update_security(stub shim.ChaincodeStubInterface, args
 	var err error
 	fmt.Println("Updating Security")
 	if len(args) != 12 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 12\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "update_security", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 12")
+	}
+	if err = validateSecurity(args); err != nil {
+		return nil, chaincode.SendInvalid(stub, "update_security", chaincode.Entities{AccountNumber: args[1], SecurityID: args[0]}, err)
 	}
 	// set accountNumber
 	securityId := args[0]
 	accountNumber := args[1]
 	securityAsBytes, err := stub.GetState(accountNumber + "-" + securityId)									//get the Security for the specified accountNumber-securityId from chaincode state
 	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to get state for " + accountNumber + "-" + securityId + "\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "update_security", chaincode.ErrUpstream, chaincode.Entities{AccountNumber: accountNumber, SecurityID: securityId}, "Failed to get state for " + accountNumber + "-" + securityId)
 	}
 	fmt.Print("securityAsBytes in update Security")
 	fmt.Println(securityAsBytes);
@@ -882,38 +735,26 @@ func (t *ManageAccounts) update_security(stub shim.ChaincodeStubInterface, args
 	if res.SecurityId == securityId{
 		fmt.Println("Security found with SecurityId : " + securityId)
 		fmt.Println(res);
-		//build the Account json string manually
-		order := 	`{`+
-			`"securityId": "` + res.SecurityId + `" ,`+
-			`"accountNumber": "` + res.AccountNumber + `" ,`+
-			`"securityName": "` + args[2] + `" ,`+
-			`"securityQuantity": "` + args[3] + `" ,`+
-			`"securityType": "` + args[4] + `" ,`+
-			`"collateralForm": "` + args[5] + `" ,`+
-			`"totalvalue": "` + args[6]+ `" ,`+
-			`"valuePercentage": "` + args[7]+ `" ,`+
-			`"mtm": "` + args[8]+ `" ,`+
-			`"effectivePercentage": "` + args[9]+ `" ,`+
-			`"effectiveValueinUSD": "` + args[10]+ `" ,`+
-			`"currency": "` + args[11] + `"`+
-			`}`
-		fmt.Println(order);
-		err = stub.PutState(accountNumber + "-" + securityId, []byte(order))									//store security with id as key
+		res.SecurityName = args[2]
+		res.SecurityQuantity = args[3]
+		res.SecurityType = args[4]
+		res.CollateralForm = args[5]
+		res.TotalValue = args[6]
+		res.ValuePercentage = args[7]
+		res.MTM = args[8]
+		res.EffectivePercentage = args[9]
+		res.EffectiveValueinUSD = args[10]
+		res.Currency = args[11]
+		err = chaincode.PutRecord(stub, accountNumber + "-" + securityId, &res)									//store security with id as key
 		if err != nil {
 			return nil, err
 		}
 		fmt.Println("Security updated succcessfully")
 	}else{
-		errMsg := "{ \"message\" : \""+ securityId+ " Not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "update_security", chaincode.ErrNotFound, chaincode.Entities{SecurityID: securityId}, securityId + " Not Found.")
 	}
 	
-	tosend := "{ \"Security\" : \"" + accountNumber + "-" + securityId + "\", \"message\" : \"Security updated succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	err = chaincode.SendEvent(stub, "update_security", chaincode.Entities{AccountNumber: accountNumber, SecurityID: securityId}, "Security updated succcessfully", nil)
 	if err != nil {
 		return nil, err
 	} 
@@ -925,36 +766,33 @@ func (t *ManageAccounts) update_security(stub shim.ChaincodeStubInterface, args
 // ============================================================================================================================
 func (t *ManageAccounts) delete_security(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	if len(args) != 2 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting \"accountNumber,securityId\" arguments.\", \"code\" : \"503\"}"
-		err := stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "delete_security", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'accountNumber' and 'securityId'")
 	}
 	// set security
 	_securityId := args[0];
 	_accountNumber := args[1];
 	security := _accountNumber + "-" + _securityId;
 	fmt.Println(security);
-	err := stub.DelState(security)													//remove the key from chaincode state
+	securityAsBytes, err := stub.GetState(security)
 	if err != nil {
-		errMsg := "{ \"security\" : \"" + security + "\", \"message\" : \"Failed to delete state\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "delete_security", chaincode.ErrUpstream, chaincode.Entities{SecurityID: security}, "Failed to get Security " + security)
+	}
+	deleted := Securities{}
+	json.Unmarshal(securityAsBytes, &deleted)
+	err = stub.DelState(security)													//remove the key from chaincode state
+	if err != nil {
+		return nil, chaincode.SendError(stub, "delete_security", chaincode.ErrUpstream, chaincode.Entities{SecurityID: security}, "Failed to delete state")
 	}
 
 	//get the account Number details
 	accountAsBytes, err := stub.GetState(_accountNumber)
 	if err != nil {
-		return nil, errors.New("Failed to get Account number")
+		return nil, chaincode.SendError(stub, "delete_security", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get Account number")
 	}
 	valIndex := Accounts{}
 	json.Unmarshal(accountAsBytes, &valIndex)	
 	_SecuritySplit := strings.Split(valIndex.Securities, ",")
+	found := false
 	fmt.Print("_SecuritySplit: " )
 	fmt.Println(_SecuritySplit)
 	for i := range _SecuritySplit{
@@ -962,11 +800,12 @@ func (t *ManageAccounts) delete_security(stub shim.ChaincodeStubInterface, args
 		fmt.Println(_SecuritySplit[i] == (_accountNumber+"-"+_securityId))
 		if _SecuritySplit[i] == (_accountNumber+"-"+_securityId) {
 			fmt.Println("Security Found.");
+			found = true
 			_SecuritySplit = append(_SecuritySplit[:i], _SecuritySplit[i+1:]...)			//remove it
 			fmt.Println(_SecuritySplit[:i])
 			fmt.Println(_SecuritySplit)
 			for x:= range _SecuritySplit{											//debug prints...
-				fmt.Println(string(x) + " - " + _SecuritySplit[x])
+				fmt.Println(strconv.Itoa(x) + " - " + _SecuritySplit[x])
 			}
 			break
 		}
@@ -974,25 +813,17 @@ func (t *ManageAccounts) delete_security(stub shim.ChaincodeStubInterface, args
 	fmt.Println(_SecuritySplit);
 	valIndex.Securities = strings.Join(_SecuritySplit,",");
 	fmt.Println(_SecuritySplit);
-	//build the Account json string manually
-	order := 	`{`+
-		`"accountId": "` + valIndex.AccountID + `" ,`+
-		`"accountName": "` + valIndex.AccountName + `" ,`+
-		`"accountNumber": "` + valIndex.AccountNumber + `" ,`+
-		`"accountType": "` + valIndex.AccountType + `" ,`+
-		`"totalValue": "` + valIndex.TotalValue + `" ,`+
-		`"currency": "` + valIndex.Currency + `" ,`+
-		`"pledger": "` + valIndex.Pledger + `" ,`+
-		`"securities": "`+ valIndex.Securities +`" `+
-		`}`
-		
-	fmt.Println("order: " + order)
-	err = stub.PutState(_accountNumber, []byte(order))									//store Account with _accountNumber as key
+	// The deleted position's value leaves the account with it
+	if found {
+		totalValue, _ := strconv.ParseFloat(valIndex.TotalValue, 64)
+		deletedValue, _ := strconv.ParseFloat(deleted.TotalValue, 64)
+		valIndex.TotalValue = strconv.FormatFloat(totalValue - deletedValue, 'f', -1, 64)
+	}
+	err = chaincode.PutRecord(stub, _accountNumber, &valIndex)									//store Account with _accountNumber as key
 	if err != nil {
 		return nil, err
 	}
-	tosend := "{ \"security\" : \""+security+"\", \"message\" : \"Security deleted succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	err = chaincode.SendEvent(stub, "delete_security", chaincode.Entities{SecurityID: security}, "Security deleted succcessfully", nil)
 	if err != nil {
 		return nil, err
 	} 
@@ -0,0 +1,341 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package account
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+	"github.com/mukutb/TCM/validation"
+)
+
+// Kinds of record a bulk load row can hold
+const (
+	BulkAccount  = "account"
+	BulkSecurity = "security"
+)
+
+// Outcomes of a bulk load row
+const (
+	BulkCreated   = "created"
+	BulkUpdated   = "updated"   // a holding already held with other fields, it is replaced
+	BulkUnchanged = "unchanged" // the ledger already has the row, loading a file again changes nothing
+	BulkFailed    = "failed"
+)
+
+// BulkRow is a row of an onboarding file: an account with the fields of create_account or a holding with those of add_security.
+// The total value and securities of an account come from its holdings, the fields are ignored for bulk loaded accounts
+type BulkRow struct {
+	Row    int               `json:"row"`    // line of the file, reported back with the outcome
+	Record string            `json:"record"` // BulkAccount or BulkSecurity
+	Fields map[string]string `json:"fields"`
+}
+
+// BulkRowResult is the outcome of a row, Fields says what is wrong with each field of a row that failed validation
+type BulkRowResult struct {
+	Row    int               `json:"row"`
+	Record string            `json:"record"`
+	Key    string            `json:"key"`
+	Status string            `json:"status"`
+	Error  string            `json:"error,omitempty"`
+	Fields validation.Errors `json:"fields,omitempty"`
+}
+
+// BulkLoadResult is the outcome of every row of a bulk load with the number of rows per outcome
+type BulkLoadResult struct {
+	Created   int             `json:"created"`
+	Updated   int             `json:"updated"`
+	Unchanged int             `json:"unchanged"`
+	Failed    int             `json:"failed"`
+	Rows      []BulkRowResult `json:"rows"`
+}
+
+// Add counts a row result in the totals of the load
+func (result *BulkLoadResult) Add(row BulkRowResult) {
+	switch row.Status {
+	case BulkCreated:
+		result.Created++
+	case BulkUpdated:
+		result.Updated++
+	case BulkUnchanged:
+		result.Unchanged++
+	default:
+		result.Failed++
+	}
+	result.Rows = append(result.Rows, row)
+}
+
+// Args are the positional arguments of create_account or add_security the row gives
+func (row BulkRow) Args() ([]string, error) {
+	var fields []Field
+	switch row.Record {
+	case BulkAccount:
+		fields = accountFields
+	case BulkSecurity:
+		fields = securityFields
+	default:
+		return nil, errors.New("record must be '" + BulkAccount + "' or '" + BulkSecurity + "', got '" + row.Record + "'")
+	}
+	args := []string{}
+	for _, field := range fields {
+		args = append(args, strings.TrimSpace(row.Fields[field.Name]))
+	}
+	if row.Record == BulkAccount {
+		args[4], args[7] = "0", ""
+	}
+	return args, nil
+}
+
+// Key is the key the row is kept under on the ledger
+func (row BulkRow) Key() string {
+	if row.Record == BulkSecurity {
+		return strings.TrimSpace(row.Fields["accountNumber"]) + "-" + strings.TrimSpace(row.Fields["securityId"])
+	}
+	return strings.TrimSpace(row.Fields["accountNumber"])
+}
+
+// Validate checks a row the way create_account or add_security check their arguments, a file can be checked offline with it
+func (row BulkRow) Validate() error {
+	args, err := row.Args()
+	if err != nil {
+		return err
+	}
+	return validateRow(row.Record, args)
+}
+
+func validateRow(record string, args []string) error {
+	if record == BulkAccount {
+		return validateAccount(args)
+	}
+	return validateSecurity(args)
+}
+
+// bulkLedger keeps the accounts and positions a bulk load reads and writes, a transaction does not read its own writes.
+// Keys are written in the order they were first changed
+type bulkLedger struct {
+	stub         shim.ChaincodeStubInterface
+	accountIndex []string
+	accounts     map[string]*Accounts
+	positions    map[string]*Securities
+	changed      []string
+}
+
+func (ledger *bulkLedger) account(accountNumber string) (*Accounts, error) {
+	if account, ok := ledger.accounts[accountNumber]; ok {
+		return account, nil
+	}
+	accountAsBytes, err := ledger.stub.GetState(accountNumber)
+	if err != nil {
+		return nil, err
+	}
+	account := &Accounts{}
+	json.Unmarshal(accountAsBytes, account)
+	if account.AccountNumber != accountNumber {
+		account = nil
+	}
+	ledger.accounts[accountNumber] = account
+	return account, nil
+}
+
+func (ledger *bulkLedger) position(key string) (*Securities, error) {
+	if position, ok := ledger.positions[key]; ok {
+		return position, nil
+	}
+	positionAsBytes, err := ledger.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	position := &Securities{}
+	json.Unmarshal(positionAsBytes, position)
+	if position.SecurityId == "" {
+		position = nil
+	}
+	ledger.positions[key] = position
+	return position, nil
+}
+
+func (ledger *bulkLedger) change(key string) {
+	for _, changed := range ledger.changed {
+		if changed == key {
+			return
+		}
+	}
+	ledger.changed = append(ledger.changed, key)
+}
+
+// loadAccount creates the account of a row, an account that exists with the same details is unchanged
+func (ledger *bulkLedger) loadAccount(args []string) (string, error) {
+	account, err := ledger.account(args[2])
+	if err != nil {
+		return "", err
+	}
+	if account != nil {
+		if account.AccountID != args[0] || account.AccountName != args[1] || account.AccountType != args[3] ||
+			account.Currency != args[5] || account.Pledger != args[6] {
+			return "", errors.New(args[2] + " already exists with other details")
+		}
+		return BulkUnchanged, nil
+	}
+	ledger.accounts[args[2]] = &Accounts{AccountID: args[0], AccountName: args[1], AccountNumber: args[2], AccountType: args[3],
+		TotalValue: args[4], Currency: args[5], Pledger: args[6], Securities: args[7]}
+	ledger.accountIndex = append(ledger.accountIndex, args[2])
+	ledger.change(args[2])
+	return BulkCreated, nil
+}
+
+// loadSecurity adds the holding of a row to its account, a holding the account has with other fields is replaced
+func (ledger *bulkLedger) loadSecurity(args []string) (string, error) {
+	account, err := ledger.account(args[1])
+	if err != nil {
+		return "", err
+	}
+	if account == nil {
+		return "", errors.New(args[1] + " Not Found.")
+	}
+	key := args[1] + "-" + args[0]
+	held, err := ledger.position(key)
+	if err != nil {
+		return "", err
+	}
+	position := &Securities{SecurityId: args[0], AccountNumber: args[1], SecurityName: args[2], SecurityQuantity: args[3],
+		SecurityType: args[4], CollateralForm: args[5], TotalValue: args[6], ValuePercentage: args[7], MTM: args[8],
+		EffectivePercentage: args[9], EffectiveValueinUSD: args[10], Currency: args[11]}
+	status := BulkCreated
+	listed := contains(strings.Split(account.Securities, ","), key)
+	totalValue, _ := strconv.ParseFloat(account.TotalValue, 64)
+	if held != nil {
+		position.Record = held.Record
+		if *position == *held && listed {
+			return BulkUnchanged, nil
+		}
+		if listed {
+			heldValue, _ := strconv.ParseFloat(held.TotalValue, 64)
+			totalValue -= heldValue
+		}
+		status = BulkUpdated
+	}
+	if !listed {
+		account.Securities = strings.Join(append(removeSecurityKey(strings.Split(account.Securities, ","), ""), key), ",")
+	}
+	value, _ := strconv.ParseFloat(args[6], 64)
+	account.TotalValue = strconv.FormatFloat(totalValue+value, 'f', 2, 64)
+	ledger.positions[key] = position
+	ledger.change(key)
+	ledger.change(args[1])
+	return status, nil
+}
+
+// write stores what the load changed and the account index when accounts were created
+func (ledger *bulkLedger) write(accountsCreated bool) error {
+	for _, key := range ledger.changed {
+		var err error
+		if account, ok := ledger.accounts[key]; ok && account != nil && account.AccountNumber == key {
+			err = chaincode.PutRecord(ledger.stub, key, account)
+		} else {
+			err = chaincode.PutRecord(ledger.stub, key, ledger.positions[key])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !accountsCreated {
+		return nil
+	}
+	jsonAsBytes, _ := json.Marshal(ledger.accountIndex)
+	return ledger.stub.PutState(AccountIndexStr, jsonAsBytes)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================================================================================================
+// bulk_load - create the accounts and add the holdings of a chunk of onboarding rows, given as a JSON array of BulkRow.
+// Every row is validated and reported on its own, a row that fails does not stop the others. Loading rows the ledger
+// already has leaves them unchanged, so a file can be loaded again after a failure. With a 'resultId' the result is kept
+// for getResult_byID
+// ============================================================================================================================
+func (t *ManageAccounts) bulk_load(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, chaincode.SendError(stub, "bulk_load", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting a JSON array of rows and optionally 'resultId'")
+	}
+	fmt.Println("start bulk_load")
+	var rows []BulkRow
+	if err := json.Unmarshal([]byte(args[0]), &rows); err != nil {
+		return nil, chaincode.SendError(stub, "bulk_load", chaincode.ErrValidation, chaincode.Entities{}, "Rows are not a JSON array: "+err.Error())
+	}
+	accountIndexAsBytes, err := stub.GetState(AccountIndexStr)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "bulk_load", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get Account index")
+	}
+	ledger := &bulkLedger{stub: stub, accounts: make(map[string]*Accounts), positions: make(map[string]*Securities)}
+	json.Unmarshal(accountIndexAsBytes, &ledger.accountIndex)
+
+	result := BulkLoadResult{Rows: []BulkRowResult{}}
+	for _, row := range rows {
+		outcome := BulkRowResult{Row: row.Row, Record: row.Record, Key: row.Key()}
+		args, err := row.Args()
+		if err == nil {
+			err = validateRow(row.Record, args)
+		}
+		if err == nil && row.Record == BulkAccount {
+			outcome.Status, err = ledger.loadAccount(args)
+		} else if err == nil {
+			outcome.Status, err = ledger.loadSecurity(args)
+		}
+		if err != nil {
+			outcome.Status, outcome.Error = BulkFailed, err.Error()
+			if fields, ok := err.(validation.Errors); ok {
+				outcome.Fields = fields
+			}
+		}
+		result.Add(outcome)
+	}
+	err = ledger.write(result.Created > 0)
+	if err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("%d rows loaded: %d created, %d updated, %d unchanged, %d failed", len(rows),
+		result.Created, result.Updated, result.Unchanged, result.Failed)
+	err = chaincode.SendEvent(stub, "bulk_load", chaincode.Entities{}, message, result)
+	if err != nil {
+		return nil, err
+	}
+	resultAsBytes, _ := json.Marshal(result)
+	if len(args) == 2 {
+		err = chaincode.PutResult(stub, args[1], resultAsBytes)
+		if err != nil {
+			return nil, chaincode.SendError(stub, "bulk_load", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to keep result "+args[1])
+		}
+	}
+	fmt.Println("end bulk_load: " + message)
+	return resultAsBytes, nil
+}
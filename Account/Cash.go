@@ -0,0 +1,185 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package account
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+	"github.com/mukutb/TCM/validation"
+)
+
+// Cash is held in an account as one position per currency, keyed accountNumber-CASH-<currency>.
+// The position carries the balance as its quantity with an MTM of 1 so allocation can value it like any security.
+var cashSecurityPrefix = "CASH-"
+var cashCollateralForm = "Cash"
+
+// ============================================================================================================================
+// deposit_cash - add cash in a currency to an account
+// ============================================================================================================================
+func (t *ManageAccounts) deposit_cash(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return t.move_cash(stub, "deposit_cash", args, 1)
+}
+
+// ============================================================================================================================
+// withdraw_cash - take cash in a currency out of an account
+// ============================================================================================================================
+func (t *ManageAccounts) withdraw_cash(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return t.move_cash(stub, "withdraw_cash", args, -1)
+}
+
+// move_cash adjusts the cash position of an account by amount in the given direction for function. A withdrawal cannot
+// take cash other transactions reserved
+func (t *ManageAccounts) move_cash(stub shim.ChaincodeStubInterface, function string, args []string, direction float64) ([]byte, error) {
+	var err error
+	if len(args) != 3 {
+		return nil, chaincode.SendError(stub, function, chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'accountNumber', 'currency' and 'amount'")
+	}
+	fmt.Println("start " + function)
+	_accountNumber := args[0]
+	_currency := strings.ToUpper(args[1])
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || amount <= 0 {
+		return nil, chaincode.SendError(stub, function, chaincode.ErrValidation, chaincode.Entities{AccountNumber: _accountNumber}, "Cash amount must be a number greater than 0.")
+	}
+	v := validation.Validator{}
+	v.Required("currency", _currency)
+	v.Currency("currency", _currency)
+	if err = v.Err(); err != nil {
+		return nil, chaincode.SendInvalid(stub, function, chaincode.Entities{AccountNumber: _accountNumber}, err)
+	}
+
+	AccountAsBytes, err := stub.GetState(_accountNumber)
+	if err != nil {
+		return nil, chaincode.SendError(stub, function, chaincode.ErrUpstream, chaincode.Entities{AccountNumber: _accountNumber}, "Failed to get Account "+_accountNumber)
+	}
+	account := Accounts{}
+	json.Unmarshal(AccountAsBytes, &account)
+	if account.AccountNumber != _accountNumber {
+		return nil, chaincode.SendError(stub, function, chaincode.ErrNotFound, chaincode.Entities{AccountNumber: _accountNumber}, _accountNumber+" Not Found.")
+	}
+
+	_securityKey := _accountNumber + "-" + cashSecurityPrefix + _currency
+	SecurityAsBytes, err := stub.GetState(_securityKey)
+	if err != nil {
+		return nil, chaincode.SendError(stub, function, chaincode.ErrUpstream, chaincode.Entities{SecurityID: _securityKey}, "Failed to get Security "+_securityKey)
+	}
+	cash := Securities{}
+	json.Unmarshal(SecurityAsBytes, &cash)
+	isNew := cash.SecurityId == ""
+	balance, _ := strconv.ParseFloat(cash.SecurityQuantity, 64)
+	balance += direction * amount
+	if balance < 0 {
+		return nil, chaincode.SendError(stub, function, chaincode.ErrConflict, chaincode.Entities{AccountNumber: _accountNumber}, "Insufficient "+_currency+" cash balance.")
+	}
+	if direction < 0 {
+		now, err := chaincode.TxSeconds(stub)
+		if err != nil {
+			return nil, err
+		}
+		reservations, err := activeReservations(stub)
+		if err != nil {
+			return nil, chaincode.SendError(stub, function, chaincode.ErrUpstream, chaincode.Entities{AccountNumber: _accountNumber}, err.Error())
+		}
+		if reserved := reservedByOthers(reservations, _accountNumber, nil, now)[cashSecurityPrefix+_currency]; balance < reserved-0.005 {
+			return nil, chaincode.SendError(stub, function, chaincode.ErrConflict, chaincode.Entities{AccountNumber: _accountNumber},
+				fmt.Sprintf("Insufficient %s cash balance, %.2f is reserved by transactions.", _currency, reserved))
+		}
+	}
+	cash.SecurityId = cashSecurityPrefix + _currency
+	cash.AccountNumber = _accountNumber
+	cash.SecurityName = _currency + " Cash"
+	cash.SecurityQuantity = strconv.FormatFloat(balance, 'f', 2, 64)
+	cash.SecurityType = cashCollateralForm
+	cash.CollateralForm = cashCollateralForm
+	cash.TotalValue = cash.SecurityQuantity
+	cash.MTM = "1"
+	cash.Currency = _currency
+	err = chaincode.PutRecord(stub, _securityKey, &cash)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the account's security list and total value in line with the cash position
+	if isNew {
+		if account.Securities == " " || account.Securities == "" {
+			account.Securities = _securityKey
+		} else {
+			account.Securities = account.Securities + "," + _securityKey
+		}
+	}
+	totalValue, _ := strconv.ParseFloat(account.TotalValue, 64)
+	account.TotalValue = strconv.FormatFloat(totalValue+direction*amount, 'f', -1, 64)
+	err = chaincode.PutRecord(stub, _accountNumber, &account)
+	if err != nil {
+		return nil, err
+	}
+
+	err = chaincode.SendEvent(stub, function, chaincode.Entities{AccountNumber: _accountNumber}, "Cash balance updated succcessfully", map[string]string{"currency": _currency, "balance": cash.SecurityQuantity})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end " + function)
+	return nil, nil
+}
+
+// ============================================================================================================================
+//
+//	getCashBalances_byAccount - get the cash balance per currency of an account
+//
+// ============================================================================================================================
+func (t *ManageAccounts) getCashBalances_byAccount(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("start getCashBalances_byAccount")
+	if len(args) != 1 {
+		return nil, chaincode.SendError(stub, "getCashBalances_byAccount", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'AccountNumber' as an argument")
+	}
+	_AccountNumber := args[0]
+	account := Accounts{}
+	AccountAsBytes, err := stub.GetState(_AccountNumber)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "getCashBalances_byAccount", chaincode.ErrUpstream, chaincode.Entities{AccountNumber: _AccountNumber}, "Failed to get Account "+_AccountNumber)
+	}
+	json.Unmarshal(AccountAsBytes, &account)
+	if account.AccountNumber != _AccountNumber {
+		return nil, chaincode.SendError(stub, "getCashBalances_byAccount", chaincode.ErrNotFound, chaincode.Entities{AccountNumber: _AccountNumber}, "Account Not Found.")
+	}
+	balances := make(map[string]string)
+	for _, key := range strings.Split(account.Securities, ",") {
+		if !strings.HasPrefix(key, _AccountNumber+"-"+cashSecurityPrefix) {
+			continue
+		}
+		valueAsBytes, err := stub.GetState(key)
+		if err != nil {
+			return nil, chaincode.SendError(stub, "getCashBalances_byAccount", chaincode.ErrUpstream, chaincode.Entities{}, "{\"Error\":\"Failed to get state for "+key+"\"}")
+		}
+		cash := Securities{}
+		json.Unmarshal(valueAsBytes, &cash)
+		if cash.CollateralForm == cashCollateralForm {
+			balances[cash.Currency] = cash.SecurityQuantity
+		}
+	}
+	fmt.Println("end getCashBalances_byAccount")
+	return json.Marshal(balances)
+}
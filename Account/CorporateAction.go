@@ -0,0 +1,214 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package account
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+// Outcome of a corporate action on one position, returned to the caller of apply_corporate_action
+type CorporateActionResult struct {
+	AccountNumber  string `json:"accountNumber"`
+	SecurityId     string `json:"securityId"`
+	NewSecurityId  string `json:"newSecurityId"`
+	EventType      string `json:"eventType"`
+	QuantityBefore string `json:"quantityBefore"`
+	QuantityAfter  string `json:"quantityAfter"`
+	Income         string `json:"income"`
+	Currency       string `json:"currency"`
+	ValueBefore    string `json:"valueBefore"`
+	ValueAfter     string `json:"valueAfter"`
+}
+
+// ============================================================================================================================
+// apply_corporate_action - apply a coupon, dividend, redemption, split or merger to a position held in an account
+// args: accountNumber, securityId, eventType, rate, ratio, newSecurityId, newSecurityName
+//
+//	Coupon / Dividend : rate is the income per unit held
+//	Redemption        : rate is the redemption price per unit, ratio the fraction of the position redeemed
+//	Split             : ratio is the number of new units per unit held
+//	Merger            : ratio is the number of newSecurityId units per unit held, rate any cash paid per unit held
+//
+// ============================================================================================================================
+func (t *ManageAccounts) apply_corporate_action(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 7 {
+		return nil, chaincode.SendError(stub, "apply_corporate_action", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'accountNumber', 'securityId', 'eventType', 'rate', 'ratio', 'newSecurityId' and 'newSecurityName'")
+	}
+	fmt.Println("start apply_corporate_action")
+	_accountNumber := args[0]
+	_securityId := args[1]
+	_eventType := args[2]
+	_newSecurityId := args[5]
+	_newSecurityName := args[6]
+	rate, errRate := strconv.ParseFloat(args[3], 64)
+	ratio, errRatio := strconv.ParseFloat(args[4], 64)
+	if args[3] == "" || args[3] == " " {
+		rate, errRate = 0, nil
+	}
+	if args[4] == "" || args[4] == " " {
+		ratio, errRatio = 1, nil
+	}
+	if errRate != nil || errRatio != nil || rate < 0 || ratio <= 0 {
+		return nil, chaincode.SendError(stub, "apply_corporate_action", chaincode.ErrValidation, chaincode.Entities{SecurityID: _securityId}, "Corporate action rate and ratio must be positive numbers.")
+	}
+
+	AccountAsBytes, err := stub.GetState(_accountNumber)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "apply_corporate_action", chaincode.ErrUpstream, chaincode.Entities{AccountNumber: _accountNumber}, "Failed to get Account "+_accountNumber)
+	}
+	account := Accounts{}
+	json.Unmarshal(AccountAsBytes, &account)
+	_securityKey := _accountNumber + "-" + _securityId
+	SecurityAsBytes, err := stub.GetState(_securityKey)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "apply_corporate_action", chaincode.ErrUpstream, chaincode.Entities{SecurityID: _securityKey}, "Failed to get Security "+_securityKey)
+	}
+	security := Securities{}
+	json.Unmarshal(SecurityAsBytes, &security)
+	if account.AccountNumber != _accountNumber || security.SecurityId != _securityId {
+		return nil, chaincode.SendError(stub, "apply_corporate_action", chaincode.ErrNotFound, chaincode.Entities{AccountNumber: _accountNumber, SecurityID: _securityId}, "Security Not Found in the account.")
+	}
+
+	quantity, _ := strconv.ParseFloat(security.SecurityQuantity, 64)
+	valueBefore, _ := strconv.ParseFloat(security.TotalValue, 64)
+	mtm, _ := strconv.ParseFloat(security.MTM, 64)
+	effectiveValue, _ := strconv.ParseFloat(security.EffectiveValueinUSD, 64)
+	newQuantity := quantity
+	valueAfter := valueBefore
+	income := 0.0
+	switch _eventType {
+	case "Coupon", "Dividend":
+		income = quantity * rate
+	case "Redemption":
+		redeemed := quantity * math.Min(ratio, 1)
+		income = redeemed * rate
+		newQuantity = quantity - redeemed
+		if quantity > 0 {
+			valueAfter = valueBefore * newQuantity / quantity
+		}
+	case "Split":
+		newQuantity = quantity * ratio
+		mtm = mtm / ratio
+		effectiveValue = effectiveValue / ratio
+	case "Merger":
+		if _newSecurityId == "" || _newSecurityId == " " {
+			return nil, chaincode.SendError(stub, "apply_corporate_action", chaincode.ErrValidation, chaincode.Entities{AccountNumber: _accountNumber, SecurityID: _securityId}, "Merger needs the security it is merged into.")
+		}
+		income = quantity * rate
+		newQuantity = quantity * ratio
+		mtm = mtm / ratio
+		effectiveValue = effectiveValue / ratio
+	default:
+		return nil, chaincode.SendError(stub, "apply_corporate_action", chaincode.ErrValidation, chaincode.Entities{}, "Unknown corporate action "+_eventType+". Expecting Coupon, Dividend, Redemption, Split or Merger.")
+	}
+
+	result := CorporateActionResult{
+		AccountNumber:  _accountNumber,
+		SecurityId:     _securityId,
+		EventType:      _eventType,
+		QuantityBefore: security.SecurityQuantity,
+		QuantityAfter:  strconv.FormatFloat(newQuantity, 'f', 2, 64),
+		Income:         strconv.FormatFloat(income, 'f', 2, 64),
+		Currency:       security.Currency,
+		ValueBefore:    strconv.FormatFloat(valueBefore, 'f', 2, 64),
+		ValueAfter:     strconv.FormatFloat(valueAfter, 'f', 2, 64),
+	}
+
+	security.SecurityQuantity = result.QuantityAfter
+	security.TotalValue = result.ValueAfter
+	security.MTM = strconv.FormatFloat(mtm, 'f', -1, 64)
+	security.EffectiveValueinUSD = strconv.FormatFloat(effectiveValue, 'f', 2, 64)
+	_securitySplit := strings.Split(account.Securities, ",")
+	if _eventType == "Merger" {
+		// The position moves to the surviving security, joining any units of it already held
+		result.NewSecurityId = _newSecurityId
+		_newSecurityKey := _accountNumber + "-" + _newSecurityId
+		NewSecurityAsBytes, err := stub.GetState(_newSecurityKey)
+		if err != nil {
+			return nil, chaincode.SendError(stub, "apply_corporate_action", chaincode.ErrUpstream, chaincode.Entities{SecurityID: _newSecurityKey}, "Failed to get Security "+_newSecurityKey)
+		}
+		existing := Securities{}
+		json.Unmarshal(NewSecurityAsBytes, &existing)
+		if existing.SecurityId == _newSecurityId {
+			heldQuantity, _ := strconv.ParseFloat(existing.SecurityQuantity, 64)
+			heldValue, _ := strconv.ParseFloat(existing.TotalValue, 64)
+			existing.SecurityQuantity = strconv.FormatFloat(heldQuantity+newQuantity, 'f', 2, 64)
+			existing.TotalValue = strconv.FormatFloat(heldValue+valueAfter, 'f', 2, 64)
+			security = existing
+		} else {
+			security.SecurityId = _newSecurityId
+			security.SecurityName = _newSecurityName
+			_securitySplit = append(_securitySplit, _newSecurityKey)
+		}
+		err = stub.DelState(_securityKey)
+		if err != nil {
+			return nil, err
+		}
+		_securitySplit = removeSecurityKey(_securitySplit, _securityKey)
+		_securityKey = _newSecurityKey
+	}
+	if newQuantity <= 0 {
+		err = stub.DelState(_securityKey)
+		if err != nil {
+			return nil, err
+		}
+		_securitySplit = removeSecurityKey(_securitySplit, _securityKey)
+	} else {
+		err = chaincode.PutRecord(stub, _securityKey, &security)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	accountTotal, _ := strconv.ParseFloat(account.TotalValue, 64)
+	account.TotalValue = strconv.FormatFloat(accountTotal-valueBefore+valueAfter, 'f', -1, 64)
+	account.Securities = strings.Join(_securitySplit, ",")
+	err = chaincode.PutRecord(stub, _accountNumber, &account)
+	if err != nil {
+		return nil, err
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	err = chaincode.SendEvent(stub, "apply_corporate_action", chaincode.Entities{AccountNumber: _accountNumber, SecurityID: _securityId}, "Corporate action applied succcessfully", map[string]string{"eventType": _eventType})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end apply_corporate_action")
+	return resultAsBytes, nil
+}
+
+// removeSecurityKey drops a security key from an account's security list
+func removeSecurityKey(securityKeys []string, securityKey string) []string {
+	var kept []string
+	for _, key := range securityKeys {
+		if key != securityKey && key != "" && key != " " {
+			kept = append(kept, key)
+		}
+	}
+	return kept
+}
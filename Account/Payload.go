@@ -0,0 +1,149 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package account
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+	"github.com/mukutb/TCM/validation"
+)
+
+// Field is a named argument of a function, the schema of a function lists them in the order of its positional arguments
+type Field struct {
+	Name string
+	// Optional starts a group of fields that can be left out together with every field after it.
+	// Fields of a group that is given but not filled in take their Default
+	Optional bool
+	Default  string
+}
+
+// payloadArgs turns a single JSON object argument into the positional arguments of the function.
+// Any other call, and functions without a schema, keep their arguments as they are
+func payloadArgs(stub shim.ChaincodeStubInterface, function string, args []string) ([]string, error) {
+	fields, ok := schemas[function]
+	if !ok || len(args) != 1 || !strings.HasPrefix(strings.TrimSpace(args[0]), "{") {
+		return args, nil
+	}
+	payload := make(map[string]json.RawMessage)
+	if err := json.Unmarshal([]byte(args[0]), &payload); err != nil {
+		return nil, chaincode.SendError(stub, function, chaincode.ErrValidation, chaincode.Entities{}, "Payload is not a JSON object: "+err.Error())
+	}
+	known := make(map[string]bool)
+	for _, field := range fields {
+		known[field.Name] = true
+	}
+	unknown := []string{}
+	for name := range payload {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	v := validation.Validator{}
+
+	// Everything up to the first optional group is required, the groups are passed up to the last one given
+	required, given := len(fields), 0
+	for i, field := range fields {
+		if field.Optional && required == len(fields) {
+			required = i
+		}
+		if value, ok := payload[field.Name]; ok && string(value) != "null" {
+			given = i + 1
+		}
+	}
+	if given < required {
+		given = required
+	}
+	for given < len(fields) && given > required && !fields[given].Optional {
+		given++
+	}
+	positional := []string{}
+	for i, field := range fields[:given] {
+		value, ok := payload[field.Name]
+		if !ok || string(value) == "null" {
+			if i < required {
+				v.Add(field.Name, "is required")
+			}
+			positional = append(positional, field.Default)
+			continue
+		}
+		// Strings are passed unquoted, numbers, booleans, objects and arrays as their JSON text
+		var text string
+		if json.Unmarshal(value, &text) != nil {
+			text = string(value)
+		}
+		positional = append(positional, text)
+	}
+	for _, name := range unknown {
+		v.Add(name, "is not a field of "+function)
+	}
+	if err := v.Err(); err != nil {
+		return nil, chaincode.SendInvalid(stub, function, chaincode.Entities{}, err)
+	}
+	return positional, nil
+}
+
+// securityFields are the fields of add_security, update_security and credit_security
+var securityFields = []Field{{Name: "securityId"}, {Name: "accountNumber"}, {Name: "securityName"}, {Name: "securityQuantity"},
+	{Name: "securityType"}, {Name: "collateralForm"}, {Name: "totalValue"}, {Name: "valuePercentage"}, {Name: "mtm"},
+	{Name: "effectivePercentage"}, {Name: "effectiveValueinUSD"}, {Name: "currency"}}
+
+// accountFields are the fields of create_account and update_account, securities may be a JSON array
+var accountFields = []Field{{Name: "accountId"}, {Name: "accountName"}, {Name: "accountNumber"}, {Name: "accountType"},
+	{Name: "totalValue"}, {Name: "currency"}, {Name: "pledger"}, {Name: "securities"}}
+
+// cashFields are the fields of deposit_cash and withdraw_cash
+var cashFields = []Field{{Name: "accountNumber"}, {Name: "currency"}, {Name: "amount"}}
+
+// schemas are the named arguments functions accept as a single JSON object
+var schemas = map[string][]Field{
+	"create_account":               accountFields,
+	"update_account":               accountFields,
+	"add_security":                 securityFields,
+	"remove_securitiesFromAccount": {{Name: "accountNumber"}},
+	"update_security":              securityFields,
+	"delete_security":              {{Name: "securityId"}, {Name: "accountNumber"}},
+	"deposit_cash":                 cashFields,
+	"withdraw_cash":                cashFields,
+	"apply_corporate_action": {{Name: "accountNumber"}, {Name: "securityId"}, {Name: "eventType"}, {Name: "rate"}, {Name: "ratio"},
+		{Name: "newSecurityId"}, {Name: "newSecurityName"}},
+	"credit_security":                securityFields,
+	"getAccount_byName":              {{Name: "accountName"}},
+	"getAccount_byType":              {{Name: "accountType"}},
+	"getAccount_byNumber":            {{Name: "accountNumber"}},
+	"get_AllAccount":                 {},
+	"getSecurities_byAccount":        {{Name: "accountNumber"}},
+	"getCashBalances_byAccount":      {{Name: "accountNumber"}},
+	"migrate_records":                {},
+	"reconcile_accounts":             {{Name: "repair", Optional: true, Default: "false"}},
+	"bulk_load":                      {{Name: "rows"}, {Name: "resultId", Optional: true}},
+	"getResult_byID":                 {{Name: "resultId"}},
+	"reserve_securities":             {{Name: "transactionId"}, {Name: "accountNumber"}, {Name: "quantities"}, {Name: "expiresAt"}},
+	"commit_reservations":            {{Name: "transactionId"}},
+	"release_reservations":           {{Name: "transactionId"}},
+	"expire_reservations":            {},
+	"reserve_allocations":            {{Name: "accountNumber"}, {Name: "reservations"}},
+	"getAvailability_byAccount":      {{Name: "accountNumber"}, {Name: "transactionIds", Optional: true, Default: ""}},
+	"getReservation_byTransactionID": {{Name: "transactionId"}, {Name: "accountNumber"}},
+}
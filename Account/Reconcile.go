@@ -0,0 +1,196 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package account
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+// AccountReconciliation is how an account drifted from its position records, the values are those found before repair
+type AccountReconciliation struct {
+	AccountNumber  string   `json:"accountNumber"`
+	TotalValue     string   `json:"totalValue"`     // as the account has it
+	PositionsValue string   `json:"positionsValue"` // sum of the total value of its position records
+	DuplicateKeys  []string `json:"duplicateKeys"`  // listed more than once
+	MissingKeys    []string `json:"missingKeys"`    // listed without a position record
+	OrphanedKeys   []string `json:"orphanedKeys"`   // position records of the account it does not list
+	Repaired       bool     `json:"repaired"`
+}
+
+// ReconciliationResult is what reconcile_accounts found. Accounts lists only the accounts that drifted
+type ReconciliationResult struct {
+	Repair          bool                    `json:"repair"`
+	AccountsChecked int                     `json:"accountsChecked"`
+	Accounts        []AccountReconciliation `json:"accounts"`
+	UnknownAccounts map[string][]string     `json:"unknownAccounts"` // position records per account number that is not in the index
+}
+
+// positionRecords reads every position record in the world state by the account it belongs to.
+// A position is kept under accountNumber-securityId, records of any other kind do not match their key that way
+func positionRecords(stub shim.ChaincodeStubInterface) (map[string]map[string]Securities, error) {
+	records, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer records.Close()
+	positions := make(map[string]map[string]Securities)
+	for records.HasNext() {
+		kv, err := records.Next()
+		if err != nil {
+			return nil, err
+		}
+		position := Securities{}
+		if json.Unmarshal(kv.Value, &position) != nil || position.SecurityId == "" || kv.Key != position.AccountNumber+"-"+position.SecurityId {
+			continue
+		}
+		if positions[position.AccountNumber] == nil {
+			positions[position.AccountNumber] = make(map[string]Securities)
+		}
+		positions[position.AccountNumber][kv.Key] = position
+	}
+	return positions, nil
+}
+
+// reconcileAccount compares the security list and total value of an account with its position records
+// and returns the list and total value they give
+func reconcileAccount(account Accounts, positions map[string]Securities) (AccountReconciliation, []string, float64) {
+	drift := AccountReconciliation{AccountNumber: account.AccountNumber, TotalValue: account.TotalValue,
+		DuplicateKeys: []string{}, MissingKeys: []string{}, OrphanedKeys: []string{}}
+	listed := make(map[string]bool)
+	securityKeys := []string{}
+	for _, securityKey := range removeSecurityKey(strings.Split(account.Securities, ","), "") {
+		switch _, held := positions[securityKey]; {
+		case listed[securityKey]:
+			drift.DuplicateKeys = append(drift.DuplicateKeys, securityKey)
+		case !held:
+			drift.MissingKeys = append(drift.MissingKeys, securityKey)
+		default:
+			securityKeys = append(securityKeys, securityKey)
+		}
+		listed[securityKey] = true
+	}
+	orphaned := []string{}
+	for securityKey := range positions {
+		if !listed[securityKey] {
+			orphaned = append(orphaned, securityKey)
+		}
+	}
+	sort.Strings(orphaned)
+	drift.OrphanedKeys = orphaned
+	securityKeys = append(securityKeys, orphaned...)
+
+	positionsValue := 0.0
+	for _, position := range positions {
+		value, _ := strconv.ParseFloat(position.TotalValue, 64)
+		positionsValue += value
+	}
+	drift.PositionsValue = strconv.FormatFloat(positionsValue, 'f', 2, 64)
+	return drift, securityKeys, positionsValue
+}
+
+func (drift AccountReconciliation) drifted() bool {
+	totalValue, _ := strconv.ParseFloat(drift.TotalValue, 64)
+	positionsValue, _ := strconv.ParseFloat(drift.PositionsValue, 64)
+	return len(drift.DuplicateKeys)+len(drift.MissingKeys)+len(drift.OrphanedKeys) > 0 || math.Abs(totalValue-positionsValue) >= 0.005
+}
+
+// ============================================================================================================================
+// reconcile_accounts - compare every account with the position records kept under its number and report how it drifted.
+// With 'repair' set to true the security list and total value of each account that drifted are rebuilt from its
+// position records in the same invocation, the event of reconcile_accounts keeps what they were before
+// ============================================================================================================================
+func (t *ManageAccounts) reconcile_accounts(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) > 1 {
+		return nil, chaincode.SendError(stub, "reconcile_accounts", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting at most 'repair'")
+	}
+	fmt.Println("start reconcile_accounts")
+	repair := false
+	if len(args) == 1 && strings.TrimSpace(args[0]) != "" {
+		var err error
+		if repair, err = strconv.ParseBool(args[0]); err != nil {
+			return nil, chaincode.SendError(stub, "reconcile_accounts", chaincode.ErrValidation, chaincode.Entities{}, "'repair' must be true or false.")
+		}
+	}
+	accountIndexAsBytes, err := stub.GetState(AccountIndexStr)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "reconcile_accounts", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get Account index")
+	}
+	var accountIndex []string
+	json.Unmarshal(accountIndexAsBytes, &accountIndex)
+	positions, err := positionRecords(stub)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "reconcile_accounts", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to read position records: "+err.Error())
+	}
+
+	result := ReconciliationResult{Repair: repair, Accounts: []AccountReconciliation{}, UnknownAccounts: make(map[string][]string)}
+	for _, accountNumber := range accountIndex {
+		accountAsBytes, err := stub.GetState(accountNumber)
+		if err != nil {
+			return nil, chaincode.SendError(stub, "reconcile_accounts", chaincode.ErrUpstream, chaincode.Entities{AccountNumber: accountNumber}, "Failed to get Account "+accountNumber)
+		}
+		account := Accounts{}
+		json.Unmarshal(accountAsBytes, &account)
+		if account.AccountNumber != accountNumber {
+			continue
+		}
+		result.AccountsChecked++
+		drift, securityKeys, positionsValue := reconcileAccount(account, positions[accountNumber])
+		delete(positions, accountNumber)
+		if !drift.drifted() {
+			continue
+		}
+		if repair {
+			account.Securities = strings.Join(securityKeys, ",")
+			account.TotalValue = strconv.FormatFloat(positionsValue, 'f', 2, 64)
+			err = chaincode.PutRecord(stub, accountNumber, &account)
+			if err != nil {
+				return nil, err
+			}
+			drift.Repaired = true
+		}
+		result.Accounts = append(result.Accounts, drift)
+	}
+	// Positions of an account that does not exist are reported, there is no account to repair them into
+	for accountNumber, records := range positions {
+		for securityKey := range records {
+			result.UnknownAccounts[accountNumber] = append(result.UnknownAccounts[accountNumber], securityKey)
+		}
+		sort.Strings(result.UnknownAccounts[accountNumber])
+	}
+
+	message := strconv.Itoa(len(result.Accounts)) + " of " + strconv.Itoa(result.AccountsChecked) + " accounts drifted from their positions"
+	if repair {
+		message += " and were repaired"
+	}
+	err = chaincode.SendEvent(stub, "reconcile_accounts", chaincode.Entities{}, message, result)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end reconcile_accounts: " + message)
+	return json.Marshal(result)
+}
@@ -0,0 +1,55 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package account
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+// ============================================================================================================================
+// migrate_records - upgrade the accounts and their securities on the ledger in place to the canonical format
+// ============================================================================================================================
+func (t *ManageAccounts) migrate_records(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("start migrate_records")
+	accountIndexAsBytes, err := stub.GetState(AccountIndexStr)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "migrate_records", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get Account index")
+	}
+	var accountIndex []string
+	json.Unmarshal(accountIndexAsBytes, &accountIndex)
+	result := chaincode.NewMigrationResult()
+	for _, accountNumber := range accountIndex {
+		chaincode.MigrateRecord(stub, accountNumber, &Accounts{}, result)
+		account := Accounts{}
+		accountAsBytes, _ := stub.GetState(accountNumber)
+		json.Unmarshal(accountAsBytes, &account)
+		for _, securityKey := range strings.Split(account.Securities, ",") {
+			if strings.TrimSpace(securityKey) != "" {
+				chaincode.MigrateRecord(stub, securityKey, &Securities{}, result)
+			}
+		}
+	}
+	return chaincode.MigrationDone(stub, result)
+}
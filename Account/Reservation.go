@@ -0,0 +1,480 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package account
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+	"github.com/mukutb/TCM/validation"
+)
+
+// name for the key/value that will store the ids of the reservations still holding quantity back
+var reservationIndexStr = "_reservationIndex"
+
+// Statuses of a reservation; only a reservation that is Reserved and has not expired holds quantity back
+var (
+	reservationReserved  = "Reserved"
+	reservationCommitted = "Committed" // the allocation moved the reserved quantities
+	reservationReleased  = "Released"
+	reservationExpired   = "Expired"
+)
+
+// Reservations hold quantities of the positions of an account for the allocation of a transaction,
+// so the allocations of other transactions do not plan with them. There is one per transaction and account
+type Reservations struct {
+	chaincode.Record
+	ReservationID string            `json:"reservationId"`
+	TransactionID string            `json:"transactionId"`
+	AccountNumber string            `json:"accountNumber"`
+	Quantities    map[string]string `json:"quantities"` // reserved quantity by security id
+	Status        string            `json:"status"`
+	ReservedDate  string            `json:"reservedDate"` // unix seconds
+	ExpiresAt     string            `json:"expiresAt"`    // unix seconds, an abandoned reservation stops holding quantity back then
+	ClosedDate    string            `json:"closedDate"`
+}
+
+// PositionAvailability is the quantity of a position other transactions have not reserved
+type PositionAvailability struct {
+	SecurityID string `json:"securityId"`
+	Quantity   string `json:"quantity"`
+	Reserved   string `json:"reserved"`
+	Available  string `json:"available"`
+}
+
+func reservationKey(transactionId string, accountNumber string) string {
+	return "_reservation-" + transactionId + "-" + accountNumber
+}
+
+// holding tells whether a reservation still holds quantity back at a time in unix seconds
+func (r Reservations) holding(now int64) bool {
+	expiresAt, err := strconv.ParseInt(r.ExpiresAt, 10, 64)
+	return r.Status == reservationReserved && (err != nil || now < expiresAt)
+}
+
+// activeReservations are the reservations in the index, reservations no longer holding quantity back leave it when closed
+func activeReservations(stub shim.ChaincodeStubInterface) ([]Reservations, error) {
+	indexAsBytes, err := stub.GetState(reservationIndexStr)
+	if err != nil {
+		return nil, errors.New("Failed to get reservation index")
+	}
+	var index []string
+	json.Unmarshal(indexAsBytes, &index)
+	reservations := []Reservations{}
+	for _, reservationId := range index {
+		reservationAsBytes, err := stub.GetState(reservationId)
+		if err != nil {
+			return nil, errors.New("Failed to get reservation " + reservationId)
+		}
+		reservation := Reservations{}
+		if json.Unmarshal(reservationAsBytes, &reservation) == nil && reservation.ReservationID == reservationId {
+			reservations = append(reservations, reservation)
+		}
+	}
+	return reservations, nil
+}
+
+// putReservationIndex stores the ids of the reservations still holding quantity back
+func putReservationIndex(stub shim.ChaincodeStubInterface, reservations []Reservations) error {
+	index := []string{}
+	for _, reservation := range reservations {
+		index = append(index, reservation.ReservationID)
+	}
+	jsonAsBytes, _ := json.Marshal(index)
+	return stub.PutState(reservationIndexStr, jsonAsBytes)
+}
+
+// reservedByOthers is the quantity of each security of an account the reservations of transactions other than the
+// excluded ones hold back
+func reservedByOthers(reservations []Reservations, accountNumber string, excluded map[string]bool, now int64) map[string]float64 {
+	reserved := make(map[string]float64)
+	for _, reservation := range reservations {
+		if reservation.AccountNumber != accountNumber || excluded[reservation.TransactionID] || !reservation.holding(now) {
+			continue
+		}
+		for securityId, quantity := range reservation.Quantities {
+			value, _ := strconv.ParseFloat(quantity, 64)
+			reserved[securityId] += value
+		}
+	}
+	return reserved
+}
+
+// ReservationRequest is what the allocation of a transaction asks to hold back on an account until it expires
+type ReservationRequest struct {
+	TransactionID string            `json:"transactionId"`
+	Quantities    map[string]string `json:"quantities"` // quantity by security id
+	ExpiresAt     string            `json:"expiresAt"`  // unix seconds
+}
+
+// validateReservation checks a request, its fields named after prefix
+func validateReservation(v *validation.Validator, prefix string, request ReservationRequest) {
+	v.Required(prefix+"transactionId", request.TransactionID)
+	for securityId, quantity := range request.Quantities {
+		v.NonNegative(prefix+"quantities."+securityId, quantity)
+	}
+	if _, err := strconv.ParseInt(request.ExpiresAt, 10, 64); err != nil {
+		v.Add(prefix+"expiresAt", "must be unix seconds")
+	}
+}
+
+// reserve replaces what each requesting transaction reserved on an account with its request, a request without
+// quantities releases. Either every request is reserved or, when other transactions hold too much back, none
+func reserve(stub shim.ChaincodeStubInterface, function string, accountNumber string, requests []ReservationRequest) ([]Reservations, error) {
+	entities := chaincode.Entities{AccountNumber: accountNumber}
+	AccountAsBytes, err := stub.GetState(accountNumber)
+	if err != nil {
+		return nil, chaincode.SendError(stub, function, chaincode.ErrUpstream, entities, "Failed to get Account "+accountNumber)
+	}
+	account := Accounts{}
+	json.Unmarshal(AccountAsBytes, &account)
+	if account.AccountNumber != accountNumber {
+		return nil, chaincode.SendError(stub, function, chaincode.ErrNotFound, entities, accountNumber+" Not Found.")
+	}
+	now, err := chaincode.TxSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+	reservations, err := activeReservations(stub)
+	if err != nil {
+		return nil, chaincode.SendError(stub, function, chaincode.ErrUpstream, entities, err.Error())
+	}
+	requesting := make(map[string]bool)
+	for _, request := range requests {
+		requesting[request.TransactionID] = true
+	}
+	reserved := reservedByOthers(reservations, accountNumber, requesting, now)
+
+	// Quantities are kept to two decimals, the requests together have to fit in what other transactions left
+	requested := make(map[string]float64)
+	kept := make([]map[string]string, len(requests))
+	for i, request := range requests {
+		kept[i] = make(map[string]string)
+		for securityId, quantity := range request.Quantities {
+			value, _ := strconv.ParseFloat(quantity, 64)
+			if value < 0.005 {
+				continue
+			}
+			kept[i][securityId] = strconv.FormatFloat(value, 'f', 2, 64)
+			requested[securityId] += value
+		}
+	}
+	securityIds := make([]string, 0, len(requested))
+	for securityId := range requested {
+		securityIds = append(securityIds, securityId)
+	}
+	sort.Strings(securityIds)
+	for _, securityId := range securityIds {
+		security := Securities{}
+		SecurityAsBytes, err := stub.GetState(accountNumber + "-" + securityId)
+		if err != nil {
+			return nil, chaincode.SendError(stub, function, chaincode.ErrUpstream, entities, "Failed to get Security "+accountNumber+"-"+securityId)
+		}
+		json.Unmarshal(SecurityAsBytes, &security)
+		held, _ := strconv.ParseFloat(security.SecurityQuantity, 64)
+		if available := held - reserved[securityId]; requested[securityId] > available+0.005 {
+			return nil, chaincode.SendError(stub, function, chaincode.ErrConflict, chaincode.Entities{AccountNumber: accountNumber, SecurityID: securityId},
+				fmt.Sprintf("Only %.2f of %s is available, %.2f is reserved by other transactions.", math.Max(available, 0), securityId, reserved[securityId]))
+		}
+	}
+
+	active := []Reservations{}
+	for _, other := range reservations {
+		if !(other.AccountNumber == accountNumber && requesting[other.TransactionID]) {
+			active = append(active, other)
+		}
+	}
+	written := []Reservations{}
+	for i, request := range requests {
+		reservation := Reservations{
+			ReservationID: reservationKey(request.TransactionID, accountNumber),
+			TransactionID: request.TransactionID,
+			AccountNumber: accountNumber,
+			Quantities:    kept[i],
+			Status:        reservationReserved,
+			ReservedDate:  strconv.FormatInt(now, 10),
+			ExpiresAt:     request.ExpiresAt,
+		}
+		if len(kept[i]) == 0 {
+			reservation.Status = reservationReleased
+			reservation.ClosedDate = reservation.ReservedDate
+		} else {
+			active = append(active, reservation)
+		}
+		err = chaincode.PutRecord(stub, reservation.ReservationID, &reservation)
+		if err != nil {
+			return nil, err
+		}
+		written = append(written, reservation)
+	}
+	return written, putReservationIndex(stub, active)
+}
+
+// ============================================================================================================================
+// reserve_securities - hold quantities of the positions of an Account back for the allocation of a transaction until they
+// expire. 'Quantities' is a JSON object of quantities by security id, it replaces what the transaction reserved on the
+// Account before and an empty object releases it. Quantities other transactions reserved cannot be reserved again
+// ============================================================================================================================
+func (t *ManageAccounts) reserve_securities(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 4 {
+		return nil, chaincode.SendError(stub, "reserve_securities", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'TransactionID', 'AccountNumber', 'Quantities' and 'ExpiresAt'")
+	}
+	fmt.Println("start reserve_securities")
+	request := ReservationRequest{TransactionID: args[0], ExpiresAt: args[3]}
+	entities := chaincode.Entities{TransactionID: args[0], AccountNumber: args[1]}
+	v := validation.Validator{}
+	v.Required("accountNumber", args[1])
+	if json.Unmarshal([]byte(args[2]), &request.Quantities) != nil {
+		v.Add("quantities", "must be a JSON object of quantities by security id")
+	}
+	validateReservation(&v, "", request)
+	if err := v.Err(); err != nil {
+		return nil, chaincode.SendInvalid(stub, "reserve_securities", entities, err)
+	}
+	written, err := reserve(stub, "reserve_securities", args[1], []ReservationRequest{request})
+	if err != nil {
+		return nil, err
+	}
+	reservation := written[0]
+	err = chaincode.SendEvent(stub, "reserve_securities", entities, "Securities reserved succcessfully", map[string]string{"status": reservation.Status, "expiresAt": reservation.ExpiresAt})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end reserve_securities")
+	return json.Marshal(reservation)
+}
+
+// ============================================================================================================================
+// reserve_allocations - reserve_securities for the allocations of several transactions on an Account at once, so one
+// invocation can reserve collateral planned for several calls. 'Reservations' is a JSON array of requests
+// ============================================================================================================================
+func (t *ManageAccounts) reserve_allocations(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, chaincode.SendError(stub, "reserve_allocations", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'AccountNumber' and 'Reservations'")
+	}
+	fmt.Println("start reserve_allocations")
+	_accountNumber := args[0]
+	var requests []ReservationRequest
+	v := validation.Validator{}
+	v.Required("accountNumber", _accountNumber)
+	if json.Unmarshal([]byte(args[1]), &requests) != nil {
+		v.Add("reservations", "must be a JSON array of reservations")
+	}
+	seen := make(map[string]bool)
+	for i, request := range requests {
+		prefix := "reservations[" + strconv.Itoa(i) + "]."
+		validateReservation(&v, prefix, request)
+		if seen[request.TransactionID] {
+			v.Add(prefix+"transactionId", "is requested more than once")
+		}
+		seen[request.TransactionID] = true
+	}
+	if err := v.Err(); err != nil {
+		return nil, chaincode.SendInvalid(stub, "reserve_allocations", chaincode.Entities{AccountNumber: _accountNumber}, err)
+	}
+	written, err := reserve(stub, "reserve_allocations", _accountNumber, requests)
+	if err != nil {
+		return nil, err
+	}
+	err = chaincode.SendEvent(stub, "reserve_allocations", chaincode.Entities{AccountNumber: _accountNumber}, strconv.Itoa(len(written))+" reservations made succcessfully", nil)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end reserve_allocations")
+	return json.Marshal(written)
+}
+
+// closeReservations closes the reservations the filter accepts with a status, they stop holding quantity back
+func closeReservations(stub shim.ChaincodeStubInterface, status string, filter func(Reservations) bool) ([]Reservations, error) {
+	now, err := chaincode.TxSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+	reservations, err := activeReservations(stub)
+	if err != nil {
+		return nil, err
+	}
+	active, closed := []Reservations{}, []Reservations{}
+	for _, reservation := range reservations {
+		if !filter(reservation) {
+			active = append(active, reservation)
+			continue
+		}
+		reservation.Status = status
+		reservation.ClosedDate = strconv.FormatInt(now, 10)
+		if err = chaincode.PutRecord(stub, reservation.ReservationID, &reservation); err != nil {
+			return nil, err
+		}
+		closed = append(closed, reservation)
+	}
+	if len(closed) == 0 {
+		return closed, nil
+	}
+	return closed, putReservationIndex(stub, active)
+}
+
+// closeTransactionReservations closes what a transaction reserved on every account, for commit_reservations and release_reservations
+func (t *ManageAccounts) closeTransactionReservations(stub shim.ChaincodeStubInterface, function string, status string, args []string) ([]byte, error) {
+	if len(args) != 1 || strings.TrimSpace(args[0]) == "" {
+		return nil, chaincode.SendError(stub, function, chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'TransactionID' as an argument")
+	}
+	fmt.Println("start " + function)
+	_transactionId := args[0]
+	closed, err := closeReservations(stub, status, func(r Reservations) bool { return r.TransactionID == _transactionId })
+	if err != nil {
+		return nil, chaincode.SendError(stub, function, chaincode.ErrUpstream, chaincode.Entities{TransactionID: _transactionId}, err.Error())
+	}
+	err = chaincode.SendEvent(stub, function, chaincode.Entities{TransactionID: _transactionId}, strconv.Itoa(len(closed))+" reservations "+strings.ToLower(status), nil)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end " + function)
+	return json.Marshal(closed)
+}
+
+// ============================================================================================================================
+// commit_reservations - the allocation of a transaction moved what it reserved, its reservations stop holding quantity back
+// ============================================================================================================================
+func (t *ManageAccounts) commit_reservations(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return t.closeTransactionReservations(stub, "commit_reservations", reservationCommitted, args)
+}
+
+// ============================================================================================================================
+// release_reservations - the allocation of a transaction no longer needs what it reserved
+// ============================================================================================================================
+func (t *ManageAccounts) release_reservations(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return t.closeTransactionReservations(stub, "release_reservations", reservationReleased, args)
+}
+
+// ============================================================================================================================
+// expire_reservations - close the reservations that expired. Expired reservations already stop holding quantity back,
+// this takes them out of the index of the reservations that do
+// ============================================================================================================================
+func (t *ManageAccounts) expire_reservations(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) > 1 {
+		return nil, chaincode.SendError(stub, "expire_reservations", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting none")
+	}
+	fmt.Println("start expire_reservations")
+	now, err := chaincode.TxSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+	closed, err := closeReservations(stub, reservationExpired, func(r Reservations) bool { return !r.holding(now) })
+	if err != nil {
+		return nil, chaincode.SendError(stub, "expire_reservations", chaincode.ErrUpstream, chaincode.Entities{}, err.Error())
+	}
+	err = chaincode.SendEvent(stub, "expire_reservations", chaincode.Entities{}, strconv.Itoa(len(closed))+" reservations expired", nil)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end expire_reservations")
+	return json.Marshal(closed)
+}
+
+// ============================================================================================================================
+// getAvailability_byAccount - the quantity of each position of an Account that is reserved and available.
+// Reservations of 'TransactionIDs', comma separated when given, count as available: they are held for those transactions
+// ============================================================================================================================
+func (t *ManageAccounts) getAvailability_byAccount(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, chaincode.SendError(stub, "getAvailability_byAccount", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'AccountNumber' and optionally 'TransactionIDs'")
+	}
+	fmt.Println("start getAvailability_byAccount")
+	_accountNumber := args[0]
+	excluded := make(map[string]bool)
+	if len(args) == 2 {
+		for _, transactionId := range strings.Split(args[1], ",") {
+			excluded[strings.TrimSpace(transactionId)] = true
+		}
+	}
+	AccountAsBytes, err := stub.GetState(_accountNumber)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "getAvailability_byAccount", chaincode.ErrUpstream, chaincode.Entities{AccountNumber: _accountNumber}, "Failed to get Account "+_accountNumber)
+	}
+	account := Accounts{}
+	json.Unmarshal(AccountAsBytes, &account)
+	if account.AccountNumber != _accountNumber {
+		return nil, chaincode.SendError(stub, "getAvailability_byAccount", chaincode.ErrNotFound, chaincode.Entities{AccountNumber: _accountNumber}, _accountNumber+" Not Found.")
+	}
+	now, err := chaincode.TxSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+	reservations, err := activeReservations(stub)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "getAvailability_byAccount", chaincode.ErrUpstream, chaincode.Entities{AccountNumber: _accountNumber}, err.Error())
+	}
+	reserved := reservedByOthers(reservations, _accountNumber, excluded, now)
+
+	positions := []PositionAvailability{}
+	for _, key := range strings.Split(account.Securities, ",") {
+		if strings.TrimSpace(key) == "" {
+			continue
+		}
+		SecurityAsBytes, err := stub.GetState(key)
+		if err != nil {
+			return nil, chaincode.SendError(stub, "getAvailability_byAccount", chaincode.ErrUpstream, chaincode.Entities{AccountNumber: _accountNumber}, "Failed to get Security "+key)
+		}
+		security := Securities{}
+		json.Unmarshal(SecurityAsBytes, &security)
+		if security.SecurityId == "" {
+			continue
+		}
+		quantity, _ := strconv.ParseFloat(security.SecurityQuantity, 64)
+		held := math.Min(reserved[security.SecurityId], quantity)
+		positions = append(positions, PositionAvailability{
+			SecurityID: security.SecurityId,
+			Quantity:   strconv.FormatFloat(quantity, 'f', 2, 64),
+			Reserved:   strconv.FormatFloat(held, 'f', 2, 64),
+			Available:  strconv.FormatFloat(quantity-held, 'f', 2, 64),
+		})
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i].SecurityID < positions[j].SecurityID })
+	fmt.Println("end getAvailability_byAccount")
+	return json.Marshal(positions)
+}
+
+// ============================================================================================================================
+// getReservation_byTransactionID - the reservation a transaction holds or held on an Account
+// ============================================================================================================================
+func (t *ManageAccounts) getReservation_byTransactionID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, chaincode.SendError(stub, "getReservation_byTransactionID", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'TransactionID' and 'AccountNumber'")
+	}
+	fmt.Println("start getReservation_byTransactionID")
+	_transactionId := args[0]
+	_accountNumber := args[1]
+	reservationAsBytes, err := stub.GetState(reservationKey(_transactionId, _accountNumber))
+	if err != nil {
+		return nil, chaincode.SendError(stub, "getReservation_byTransactionID", chaincode.ErrUpstream, chaincode.Entities{TransactionID: _transactionId, AccountNumber: _accountNumber}, "Failed to get reservation of "+_transactionId)
+	}
+	if reservationAsBytes == nil {
+		return nil, chaincode.SendError(stub, "getReservation_byTransactionID", chaincode.ErrNotFound, chaincode.Entities{TransactionID: _transactionId, AccountNumber: _accountNumber}, _transactionId+" holds no reservation on "+_accountNumber+".")
+	}
+	fmt.Println("end getReservation_byTransactionID")
+	return reservationAsBytes, nil
+}
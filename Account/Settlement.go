@@ -0,0 +1,112 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package account
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+// ============================================================================================================================
+// credit_security - add a settled quantity of a Security to an Account, on top of what the Account already holds.
+// Takes the same arguments as add_security with 'securityQuantity' and 'totalValue' being the amounts credited
+// ============================================================================================================================
+func (t *ManageAccounts) credit_security(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 12 {
+		return nil, chaincode.SendError(stub, "credit_security", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 12")
+	}
+	fmt.Println("start credit_security")
+	if err = validateSecurity(args); err != nil {
+		return nil, chaincode.SendInvalid(stub, "credit_security", chaincode.Entities{AccountNumber: args[1], SecurityID: args[0]}, err)
+	}
+	_securityId := args[0]
+	_accountNumber := args[1]
+	quantity, err := strconv.ParseFloat(args[3], 64)
+	if err != nil || quantity <= 0 {
+		return nil, chaincode.SendError(stub, "credit_security", chaincode.ErrValidation, chaincode.Entities{AccountNumber: _accountNumber, SecurityID: _securityId}, "Quantity credited must be a number greater than 0.")
+	}
+	value, _ := strconv.ParseFloat(args[6], 64)
+
+	AccountAsBytes, err := stub.GetState(_accountNumber)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "credit_security", chaincode.ErrUpstream, chaincode.Entities{AccountNumber: _accountNumber}, "Failed to get Account "+_accountNumber)
+	}
+	account := Accounts{}
+	json.Unmarshal(AccountAsBytes, &account)
+	if account.AccountNumber != _accountNumber {
+		return nil, chaincode.SendError(stub, "credit_security", chaincode.ErrNotFound, chaincode.Entities{AccountNumber: _accountNumber}, _accountNumber+" Not Found.")
+	}
+
+	_securityKey := _accountNumber + "-" + _securityId
+	SecurityAsBytes, err := stub.GetState(_securityKey)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "credit_security", chaincode.ErrUpstream, chaincode.Entities{SecurityID: _securityKey}, "Failed to get Security "+_securityKey)
+	}
+	security := Securities{}
+	json.Unmarshal(SecurityAsBytes, &security)
+	isNew := security.SecurityId == ""
+	heldQuantity, _ := strconv.ParseFloat(security.SecurityQuantity, 64)
+	heldValue, _ := strconv.ParseFloat(security.TotalValue, 64)
+	security = Securities{
+		SecurityId:          _securityId,
+		AccountNumber:       _accountNumber,
+		SecurityName:        args[2],
+		SecurityQuantity:    strconv.FormatFloat(heldQuantity+quantity, 'f', 2, 64),
+		SecurityType:        args[4],
+		CollateralForm:      args[5],
+		TotalValue:          strconv.FormatFloat(heldValue+value, 'f', 2, 64),
+		ValuePercentage:     args[7],
+		MTM:                 args[8],
+		EffectivePercentage: args[9],
+		EffectiveValueinUSD: args[10],
+		Currency:            args[11],
+	}
+	err = chaincode.PutRecord(stub, _securityKey, &security)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the account's security list and total value in line with the position
+	if isNew {
+		if account.Securities == " " || account.Securities == "" {
+			account.Securities = _securityKey
+		} else {
+			account.Securities = account.Securities + "," + _securityKey
+		}
+	}
+	totalValue, _ := strconv.ParseFloat(account.TotalValue, 64)
+	account.TotalValue = strconv.FormatFloat(totalValue+value, 'f', -1, 64)
+	err = chaincode.PutRecord(stub, _accountNumber, &account)
+	if err != nil {
+		return nil, err
+	}
+
+	err = chaincode.SendEvent(stub, "credit_security", chaincode.Entities{AccountNumber: _accountNumber, SecurityID: _securityId}, "Security credited succcessfully", map[string]string{"securityQuantity": security.SecurityQuantity})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end credit_security")
+	return nil, nil
+}
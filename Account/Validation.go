@@ -0,0 +1,47 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package account
+
+import "github.com/mukutb/TCM/validation"
+
+// validateAccount checks the fields create_account and update_account take
+func validateAccount(args []string) error {
+	v := validation.Validator{}
+	v.Required("accountNumber", args[2])
+	v.Number("totalValue", args[4])
+	v.Currency("currency", args[5])
+	return v.Err()
+}
+
+// validateSecurity checks the fields add_security, update_security and credit_security take
+func validateSecurity(args []string) error {
+	v := validation.Validator{}
+	v.Required("securityId", args[0])
+	v.Required("accountNumber", args[1])
+	v.NonNegative("securityQuantity", args[3])
+	v.CollateralForm("collateralForm", args[5])
+	v.Number("totalValue", args[6])
+	v.NonNegative("valuePercentage", args[7])
+	v.Number("mtm", args[8])
+	v.NonNegative("effectivePercentage", args[9])
+	v.Number("effectiveValueinUSD", args[10])
+	v.Currency("currency", args[11])
+	return v.Err()
+}
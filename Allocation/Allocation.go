@@ -17,14 +17,14 @@ specific language governing permissions and limitations
 under the License.
 */
 
-package main
+package allocation
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
-	"github.com/hyperledger/fabric/core/chaincode/shim"
-	"github.com/hyperledger/fabric/core/util"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/mukutb/TCM/chaincode"
 	"math"
 	"net/http"
 	//"net/url"
@@ -48,6 +48,13 @@ type Transactions struct {
 	AllocationStatus       string `json:"allocationStatus"`
 	TransactionStatus      string `json:"transactionStatus"`
 	ComplianceStatus      string `json:"complianceStatus"`
+	Direction              string `json:"direction"` //"Call" or "Return" of collateral to the pledger
+}
+
+type MarginCallDeadline struct {
+	TransactionID string `json:"transactionId"`
+	Deadline      string `json:"deadline"` // unix seconds
+	DeadlineDate  string `json:"deadlineDate"`
 }
 
 type Deals struct { // Attributes of a Allocation
@@ -55,6 +62,11 @@ type Deals struct { // Attributes of a Allocation
 	Pledger                      string `json:"pledger"`
 	Pledgee                      string `json:"pledgee"`
 	MaxValue                     string `json:"maxValue"` //Maximum Value of all the securities of each Collateral Form
+	CashInterestRate             string `json:"cashInterestRate"` //Annual interest rate in percent paid on cash posted as collateral
+	IncomeTreatment              string `json:"incomeTreatment"` //"PassThrough" income to the pledger or "Retain" it as collateral
+	MinimumTransferAmount        string `json:"minimumTransferAmount"` //Smallest rebalance of the segregated account worth moving
+	BaseCurrency                 string `json:"baseCurrency"` //Currency RQVs of the deal are expressed in
+	EligibleCollateral           string `json:"eligibleCollateral"` //Comma separated collateral forms the deal accepts, the ruleset decides when empty
 	TotalValueLongBoxAccount     string `json:"totalValueLongBoxAccount"`
 	TotalValueSegregatedAccount  string `json:"totalValueSegregatedAccount"`
 	IssueDate                    string `json:"issueDate"`
@@ -84,7 +96,7 @@ type Securities struct {
 	ValuePercentage     string `json:"valuePercentage"`
 	MTM                 string `json:"mtm"`
 	EffectivePercentage string `json:"effectivePercentage"`
-	EffectiveValueChanged string `json:"effectiveValueChanged"`
+	EffectiveValueinUSD string `json:"effectiveValueinUSD"`
 	Currency            string `json:"currency"`
 }
 
@@ -94,10 +106,14 @@ type Ruleset struct {
 	Security         map[string]map[string]float64 `json:"Security"`
 	BaseCurrency     string               `json:"BaseCurrency"`
 	EligibleCurrency []string             `json:"EligibleCurrency"`
+	Version          string               `json:"Version,omitempty"`
 }
 // Varaible record to be filled with the data from the JSON
 var rulesetFetched Ruleset
 
+// Service the exchange rates of an RQV currency are fetched from, as <ExchangeRateAPI>/latest?base=<currency>
+var ExchangeRateAPI = "http://api.fixer.io"
+
 // Used for Security Array Sort
 // Reference at https://play.golang.org/p/Rz9NCEVhGu
 type SecurityArrayStruct []Securities 
@@ -133,31 +149,17 @@ var SecurityJSON = map[string]map[string]string{
 	"Revenue Bonds":         map[string]string{"Concentration Limit": "15", "Priority": "12", "Valuation Percentage": "90"},
 	"Medium Term Note":       map[string]string{"Concentration Limit": "15", "Priority": "13", "Valuation Percentage": "89"},
 	"Short Term Investments": map[string]string{"Concentration Limit": "15", "Priority": "14", "Valuation Percentage": "87"},
-	"Builder Bonds":         map[string]string{"Concentration Limit": "15", "Priority": "15", "Valuation Percentage": "85"}}
-
-// ============================================================================================================================
-// Main - start the chaincode for Allocation management
-// ============================================================================================================================
-func main() {
-	err := shim.Start(new(ManageAllocations))
-	if err != nil {
-		fmt.Printf("Error starting Allocation management chaincode: %s", err)
-	}
-}
+	"Builder Bonds":         map[string]string{"Concentration Limit": "15", "Priority": "15", "Valuation Percentage": "85"},
+	"Cash":                  map[string]string{"Concentration Limit": "100", "Priority": "16", "Valuation Percentage": "100"}}
 
 // ============================================================================================================================
-// Init - reset all the things
+// reset - reset all the things, used at instantiation and as the "init" function
 // ============================================================================================================================
-func (t *ManageAllocations) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+func (t *ManageAllocations) reset(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var msg string
 	var err error
 	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting ' ' as an argument\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		}
-		return nil, nil
+		return nil, chaincode.SendError(stub, "init", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting ' ' as an argument")
 	}
 	// Initialize the chaincode
 	msg = args[0]
@@ -173,8 +175,7 @@ func (t *ManageAllocations) Init(stub shim.ChaincodeStubInterface, function stri
 		return nil, err
 	}
 
-	tosend := "{ \"message\" : \"ManageAllocations chaincode is deployed successfully.\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	err = chaincode.SendEvent(stub, "init", chaincode.Entities{}, "ManageAllocations chaincode is deployed successfully.", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -182,54 +183,40 @@ func (t *ManageAllocations) Init(stub shim.ChaincodeStubInterface, function stri
 }
 
 // ============================================================================================================================
-// Run - Our entry Dealint for Invocations - [LEGACY] obc-peer 4/25/2016
-// ============================================================================================================================
-func (t *ManageAllocations) Run(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Println("run is running " + function)
-	return t.Invoke(stub, function, args)
-}
-
-// ============================================================================================================================
-// Invoke - Our entry Dealint for Invocations
+// Init - called when the chaincode is instantiated or upgraded, an upgrade keeps the records of the ledger
 // ============================================================================================================================
-func (t *ManageAllocations) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Println("invoke is running " + function)
-
-	// Handle different functions
-	if function == "init" { // Initialize the chaincode state, used as reset
-		return t.Init(stub, "init", args)
-	} else if function == "start_allocation" { // Create a new Allocation
-		return t.start_allocation(stub, args)
-	} else if function == "LongboxAccountUpdated" { // Secondary Fire when Longbox account is updated
-		return t.LongboxAccountUpdated(stub, args)
-	}
-	fmt.Println("invoke did not find func: " + function)
-	errMsg := "{ \"message\" : \"Received unknown function invocation\", \"code\" : \"503\"}"
-	err := stub.SetEvent("errEvent", []byte(errMsg))
+func (t *ManageAllocations) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	indexAsBytes, err := stub.GetState("_init")
 	if err != nil {
-		return nil, err
+		return chaincode.Respond(nil, err)
 	}
-	return nil, nil
+	if indexAsBytes != nil {
+		return shim.Success(nil)
+	}
+	return chaincode.Respond(t.reset(stub, args))
 }
 
 // ============================================================================================================================
-// Query - Our entry Dealint for Queries
+// Invoke - Our entry point for Invocations and Queries, functions are routed by name
 // ============================================================================================================================
-
-func (t *ManageAllocations) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Println("query is running " + function)
-
-	// Handle different functions
-	/*if function == "nil" {
-		return t.nil(stub, args)
-	}*/
-	fmt.Println("Allocation does not support query functions.")
-	errMsg := "{ \"message\" : \"Allocation does not support query functions.\", \"code\" : \"503\"}"
-	err := stub.SetEvent("errEvent", []byte(errMsg))
-	if err != nil {
-		return nil, err
-	}
-	return nil, nil
+func (t *ManageAllocations) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return chaincode.Route(stub, payloadArgs, map[string]chaincode.Function{
+		"init":                                t.reset,                               // Initialize the chaincode state, used as reset
+		"start_allocation":                    t.start_allocation,                    // Create a new Allocation
+		"LongboxAccountUpdated":               t.LongboxAccountUpdated,               // Secondary Fire when Longbox account is updated
+		"process_corporate_action":            t.process_corporate_action,            // Corporate action on a pledged security
+		"update_settlement_status":            t.update_settlement_status,            // Settlement agent matched, settled or failed a movement
+		"retry_settlement":                    t.retry_settlement,                    // Instruct a failed movement again
+		"migrate_records":                     t.migrate_records,                     // Upgrade stored records to the current schema version
+		"getMovements_byTransactionID":        t.getMovements_byTransactionID,        // Movements instructed for the allocation of a transaction
+		"getFailedSettlements":                t.getFailedSettlements,                // Movements that failed and wait for a retry
+		"getAllocationReport_byTransactionID": t.getAllocationReport_byTransactionID, // Report stored when the transaction was allocated
+		"check_allocation":                    t.check_allocation,                    // Check the invariants of the allocation of a transaction
+		"plan_allocations":                    t.plan_allocations,                    // Transactions ready for allocation with the accounts of their deals
+		"optimise_allocations":                t.optimise_allocations,                // Assign a pledger's collateral to all of its open calls at once
+		"getResult_byID":                      chaincode.GetResult,                   // Result an invocation kept under the id its client chose
+	})
 }
 
 // ============================================================================================================================
@@ -238,41 +225,37 @@ func (t *ManageAllocations) Query(stub shim.ChaincodeStubInterface, function str
 func (t *ManageAllocations) LongboxAccountUpdated(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 
 	var err error
-	if len(args) != 4 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 4\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		}
-		return nil, nil
+	// A fourth argument carried the current hour in the past; it is accepted but the transaction timestamp is used instead
+	if len(args) != 3 && len(args) != 4 {
+		return nil, chaincode.SendError(stub, "LongboxAccountUpdated", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 3")
 	}
 	fmt.Println("start LongboxAccountUpdated")
 
 	_DealChaincode := args[0]
 	_AccountName := args[1]
 	_Role := args[2]
-	_CurrentTimeStamp := args[3]
 
 	fmt.Println("args: ", args)
 	var TransactionsDataFetched []Transactions
 
 	// Fetching Attl transactions for the user
 	function := "getTransactions_byUser"
-	QueryArgs := util.ToChaincodeArgs(function, _AccountName, _Role)
-	result, err := stub.QueryChaincode(_DealChaincode, QueryArgs)
+	QueryArgs := chaincode.ToChaincodeArgs(function, _AccountName, _Role)
+	result, err := chaincode.InvokeChaincode(stub, _DealChaincode, QueryArgs)
+	if chaincode.IsErrorCode(err, chaincode.ErrNotFound) {
+		// A user without transactions has nothing waiting for collateral
+		fmt.Println("No transactions for " + _AccountName)
+		return nil, nil
+	}
 	if err != nil {
-		errStr := fmt.Sprintf("Error in fetching Transactions from 'Deal' chaincode. Got error: %s", err.Error())
-		fmt.Printf(errStr)
-		return nil, errors.New(errStr)
+		return nil, chaincode.CalledError(stub, "LongboxAccountUpdated", chaincode.Entities{}, "Error in fetching Transactions from 'Deal' chaincode", err)
 	}
 	json.Unmarshal(result, &TransactionsDataFetched)
 
-	// Timestamp to Date/Time Objest in Go and Logic behind cutoff time
-	// Ref: https://play.golang.org/p/KJRigmHzu9
-
-	_CurrentTimeStampHour, err := strconv.ParseInt(_CurrentTimeStamp, 10, 64)
+	// Cutoff is judged on the time of this transaction, every peer sees the same one
+	txTimestamp, err := stub.GetTxTimestamp()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	var newAllStatus string
 
@@ -280,7 +263,27 @@ func (t *ManageAllocations) LongboxAccountUpdated(stub shim.ChaincodeStubInterfa
 
 		if ValueTransaction.AllocationStatus == "Pending due to insufficient collateral" {
 
-			if _CurrentTimeStampHour <=18 && _CurrentTimeStampHour >= 0 {
+			// Deadline of the margin call from the calendars and cutoff of its deal
+			function = "getMarginCallDeadline_byTransactionID"
+			QueryArgs = chaincode.ToChaincodeArgs(function, ValueTransaction.TransactionId)
+			deadlineAsBytes, err := chaincode.InvokeChaincode(stub, _DealChaincode, QueryArgs)
+			if chaincode.IsErrorCode(err, chaincode.ErrNotFound) || chaincode.IsErrorCode(err, chaincode.ErrValidation) {
+				// The transaction or its deal is gone, or its deal has no usable cutoff; the other transactions still count
+				fmt.Println("No deadline for " + ValueTransaction.TransactionId + ": " + err.Error())
+				continue
+			}
+			if err != nil {
+				return nil, chaincode.CalledError(stub, "LongboxAccountUpdated", chaincode.Entities{TransactionID: ValueTransaction.TransactionId}, "Error in fetching margin call deadline from 'Deal' chaincode", err)
+			}
+			var deadline MarginCallDeadline
+			json.Unmarshal(deadlineAsBytes, &deadline)
+			_Deadline, err := strconv.ParseInt(deadline.Deadline, 10, 64)
+			if err != nil {
+				fmt.Println("No deadline for " + ValueTransaction.TransactionId + ": " + string(deadlineAsBytes))
+				continue
+			}
+
+			if txTimestamp.Seconds <= _Deadline {
 				// New securites are uploaded in cutoff time
 				newAllStatus = "Ready for Allocation"
 			} else {
@@ -290,7 +293,7 @@ func (t *ManageAllocations) LongboxAccountUpdated(stub shim.ChaincodeStubInterfa
 
 			// Update allocation status of a transaction
 			function = "update_transaction"
-			invokeArgs := util.ToChaincodeArgs(function,
+			invokeArgs := chaincode.ToChaincodeArgs(function,
 				ValueTransaction.TransactionId,
 				ValueTransaction.TransactionDate,
 				ValueTransaction.DealID,
@@ -298,31 +301,27 @@ func (t *ManageAllocations) LongboxAccountUpdated(stub shim.ChaincodeStubInterfa
 				ValueTransaction.Pledgee,
 				ValueTransaction.RQV,
 				ValueTransaction.Currency,
-				"\""+ValueTransaction.CurrencyConversionRate+"\"",
+				ValueTransaction.CurrencyConversionRate,
 				ValueTransaction.MarginCAllDate,
 				newAllStatus,
 				ValueTransaction.TransactionStatus,
 				"NA")
 			fmt.Println(ValueTransaction)
-			result, err := stub.InvokeChaincode(_DealChaincode, invokeArgs)
+			result, err := chaincode.InvokeChaincode(stub, _DealChaincode, invokeArgs)
 			if err != nil {
-				errStr := fmt.Sprintf("Failed to update Transaction status from 'Deal' chaincode. Got error: %s", err.Error())
-				fmt.Printf(errStr)
-				return nil, errors.New(errStr)
+				return nil, chaincode.CalledError(stub, "LongboxAccountUpdated", chaincode.Entities{}, "Failed to update Transaction status from 'Deal' chaincode", err)
 			}
 			fmt.Println("Transaction hash returned: ", result)
 			fmt.Println(ValueTransaction.TransactionId + " updated with AllocationStatus as " + newAllStatus)
 
 			//Sending event call
-			tosend := "{ \"transactionId\" : \"" + ValueTransaction.TransactionId + "\", \"message\" : \"Transaction updated succcessfully with Allocation Status as " + newAllStatus + " \", \"code\" : \"200\"}"
-			err = stub.SetEvent("evtsender", []byte(tosend))
+			err = chaincode.SendEvent(stub, "LongboxAccountUpdated", chaincode.Entities{TransactionID: ValueTransaction.TransactionId}, "Transaction updated succcessfully with Allocation Status as " + newAllStatus + " ", nil)
 			if err != nil {
 				return nil, err
 			}
 		} else if ValueTransaction.TransactionStatus == "Ready for Allocation" {
 			//Sending event call
-			tosend := "{ \"transactionId\" : \"" + ValueTransaction.TransactionId + "\", \"message\" : \"Transaction updated succcessfully with Allocation Status as 'Ready for Allocation' \", \"code\" : \"200\"}"
-			err = stub.SetEvent("evtsender", []byte(tosend))
+			err = chaincode.SendEvent(stub, "LongboxAccountUpdated", chaincode.Entities{TransactionID: ValueTransaction.TransactionId}, "Transaction updated succcessfully with Allocation Status as 'Ready for Allocation' ", nil)
 			if err != nil {
 				return nil, err
 			}
@@ -339,12 +338,7 @@ func (t *ManageAllocations) LongboxAccountUpdated(stub shim.ChaincodeStubInterfa
 func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var err error
 	if len(args) != 8 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 8\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		}
-		return nil, nil
+		return nil, chaincode.SendError(stub, "start_allocation", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 8")
 	}
 	fmt.Println("start start_allocation")
 
@@ -358,19 +352,17 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 	PledgeeSegregatedAccount := args[6]
 	MarginCallTimpestamp := args[7]
 
-	// Json to create report
-	reportInJson := `{`
+	// Report of the allocation, stored for the transaction once the allocation is done
+	report := AllocationReport{MarketPrices: make(map[string]string)}
 
 	//-----------------------------------------------------------------------------
 
 	// Fetch Deal details from Blockchain
 	f := "getDeal_byID"
-	queryArgs := util.ToChaincodeArgs(f, DealID)
-	dealAsBytes, err := stub.QueryChaincode(DealChaincode, queryArgs)
+	queryArgs := chaincode.ToChaincodeArgs(f, DealID)
+	dealAsBytes, err := chaincode.InvokeChaincode(stub, DealChaincode, queryArgs)
 	if err != nil {
-		errStr := fmt.Sprintf("Failed to query chaincode. Got error: %s", err.Error())
-		fmt.Printf(errStr)
-		return nil, errors.New(errStr)
+		return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to get "+DealID+" from 'Deal' chaincode", err)
 	}
 	DealData := Deals{}
 	json.Unmarshal(dealAsBytes, &DealData)
@@ -378,12 +370,7 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 	if DealData.DealID == DealID {
 		fmt.Println("Deal found with DealID : " + DealID)
 	} else {
-		errMsg := "{ \"message\" : \"" + DealID + " Not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		}
-		return nil, nil
+		return nil, chaincode.SendError(stub, "start_allocation", chaincode.ErrNotFound, chaincode.Entities{DealID: DealID}, DealID + " Not Found.")
 	}
 
 	Pledger := DealData.Pledger
@@ -393,12 +380,10 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 
 	// Fetch Transaction details from Blockchain
 	function := "getTransaction_byID"
-	queryArgs = util.ToChaincodeArgs(function, TransactionID)
-	transactionAsBytes, err := stub.QueryChaincode(DealChaincode, queryArgs)
+	queryArgs = chaincode.ToChaincodeArgs(function, TransactionID)
+	transactionAsBytes, err := chaincode.InvokeChaincode(stub, DealChaincode, queryArgs)
 	if err != nil {
-		errStr := fmt.Sprintf("Failed to query chaincode. Got error: %s", err.Error())
-		fmt.Printf(errStr)
-		return nil, errors.New(errStr)
+		return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to get "+TransactionID+" from 'Deal' chaincode", err)
 	}
 	TransactionData := Transactions{}
 	json.Unmarshal(transactionAsBytes, &TransactionData)
@@ -406,13 +391,36 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 	if TransactionData.TransactionId == TransactionID {
 		fmt.Println("Transaction found with TransactionID : " + TransactionID)
 	} else {
-		errMsg := "{ \"message\" : \"" + TransactionID + " Not Found.\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
+		return nil, chaincode.SendError(stub, "start_allocation", chaincode.ErrNotFound, chaincode.Entities{TransactionID: TransactionID}, TransactionID + " Not Found.")
+	}
+	// Collateral still in flight for the deal is in neither account, allocating again would call it twice
+	unsettled, err := getMovements(stub, func(m Movements) bool {
+		return m.DealID == DealID && m.SettlementStatus != settlementSettled
+	})
+	if err != nil {
+		return nil, chaincode.SendError(stub, "start_allocation", chaincode.ErrUpstream, chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, err.Error())
+	}
+	if len(unsettled) > 0 {
+		return nil, chaincode.SendError(stub, "start_allocation", chaincode.ErrConflict, chaincode.Entities{DealID: DealID}, DealID + " has " + strconv.Itoa(len(unsettled)) + " movements waiting for settlement.")
+	}
+
+	// Movements are due by the margin call deadline of the deal, the margin call date when there is none
+	IntendedSettlementDate := MarginCallTimpestamp
+	ReservationExpiry := reservationExpiry(MarginCallTimpestamp, "")
+	queryArgs = chaincode.ToChaincodeArgs("getMarginCallDeadline_byTransactionID", TransactionID)
+	deadlineAsBytes, err := chaincode.InvokeChaincode(stub, DealChaincode, queryArgs)
+	if err != nil && !chaincode.IsErrorCode(err, chaincode.ErrValidation) {
+		return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to get margin call deadline from 'Deal' chaincode", err)
+	}
+	if err == nil {
+		var deadline MarginCallDeadline
+		json.Unmarshal(deadlineAsBytes, &deadline)
+		if deadline.DeadlineDate != "" {
+			IntendedSettlementDate = deadline.DeadlineDate
 		}
-		return nil, nil
+		ReservationExpiry = reservationExpiry(MarginCallTimpestamp, deadline.Deadline)
 	}
+
 	/*RQV,errBool := strconv.ParseFloat(TransactionData.RQV)*/
 	RQV, errBool := strconv.ParseFloat(TransactionData.RQV, 64)
 	if errBool != nil {
@@ -422,31 +430,30 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 	fmt.Println("RQV : ", RQV)
 	// RQV currency of a deal
 	RQVCurrency := TransactionData.Currency
+	if RQVCurrency == "" {
+		RQVCurrency = DealData.BaseCurrency
+	}
 	fmt.Println("RQVCurrency : ", RQVCurrency)
 	//-----------------------------------------------------------------------------
 
-	reportInJson += `"Deal ID" : "` + DealID + `",`
-	reportInJson += `"Transaction ID" : "` + TransactionID + `",`
-	reportInJson += `"Margin Call Date" : "` + MarginCallTimpestamp + `",`
-	reportInJson += `"Pledgee" : "` + Pledgee + `",`
-	reportInJson += `"Pledger" : "` + Pledger + `",`
-	reportInJson += `"Pledger Longbox Account" : "` + PledgerLongboxAccount + `",`
-	reportInJson += `"Pledgee Segregated Account" : "` + PledgeeSegregatedAccount + `",`
-	reportInJson += `"RQV" : "` + strconv.FormatFloat(RQV, 'f', 2, 64) + `",`
-	reportInJson += `"Currency" : "` + TransactionData.Currency + `",`
-
-	// SecurityJSON to String https://play.golang.org/p/_C21BONfZk
-	reportInJson += `"Public Rule Set" : {"US Treasury Bills":{"Valuation Percentage":"95","Concentration Limit":"25","Priority":"4"},"US Treasury Notes":{"Concentration Limit":"25","Priority":"6","Valuation Percentage":"95"},"Gilt":{"Priority":"7","Valuation Percentage":"94","Concentration Limit":"25"},"Common Stocks":{"Valuation Percentage":"97","Concentration Limit":"40","Priority":"1"},"Federal Agency Bonds":{"Concentration Limit":"20","Priority":"8","Valuation Percentage":"93"},"Convertible Bonds":{"Concentration Limit":"20","Priority":"11","Valuation Percentage":"90"},"Revenue Bonds":{"Concentration Limit":"15","Priority":"12","Valuation Percentage":"90"},"Medium Term Note":{"Priority":"13","Valuation Percentage":"89","Concentration Limit":"15"},"Corporate Bonds":{"Valuation Percentage":"97","Concentration Limit":"30","Priority":"2"},"Global Bonds":{"Concentration Limit":"20","Priority":"9","Valuation Percentage":"92"},"Builder Bonds":{"Concentration Limit":"15","Priority":"15","Valuation Percentage":"85"},"Sovereign Bonds":{"Concentration Limit":"25","Priority":"3","Valuation Percentage":"95"},"US Treasury Bonds":{"Priority":"5","Valuation Percentage":"95","Concentration Limit":"25"},"Preferrred Shares":{"Concentration Limit":"20","Priority":"10","Valuation Percentage":"91"},"Short Term Investments":{"Valuation Percentage":"87","Concentration Limit":"15","Priority":"14"}} ,`
+	report.DealID = DealID
+	report.TransactionID = TransactionID
+	report.MarginCallDate = MarginCallTimpestamp
+	report.Pledgee = Pledgee
+	report.Pledger = Pledger
+	report.PledgerLongboxAccount = PledgerLongboxAccount
+	report.PledgeeSegregatedAccount = PledgeeSegregatedAccount
+	report.RQV = strconv.FormatFloat(RQV, 'f', 2, 64)
+	report.Currency = TransactionData.Currency
+	report.PublicRuleSet = SecurityJSON
 	//-----------------------------------------------------------------------------
 
 	// Update allocation status to "Allocation in progress"
 	function = "update_transaction_AllocationStatus"
-	invokeArgs := util.ToChaincodeArgs(function, TransactionID, "Allocation in progress")
-	result, err := stub.InvokeChaincode(DealChaincode, invokeArgs)
+	invokeArgs := chaincode.ToChaincodeArgs(function, TransactionID, "Allocation in progress")
+	result, err := chaincode.InvokeChaincode(stub, DealChaincode, invokeArgs)
 	if err != nil {
-		errStr := fmt.Sprintf("Failed to update Transaction status from 'Deal' chaincode. Got error: %s", err.Error())
-		fmt.Printf(errStr)
-		return nil, errors.New(errStr)
+		return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to update Transaction status from 'Deal' chaincode", err)
 	}
 	fmt.Print("Transaction hash returned: ")
 	fmt.Println(result)
@@ -478,11 +485,7 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 	resp, err := client.Do(req)
 	if err != nil {
 		fmt.Println("Do: ", err)
-		errMsg := "{ \"message\" : \"Unable to fetch Security Ruleset at " + APIIP + ".\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		}
+		return nil, chaincode.SendError(stub, "start_allocation", chaincode.ErrUpstream, chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Unable to fetch Security Ruleset at " + APIIP + ".")
 	}
 
 	fmt.Println("The SecurityRuleset response is::" + strconv.Itoa(resp.StatusCode))
@@ -490,15 +493,14 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 	
 
 	// Use json.Decode for reading streams of JSON data and store it
+	// Decoding merges into the maps of a ruleset, the collateral forms of the last allocation must not carry over
+	rulesetFetched = Ruleset{}
 	if err := json.NewDecoder(resp.Body).Decode(&rulesetFetched); err != nil {
 		fmt.Println(err)
 	}
 
-	resbody, err := json.Marshal(rulesetFetched)
-	if err != nil {
-		fmt.Println(err)
-	}
-	reportInJson += `"Private Rule set" : ` + string(resbody) + `,`
+	report.PrivateRuleSet = rulesetFetched
+	report.RulesetVersion = rulesetVersion(rulesetFetched)
 
 	// Callers should close resp.Body when done reading from it
 	// Defer the closing of the body
@@ -506,7 +508,6 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 
 	fmt.Println("Ruleset : ")
 	fmt.Println(rulesetFetched)
-	//fmt.Println(resbody)
 
 	//-----------------------------------------------------------------------------
 
@@ -550,7 +551,7 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 			}
 		}
 	*/
-	url2 := fmt.Sprintf("http://api.fixer.io/latest?base=" + RQVCurrency)
+	url2 := fmt.Sprintf(ExchangeRateAPI + "/latest?base=" + RQVCurrency)
 
 	// Build the request
 	req2, err2 := http.NewRequest("GET", url2, nil)
@@ -568,11 +569,7 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 	resp2, err2 := client2.Do(req2)
 	if err2 != nil {
 		fmt.Println("Do: ", err2)
-		errMsg := "{ \"message\" : \"Unable to fetch Currency Exchange Rates from: " + url2 + ".\", \"code\" : \"503\"}"
-		err2 = stub.SetEvent("errEvent", []byte(errMsg))
-		if err2 != nil {
-			return nil, err2
-		}
+		return nil, chaincode.SendError(stub, "start_allocation", chaincode.ErrUpstream, chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Unable to fetch Currency Exchange Rates from: " + url2 + ".")
 	}
 
 	fmt.Println("The SecurityRuleset response is::" + strconv.Itoa(resp2.StatusCode))
@@ -585,11 +582,7 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 		fmt.Println(err)
 	}
 
-	respbody, err := json.Marshal(ConversionRate)
-	if err != nil {
-		fmt.Println(err)
-	}
-	reportInJson += `"Currency Conversion Rate" : ` + string(respbody) + `,`
+	report.ConversionRate = ConversionRate
 
 	// Callers should close resp.Body when done reading from it
 	// Defer the closing of the body
@@ -623,11 +616,34 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 	// Fetch Pledger & Pledgee securities for longbox and segregated accounts
 	function = "getSecurities_byAccount"
 
-	queryArgs = util.ToChaincodeArgs(function, PledgerLongboxAccount)
-	PledgerLongboxSecuritiesString, err := stub.QueryChaincode(AccountChainCode, queryArgs)
+	queryArgs = chaincode.ToChaincodeArgs(function, PledgerLongboxAccount)
+	PledgerLongboxSecuritiesString, err := chaincode.InvokeChaincode(stub, AccountChainCode, queryArgs)
+	if err != nil {
+		return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID, AccountNumber: PledgerLongboxAccount}, "Failed to get securities of "+PledgerLongboxAccount+" from 'Account' chaincode", err)
+	}
+
+	queryArgs = chaincode.ToChaincodeArgs(function, PledgeeSegregatedAccount)
+	PledgeeSegregatedSecuritiesString, err := chaincode.InvokeChaincode(stub, AccountChainCode, queryArgs)
+	if err != nil {
+		return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID, AccountNumber: PledgeeSegregatedAccount}, "Failed to get securities of "+PledgeeSegregatedAccount+" from 'Account' chaincode", err)
+	}
 
-	queryArgs = util.ToChaincodeArgs(function, PledgeeSegregatedAccount)
-	PledgeeSegregatedSecuritiesString, err := stub.QueryChaincode(AccountChainCode, queryArgs)
+	// Quantities other transactions reserved are held back from this allocation and stay in the longbox
+	ReservedByOthers, err := reservedByOthers(stub, AccountChainCode, PledgerLongboxAccount, TransactionID)
+	if err != nil {
+		return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID, AccountNumber: PledgerLongboxAccount}, "Failed to get reservations of "+PledgerLongboxAccount+" from 'Account' chaincode", err)
+	}
+	HeldBack := make(map[string]Securities)
+
+	// A transaction holding a reservation, as the optimiser assigns it, is allocated exactly what it reserved
+	now, err := chaincode.TxSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+	ReservedForTransaction, err := reservedFor(stub, AccountChainCode, PledgerLongboxAccount, TransactionID, now)
+	if err != nil {
+		return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID, AccountNumber: PledgerLongboxAccount}, "Failed to get reservation of "+TransactionID+" from 'Account' chaincode", err)
+	}
 
 	/**	Calculate the effective value and total value of each Security present in the Longbox account of the pledger
 	and the Segregated account of the pledgee
@@ -639,6 +655,8 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 	var PledgerLongboxSecuritiesJSON, PledgeeSegregatedSecuritiesJSON SecurityArrayStruct
 	json.Unmarshal(PledgerLongboxSecuritiesString, &PledgerLongboxSecuritiesJSON)
 	json.Unmarshal(PledgeeSegregatedSecuritiesString, &PledgeeSegregatedSecuritiesJSON)
+	report.PledgerLongboxHoldings = append([]Securities{}, PledgerLongboxSecuritiesJSON...)
+	report.PledgeeSegregatedHoldings = append([]Securities{}, PledgeeSegregatedSecuritiesJSON...)
 
 	TotalValuePledgerLongboxSecurities := make(map[string]float64)
 	TotalValuePledgeeSegregatedSecurities := make(map[string]float64)
@@ -657,47 +675,65 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 		tempSecurity := Securities{}
 		tempSecurity = value
 
-		// Check if Current Collateral Form type is acceptied in ruleset. If not skip it!
-		if len(rulesetFetched.Security[tempSecurity.CollateralForm]) > 0 {
+		// Check if Current Collateral Form type (and currency for cash) is acceptied in ruleset. If not skip it!
+		if acceptsCollateral(rulesetFetched, DealData.EligibleCollateral, tempSecurity) {
 
-			url2 := fmt.Sprintf("http://" + APIIP + "/MarketData/" + tempSecurity.SecurityId)
-
-			// Build the request
-			req2, err2 := http.NewRequest("GET", url2, nil)
-			if err2 != nil {
-				fmt.Println("Market rate fetch error: ", err2)
-				return nil, err2
+			reserved := ReservedByOthers[tempSecurity.SecurityId]
+			if ReservedForTransaction != nil {
+				// only the reserved quantity is allocated, the rest of the position is held back with what others reserved
+				quantity, _ := strconv.ParseFloat(tempSecurity.SecuritiesQuantity, 64)
+				reserved = math.Max(reserved, quantity-ReservedForTransaction[tempSecurity.SecurityId])
+			}
+			if reserved >= 0.005 {
+				quantity, _ := strconv.ParseFloat(tempSecurity.SecuritiesQuantity, 64)
+				reserved = math.Min(reserved, quantity)
+				HeldBack[tempSecurity.SecurityId] = slicePosition(tempSecurity, reserved)
+				tempSecurity = slicePosition(tempSecurity, quantity-reserved)
+				if quantity-reserved < 0.005 {
+					continue
+				}
 			}
 
-			// For control over HTTP client headers, redirect policy, and other settings, create a Client
-			// A Client is an HTTP cliPledgeeSegregatedSecuritiesent
-			client2 := &http.Client{}
-
-			// Send the request via a client
-			// Do sends an HTTP request and returns an HTTP response
-			resp2, err2 := client2.Do(req2)
-			if err2 != nil {
-				fmt.Println("Do: ", err2)
-				errMsg := "{ \"message\" : \"Unable to fetch Market Rates from: " + url2 + ".\", \"code\" : \"503\"}"
-				err2 = stub.SetEvent("errEvent", []byte(errMsg))
+			if isCash(tempSecurity) {
+				// Cash is valued at par, no market data needed
+				tempSecurity.MTM = "1"
+			} else {
+				url2 := fmt.Sprintf("http://" + APIIP + "/MarketData/" + tempSecurity.SecurityId)
+
+				// Build the request
+				req2, err2 := http.NewRequest("GET", url2, nil)
 				if err2 != nil {
+					fmt.Println("Market rate fetch error: ", err2)
 					return nil, err2
 				}
-			}
 
-			fmt.Println("The MarketData response is::" + strconv.Itoa(resp2.StatusCode))
+				// For control over HTTP client headers, redirect policy, and other settings, create a Client
+				// A Client is an HTTP cliPledgeeSegregatedSecuritiesent
+				client2 := &http.Client{}
 
-			var stringArr []string
+				// Send the request via a client
+				// Do sends an HTTP request and returns an HTTP response
+				resp2, err2 := client2.Do(req2)
+				if err2 != nil {
+					fmt.Println("Do: ", err2)
+					return nil, chaincode.SendError(stub, "start_allocation", chaincode.ErrUpstream, chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Unable to fetch Market Rates from: " + url2 + ".")
+				}
 
-			// Use json.Decode for reading streams of JSON data and store it
-			if err := json.NewDecoder(resp2.Body).Decode(&stringArr); err != nil {
-				fmt.Println(err)
-			}
-			// Callers should close resp.Body when done reading from it
-			// Defer the closing of the body
-			defer resp2.Body.Close()
+				fmt.Println("The MarketData response is::" + strconv.Itoa(resp2.StatusCode))
+
+				var stringArr []string
+
+				// Use json.Decode for reading streams of JSON data and store it
+				if err := json.NewDecoder(resp2.Body).Decode(&stringArr); err != nil {
+					fmt.Println(err)
+				}
+				// Callers should close resp.Body when done reading from it
+				// Defer the closing of the body
+				defer resp2.Body.Close()
 
-			tempSecurity.MTM = stringArr[0]
+				tempSecurity.MTM = stringArr[0]
+			}
+			report.MarketPrices[tempSecurity.SecurityId] = tempSecurity.MTM
 			// Storing the Value percentage in the security ruleset data itself
 			tempSecurity.ValuePercentage = strconv.FormatFloat(rulesetFetched.Security[tempSecurity.CollateralForm]["Valuation Percentage"], 'f', 2, 64)
 			//convert valuePercentage(string) to float
@@ -727,7 +763,7 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 			temp3 := (_changedMTM * tempValuePercentage)/100
 			fmt.Println("temp3")
 			fmt.Println(temp3)
-			tempSecurity.EffectiveValueChanged = strconv.FormatFloat(temp3, 'f', 2, 64)
+			tempSecurity.EffectiveValueinUSD = strconv.FormatFloat(temp3, 'f', 2, 64)
 			// Adding it to TotalValue
 			temp2, errBool := strconv.ParseFloat(tempSecurity.SecuritiesQuantity, 64)
 			if errBool != nil {
@@ -770,8 +806,9 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 		tempSecurity := Securities{}
 		tempSecurity = value
 
-		// Check if Current Collateral Form type is acceptied in ruleset. If not skip it!
-		if len(rulesetFetched.Security[tempSecurity.CollateralForm]) > 0 {
+		// Check if Current Collateral Form type (and currency for cash) is acceptied in ruleset. If not skip it!
+		if acceptsCollateral(rulesetFetched, DealData.EligibleCollateral, tempSecurity) {
+			report.MarketPrices[tempSecurity.SecurityId] = tempSecurity.MTM
 
 			// Storing the Value percentage in the security data itself
 			tempSecurity.ValuePercentage = SecurityJSON[tempSecurity.CollateralForm]["Valuation Percentage"]
@@ -802,7 +839,7 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 			temp3 := (_changedMTM * tempValuePercentage)/100
 			fmt.Println("temp3")
 			fmt.Println(temp3)
-			tempSecurity.EffectiveValueChanged = strconv.FormatFloat(temp3, 'f', 2, 64)
+			tempSecurity.EffectiveValueinUSD = strconv.FormatFloat(temp3, 'f', 2, 64)
 			// Adding it to TotalValue
 
 			temp2, errBool := strconv.ParseFloat(tempSecurity.SecuritiesQuantity, 64)
@@ -840,7 +877,8 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 			fmt.Println(tempSecurity.ValuePercentage)
 			// Append Securities to an array
 			PledgeeSegregatedSecurities = append(PledgeeSegregatedSecurities, tempSecurity)
-			CombinedSecurities = append(CombinedSecurities, tempSecurity)
+			// A security both accounts hold is allocated as one position, it is written back once
+			CombinedSecurities = poolPosition(CombinedSecurities, tempSecurity)
 		}
 
 	}
@@ -888,27 +926,59 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 	fmt.Println()
 	//-----------------------------------------------------------------------------
 
+	if belowMinimumTransfer(DealData, RQV, TotalValuePledgeeSegregated) {
+		// The segregated account already holds the RQV within the minimum transfer amount, nothing is moved
+		f := "update_transaction_AllocationStatus"
+		invoke_args := chaincode.ToChaincodeArgs(f, TransactionData.TransactionId, "Below minimum transfer amount")
+		result, err := chaincode.InvokeChaincode(stub, DealChaincode, invoke_args)
+		if err != nil {
+			return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to invoke chaincode", err)
+		}
+		fmt.Print("Update transaction returned : ")
+		fmt.Println(result)
+		// Nothing moves, collateral reserved for the transaction is free for others again
+		_, err = chaincode.InvokeChaincode(stub, AccountChainCode, chaincode.ToChaincodeArgs("release_reservations", TransactionID))
+		if err != nil {
+			return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to release reservations from 'Account' chaincode", err)
+		}
+		err = putUnallocatedReport(stub, report, MarginCallTimpestamp, "Below minimum transfer amount")
+		if err != nil {
+			return nil, err
+		}
+		err = chaincode.SendEvent(stub, "start_allocation", chaincode.Entities{TransactionID: TransactionData.TransactionId}, "Transaction not allocated as the change is below the minimum transfer amount.", nil)
+		if err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
 	if AvailableEligibleCollateral < RQV {
 		RQVLeft:= RQV - AvailableEligibleCollateral
 		// Update transaction's allocation status to "Pending due to insufficient collateral" and transaction status to "Pending"
 		f := "update_transaction"
-		invoke_args := util.ToChaincodeArgs(f, TransactionData.TransactionId,TransactionData.TransactionDate, TransactionData.DealID, TransactionData.Pledger,TransactionData.Pledgee, TransactionData.RQV, TransactionData.Currency,"\" \"", TransactionData.MarginCAllDate, "Pending due to insufficient collateral",TransactionData.TransactionStatus,"NA")
+		invoke_args := chaincode.ToChaincodeArgs(f, TransactionData.TransactionId,TransactionData.TransactionDate, TransactionData.DealID, TransactionData.Pledger,TransactionData.Pledgee, TransactionData.RQV, TransactionData.Currency," ", TransactionData.MarginCAllDate, "Pending due to insufficient collateral",TransactionData.TransactionStatus,"NA")
 		fmt.Println(TransactionData);
-		result, err := stub.InvokeChaincode(DealChaincode, invoke_args)
+		result, err := chaincode.InvokeChaincode(stub, DealChaincode, invoke_args)
 		if err != nil {
-			errStr := fmt.Sprintf("Failed to invoke chaincode. Got error: %s", err.Error())
-			fmt.Printf(errStr)
-			return nil, errors.New(errStr)
+			return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to invoke chaincode", err)
 		} 	
 		fmt.Print("Update transaction returned : ")
 		fmt.Println(result)
 		fmt.Println("Successfully updated allocation status to 'Pending' due to insufficient collateral'")
+	    err = putUnallocatedReport(stub, report, MarginCallTimpestamp, "Pending due to insufficient collateral")
+	    if err != nil {
+	        return nil, err
+	    }
 	    //Send a event to event handler
-	    tosend:= "{ \"transactionId\" : \"" + TransactionData.TransactionId + "\", \"message\" : \"Transaction Allocation updated succcessfully with status 'Pending' due to insufficient collateral.\", \"code\" : \"200\",\"RQVLeft\" : \"" + strconv.FormatFloat(RQVLeft, 'f', 2, 64) + "\"}"
-	    err = stub.SetEvent("evtsender", [] byte(tosend))
+	    err = chaincode.SendEvent(stub, "start_allocation", chaincode.Entities{TransactionID: TransactionData.TransactionId}, "Transaction Allocation updated succcessfully with status 'Pending' due to insufficient collateral.", map[string]string{"RQVLeft": strconv.FormatFloat(RQVLeft, 'f', 2, 64)})
 	    if err != nil {
 	        return nil, err
 	    }
+		// A call that cannot be met holds nothing back, collateral reserved for it is free for others again
+		_, err = chaincode.InvokeChaincode(stub, AccountChainCode, chaincode.ToChaincodeArgs("release_reservations", TransactionID))
+		if err != nil {
+			return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to release reservations from 'Account' chaincode", err)
+		}
 
 	    // Actual return of process end. 
 		
@@ -938,12 +1008,26 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 		SecuritiesAllocated := make(map[string]float64)
 		TotalValueAllocated := make(map[string]float64)
 		var ReallocatedSecurities []Securities
+
+		// What the transaction reserved is allocated in full, with what the segregated account holds, instead of by priority
+		PrioritisedSecurities := CombinedSecurities
+		if ReservedForTransaction != nil {
+			for _, valueSecurity := range CombinedSecurities {
+				securityQuantity, _ := strconv.ParseFloat(valueSecurity.SecuritiesQuantity, 64)
+				totalValue, _ := strconv.ParseFloat(valueSecurity.TotalValue, 64)
+				RQVLeft -= totalValue
+				ReallocatedSecurities = append(ReallocatedSecurities, valueSecurity)
+				SecuritiesAllocated[valueSecurity.SecurityId] = securityQuantity
+				TotalValueAllocated[valueSecurity.SecurityId] = totalValue
+			}
+			PrioritisedSecurities = nil
+		}
 		
 		// Iterating through all the securities 
 		// Label: PledgerLongboxSecuritiesIterator --> to be used for break statements
 		
 		CombinedSecuritiesIterator:
-		for _, valueSecurity := range CombinedSecurities {
+		for _, valueSecurity := range PrioritisedSecurities {
 			fmt.Println("RQVLeft: ", RQVLeft)
 			fmt.Println("TotalValuePledgeeSegregated: ", TotalValuePledgeeSegregated)
 			fmt.Println("TotalValuePledgerLongbox: ", TotalValuePledgerLongbox)
@@ -987,15 +1071,15 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 								fmt.Println(errBool)
 							}
 							fmt.Println("securityQuantity: ",securityQuantity)
-							effectiveValueChanged, errBool := strconv.ParseFloat(valueSecurity.EffectiveValueChanged, 64)
+							effectiveValueChanged, errBool := strconv.ParseFloat(valueSecurity.EffectiveValueinUSD, 64)
 							if errBool != nil {
 								fmt.Println(errBool)
 							}
 							fmt.Println("effectiveValueChanged: ",effectiveValueChanged)
-							QuantityToTakeout := math.Floor((RQVLeft * securityQuantity)/ totalValue)
+							QuantityToTakeout := sliceQuantity(valueSecurity, (RQVLeft * securityQuantity)/ totalValue)
 							fmt.Println("QuantityToTakeout: ", QuantityToTakeout)
 							if QuantityToTakeout ==0{
-								QuantityToTakeout = 1
+								QuantityToTakeout = minimumQuantity(valueSecurity)
 							}
 							totalValueToAllocate := QuantityToTakeout * effectiveValueChanged
 							fmt.Println(totalValueToAllocate)
@@ -1025,12 +1109,12 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 							fmt.Println(errBool)
 						}
 						fmt.Println("securityQuantity: ",securityQuantity)
-						effectiveValueChanged, errBool := strconv.ParseFloat(valueSecurity.EffectiveValueChanged, 64)
+						effectiveValueChanged, errBool := strconv.ParseFloat(valueSecurity.EffectiveValueinUSD, 64)
 						if errBool != nil {
 							fmt.Println(errBool)
 						}
 						fmt.Println("effectiveValueChanged: ",effectiveValueChanged)
-						QuantityToTakeout := math.Floor((rqvEligibleValueLeft * securityQuantity)/ totalValue)
+						QuantityToTakeout := sliceQuantity(valueSecurity, (rqvEligibleValueLeft * securityQuantity)/ totalValue)
 						fmt.Println("QuantityToTakeout: ", QuantityToTakeout)
 						totalValueToAllocate := QuantityToTakeout * effectiveValueChanged
 						fmt.Println("totalValueToAllocate: ", totalValueToAllocate)
@@ -1077,24 +1161,37 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 		if RQVLeft <= 0 {
 			//-----------------------------------------------------------------------------
 
+			// What the segregated account holds stays, the rest of the allocation is delivered and what it holds beyond goes back
+			SettledHeld, Returning := settlementSplit(PledgeeSegregatedSecuritiesJSON, ReallocatedSecurities)
+			MovementsInstructed := 0
+
+			// The quantities planned to leave the longbox are reserved for the transaction before anything moves
+			Planned := make(map[string]float64)
+			for i, valueSecurity := range ReallocatedSecurities {
+				allocatedQuantity, _ := strconv.ParseFloat(valueSecurity.SecuritiesQuantity, 64)
+				if delivered := allocatedQuantity - SettledHeld[i]; delivered >= 0.005 {
+					Planned[valueSecurity.SecurityId] += delivered
+				}
+			}
+			err = reserveCollateral(stub, AccountChainCode, TransactionID, PledgerLongboxAccount, Planned, ReservationExpiry)
+			if err != nil {
+				return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID, AccountNumber: PledgerLongboxAccount}, "Failed to reserve collateral in 'Account' chaincode", err)
+			}
+
 			// Flushing securities from both Accounts
 			// remove_securitiesFromAccount
 			function = "remove_securitiesFromAccount"
 
-			invokeArgs := util.ToChaincodeArgs(function, PledgerLongboxAccount)
-			result, err := stub.InvokeChaincode(AccountChainCode, invokeArgs)
+			invokeArgs := chaincode.ToChaincodeArgs(function, PledgerLongboxAccount)
+			result, err := chaincode.InvokeChaincode(stub, AccountChainCode, invokeArgs)
 			if err != nil {
-				errStr := fmt.Sprintf("Failed to flush "+PledgerLongboxAccount+" from 'Account' chaincode. Got error: %s", err.Error())
-				fmt.Printf(errStr)
-				return nil, errors.New(errStr)
+				return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to flush "+PledgerLongboxAccount+" from 'Account' chaincode", err)
 			}
 			fmt.Println(result)
-			invokeArgs2 := util.ToChaincodeArgs(function, PledgeeSegregatedAccount)
-			result2, err := stub.InvokeChaincode(AccountChainCode, invokeArgs2)
+			invokeArgs2 := chaincode.ToChaincodeArgs(function, PledgeeSegregatedAccount)
+			result2, err := chaincode.InvokeChaincode(stub, AccountChainCode, invokeArgs2)
 			if err != nil {
-				errStr := fmt.Sprintf("Failed to flush "+PledgeeSegregatedAccount+" from 'Account' chaincode. Got error: %s", err.Error())
-				fmt.Printf(errStr)
-				return nil, errors.New(errStr)
+				return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to flush "+PledgeeSegregatedAccount+" from 'Account' chaincode", err)
 			}
 			fmt.Println(result2)
 			fmt.Print("Securities removed from accounts")
@@ -1105,9 +1202,8 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 			// Function from Account Chaincode for
 			functionAddSecurity := "add_security" // Security Object
 
-			pledgerLongboxSecuritiesJson := `[`
 			// Update the existing Securities for Pledger Longbox A/c
-			for i, valueSecurity := range CombinedSecurities {
+			for _, valueSecurity := range CombinedSecurities {
 				securityQuantity, err := strconv.ParseFloat(valueSecurity.SecuritiesQuantity, 64)
 				if err != nil {
 					errStr := fmt.Sprintf("Failed to convert SecurityQuantity(string) to SecurityQuantity(int). Got error: %s", err.Error())
@@ -1126,19 +1222,42 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 				fmt.Println("newQuantity: ",newQuantity)
 				newTotalValue := totalValue - totalValueAllocated
 				fmt.Println("newTotalValue: ",newTotalValue)
-				/*effectiveValueChanged, err := strconv.ParseFloat(valueSecurity.EffectiveValueChanged, 64)
+				/*effectiveValueChanged, err := strconv.ParseFloat(valueSecurity.EffectiveValueinUSD, 64)
 				if err != nil {
-					errStr := fmt.Sprintf("Failed to convert effectiveValueChanged(string) to effectiveValueChanged(float64). Got error: %s", err.Error())
-					fmt.Printf(errStr)
-					return nil, errors.New(errStr)
+					return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to convert effectiveValueChanged(string) to effectiveValueChanged(float64)", err)
 				}
 				fmt.Println(effectiveValueChanged)
 				_totalValue := effectiveValueChanged * newQuantity*/
 				valueSecurity.TotalValue = strconv.FormatFloat(newTotalValue, 'f', 2, 64)
 
+				// Collateral returned from the segregated account reaches the longbox when the return settles
+				if returned := math.Min(newQuantity, Returning[valueSecurity.SecurityId]); returned >= 0.005 {
+					valueSecurity.SecuritiesQuantity = strconv.FormatFloat(newQuantity, 'f', 2, 64)
+					movement, err := instructMovement(stub, TransactionData, "Return", PledgeeSegregatedAccount, PledgerLongboxAccount, slicePosition(valueSecurity, returned), IntendedSettlementDate)
+					if err != nil {
+						return nil, err
+					}
+					MovementsInstructed++
+					report.Movements = append(report.Movements, movement)
+					Returning[valueSecurity.SecurityId] -= returned
+					valueSecurity = slicePosition(valueSecurity, newQuantity-returned)
+					newQuantity -= returned
+				}
+
+				// What other transactions reserved was held back from the allocation, it stays with the rest of the position
+				if heldBack, ok := HeldBack[valueSecurity.SecurityId]; ok {
+					heldQuantity, _ := strconv.ParseFloat(heldBack.SecuritiesQuantity, 64)
+					heldValue, _ := strconv.ParseFloat(heldBack.TotalValue, 64)
+					keptValue, _ := strconv.ParseFloat(valueSecurity.TotalValue, 64)
+					valueSecurity.TotalValue = strconv.FormatFloat(keptValue+heldValue, 'f', 2, 64)
+					newQuantity += heldQuantity
+					securityQuantity += heldQuantity
+					delete(HeldBack, valueSecurity.SecurityId)
+				}
+
 				if newQuantity <= securityQuantity && quantityAllocated >= 0 {
 					if newQuantity != 0 {
-						invokeArgs := util.ToChaincodeArgs(functionAddSecurity, valueSecurity.SecurityId,
+						invokeArgs := chaincode.ToChaincodeArgs(functionAddSecurity, valueSecurity.SecurityId,
 							PledgerLongboxAccount,
 							valueSecurity.SecuritiesName,
 							strconv.FormatFloat(newQuantity, 'f', 2, 64),
@@ -1148,41 +1267,58 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 							valueSecurity.ValuePercentage,
 							valueSecurity.MTM,
 							valueSecurity.EffectivePercentage,
-							valueSecurity.EffectiveValueChanged,
+							valueSecurity.EffectiveValueinUSD,
 							valueSecurity.Currency)
 						fmt.Println(valueSecurity)
-						result, err := stub.InvokeChaincode(AccountChainCode, invokeArgs)
+						result, err := chaincode.InvokeChaincode(stub, AccountChainCode, invokeArgs)
 						if err != nil {
-							errStr := fmt.Sprintf("Failed to update Security from 'Account' chaincode. Got error: %s", err.Error())
-							fmt.Printf(errStr)
-							return nil, errors.New(errStr)
+							return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to update Security from 'Account' chaincode", err)
 						}
 						fmt.Println(result)
-						sec, err := json.Marshal(valueSecurity)
-						if err != nil {
-							fmt.Println("Error while converting CombinedSecurities struct to string")
-						}
-						pledgerLongboxSecuritiesJson += string(sec)
-						if i < len(CombinedSecurities)-1 {
-							pledgerLongboxSecuritiesJson += `,`
-						}
+						valueSecurity.SecuritiesQuantity = strconv.FormatFloat(newQuantity, 'f', 2, 64)
+						report.PledgerLongboxSecurities = append(report.PledgerLongboxSecurities, valueSecurity)
 					}
 				}
 
 			}
 
-			pledgerLongboxSecuritiesJson += `]`
-			fmt.Println("pledgerLongboxSecuritiesJson:")
-			fmt.Println(pledgerLongboxSecuritiesJson)
-			compliance_status := "Regulatory Compliant"
-			totalValue_Pri := make(map[string]float64)
-			eligibleValue_Pub := make(map[string]float64)
-			reallocatedSecuritiesJson := `[`
-			// Update the new Securities to Pledgee Segregated A/c
-			for i, valueSecurity := range ReallocatedSecurities {
-				if valueSecurity.SecuritiesQuantity != "0.00" {
-					invokeArgs := util.ToChaincodeArgs(functionAddSecurity, valueSecurity.SecurityId,
-						PledgeeSegregatedAccount,
+			// Positions other transactions reserved in full were left out of the allocation, they stay in the longbox
+			for _, securityId := range sortedSecurityIds(HeldBack) {
+				heldBack := HeldBack[securityId]
+				invokeArgs := chaincode.ToChaincodeArgs(functionAddSecurity, heldBack.SecurityId,
+					PledgerLongboxAccount,
+					heldBack.SecuritiesName,
+					heldBack.SecuritiesQuantity,
+					heldBack.SecurityType,
+					heldBack.CollateralForm,
+					heldBack.TotalValue,
+					heldBack.ValuePercentage,
+					heldBack.MTM,
+					heldBack.EffectivePercentage,
+					heldBack.EffectiveValueinUSD,
+					heldBack.Currency)
+				_, err := chaincode.InvokeChaincode(stub, AccountChainCode, invokeArgs)
+				if err != nil {
+					return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to keep reserved "+heldBack.SecurityId+" in "+PledgerLongboxAccount+" from 'Account' chaincode", err)
+				}
+				report.PledgerLongboxSecurities = append(report.PledgerLongboxSecurities, heldBack)
+			}
+
+			// Securities the deal does not accept are not allocated, they stay where they were
+			for _, holdings := range []struct {
+				account    string
+				securities SecurityArrayStruct
+				kept       *[]Securities
+			}{
+				{PledgerLongboxAccount, PledgerLongboxSecuritiesJSON, &report.PledgerLongboxSecurities},
+				{PledgeeSegregatedAccount, PledgeeSegregatedSecuritiesJSON, &report.PledgeeIneligibleSecurities},
+			} {
+				for _, valueSecurity := range holdings.securities {
+					if acceptsCollateral(rulesetFetched, DealData.EligibleCollateral, valueSecurity) {
+						continue
+					}
+					invokeArgs := chaincode.ToChaincodeArgs(functionAddSecurity, valueSecurity.SecurityId,
+						holdings.account,
 						valueSecurity.SecuritiesName,
 						valueSecurity.SecuritiesQuantity,
 						valueSecurity.SecurityType,
@@ -1191,24 +1327,56 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 						valueSecurity.ValuePercentage,
 						valueSecurity.MTM,
 						valueSecurity.EffectivePercentage,
-						valueSecurity.EffectiveValueChanged,
+						valueSecurity.EffectiveValueinUSD,
 						valueSecurity.Currency)
-					fmt.Println(valueSecurity)
-					result, err := stub.InvokeChaincode(AccountChainCode, invokeArgs)
+					_, err := chaincode.InvokeChaincode(stub, AccountChainCode, invokeArgs)
 					if err != nil {
-						errStr := fmt.Sprintf("Failed to update Security from 'Account' chaincode. Got error: %s", err.Error())
-						fmt.Printf(errStr)
-						return nil, errors.New(errStr)
+						return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to keep "+valueSecurity.SecurityId+" in "+holdings.account+" from 'Account' chaincode", err)
 					}
-					fmt.Println(result)
-					sec, err := json.Marshal(valueSecurity)
-					if err != nil {
-						fmt.Println("Error while converting CombinedSecurities struct to string")
+					*holdings.kept = append(*holdings.kept, valueSecurity)
+				}
+			}
+
+			fmt.Println("report.PledgerLongboxSecurities:")
+			fmt.Println(report.PledgerLongboxSecurities)
+			compliance_status := "Regulatory Compliant"
+			totalValue_Pri := make(map[string]float64)
+			eligibleValue_Pub := make(map[string]float64)
+			// Update the new Securities to Pledgee Segregated A/c
+			for i, valueSecurity := range ReallocatedSecurities {
+				if valueSecurity.SecuritiesQuantity != "0.00" {
+					// Only what the segregated account already held is kept there, the rest is credited on settlement
+					if SettledHeld[i] > 0 {
+						heldSecurity := slicePosition(valueSecurity, SettledHeld[i])
+						invokeArgs := chaincode.ToChaincodeArgs(functionAddSecurity, heldSecurity.SecurityId,
+							PledgeeSegregatedAccount,
+							heldSecurity.SecuritiesName,
+							heldSecurity.SecuritiesQuantity,
+							heldSecurity.SecurityType,
+							heldSecurity.CollateralForm,
+							heldSecurity.TotalValue,
+							heldSecurity.ValuePercentage,
+							heldSecurity.MTM,
+							heldSecurity.EffectivePercentage,
+							heldSecurity.EffectiveValueinUSD,
+							heldSecurity.Currency)
+						fmt.Println(heldSecurity)
+						result, err := chaincode.InvokeChaincode(stub, AccountChainCode, invokeArgs)
+						if err != nil {
+							return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to update Security from 'Account' chaincode", err)
+						}
+						fmt.Println(result)
 					}
-					reallocatedSecuritiesJson += string(sec)
-					if i < len(ReallocatedSecurities)-1 {
-						reallocatedSecuritiesJson += `,`
+					allocatedQuantity, _ := strconv.ParseFloat(valueSecurity.SecuritiesQuantity, 64)
+					if delivered := allocatedQuantity - SettledHeld[i]; delivered >= 0.005 {
+						movement, err := instructMovement(stub, TransactionData, "Call", PledgerLongboxAccount, PledgeeSegregatedAccount, slicePosition(valueSecurity, delivered), IntendedSettlementDate)
+						if err != nil {
+							return nil, err
+						}
+						MovementsInstructed++
+						report.Movements = append(report.Movements, movement)
 					}
+					report.PledgeeSegregatedSecurities = append(report.PledgeeSegregatedSecurities, valueSecurity)
 					ConcentrationLimit_Pub, errBool1 := strconv.ParseFloat(SecurityJSON[valueSecurity.CollateralForm]["Concentration Limit"], 64)
 					if errBool1 != nil {
 						fmt.Println(errBool1)
@@ -1225,7 +1393,7 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 					}
 
 					//ValuationPercentage_Pri := rulesetFetched.Security[valueSecurity.CollateralForm]["Valuation Percentage"]
-					effectiveValueChanged_Pri, errBool4 := strconv.ParseFloat(valueSecurity.EffectiveValueChanged, 64)
+					effectiveValueChanged_Pri, errBool4 := strconv.ParseFloat(valueSecurity.EffectiveValueinUSD, 64)
 					if errBool4 != nil {
 						fmt.Println(errBool4)
 					}
@@ -1258,16 +1426,35 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 				}
 			}
 
-			reallocatedSecuritiesJson += `]`
+			//-----------------------------------------------------------------------------
+
+			// Nothing is written to the transaction or the report of an allocation that broke an invariant
+			invariants, err := checkAllocation(stub, AccountChainCode, report)
+			if err != nil {
+				return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to check allocation against 'Account' chaincode", err)
+			}
+			if !invariants.Passed {
+				return nil, invariantError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, invariants)
+			}
+
+			// The movements are instructed, the reservation of the planned quantities has been allocated
+			_, err = chaincode.InvokeChaincode(stub, AccountChainCode, chaincode.ToChaincodeArgs("commit_reservations", TransactionID))
+			if err != nil {
+				return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to commit reservations in 'Account' chaincode", err)
+			}
 
 			//-----------------------------------------------------------------------------
 
-			// Update Transaction data finally
+			// Update Transaction data finally, the allocation is only successful once its movements settled
+			AllocationStatus := "Allocation Successful"
+			if MovementsInstructed > 0 {
+				AllocationStatus = "Pending settlement"
+			}
 
 			ConversionRateAsBytes, _ := json.Marshal(ConversionRate) //marshal an emtpy array of strings to clear the index
 			ConversionRateAsString := string(ConversionRateAsBytes[:])
 			f := "update_transaction"
-			invoke_args := util.ToChaincodeArgs(f,
+			invoke_args := chaincode.ToChaincodeArgs(f,
 				TransactionData.TransactionId,
 				TransactionData.TransactionDate,
 				TransactionData.DealID,
@@ -1277,55 +1464,60 @@ func (t *ManageAllocations) start_allocation(stub shim.ChaincodeStubInterface, a
 				TransactionData.Currency,
 				ConversionRateAsString,
 				TransactionData.MarginCAllDate,
-				"Allocation Successful",
+				AllocationStatus,
 				TransactionData.TransactionStatus,
 				compliance_status)
 			fmt.Println(TransactionData)
-			res, err := stub.InvokeChaincode(DealChaincode, invoke_args)
+			res, err := chaincode.InvokeChaincode(stub, DealChaincode, invoke_args)
 			if err != nil {
-				errStr := fmt.Sprintf("Failed to invoke chaincode. Got error: %s", err.Error())
-				fmt.Printf(errStr)
-				return nil, errors.New(errStr)
+				return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to invoke chaincode", err)
 			}
 			fmt.Print("Update transaction returned hash: ")
 			fmt.Println(res)
-			fmt.Println("Successfully updated allocation status to 'Allocation Successful'")
+			fmt.Println("Successfully updated allocation status to '" + AllocationStatus + "'")
 			
 			
-			reportInJson += `"Pledger Longbox Securities" : ` + pledgerLongboxSecuritiesJson + `,`
-			reportInJson += `"Pledgee Segregated Securities" : ` + reallocatedSecuritiesJson + `,`
-			reportInJson += `"Allocation Date" : ` + MarginCallTimpestamp + `,`
-			reportInJson += `"Allocation Status" : "Allocation Successful"` + `,`
-			reportInJson += `"Compliance Status" : ` + "\""+compliance_status+ "\"" 
-			reportInJson += `}`
-			fmt.Println(reportInJson)
+			report.AllocationDate = MarginCallTimpestamp
+			report.AllocationStatus = AllocationStatus
+			report.IntendedSettlementDate = IntendedSettlementDate
+			report.ComplianceStatus = compliance_status
+			err = putAllocationReport(stub, report)
+			if err != nil {
+				return nil, err
+			}
 
 			//Sending Report
-			err = stub.SetEvent("evtsender", []byte(reportInJson))
+			fmt.Println(report)
+			err = chaincode.SendEvent(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Transaction allocated with status '"+AllocationStatus+"'", report)
 			if err != nil {
 				return nil, err
 			}
 		} else {
 			f := "update_transaction"
-			invoke_args := util.ToChaincodeArgs(f, TransactionData.TransactionId, TransactionData.TransactionDate, TransactionData.DealID, TransactionData.Pledger, TransactionData.Pledgee, TransactionData.RQV, TransactionData.Currency, "\" \"", TransactionData.MarginCAllDate, "Pending due to insufficient collateral", TransactionData.TransactionStatus,"NA")
+			invoke_args := chaincode.ToChaincodeArgs(f, TransactionData.TransactionId, TransactionData.TransactionDate, TransactionData.DealID, TransactionData.Pledger, TransactionData.Pledgee, TransactionData.RQV, TransactionData.Currency, " ", TransactionData.MarginCAllDate, "Pending due to insufficient collateral", TransactionData.TransactionStatus,"NA")
 			fmt.Println(TransactionData)
-			result, err := stub.InvokeChaincode(DealChaincode, invoke_args)
+			result, err := chaincode.InvokeChaincode(stub, DealChaincode, invoke_args)
 			if err != nil {
-				errStr := fmt.Sprintf("Failed to invoke chaincode. Got error: %s", err.Error())
-				fmt.Printf(errStr)
-				return nil, errors.New(errStr)
+				return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to invoke chaincode", err)
 			}
 			fmt.Print("Update transaction returned : ")
 			fmt.Println(result)
 			fmt.Println("Successfully updated allocation status to 'Pending' due to insufficient collateral'")
+			err = putUnallocatedReport(stub, report, MarginCallTimpestamp, "Pending due to insufficient collateral")
+			if err != nil {
+				return nil, err
+			}
 			//Send a event to event handler
-			tosend := "{ \"transactionId\" : \"" + TransactionData.TransactionId + "\", \"message\" : \"Transaction Allocation updated succcessfully with status 'Pending' due to insufficient collateral.\", \"code\" : \"200\",\"RQVLeft\" : \"" + strconv.FormatFloat(RQVLeft, 'f', 2, 64) + "\"}"
-			err = stub.SetEvent("evtsender", []byte(tosend))
+			err = chaincode.SendEvent(stub, "start_allocation", chaincode.Entities{TransactionID: TransactionData.TransactionId}, "Transaction Allocation updated succcessfully with status 'Pending' due to insufficient collateral.", map[string]string{"RQVLeft": strconv.FormatFloat(RQVLeft, 'f', 2, 64)})
 			if err != nil {
 				return nil, err
 			}
+			// A call that cannot be met holds nothing back, collateral reserved for it is free for others again
+			_, err = chaincode.InvokeChaincode(stub, AccountChainCode, chaincode.ToChaincodeArgs("release_reservations", TransactionID))
+			if err != nil {
+				return nil, chaincode.CalledError(stub, "start_allocation", chaincode.Entities{DealID: DealID, TransactionID: TransactionID}, "Failed to release reservations from 'Account' chaincode", err)
+			}
 		}
-		return nil, nil
 	}
 
 	fmt.Println("end start_allocation")
@@ -0,0 +1,238 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package allocation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+// Status of the transactions a batch run picks up
+const readyForAllocation = "Ready for Allocation"
+
+// PlannedAllocation is a transaction a batch run allocates, with the accounts of its deal and the arguments of start_allocation
+type PlannedAllocation struct {
+	TransactionID            string `json:"transactionId"`
+	DealID                   string `json:"dealId"`
+	Pledger                  string `json:"pledger"`
+	Pledgee                  string `json:"pledgee"`
+	MarginCallDate           string `json:"marginCallDate"`
+	RQV                      string `json:"rqv"`
+	Currency                 string `json:"currency"`
+	PledgerLongboxAccount    string `json:"pledgerLongboxAccount"`
+	PledgeeSegregatedAccount string `json:"pledgeeSegregatedAccount"`
+}
+
+// SkippedAllocation is a transaction ready for allocation a batch run leaves alone and why
+type SkippedAllocation struct {
+	TransactionID string `json:"transactionId"`
+	DealID        string `json:"dealId"`
+	Reason        string `json:"reason"`
+}
+
+// AllocationPlan is what a batch run allocates, in the order of the margin call dates
+type AllocationPlan struct {
+	DealID  string              `json:"dealId,omitempty"`
+	Pledger string              `json:"pledger,omitempty"`
+	Planned []PlannedAllocation `json:"planned"`
+	Skipped []SkippedAllocation `json:"skipped"`
+}
+
+// Args are the arguments of start_allocation for the planned transaction
+func (p PlannedAllocation) Args(dealChaincode string, accountChaincode string, apiIp string) []string {
+	return []string{"start_allocation", dealChaincode, accountChaincode, apiIp, p.DealID, p.TransactionID,
+		p.PledgerLongboxAccount, p.PledgeeSegregatedAccount, p.MarginCallDate}
+}
+
+// transactionsInStatus are the transactions in one of the allocation statuses, of a deal and a pledger when they are
+// given, the earliest margin call first. Dates that are not unix seconds go last, ties go by transaction id
+func transactionsInStatus(stub shim.ChaincodeStubInterface, dealChaincode string, dealId string, pledger string, statuses ...string) ([]Transactions, error) {
+	transactionsAsBytes, err := chaincode.InvokeChaincode(stub, dealChaincode, chaincode.ToChaincodeArgs("get_AllTransactions"))
+	if err != nil {
+		return nil, err
+	}
+	all := make(map[string]Transactions)
+	if err = json.Unmarshal(transactionsAsBytes, &all); err != nil {
+		return nil, fmt.Errorf("transactions of 'Deal' chaincode are not readable: %v", err)
+	}
+	inStatus := make(map[string]bool)
+	for _, status := range statuses {
+		inStatus[status] = true
+	}
+	var ready []Transactions
+	for _, transaction := range all {
+		if !inStatus[transaction.AllocationStatus] ||
+			(dealId != "" && transaction.DealID != dealId) || (pledger != "" && transaction.Pledger != pledger) {
+			continue
+		}
+		ready = append(ready, transaction)
+	}
+	sort.SliceStable(ready, func(i, j int) bool {
+		a, errA := strconv.ParseInt(ready[i].MarginCAllDate, 10, 64)
+		b, errB := strconv.ParseInt(ready[j].MarginCAllDate, 10, 64)
+		if (errA == nil) != (errB == nil) {
+			return errA == nil
+		}
+		if errA == nil && a != b {
+			return a < b
+		}
+		return ready[i].TransactionId < ready[j].TransactionId
+	})
+	return ready, nil
+}
+
+// accountsOfType are the accounts of a type, none when there are no such accounts
+func accountsOfType(stub shim.ChaincodeStubInterface, accountChaincode string, accountType string) ([]Accounts, error) {
+	accountsAsBytes, err := chaincode.InvokeChaincode(stub, accountChaincode, chaincode.ToChaincodeArgs("getAccount_byType", accountType))
+	if chaincode.IsErrorCode(err, chaincode.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	byNumber := make(map[string]Accounts)
+	if err = json.Unmarshal(accountsAsBytes, &byNumber); err != nil {
+		return nil, fmt.Errorf("%s accounts of 'Account' chaincode are not readable: %v", accountType, err)
+	}
+	accounts := make([]Accounts, 0, len(byNumber))
+	for _, account := range byNumber {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].AccountNumber < accounts[j].AccountNumber })
+	return accounts, nil
+}
+
+// dealAccount is the only account of a deal matching, a reason to skip the deal when there is none or more than one
+func dealAccount(accounts []Accounts, description string, matches func(Accounts) bool) (string, string) {
+	var numbers []string
+	for _, account := range accounts {
+		if matches(account) {
+			numbers = append(numbers, account.AccountNumber)
+		}
+	}
+	switch len(numbers) {
+	case 0:
+		return "", "no " + description
+	case 1:
+		return numbers[0], ""
+	}
+	return "", "more than one " + description + ": " + strings.Join(numbers, ", ")
+}
+
+// planAllocations selects the transactions in the allocation statuses and resolves the accounts of their deals: the
+// longbox account of the pledger and the segregated account the pledger holds for the pledgee. A transaction whose
+// deal or accounts cannot be told apart is skipped
+func planAllocations(stub shim.ChaincodeStubInterface, dealChaincode string, accountChaincode string, dealId string, pledger string, statuses ...string) (AllocationPlan, error) {
+	plan := AllocationPlan{DealID: dealId, Pledger: pledger, Planned: []PlannedAllocation{}, Skipped: []SkippedAllocation{}}
+	ready, err := transactionsInStatus(stub, dealChaincode, dealId, pledger, statuses...)
+	if err != nil || len(ready) == 0 {
+		return plan, err
+	}
+	longboxAccounts, err := accountsOfType(stub, accountChaincode, "Longbox")
+	if err != nil {
+		return plan, err
+	}
+	segregatedAccounts, err := accountsOfType(stub, accountChaincode, "Segregated")
+	if err != nil {
+		return plan, err
+	}
+
+	deals := make(map[string]*Deals)
+	for _, transaction := range ready {
+		skip := func(reason string) {
+			plan.Skipped = append(plan.Skipped, SkippedAllocation{TransactionID: transaction.TransactionId, DealID: transaction.DealID, Reason: reason})
+		}
+		deal, fetched := deals[transaction.DealID]
+		if !fetched {
+			dealAsBytes, err := chaincode.InvokeChaincode(stub, dealChaincode, chaincode.ToChaincodeArgs("getDeal_byID", transaction.DealID))
+			if err != nil && !chaincode.IsErrorCode(err, chaincode.ErrNotFound) {
+				return plan, err
+			}
+			if len(dealAsBytes) > 0 {
+				deal = &Deals{}
+				json.Unmarshal(dealAsBytes, deal)
+			}
+			deals[transaction.DealID] = deal
+		}
+		if deal == nil {
+			skip("deal " + transaction.DealID + " not found")
+			continue
+		}
+		longbox, reason := dealAccount(longboxAccounts, "longbox account of "+deal.Pledger, func(account Accounts) bool {
+			return account.Pledger == deal.Pledger
+		})
+		if reason != "" {
+			skip(reason)
+			continue
+		}
+		segregated, reason := dealAccount(segregatedAccounts, "segregated account of "+deal.Pledger+" for "+deal.Pledgee, func(account Accounts) bool {
+			return account.Pledger == deal.Pledger && account.AccountName == deal.Pledgee
+		})
+		if reason != "" {
+			skip(reason)
+			continue
+		}
+		plan.Planned = append(plan.Planned, PlannedAllocation{
+			TransactionID:            transaction.TransactionId,
+			DealID:                   transaction.DealID,
+			Pledger:                  deal.Pledger,
+			Pledgee:                  deal.Pledgee,
+			MarginCallDate:           transaction.MarginCAllDate,
+			RQV:                      transaction.RQV,
+			Currency:                 transaction.Currency,
+			PledgerLongboxAccount:    longbox,
+			PledgeeSegregatedAccount: segregated,
+		})
+	}
+	return plan, nil
+}
+
+// ============================================================================================================================
+// plan_allocations - the transactions ready for allocation, of a deal or a pledger when given, ordered by margin call date
+// with the accounts of their deals. A batch run invokes start_allocation for each planned transaction in its own transaction,
+// an allocation reads the accounts the one before it wrote and a failing allocation only rolls back itself
+// ============================================================================================================================
+func (t *ManageAllocations) plan_allocations(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 2 || len(args) > 4 {
+		return nil, chaincode.SendError(stub, "plan_allocations", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'DealChaincode', 'AccountChaincode' and optionally 'DealID' and 'Pledger'")
+	}
+	fmt.Println("start plan_allocations")
+	_dealChaincode := args[0]
+	_accountChaincode := args[1]
+	var _dealId, _pledger string
+	if len(args) > 2 {
+		_dealId = strings.TrimSpace(args[2])
+	}
+	if len(args) > 3 {
+		_pledger = strings.TrimSpace(args[3])
+	}
+	plan, err := planAllocations(stub, _dealChaincode, _accountChaincode, _dealId, _pledger, readyForAllocation)
+	if err != nil {
+		return nil, chaincode.CalledError(stub, "plan_allocations", chaincode.Entities{DealID: _dealId}, "Failed to plan allocations", err)
+	}
+	fmt.Println("end plan_allocations")
+	return json.Marshal(plan)
+}
@@ -0,0 +1,46 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package allocation
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// scheduleAccepts reports whether an eligible collateral schedule accepts a collateral form, an empty one accepts all
+func scheduleAccepts(eligibleCollateral string, collateralForm string) bool {
+	if eligibleCollateral == "" {
+		return true
+	}
+	for _, form := range strings.Split(eligibleCollateral, ",") {
+		if strings.TrimSpace(form) == collateralForm {
+			return true
+		}
+	}
+	return false
+}
+
+// belowMinimumTransfer reports whether moving the segregated account from what it holds to the RQV
+// is smaller than the minimum transfer amount of the deal, in which case nothing is moved
+func belowMinimumTransfer(deal Deals, rqv float64, held float64) bool {
+	minimumTransferAmount, _ := strconv.ParseFloat(deal.MinimumTransferAmount, 64)
+	return minimumTransferAmount > 0 && math.Abs(rqv-held) < minimumTransferAmount
+}
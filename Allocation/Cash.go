@@ -0,0 +1,66 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package allocation
+
+import (
+	"math"
+)
+
+// Cash positions carry this collateral form; their quantity is the balance and their MTM is 1
+var cashCollateralForm = "Cash"
+
+// isCash reports whether a position is a cash balance rather than a security
+func isCash(security Securities) bool {
+	return security.CollateralForm == cashCollateralForm
+}
+
+// acceptsCollateral reports whether a ruleset and the eligible collateral schedule of a deal accept a position.
+// Cash is accepted only in the ruleset's eligible currencies when that list is given.
+func acceptsCollateral(ruleset Ruleset, eligibleCollateral string, security Securities) bool {
+	if len(ruleset.Security[security.CollateralForm]) == 0 || !scheduleAccepts(eligibleCollateral, security.CollateralForm) {
+		return false
+	}
+	if !isCash(security) || len(ruleset.EligibleCurrency) == 0 {
+		return true
+	}
+	for _, currency := range ruleset.EligibleCurrency {
+		if currency == security.Currency {
+			return true
+		}
+	}
+	return false
+}
+
+// minimumQuantity is the smallest amount of a position that can be moved: one unit of a security or one cent of cash
+func minimumQuantity(security Securities) float64 {
+	if isCash(security) {
+		return 0.01
+	}
+	return 1
+}
+
+// sliceQuantity rounds a quantity down to what can be moved of a position: whole units of a security or cents of cash
+func sliceQuantity(security Securities, quantity float64) float64 {
+	if !isCash(security) {
+		return math.Floor(quantity)
+	}
+	// the small offset keeps amounts such as 0.29 from flooring to 0.28 through binary rounding
+	return math.Floor(quantity*100+1e-6) / 100
+}
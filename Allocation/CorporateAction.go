@@ -0,0 +1,261 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package allocation
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+// Outcome of a corporate action on a position, as returned by apply_corporate_action of the 'Account' chaincode
+type CorporateActionResult struct {
+	AccountNumber  string `json:"accountNumber"`
+	SecurityId     string `json:"securityId"`
+	NewSecurityId  string `json:"newSecurityId"`
+	EventType      string `json:"eventType"`
+	QuantityBefore string `json:"quantityBefore"`
+	QuantityAfter  string `json:"quantityAfter"`
+	Income         string `json:"income"`
+	Currency       string `json:"currency"`
+	ValueBefore    string `json:"valueBefore"`
+	ValueAfter     string `json:"valueAfter"`
+}
+
+// A corporate action processed for a deal, stored with "CA-" + eventId + "-" + dealId as key
+type CorporateActions struct {
+	chaincode.Record
+	EventID         string                `json:"eventId"`
+	DealID          string                `json:"dealId"`
+	EventType       string                `json:"eventType"`
+	SecurityID      string                `json:"securityId"`
+	IncomeTreatment string                `json:"incomeTreatment"`
+	IncomeAccount   string                `json:"incomeAccount"`
+	Result          CorporateActionResult `json:"result"`
+	Currency        string                `json:"currency"`        // of the amounts below, the currency of the deal
+	Requirement     string                `json:"requirement"`     // RQV of the latest transaction of the deal
+	CollateralValue string                `json:"collateralValue"` // of the segregated account after the event
+	Shortfall       string                `json:"shortfall"`       // of the collateral value against the requirement
+	MarginCallID    string                `json:"marginCallId"`
+	ProcessedDate   string                `json:"processedDate"`
+}
+
+// ============================================================================================================================
+// process_corporate_action - apply a corporate action to a security pledged under a deal, pass through or retain
+// the income per deal terms and call the requirement of the deal again when the event leaves the segregated account short of it
+// ============================================================================================================================
+func (t *ManageAllocations) process_corporate_action(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 12 {
+		return nil, chaincode.SendError(stub, "process_corporate_action", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 12")
+	}
+	fmt.Println("start process_corporate_action")
+
+	DealChaincode := args[0]
+	AccountChainCode := args[1]
+	DealID := args[2]
+	PledgerLongboxAccount := args[3]
+	PledgeeSegregatedAccount := args[4]
+	EventID := args[5]
+	EventType := args[6]
+	SecurityID := args[7]
+	Rate := args[8]
+	Ratio := args[9]
+	NewSecurityID := args[10]
+	NewSecurityName := args[11]
+
+	// The same event must not be applied twice to a deal
+	eventKey := "CA-" + EventID + "-" + DealID
+	eventAsBytes, err := stub.GetState(eventKey)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "process_corporate_action", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get corporate action "+eventKey)
+	}
+	corporateAction := CorporateActions{}
+	json.Unmarshal(eventAsBytes, &corporateAction)
+	if corporateAction.EventID == EventID {
+		return nil, chaincode.SendError(stub, "process_corporate_action", chaincode.ErrConflict, chaincode.Entities{EventID: EventID, DealID: DealID}, "Corporate action already processed for this deal.")
+	}
+
+	// Fetch Deal details from Blockchain
+	queryArgs := chaincode.ToChaincodeArgs("getDeal_byID", DealID)
+	dealAsBytes, err := chaincode.InvokeChaincode(stub, DealChaincode, queryArgs)
+	if err != nil {
+		return nil, chaincode.CalledError(stub, "process_corporate_action", chaincode.Entities{}, "Failed to query chaincode", err)
+	}
+	DealData := Deals{}
+	json.Unmarshal(dealAsBytes, &DealData)
+	if DealData.DealID != DealID {
+		return nil, chaincode.SendError(stub, "process_corporate_action", chaincode.ErrNotFound, chaincode.Entities{DealID: DealID}, DealID+" Not Found.")
+	}
+
+	// The segregated account as it was before the event, the writes of this transaction are not read back
+	queryArgs = chaincode.ToChaincodeArgs("getAccount_byNumber", PledgeeSegregatedAccount)
+	accountAsBytes, err := chaincode.InvokeChaincode(stub, AccountChainCode, queryArgs)
+	if err != nil {
+		return nil, chaincode.CalledError(stub, "process_corporate_action", chaincode.Entities{AccountNumber: PledgeeSegregatedAccount}, "Failed to get "+PledgeeSegregatedAccount+" from 'Account' chaincode", err)
+	}
+	accounts := make(map[string]Accounts)
+	json.Unmarshal(accountAsBytes, &accounts)
+	heldBefore, _ := strconv.ParseFloat(accounts[PledgeeSegregatedAccount].TotalValue, 64)
+
+	// Requirement of the deal: the RQV of its latest transaction
+	queryArgs = chaincode.ToChaincodeArgs("getTransactions_byDealID", DealID)
+	transactionsAsBytes, err := chaincode.InvokeChaincode(stub, DealChaincode, queryArgs)
+	if err != nil && !chaincode.IsErrorCode(err, chaincode.ErrNotFound) {
+		return nil, chaincode.CalledError(stub, "process_corporate_action", chaincode.Entities{DealID: DealID}, "Failed to get transactions of "+DealID+" from 'Deal' chaincode", err)
+	}
+	var dealTransactions []Transactions
+	json.Unmarshal(transactionsAsBytes, &dealTransactions)
+	latest := Transactions{}
+	if len(dealTransactions) > 0 {
+		latest = dealTransactions[len(dealTransactions)-1]
+	}
+
+	// Apply the event to the position in the pledgee's segregated account
+	invokeArgs := chaincode.ToChaincodeArgs("apply_corporate_action", PledgeeSegregatedAccount, SecurityID, EventType, Rate, Ratio, NewSecurityID, NewSecurityName)
+	resultAsBytes, err := chaincode.InvokeChaincode(stub, AccountChainCode, invokeArgs)
+	if err != nil {
+		return nil, chaincode.CalledError(stub, "process_corporate_action", chaincode.Entities{}, "Failed to apply corporate action in 'Account' chaincode", err)
+	}
+	result := CorporateActionResult{}
+	json.Unmarshal(resultAsBytes, &result)
+	if result.SecurityId != SecurityID {
+		return nil, chaincode.SendError(stub, "process_corporate_action", chaincode.ErrUpstream, chaincode.Entities{EventID: EventID, SecurityID: SecurityID}, "Corporate action could not be applied to "+PledgeeSegregatedAccount+".")
+	}
+	fmt.Println("Corporate action result: ", result)
+
+	processedDate, err := chaincode.TxSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+	// Positions carry the value their allocation gave them in the RQV currency of the deal, income is paid in the
+	// currency of the security and amounts in other currencies are converted at the rates of the deal's currency
+	currency := latest.Currency
+	if currency == "" {
+		currency = DealData.BaseCurrency
+	}
+	if currency == "" {
+		currency = result.Currency
+	}
+	var rates map[string]float64
+	convert := func(amount float64, from string) (float64, error) {
+		if from == "" || from == currency || amount == 0 {
+			return amount, nil
+		}
+		if rates == nil {
+			var conversion CurrencyConversion
+			if err := getJSON(ExchangeRateAPI+"/latest?base="+currency, &conversion); err != nil {
+				return 0, err
+			}
+			rates = conversion.Rates
+		}
+		if rates[from] <= 0 {
+			return 0, fmt.Errorf("no exchange rate from %s to %s", from, currency)
+		}
+		return amount / rates[from], nil
+	}
+
+	corporateAction = CorporateActions{
+		EventID:         EventID,
+		DealID:          DealID,
+		EventType:       EventType,
+		SecurityID:      SecurityID,
+		IncomeTreatment: DealData.IncomeTreatment,
+		Result:          result,
+		Currency:        currency,
+		ProcessedDate:   strconv.FormatInt(processedDate, 10),
+	}
+
+	// Income is paid to the pledger's longbox account unless the deal retains it as collateral
+	income, _ := strconv.ParseFloat(result.Income, 64)
+	retained := 0.0
+	if income > 0 {
+		corporateAction.IncomeAccount = PledgerLongboxAccount
+		if DealData.IncomeTreatment == "Retain" {
+			corporateAction.IncomeAccount = PledgeeSegregatedAccount
+			retained = income
+		}
+		invokeArgs = chaincode.ToChaincodeArgs("deposit_cash", corporateAction.IncomeAccount, result.Currency, result.Income)
+		_, err = chaincode.InvokeChaincode(stub, AccountChainCode, invokeArgs)
+		if err != nil {
+			return nil, chaincode.CalledError(stub, "process_corporate_action", chaincode.Entities{AccountNumber: corporateAction.IncomeAccount}, "Failed to credit corporate action income to "+corporateAction.IncomeAccount+" in 'Account' chaincode", err)
+		}
+		if retained > 0 {
+			invokeArgs = chaincode.ToChaincodeArgs("record_cash_posting", DealID, result.Currency, result.Income)
+			_, err = chaincode.InvokeChaincode(stub, DealChaincode, invokeArgs)
+			if err != nil {
+				return nil, chaincode.CalledError(stub, "process_corporate_action", chaincode.Entities{}, "Failed to record retained income in 'Deal' chaincode", err)
+			}
+		}
+	}
+
+	// Revalue: the segregated account loses what the event removed and gains the income it retains. Only when that
+	// leaves it short of the requirement is the requirement called again, an over-collateralised deal is not called
+	valueBefore, _ := strconv.ParseFloat(result.ValueBefore, 64)
+	valueAfter, _ := strconv.ParseFloat(result.ValueAfter, 64)
+	retainedValue, err := convert(retained, result.Currency)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "process_corporate_action", chaincode.ErrUpstream, chaincode.Entities{EventID: EventID, DealID: DealID}, "Unable to convert "+result.Currency+" income to "+currency+": "+err.Error())
+	}
+	rqv, _ := strconv.ParseFloat(latest.RQV, 64)
+	requirement, err := convert(rqv, latest.Currency)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "process_corporate_action", chaincode.ErrUpstream, chaincode.Entities{EventID: EventID, DealID: DealID}, "Unable to convert the "+latest.Currency+" requirement to "+currency+": "+err.Error())
+	}
+	heldAfter := heldBefore - (valueBefore - valueAfter) + retainedValue
+	shortfall := math.Max(requirement-heldAfter, 0)
+	corporateAction.Requirement = strconv.FormatFloat(requirement, 'f', 2, 64)
+	corporateAction.CollateralValue = strconv.FormatFloat(heldAfter, 'f', 2, 64)
+	corporateAction.Shortfall = strconv.FormatFloat(shortfall, 'f', 2, 64)
+	if shortfall >= 0.01 {
+		// The RQV of a transaction is what the segregated account has to hold, so the call is for the whole requirement
+		corporateAction.MarginCallID = eventKey
+		invokeArgs = chaincode.ToChaincodeArgs("create_transaction",
+			corporateAction.MarginCallID,
+			corporateAction.ProcessedDate,
+			DealID,
+			DealData.Pledger,
+			DealData.Pledgee,
+			corporateAction.Requirement,
+			currency,
+			corporateAction.ProcessedDate,
+			"Matched")
+		_, err = chaincode.InvokeChaincode(stub, DealChaincode, invokeArgs)
+		if err != nil {
+			return nil, chaincode.CalledError(stub, "process_corporate_action", chaincode.Entities{}, "Failed to create margin call in 'Deal' chaincode", err)
+		}
+	}
+
+	err = chaincode.PutRecord(stub, eventKey, &corporateAction)
+	if err != nil {
+		return nil, err
+	}
+
+	err = chaincode.SendEvent(stub, "process_corporate_action", chaincode.Entities{EventID: EventID, DealID: DealID, TransactionID: corporateAction.MarginCallID}, "Corporate action processed succcessfully", map[string]string{"income": result.Income, "shortfall": corporateAction.Shortfall})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end process_corporate_action")
+	return nil, nil
+}
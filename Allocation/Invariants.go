@@ -0,0 +1,260 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package allocation
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+// Invariants an allocation must keep
+const (
+	invariantConservation       = "conservation"       // pledger plus pledgee quantity of each security is unchanged
+	invariantConcentrationLimit = "concentrationLimit" // no collateral form is worth more than its share of the RQV
+	invariantCoverage           = "coverage"           // the allocated collateral is worth at least the RQV
+	invariantAccountTotal       = "accountTotal"       // the total value of an account is the sum of its positions
+)
+
+// Quantities are kept to two decimals and values to cents, differences below these are rounding
+const (
+	quantityTolerance = 0.005
+	valueTolerance    = 0.01
+)
+
+// Violation is an invariant an allocation broke, for the security, collateral form or account it names
+type Violation struct {
+	Invariant string `json:"invariant"`
+	Subject   string `json:"subject"`
+	Expected  string `json:"expected"`
+	Actual    string `json:"actual"`
+	Message   string `json:"message"`
+}
+
+// InvariantReport is the outcome of checking the invariants of the allocation of a transaction
+type InvariantReport struct {
+	TransactionID string      `json:"transactionId"`
+	Passed        bool        `json:"passed"`
+	Violations    []Violation `json:"violations"`
+}
+
+// checkAllocation checks the invariants of an allocation: the report for what it moved and the accounts of the deal
+// for the positions they hold now. A report written before the holdings were recorded skips conservation
+func checkAllocation(stub shim.ChaincodeStubInterface, accountChaincode string, report AllocationReport) (InvariantReport, error) {
+	result := InvariantReport{TransactionID: report.TransactionID, Violations: []Violation{}}
+	if report.PledgerLongboxHoldings != nil || report.PledgeeSegregatedHoldings != nil {
+		violations, err := checkConservation(stub, accountChaincode, report)
+		if err != nil {
+			return result, err
+		}
+		result.Violations = append(result.Violations, violations...)
+	}
+	result.Violations = append(result.Violations, checkCollateral(report)...)
+	for _, account := range []string{report.PledgerLongboxAccount, report.PledgeeSegregatedAccount} {
+		violations, err := checkAccountTotal(stub, accountChaincode, account)
+		if err != nil {
+			return result, err
+		}
+		result.Violations = append(result.Violations, violations...)
+	}
+	result.Passed = len(result.Violations) == 0
+	return result, nil
+}
+
+// checkConservation compares what both accounts held before the allocation with what the Account chaincode holds
+// for them now and the movements of the allocation still have to deliver. A movement has left the delivering account
+// when it is instructed and reaches the receiving account as it settles
+func checkConservation(stub shim.ChaincodeStubInterface, accountChaincode string, report AllocationReport) ([]Violation, error) {
+	before := make(map[string]float64)
+	after := make(map[string]float64)
+	addQuantities(before, report.PledgerLongboxHoldings)
+	addQuantities(before, report.PledgeeSegregatedHoldings)
+	for _, account := range []string{report.PledgerLongboxAccount, report.PledgeeSegregatedAccount} {
+		securitiesAsBytes, err := chaincode.InvokeChaincode(stub, accountChaincode, chaincode.ToChaincodeArgs("getSecurities_byAccount", account))
+		if err != nil {
+			return nil, err
+		}
+		var securities []Securities
+		json.Unmarshal(securitiesAsBytes, &securities)
+		addQuantities(after, securities)
+	}
+	for _, movement := range report.Movements {
+		// the movement as settlement has updated it since the report was written
+		movementAsBytes, err := stub.GetState(movement.MovementID)
+		if err != nil {
+			return nil, err
+		}
+		json.Unmarshal(movementAsBytes, &movement)
+		quantity, _ := strconv.ParseFloat(movement.Quantity, 64)
+		settled, _ := strconv.ParseFloat(movement.SettledQuantity, 64)
+		after[movement.Security.SecurityId] += quantity - settled
+	}
+	violations := []Violation{}
+	for _, securityId := range sortedKeys(before, after) {
+		if math.Abs(before[securityId]-after[securityId]) >= quantityTolerance {
+			violations = append(violations, Violation{
+				Invariant: invariantConservation,
+				Subject:   securityId,
+				Expected:  strconv.FormatFloat(before[securityId], 'f', 2, 64),
+				Actual:    strconv.FormatFloat(after[securityId], 'f', 2, 64),
+				Message:   "Pledger and pledgee hold a different quantity of " + securityId + " than before the allocation",
+			})
+		}
+	}
+	return violations, nil
+}
+
+// checkCollateral checks the securities allocated to the segregated account against the concentration limits of the
+// ruleset the allocation used and against the RQV. A position is worth its quantity at its effective value
+func checkCollateral(report AllocationReport) []Violation {
+	rqv, _ := strconv.ParseFloat(report.RQV, 64)
+	valueByForm := make(map[string]float64)
+	allocated := 0.0
+	for _, security := range report.PledgeeSegregatedSecurities {
+		value := positionValue(security)
+		valueByForm[security.CollateralForm] += value
+		allocated += value
+	}
+	violations := []Violation{}
+	for _, form := range sortedKeys(valueByForm) {
+		limit := rqv * report.PrivateRuleSet.Security[form]["Concentration Limit"] / 100
+		if valueByForm[form]-limit >= valueTolerance {
+			violations = append(violations, Violation{
+				Invariant: invariantConcentrationLimit,
+				Subject:   form,
+				Expected:  "at most " + strconv.FormatFloat(limit, 'f', 2, 64),
+				Actual:    strconv.FormatFloat(valueByForm[form], 'f', 2, 64),
+				Message:   form + " is worth more than its concentration limit of the RQV",
+			})
+		}
+	}
+	if rqv-allocated >= valueTolerance {
+		violations = append(violations, Violation{
+			Invariant: invariantCoverage,
+			Subject:   report.TransactionID,
+			Expected:  "at least " + strconv.FormatFloat(rqv, 'f', 2, 64),
+			Actual:    strconv.FormatFloat(allocated, 'f', 2, 64),
+			Message:   "Allocated collateral is worth less than the RQV",
+		})
+	}
+	return violations
+}
+
+// checkAccountTotal compares the total value of an account in the Account chaincode with the sum of its positions
+func checkAccountTotal(stub shim.ChaincodeStubInterface, accountChaincode string, accountNumber string) ([]Violation, error) {
+	accountAsBytes, err := chaincode.InvokeChaincode(stub, accountChaincode, chaincode.ToChaincodeArgs("getAccount_byNumber", accountNumber))
+	if err != nil {
+		return nil, err
+	}
+	accounts := make(map[string]Accounts)
+	json.Unmarshal(accountAsBytes, &accounts)
+	securitiesAsBytes, err := chaincode.InvokeChaincode(stub, accountChaincode, chaincode.ToChaincodeArgs("getSecurities_byAccount", accountNumber))
+	if err != nil {
+		return nil, err
+	}
+	var securities []Securities
+	json.Unmarshal(securitiesAsBytes, &securities)
+	positions := 0.0
+	for _, security := range securities {
+		value, _ := strconv.ParseFloat(security.TotalValue, 64)
+		positions += value
+	}
+	totalValue, _ := strconv.ParseFloat(accounts[accountNumber].TotalValue, 64)
+	if math.Abs(totalValue-positions) < valueTolerance*float64(len(securities)+1) {
+		return nil, nil
+	}
+	return []Violation{{
+		Invariant: invariantAccountTotal,
+		Subject:   accountNumber,
+		Expected:  strconv.FormatFloat(positions, 'f', 2, 64),
+		Actual:    strconv.FormatFloat(totalValue, 'f', 2, 64),
+		Message:   "Total value of " + accountNumber + " is not the sum of its " + strconv.Itoa(len(securities)) + " positions",
+	}}, nil
+}
+
+// invariantError is the error of a function whose allocation broke invariants, the report is the data of its errEvent
+func invariantError(stub shim.ChaincodeStubInterface, eventType string, entities chaincode.Entities, result InvariantReport) error {
+	message := fmt.Sprintf("Allocation aborted, it breaks %d invariants:", len(result.Violations))
+	for _, violation := range result.Violations {
+		message += " " + violation.Invariant + " of " + violation.Subject + " expected " + violation.Expected + ", got " + violation.Actual + ";"
+	}
+	return chaincode.Fail(stub, chaincode.Event{Type: eventType, Code: chaincode.ErrInvariant.Status, ErrorCode: chaincode.ErrInvariant.Name, Message: message, Entities: entities, Data: result})
+}
+
+func positionValue(security Securities) float64 {
+	quantity, _ := strconv.ParseFloat(security.SecuritiesQuantity, 64)
+	effectiveValue, _ := strconv.ParseFloat(security.EffectiveValueinUSD, 64)
+	return quantity * effectiveValue
+}
+
+func addQuantities(quantities map[string]float64, securities []Securities) {
+	for _, security := range securities {
+		quantity, _ := strconv.ParseFloat(security.SecuritiesQuantity, 64)
+		quantities[security.SecurityId] += quantity
+	}
+}
+
+func sortedKeys(maps ...map[string]float64) []string {
+	seen := make(map[string]bool)
+	keys := []string{}
+	for _, m := range maps {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ============================================================================================================================
+// check_allocation - check the invariants of the last allocation of a transaction against its report and the accounts
+// of its deal as they are now. Run it before other activity on the accounts, or their totals are all it can vouch for
+// ============================================================================================================================
+func (t *ManageAllocations) check_allocation(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, chaincode.SendError(stub, "check_allocation", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'AccountChaincode' and 'TransactionID'")
+	}
+	fmt.Println("start check_allocation")
+	_accountChaincode := args[0]
+	_transactionId := args[1]
+	reportAsBytes, err := stub.GetState(reportKey(_transactionId))
+	if err != nil {
+		return nil, chaincode.SendError(stub, "check_allocation", chaincode.ErrUpstream, chaincode.Entities{TransactionID: _transactionId}, "Failed to get report of "+_transactionId)
+	}
+	if reportAsBytes == nil {
+		return nil, chaincode.SendError(stub, "check_allocation", chaincode.ErrNotFound, chaincode.Entities{TransactionID: _transactionId}, "No allocation report for "+_transactionId+".")
+	}
+	report := AllocationReport{}
+	json.Unmarshal(reportAsBytes, &report)
+	result, err := checkAllocation(stub, _accountChaincode, report)
+	if err != nil {
+		return nil, chaincode.CalledError(stub, "check_allocation", chaincode.Entities{TransactionID: _transactionId}, "Failed to get accounts from 'Account' chaincode", err)
+	}
+	fmt.Println("end check_allocation")
+	return json.Marshal(result)
+}
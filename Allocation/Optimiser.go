@@ -0,0 +1,569 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package allocation
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+// Status of transactions the last allocation left short of collateral, the optimiser plans them again
+const pendingCollateral = "Pending due to insufficient collateral"
+
+// Weight of covering a call in the objective of the optimiser, far above the cost of any collateral so coverage comes first
+const coverageWeight = 10000.0
+
+// AssignedCollateral is a quantity of a position the optimiser assigns to a call, valued in the currency of the call
+type AssignedCollateral struct {
+	AccountNumber  string `json:"accountNumber"`
+	SecurityID     string `json:"securityId"`
+	CollateralForm string `json:"collateralForm"`
+	Quantity       string `json:"quantity"`
+	MarketValue    string `json:"marketValue"`
+	EffectiveValue string `json:"effectiveValue"`
+}
+
+// OptimisedCall is an open call with the collateral the optimiser assigns to it. Cost is the market value the haircuts
+// of the assigned collateral give up
+type OptimisedCall struct {
+	PlannedAllocation
+	EligibleCollateral string               `json:"eligibleCollateral"`
+	Covered            string               `json:"covered"`
+	Shortfall          string               `json:"shortfall"`
+	Cost               string               `json:"cost"`
+	ExpiresAt          string               `json:"expiresAt"`
+	Collateral         []AssignedCollateral `json:"collateral"`
+}
+
+// OptimisationResult is the collateral a pledger's open calls are assigned together. Unassigned is the quantity of each
+// longbox position no call is assigned
+type OptimisationResult struct {
+	Pledger               string              `json:"pledger"`
+	PledgerLongboxAccount string              `json:"pledgerLongboxAccount"`
+	CallsCovered          int                 `json:"callsCovered"`
+	Reserved              bool                `json:"reserved"`
+	Calls                 []OptimisedCall     `json:"calls"`
+	Skipped               []SkippedAllocation `json:"skipped"`
+	Unassigned            map[string]string   `json:"unassigned"`
+}
+
+// openCall is a call with the terms its collateral is valued by
+type openCall struct {
+	planned            PlannedAllocation
+	rqv                float64
+	eligibleCollateral string
+	ruleset            Ruleset
+	rates              map[string]float64
+}
+
+// candidate is the quantity of a position a set of calls can share
+type candidate struct {
+	security  Securities
+	price     float64
+	available float64
+}
+
+// marketValue is the value of one unit of a position in the currency of a call, false when it cannot be converted
+func (c openCall) marketValue(position candidate) (float64, bool) {
+	if position.security.Currency == c.planned.Currency {
+		return position.price, true
+	}
+	rate := c.rates[position.security.Currency]
+	return position.price / rate, rate > 0
+}
+
+// accepts reports whether a call can be covered with a position: collateral of its deal in the longbox or in its
+// own segregated account that its ruleset values
+func (c openCall) accepts(position candidate, longbox string) bool {
+	if position.security.AccountNumber != longbox && position.security.AccountNumber != c.planned.PledgeeSegregatedAccount {
+		return false
+	}
+	_, converted := c.marketValue(position)
+	return converted && position.price > 0 && c.terms(position.security.CollateralForm)["Valuation Percentage"] > 0 &&
+		acceptsCollateral(c.ruleset, c.eligibleCollateral, position.security)
+}
+
+func (c openCall) terms(collateralForm string) map[string]float64 {
+	return c.ruleset.Security[collateralForm]
+}
+
+// effectiveValue is the value of one unit of a position a call counts towards its RQV
+func (c openCall) effectiveValue(position candidate) float64 {
+	value, _ := c.marketValue(position)
+	return value * c.terms(position.security.CollateralForm)["Valuation Percentage"] / 100
+}
+
+// objective is what covering the whole RQV of a call with a position is worth: the coverage, less the market value
+// the haircut gives up per unit of RQV and, breaking ties, the priority of the form and moving it out of the longbox
+func (c openCall) objective(position candidate, longbox string) float64 {
+	terms := c.terms(position.security.CollateralForm)
+	value := coverageWeight - 100/terms["Valuation Percentage"] - 1e-3*terms["Priority"]
+	if position.security.AccountNumber == longbox {
+		value -= 1e-4
+	}
+	return value
+}
+
+// simplex maximises c·x subject to A·x <= b and x >= 0. With b >= 0 the origin is feasible, so one phase is enough;
+// Bland's rule, the lowest index entering and leaving, keeps it from cycling
+func simplex(c []float64, A [][]float64, b []float64) []float64 {
+	const eps = 1e-9
+	m, n := len(A), len(c)
+	tableau := make([][]float64, m+1)
+	for i := 0; i < m; i++ {
+		tableau[i] = make([]float64, n+m+1)
+		copy(tableau[i], A[i])
+		tableau[i][n+i] = 1
+		tableau[i][n+m] = b[i]
+	}
+	tableau[m] = make([]float64, n+m+1)
+	for j := 0; j < n; j++ {
+		tableau[m][j] = -c[j]
+	}
+	basis := make([]int, m)
+	for i := range basis {
+		basis[i] = n + i
+	}
+	for {
+		entering := -1
+		for j := 0; j < n+m; j++ {
+			if tableau[m][j] < -eps {
+				entering = j
+				break
+			}
+		}
+		if entering < 0 {
+			break
+		}
+		leaving, best := -1, 0.0
+		for i := 0; i < m; i++ {
+			if tableau[i][entering] <= eps {
+				continue
+			}
+			ratio := tableau[i][n+m] / tableau[i][entering]
+			if leaving < 0 || ratio < best-eps || (ratio < best+eps && basis[i] < basis[leaving]) {
+				leaving, best = i, ratio
+			}
+		}
+		if leaving < 0 {
+			break // unbounded, the coverage of each call keeps it from happening
+		}
+		pivot := tableau[leaving][entering]
+		for j := range tableau[leaving] {
+			tableau[leaving][j] /= pivot
+		}
+		for i := range tableau {
+			if i == leaving || tableau[i][entering] == 0 {
+				continue
+			}
+			factor := tableau[i][entering]
+			for j := range tableau[i] {
+				tableau[i][j] -= factor * tableau[leaving][j]
+			}
+		}
+		basis[leaving] = entering
+	}
+	x := make([]float64, n)
+	for i, j := range basis {
+		if j < n {
+			x[j] = tableau[i][n+m]
+		}
+	}
+	return x
+}
+
+// optimise assigns positions to calls. Each call is a fraction of its RQV covered by each position it accepts, the
+// fractions are solved together as a linear program: no position is assigned beyond its quantity, no form beyond the
+// concentration limit of the call and no call beyond its RQV. Quantities are then rounded down to what can be moved
+// and calls still short are topped up with whatever is left, those accepting the fewest positions first as they have
+// the least to choose from. The result is indexed by call and position
+func optimise(calls []openCall, positions []candidate, longbox string) [][]float64 {
+	type variable struct{ call, position int }
+	var variables []variable
+	var c []float64
+	for k, call := range calls {
+		for p, position := range positions {
+			if call.accepts(position, longbox) && position.available > 0 {
+				variables = append(variables, variable{k, p})
+				c = append(c, call.objective(position, longbox))
+			}
+		}
+	}
+	var A [][]float64
+	var b []float64
+	row := func(coefficient func(variable) float64, bound float64) {
+		coefficients := make([]float64, len(variables))
+		for j, v := range variables {
+			coefficients[j] = coefficient(v)
+		}
+		A = append(A, coefficients)
+		b = append(b, bound)
+	}
+	for p, position := range positions {
+		row(func(v variable) float64 {
+			if v.position != p {
+				return 0
+			}
+			return calls[v.call].rqv / (calls[v.call].effectiveValue(position) * position.available)
+		}, 1)
+	}
+	var forms []string
+	for _, position := range positions {
+		if i := sort.SearchStrings(forms, position.security.CollateralForm); i == len(forms) || forms[i] != position.security.CollateralForm {
+			forms = append(forms[:i], append([]string{position.security.CollateralForm}, forms[i:]...)...)
+		}
+	}
+	for k, call := range calls {
+		for _, form := range forms {
+			row(func(v variable) float64 {
+				if v.call == k && positions[v.position].security.CollateralForm == form {
+					return 1
+				}
+				return 0
+			}, call.terms(form)["Concentration Limit"]/100)
+		}
+		row(func(v variable) float64 {
+			if v.call == k {
+				return 1
+			}
+			return 0
+		}, 1)
+	}
+	fractions := simplex(c, A, b)
+
+	quantities := make([][]float64, len(calls))
+	used := make([]float64, len(positions))
+	for k := range calls {
+		quantities[k] = make([]float64, len(positions))
+	}
+	for j, v := range variables {
+		call, position := calls[v.call], positions[v.position]
+		quantity := sliceQuantity(position.security, fractions[j]*call.rqv/call.effectiveValue(position)+1e-6)
+		quantities[v.call][v.position] = quantity
+		used[v.position] += quantity
+	}
+	accepted := make([]int, len(calls))
+	order := make([]int, len(calls))
+	for k, call := range calls {
+		order[k] = k
+		for _, position := range positions {
+			if call.accepts(position, longbox) {
+				accepted[k]++
+			}
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return accepted[order[i]] < accepted[order[j]] })
+	for _, k := range order {
+		call := calls[k]
+		for {
+			covered := 0.0
+			formValue := make(map[string]float64)
+			for p, position := range positions {
+				value := quantities[k][p] * call.effectiveValue(position)
+				covered += value
+				formValue[position.security.CollateralForm] += value
+			}
+			shortfall := call.rqv - covered
+			if shortfall < 0.005 {
+				break
+			}
+			best, bestQuantity := -1, 0.0
+			for p, position := range positions {
+				if !call.accepts(position, longbox) {
+					continue
+				}
+				unit := call.effectiveValue(position)
+				headroom := call.rqv*call.terms(position.security.CollateralForm)["Concentration Limit"]/100 - formValue[position.security.CollateralForm]
+				step := minimumQuantity(position.security)
+				quantity := math.Ceil(shortfall/unit/step-1e-6) * step
+				quantity = math.Min(quantity, sliceQuantity(position.security, position.available-used[p]+1e-6))
+				quantity = math.Min(quantity, sliceQuantity(position.security, headroom/unit+1e-6))
+				if quantity < step-1e-9 {
+					continue
+				}
+				if best < 0 || call.objective(position, longbox) > call.objective(positions[best], longbox) {
+					best, bestQuantity = p, quantity
+				}
+			}
+			if best < 0 {
+				break
+			}
+			quantities[k][best] += bestQuantity
+			used[best] += bestQuantity
+		}
+	}
+	return quantities
+}
+
+// getJSON decodes the JSON a service answers at url into value
+func getJSON(url string, value interface{}) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(value)
+}
+
+// ============================================================================================================================
+// optimise_allocations - assign the collateral of a pledger's longbox to all of its open calls at once: the transactions
+// ready for allocation or pending for want of collateral, each valued by the ruleset and schedule of its deal. Calls are
+// covered as fully as the longbox allows before the haircuts given up are kept low, where allocating them one at a time
+// can use up collateral a later call cannot do without. With 'Reserve' "true" the longbox collateral assigned to each
+// call it covers is reserved for it, so start_allocation of each call, in any order, allocates exactly what it is
+// assigned and around what the others are. A call the collateral cannot cover reserves nothing and what it reserved before is released.
+// Collateral a call's segregated account already holds counts for that call only. With a 'ResultID' the result is kept
+// for getResult_byID
+// ============================================================================================================================
+func (t *ManageAllocations) optimise_allocations(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 4 || len(args) > 6 {
+		return nil, chaincode.SendError(stub, "optimise_allocations", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'DealChaincode', 'AccountChaincode', 'APIIP', 'Pledger' and optionally 'Reserve' and 'ResultID'")
+	}
+	fmt.Println("start optimise_allocations")
+	_dealChaincode := args[0]
+	_accountChaincode := args[1]
+	_apiIp := args[2]
+	_pledger := strings.TrimSpace(args[3])
+	reserve := len(args) >= 5 && args[4] == "true"
+	resultId := ""
+	if len(args) == 6 {
+		resultId = args[5]
+	}
+	if _pledger == "" || (len(args) >= 5 && args[4] != "true" && args[4] != "false") {
+		return nil, chaincode.SendError(stub, "optimise_allocations", chaincode.ErrValidation, chaincode.Entities{}, "'Pledger' is required and 'Reserve' must be \"true\" or \"false\"")
+	}
+
+	plan, err := planAllocations(stub, _dealChaincode, _accountChaincode, "", _pledger, readyForAllocation, pendingCollateral)
+	if err != nil {
+		return nil, chaincode.CalledError(stub, "optimise_allocations", chaincode.Entities{}, "Failed to plan allocations of "+_pledger, err)
+	}
+	result := OptimisationResult{Pledger: _pledger, Reserved: reserve, Calls: []OptimisedCall{}, Skipped: plan.Skipped, Unassigned: make(map[string]string)}
+	if len(plan.Planned) == 0 {
+		return keepResult(stub, resultId, result)
+	}
+	longbox := plan.Planned[0].PledgerLongboxAccount
+	result.PledgerLongboxAccount = longbox
+
+	// Terms of each call: the schedule of its deal, the ruleset of its pledgee and the rates of its currency
+	deals := make(map[string]Deals)
+	rulesets := make(map[string]Ruleset)
+	rates := make(map[string]map[string]float64)
+	var calls []openCall
+	var transactionIds []string
+	for _, planned := range plan.Planned {
+		// The calls share the longbox of the first, calls on another longbox are skipped and allocated without an assignment
+		if planned.PledgerLongboxAccount != longbox {
+			result.Skipped = append(result.Skipped, SkippedAllocation{TransactionID: planned.TransactionID, DealID: planned.DealID, Reason: "longbox " + planned.PledgerLongboxAccount + " is not " + longbox})
+			continue
+		}
+		rqv, _ := strconv.ParseFloat(planned.RQV, 64)
+		if rqv < 0.005 {
+			result.Skipped = append(result.Skipped, SkippedAllocation{TransactionID: planned.TransactionID, DealID: planned.DealID, Reason: "no collateral to call"})
+			continue
+		}
+		deal, fetched := deals[planned.DealID]
+		if !fetched {
+			dealAsBytes, err := chaincode.InvokeChaincode(stub, _dealChaincode, chaincode.ToChaincodeArgs("getDeal_byID", planned.DealID))
+			if chaincode.IsErrorCode(err, chaincode.ErrNotFound) {
+				result.Skipped = append(result.Skipped, SkippedAllocation{TransactionID: planned.TransactionID, DealID: planned.DealID, Reason: "deal " + planned.DealID + " not found"})
+				continue
+			}
+			if err != nil {
+				return nil, chaincode.CalledError(stub, "optimise_allocations", chaincode.Entities{DealID: planned.DealID}, "Failed to get "+planned.DealID+" from 'Deal' chaincode", err)
+			}
+			json.Unmarshal(dealAsBytes, &deal)
+			deals[planned.DealID] = deal
+		}
+		if planned.Currency == "" {
+			planned.Currency = deal.BaseCurrency
+		}
+		ruleset, fetched := rulesets[planned.Pledgee]
+		if !fetched {
+			// A pair without a ruleset accepts nothing, as in start_allocation
+			url := fmt.Sprintf("http://%s/securityRuleset/%s/%s", _apiIp, planned.Pledger, planned.Pledgee)
+			if err := getJSON(url, &ruleset); err != nil {
+				fmt.Println("Ruleset fetch error: ", err)
+			}
+			rulesets[planned.Pledgee] = ruleset
+		}
+		conversion, fetched := rates[planned.Currency]
+		if !fetched {
+			var rate CurrencyConversion
+			if err := getJSON(ExchangeRateAPI+"/latest?base="+planned.Currency, &rate); err != nil {
+				fmt.Println("Currency coversion rate fetch error: ", err)
+			}
+			conversion = rate.Rates
+			rates[planned.Currency] = conversion
+		}
+		calls = append(calls, openCall{planned: planned, rqv: rqv, eligibleCollateral: deal.EligibleCollateral, ruleset: ruleset, rates: conversion})
+		transactionIds = append(transactionIds, planned.TransactionID)
+	}
+
+	// Positions the calls share: the longbox without what other transactions reserved, and the segregated accounts
+	availabilityAsBytes, err := chaincode.InvokeChaincode(stub, _accountChaincode, chaincode.ToChaincodeArgs("getAvailability_byAccount", longbox, strings.Join(transactionIds, ",")))
+	if err != nil {
+		return nil, chaincode.CalledError(stub, "optimise_allocations", chaincode.Entities{AccountNumber: longbox}, "Failed to get availability of "+longbox+" from 'Account' chaincode", err)
+	}
+	var availability []PositionAvailability
+	json.Unmarshal(availabilityAsBytes, &availability)
+	available := make(map[string]float64)
+	for _, position := range availability {
+		available[position.SecurityID], _ = strconv.ParseFloat(position.Available, 64)
+	}
+	accounts := []string{longbox}
+	for _, call := range calls {
+		accounts = append(accounts, call.planned.PledgeeSegregatedAccount)
+	}
+	prices := make(map[string]float64)
+	var positions []candidate
+	seen := make(map[string]bool)
+	for _, accountNumber := range accounts {
+		if seen[accountNumber] {
+			continue
+		}
+		seen[accountNumber] = true
+		securitiesAsBytes, err := chaincode.InvokeChaincode(stub, _accountChaincode, chaincode.ToChaincodeArgs("getSecurities_byAccount", accountNumber))
+		if err != nil && !chaincode.IsErrorCode(err, chaincode.ErrNotFound) {
+			return nil, chaincode.CalledError(stub, "optimise_allocations", chaincode.Entities{AccountNumber: accountNumber}, "Failed to get securities of "+accountNumber+" from 'Account' chaincode", err)
+		}
+		var securities []Securities
+		json.Unmarshal(securitiesAsBytes, &securities)
+		sort.Slice(securities, func(i, j int) bool { return securities[i].SecurityId < securities[j].SecurityId })
+		for _, security := range securities {
+			quantity, _ := strconv.ParseFloat(security.SecuritiesQuantity, 64)
+			if accountNumber == longbox {
+				quantity = math.Min(quantity, available[security.SecurityId])
+			}
+			if quantity < minimumQuantity(security) {
+				continue
+			}
+			price, priced := prices[security.SecurityId]
+			if isCash(security) {
+				price = 1
+			} else if !priced {
+				// An unpriced security is left out, no call can value it
+				var marketData []string
+				if err := getJSON("http://"+_apiIp+"/MarketData/"+security.SecurityId, &marketData); err == nil && len(marketData) > 0 {
+					price, _ = strconv.ParseFloat(marketData[0], 64)
+				}
+				prices[security.SecurityId] = price
+			}
+			security.AccountNumber = accountNumber
+			positions = append(positions, candidate{security: security, price: price, available: quantity})
+		}
+	}
+
+	quantities := optimise(calls, positions, longbox)
+	assigned := make([]float64, len(positions))
+	var requests []map[string]interface{}
+	for k, call := range calls {
+		optimised := OptimisedCall{PlannedAllocation: call.planned, EligibleCollateral: call.eligibleCollateral, Collateral: []AssignedCollateral{}}
+		var covered, marketValue float64
+		reservation := make(map[string]string)
+		for p, position := range positions {
+			quantity := quantities[k][p]
+			if quantity < 0.005 {
+				continue
+			}
+			assigned[p] += quantity
+			unitValue, _ := call.marketValue(position)
+			effectiveValue := quantity * call.effectiveValue(position)
+			covered += effectiveValue
+			marketValue += quantity * unitValue
+			optimised.Collateral = append(optimised.Collateral, AssignedCollateral{
+				AccountNumber:  position.security.AccountNumber,
+				SecurityID:     position.security.SecurityId,
+				CollateralForm: position.security.CollateralForm,
+				Quantity:       strconv.FormatFloat(quantity, 'f', 2, 64),
+				MarketValue:    strconv.FormatFloat(quantity*unitValue, 'f', 2, 64),
+				EffectiveValue: strconv.FormatFloat(effectiveValue, 'f', 2, 64),
+			})
+			if position.security.AccountNumber == longbox {
+				reservation[position.security.SecurityId] = strconv.FormatFloat(quantity, 'f', 2, 64)
+			}
+		}
+		optimised.Covered = strconv.FormatFloat(covered, 'f', 2, 64)
+		optimised.Shortfall = strconv.FormatFloat(math.Max(call.rqv-covered, 0), 'f', 2, 64)
+		optimised.Cost = strconv.FormatFloat(marketValue-covered, 'f', 2, 64)
+		if call.rqv-covered < 0.005 {
+			result.CallsCovered++
+		}
+		if reserve {
+			// Reservations last until the margin call deadline of the transaction. Only the quantities a covered call is
+			// assigned are held back, an empty request releases what a call short of collateral reserved before
+			deadline := MarginCallDeadline{}
+			deadlineAsBytes, err := chaincode.InvokeChaincode(stub, _dealChaincode, chaincode.ToChaincodeArgs("getMarginCallDeadline_byTransactionID", call.planned.TransactionID))
+			if err != nil && !chaincode.IsErrorCode(err, chaincode.ErrValidation) {
+				return nil, chaincode.CalledError(stub, "optimise_allocations", chaincode.Entities{TransactionID: call.planned.TransactionID}, "Failed to get margin call deadline from 'Deal' chaincode", err)
+			}
+			json.Unmarshal(deadlineAsBytes, &deadline)
+			expiresAt := reservationExpiry(call.planned.MarginCallDate, deadline.Deadline)
+			if call.rqv-covered < 0.005 {
+				optimised.ExpiresAt = expiresAt
+			} else {
+				reservation = map[string]string{}
+			}
+			requests = append(requests, map[string]interface{}{"transactionId": call.planned.TransactionID, "quantities": reservation, "expiresAt": expiresAt})
+		}
+		result.Calls = append(result.Calls, optimised)
+	}
+	for p, position := range positions {
+		if left := position.available - assigned[p]; position.security.AccountNumber == longbox && left >= 0.005 {
+			result.Unassigned[position.security.SecurityId] = strconv.FormatFloat(left, 'f', 2, 64)
+		}
+	}
+
+	// The reservations of all the calls are written by one invocation, so they do not overwrite each other's index
+	if reserve {
+		requestsAsBytes, _ := json.Marshal(requests)
+		_, err = chaincode.InvokeChaincode(stub, _accountChaincode, chaincode.ToChaincodeArgs("reserve_allocations", longbox, string(requestsAsBytes)))
+		if err != nil {
+			return nil, chaincode.CalledError(stub, "optimise_allocations", chaincode.Entities{AccountNumber: longbox}, "Failed to reserve collateral on "+longbox, err)
+		}
+	}
+	err = chaincode.SendEvent(stub, "optimise_allocations", chaincode.Entities{AccountNumber: longbox}, "Collateral of "+_pledger+" optimised for "+strconv.Itoa(len(result.Calls))+" calls",
+		map[string]string{"callsCovered": strconv.Itoa(result.CallsCovered), "reserved": strconv.FormatBool(reserve)})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end optimise_allocations")
+	return keepResult(stub, resultId, result)
+}
+
+// keepResult is the payload of optimise_allocations, kept under the id its client chose
+func keepResult(stub shim.ChaincodeStubInterface, resultId string, result OptimisationResult) ([]byte, error) {
+	resultAsBytes, _ := json.Marshal(result)
+	err := chaincode.PutResult(stub, resultId, resultAsBytes)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "optimise_allocations", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to keep result "+resultId)
+	}
+	return resultAsBytes, nil
+}
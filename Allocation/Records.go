@@ -0,0 +1,69 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package allocation
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+// ============================================================================================================================
+// migrate_records - upgrade the movements, allocation reports and corporate actions in place to the canonical format
+// ============================================================================================================================
+func (t *ManageAllocations) migrate_records(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("start migrate_records")
+	movementIndexAsBytes, err := stub.GetState(movementIndexStr)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "migrate_records", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get movement index")
+	}
+	var movementIndex []string
+	json.Unmarshal(movementIndexAsBytes, &movementIndex)
+	result := chaincode.NewMigrationResult()
+	for _, movementID := range movementIndex {
+		movement := Movements{}
+		chaincode.MigrateRecord(stub, movementID, &movement, result)
+		movementAsBytes, _ := stub.GetState(movementID)
+		json.Unmarshal(movementAsBytes, &movement)
+		if movement.TransactionID != "" && !chaincode.IsMigrated(result, reportKey(movement.TransactionID)) {
+			chaincode.MigrateRecord(stub, reportKey(movement.TransactionID), &AllocationReport{}, result)
+		}
+	}
+	// Corporate actions are kept under "CA-" + event id + "-" + deal id without an index
+	corporateActions, err := stub.GetStateByRange("CA-", "CA-~")
+	if err != nil {
+		return nil, chaincode.SendError(stub, "migrate_records", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to read corporate actions")
+	}
+	defer corporateActions.Close()
+	keys := []string{}
+	for corporateActions.HasNext() {
+		kv, err := corporateActions.Next()
+		if err != nil {
+			return nil, chaincode.SendError(stub, "migrate_records", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to read corporate actions")
+		}
+		keys = append(keys, kv.Key)
+	}
+	for _, key := range keys {
+		chaincode.MigrateRecord(stub, key, &CorporateActions{}, result)
+	}
+	return chaincode.MigrationDone(stub, result)
+}
@@ -0,0 +1,134 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package allocation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+// AllocationReport is what start_allocation decided for a transaction and why: the rules, rates and prices
+// it used, the positions it left in both accounts and the movements it instructed
+type AllocationReport struct {
+	chaincode.Record
+	DealID                   string `json:"Deal ID"`
+	TransactionID            string `json:"Transaction ID"`
+	MarginCallDate           string `json:"Margin Call Date"`
+	Pledgee                  string `json:"Pledgee"`
+	Pledger                  string `json:"Pledger"`
+	PledgerLongboxAccount    string `json:"Pledger Longbox Account"`
+	PledgeeSegregatedAccount string `json:"Pledgee Segregated Account"`
+	RQV                      string `json:"RQV"`
+	Currency                 string `json:"Currency"`
+
+	// Inputs
+	RulesetVersion string                       `json:"Ruleset Version"`
+	PublicRuleSet  map[string]map[string]string `json:"Public Rule Set"`
+	PrivateRuleSet Ruleset                      `json:"Private Rule set"`
+	ConversionRate CurrencyConversion           `json:"Currency Conversion Rate"`
+	MarketPrices   map[string]string            `json:"Market Prices"` // MTM per security id, in the currency of the security
+
+	// Holdings of both accounts before the allocation
+	PledgerLongboxHoldings    []Securities `json:"Pledger Longbox Holdings"`
+	PledgeeSegregatedHoldings []Securities `json:"Pledgee Segregated Holdings"`
+
+	// Outputs
+	PledgerLongboxSecurities    []Securities `json:"Pledger Longbox Securities"` // what remains in the longbox
+	PledgeeSegregatedSecurities []Securities `json:"Pledgee Segregated Securities"`
+	PledgeeIneligibleSecurities []Securities `json:"Pledgee Ineligible Securities"` // kept in the segregated account, not collateral of the deal
+	Movements                   []Movements  `json:"Movements"`
+	AllocationDate              string       `json:"Allocation Date"`
+	AllocationStatus            string       `json:"Allocation Status"`
+	IntendedSettlementDate      string       `json:"Intended Settlement Date"`
+
+	// Compliance
+	ComplianceStatus string `json:"Compliance Status"`
+}
+
+// reportKey is the key the report of a transaction is stored under
+func reportKey(transactionId string) string {
+	return transactionId + "-REPORT"
+}
+
+// rulesetVersion is the version the ruleset API gave, a digest of the ruleset when it gave none
+func rulesetVersion(ruleset Ruleset) string {
+	if ruleset.Version != "" {
+		return ruleset.Version
+	}
+	rulesetAsBytes, _ := json.Marshal(ruleset)
+	digest := sha256.Sum256(rulesetAsBytes)
+	return "sha256:" + hex.EncodeToString(digest[:8])
+}
+
+// putAllocationReport writes the report of a transaction, a new allocation of the transaction replaces it
+func putAllocationReport(stub shim.ChaincodeStubInterface, report AllocationReport) error {
+	return chaincode.PutRecord(stub, reportKey(report.TransactionID), &report)
+}
+
+// putUnallocatedReport writes the report of an allocation that moved nothing, both accounts keep what they held
+func putUnallocatedReport(stub shim.ChaincodeStubInterface, report AllocationReport, allocationDate string, status string) error {
+	report.AllocationDate = allocationDate
+	report.AllocationStatus = status
+	report.PledgerLongboxSecurities = report.PledgerLongboxHoldings
+	report.PledgeeSegregatedSecurities = report.PledgeeSegregatedHoldings
+	report.ComplianceStatus = "NA"
+	return putAllocationReport(stub, report)
+}
+
+// updateAllocationReportStatus changes the allocation status of a stored report, a transaction without one is left alone
+func updateAllocationReportStatus(stub shim.ChaincodeStubInterface, transactionId string, status string) error {
+	reportAsBytes, err := stub.GetState(reportKey(transactionId))
+	if err != nil {
+		return errors.New("Failed to get report of " + transactionId)
+	}
+	if reportAsBytes == nil {
+		return nil
+	}
+	report := AllocationReport{}
+	json.Unmarshal(reportAsBytes, &report)
+	report.AllocationStatus = status
+	return putAllocationReport(stub, report)
+}
+
+// ============================================================================================================================
+// getAllocationReport_byTransactionID - get the report stored when the transaction was allocated
+// ============================================================================================================================
+func (t *ManageAllocations) getAllocationReport_byTransactionID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("start getAllocationReport_byTransactionID")
+	if len(args) != 1 {
+		return nil, chaincode.SendError(stub, "getAllocationReport_byTransactionID", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'TransactionID' as an argument")
+	}
+	reportAsBytes, err := stub.GetState(reportKey(args[0]))
+	if err != nil {
+		return nil, chaincode.SendError(stub, "getAllocationReport_byTransactionID", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get report of "+args[0])
+	}
+	if reportAsBytes == nil {
+		return nil, chaincode.SendError(stub, "getAllocationReport_byTransactionID", chaincode.ErrNotFound, chaincode.Entities{}, "No allocation report for "+args[0]+".")
+	}
+	fmt.Println("end getAllocationReport_byTransactionID")
+	return reportAsBytes, nil
+}
@@ -0,0 +1,120 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package allocation
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+// Collateral reserved for a transaction without a margin call deadline is held this long past its margin call date
+const reservationLifetime int64 = 24 * 60 * 60
+
+// PositionAvailability is the quantity of a position other transactions have not reserved, as the Account chaincode keeps it
+type PositionAvailability struct {
+	SecurityID string `json:"securityId"`
+	Quantity   string `json:"quantity"`
+	Reserved   string `json:"reserved"`
+	Available  string `json:"available"`
+}
+
+// reservationExpiry is when the reservations of a transaction stop holding collateral back: the margin call deadline,
+// or a reservationLifetime after the margin call date when there is none
+func reservationExpiry(marginCallTimestamp string, deadline string) string {
+	if _, err := strconv.ParseInt(deadline, 10, 64); err == nil {
+		return deadline
+	}
+	marginCallDate, _ := strconv.ParseInt(marginCallTimestamp, 10, 64)
+	return strconv.FormatInt(marginCallDate+reservationLifetime, 10)
+}
+
+// reservedByOthers is the quantity of each security of an account the reservations of other transactions hold back
+func reservedByOthers(stub shim.ChaincodeStubInterface, accountChaincode string, account string, transactionId string) (map[string]float64, error) {
+	availabilityAsBytes, err := chaincode.InvokeChaincode(stub, accountChaincode, chaincode.ToChaincodeArgs("getAvailability_byAccount", account, transactionId))
+	if err != nil {
+		return nil, err
+	}
+	var positions []PositionAvailability
+	json.Unmarshal(availabilityAsBytes, &positions)
+	reserved := make(map[string]float64)
+	for _, position := range positions {
+		if quantity, _ := strconv.ParseFloat(position.Reserved, 64); quantity > 0 {
+			reserved[position.SecurityID] = quantity
+		}
+	}
+	return reserved, nil
+}
+
+// Reservation is what a transaction reserved on an account, as the Account chaincode keeps it
+type Reservation struct {
+	TransactionID string            `json:"transactionId"`
+	AccountNumber string            `json:"accountNumber"`
+	Quantities    map[string]string `json:"quantities"`
+	Status        string            `json:"status"`
+	ExpiresAt     string            `json:"expiresAt"`
+}
+
+// reservedFor is the quantity of each security of an account the reservation of a transaction holds back for it at
+// now, nil when the transaction holds none
+func reservedFor(stub shim.ChaincodeStubInterface, accountChaincode string, account string, transactionId string, now int64) (map[string]float64, error) {
+	reservationAsBytes, err := chaincode.InvokeChaincode(stub, accountChaincode, chaincode.ToChaincodeArgs("getReservation_byTransactionID", transactionId, account))
+	if chaincode.IsErrorCode(err, chaincode.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var reservation Reservation
+	json.Unmarshal(reservationAsBytes, &reservation)
+	if expiresAt, err := strconv.ParseInt(reservation.ExpiresAt, 10, 64); reservation.Status != "Reserved" || (err == nil && now >= expiresAt) {
+		return nil, nil
+	}
+	reserved := make(map[string]float64)
+	for securityId, quantity := range reservation.Quantities {
+		reserved[securityId], _ = strconv.ParseFloat(quantity, 64)
+	}
+	return reserved, nil
+}
+
+// reserveCollateral holds the quantities an allocation plans to take out of an account back for its transaction until
+// expiresAt, in place of what the transaction reserved on the account before
+func reserveCollateral(stub shim.ChaincodeStubInterface, accountChaincode string, transactionId string, account string, planned map[string]float64, expiresAt string) error {
+	quantities := make(map[string]string)
+	for securityId, quantity := range planned {
+		quantities[securityId] = strconv.FormatFloat(quantity, 'f', 2, 64)
+	}
+	quantitiesAsBytes, _ := json.Marshal(quantities)
+	_, err := chaincode.InvokeChaincode(stub, accountChaincode, chaincode.ToChaincodeArgs("reserve_securities", transactionId, account, string(quantitiesAsBytes), expiresAt))
+	return err
+}
+
+// sortedSecurityIds are the security ids of positions in order, so the ledger is written the same way on every peer
+func sortedSecurityIds(positions map[string]Securities) []string {
+	ids := make([]string, 0, len(positions))
+	for id := range positions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,374 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package allocation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+// name for the key/value that will store a list of all known movement ids
+var movementIndexStr = "_movementIndex"
+
+// Settlement statuses a movement goes through; a failed movement is instructed again by retry_settlement
+var (
+	settlementInstructed = "Instructed"
+	settlementMatched    = "Matched"
+	settlementSettled    = "Settled"
+	settlementFailed     = "Failed"
+)
+
+// Movements are deliveries of collateral between the longbox and the segregated account of a deal.
+// The delivering account is debited when the movement is instructed, the receiving account is only
+// credited with what has settled, so collateral in flight does not count towards coverage
+type Movements struct {
+	chaincode.Record
+	MovementID             string     `json:"movementId"`
+	TransactionID          string     `json:"transactionId"`
+	DealID                 string     `json:"dealId"`
+	FromAccount            string     `json:"fromAccount"`
+	ToAccount              string     `json:"toAccount"`
+	Direction              string     `json:"direction"` // "Call" from the longbox into the segregated account, "Return" back to the longbox
+	Security               Securities `json:"security"`  // position delivered, its quantity and total value are those of the whole movement
+	Quantity               string     `json:"quantity"`
+	SettledQuantity        string     `json:"settledQuantity"`
+	SettlementStatus       string     `json:"settlementStatus"`
+	IntendedSettlementDate string     `json:"intendedSettlementDate"`
+	Attempts               string     `json:"attempts"`
+	FailureReason          string     `json:"failureReason"`
+}
+
+// settlementSplit splits the positions allocated to the segregated account into the quantity it already
+// holds, per allocated position, and what it holds beyond the allocation and has to return, per security
+func settlementSplit(previous []Securities, allocated []Securities) ([]float64, map[string]float64) {
+	heldLeft := make(map[string]float64)
+	for _, security := range previous {
+		quantity, _ := strconv.ParseFloat(security.SecuritiesQuantity, 64)
+		heldLeft[security.SecurityId] += quantity
+	}
+	held := make([]float64, len(allocated))
+	for i, security := range allocated {
+		quantity, _ := strconv.ParseFloat(security.SecuritiesQuantity, 64)
+		held[i] = math.Min(quantity, heldLeft[security.SecurityId])
+		heldLeft[security.SecurityId] -= held[i]
+	}
+	for securityId, quantity := range heldLeft {
+		if quantity < 0.005 {
+			delete(heldLeft, securityId)
+		}
+	}
+	return held, heldLeft
+}
+
+// slicePosition is part of a position with its total value in proportion to the quantity
+func slicePosition(security Securities, quantity float64) Securities {
+	total, _ := strconv.ParseFloat(security.SecuritiesQuantity, 64)
+	value, _ := strconv.ParseFloat(security.TotalValue, 64)
+	if total > 0 {
+		value = value * quantity / total
+	}
+	security.SecuritiesQuantity = strconv.FormatFloat(quantity, 'f', 2, 64)
+	security.TotalValue = strconv.FormatFloat(value, 'f', 2, 64)
+	return security
+}
+
+// poolPosition adds a position to the positions of both accounts, to the position of the same security when there is one.
+// The pooled position keeps the effective value of the one already pooled
+func poolPosition(pool []Securities, security Securities) []Securities {
+	for i, pooled := range pool {
+		if pooled.SecurityId != security.SecurityId {
+			continue
+		}
+		quantity, _ := strconv.ParseFloat(pooled.SecuritiesQuantity, 64)
+		added, _ := strconv.ParseFloat(security.SecuritiesQuantity, 64)
+		effectiveValue, _ := strconv.ParseFloat(pooled.EffectiveValueinUSD, 64)
+		pool[i].SecuritiesQuantity = strconv.FormatFloat(quantity+added, 'f', 2, 64)
+		pool[i].TotalValue = strconv.FormatFloat((quantity+added)*effectiveValue, 'f', 2, 64)
+		return pool
+	}
+	return append(pool, security)
+}
+
+// instructMovement stores a new movement of a position and adds it to the movement index. Movements are keyed by
+// the allocation that instructs them, so allocating a transaction again keeps the movements of earlier runs
+func instructMovement(stub shim.ChaincodeStubInterface, transaction Transactions, direction string, from string, to string, security Securities, intendedSettlementDate string) (Movements, error) {
+	movement := Movements{
+		MovementID:             transaction.TransactionId + "-" + stub.GetTxID() + "-" + security.SecurityId + "-" + direction,
+		TransactionID:          transaction.TransactionId,
+		DealID:                 transaction.DealID,
+		FromAccount:            from,
+		ToAccount:              to,
+		Direction:              direction,
+		Security:               security,
+		Quantity:               security.SecuritiesQuantity,
+		SettledQuantity:        "0.00",
+		SettlementStatus:       settlementInstructed,
+		IntendedSettlementDate: intendedSettlementDate,
+		Attempts:               "1",
+	}
+	err := putMovement(stub, movement)
+	if err != nil {
+		return movement, err
+	}
+	movementIndexAsBytes, err := stub.GetState(movementIndexStr)
+	if err != nil {
+		return movement, errors.New("Failed to get movement index")
+	}
+	var movementIndex []string
+	json.Unmarshal(movementIndexAsBytes, &movementIndex)
+	for _, movementId := range movementIndex {
+		if movementId == movement.MovementID {
+			return movement, nil
+		}
+	}
+	movementIndex = append(movementIndex, movement.MovementID)
+	jsonAsBytes, _ := json.Marshal(movementIndex)
+	return movement, stub.PutState(movementIndexStr, jsonAsBytes)
+}
+
+// putMovement writes a movement with its id as key
+func putMovement(stub shim.ChaincodeStubInterface, movement Movements) error {
+	return chaincode.PutRecord(stub, movement.MovementID, &movement)
+}
+
+// getMovements reads every movement the filter accepts
+func getMovements(stub shim.ChaincodeStubInterface, filter func(Movements) bool) ([]Movements, error) {
+	movements := []Movements{}
+	movementIndexAsBytes, err := stub.GetState(movementIndexStr)
+	if err != nil {
+		return movements, errors.New("Failed to get movement index")
+	}
+	var movementIndex []string
+	json.Unmarshal(movementIndexAsBytes, &movementIndex)
+	seen := make(map[string]bool)
+	for _, movementId := range movementIndex {
+		// indexes written before movements were keyed by allocation list a re-allocated movement more than once
+		if seen[movementId] {
+			continue
+		}
+		seen[movementId] = true
+		movementAsBytes, err := stub.GetState(movementId)
+		if err != nil {
+			return movements, errors.New("Failed to get movement " + movementId)
+		}
+		movement := Movements{}
+		json.Unmarshal(movementAsBytes, &movement)
+		if filter(movement) {
+			movements = append(movements, movement)
+		}
+	}
+	return movements, nil
+}
+
+// ============================================================================================================================
+// update_settlement_status - a movement was matched, settled in full or in part, or failed at the settlement agent.
+// 'SettledQuantity' is the quantity settled now, the rest of the movement when empty
+// ============================================================================================================================
+func (t *ManageAllocations) update_settlement_status(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 6 {
+		return nil, chaincode.SendError(stub, "update_settlement_status", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'AccountChaincode', 'DealChaincode', 'MovementID', 'Status', 'SettledQuantity' and 'Reason'")
+	}
+	fmt.Println("start update_settlement_status")
+	AccountChaincode := args[0]
+	DealChaincode := args[1]
+	MovementID := args[2]
+	Status := args[3]
+
+	movementAsBytes, err := stub.GetState(MovementID)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "update_settlement_status", chaincode.ErrUpstream, chaincode.Entities{MovementID: MovementID}, "Failed to get movement "+MovementID)
+	}
+	movement := Movements{}
+	json.Unmarshal(movementAsBytes, &movement)
+	if movement.MovementID != MovementID {
+		return nil, chaincode.SendError(stub, "update_settlement_status", chaincode.ErrNotFound, chaincode.Entities{MovementID: MovementID}, MovementID+" Not Found.")
+	}
+	if movement.SettlementStatus == settlementSettled || movement.SettlementStatus == settlementFailed {
+		return nil, chaincode.SendError(stub, "update_settlement_status", chaincode.ErrConflict, chaincode.Entities{MovementID: MovementID}, "Movement is "+movement.SettlementStatus+", retry it before updating.")
+	}
+
+	quantity, _ := strconv.ParseFloat(movement.Quantity, 64)
+	settled, _ := strconv.ParseFloat(movement.SettledQuantity, 64)
+	message := ""
+	switch Status {
+	case settlementMatched:
+		movement.SettlementStatus = settlementMatched
+		message = "Movement matched succcessfully"
+	case settlementFailed:
+		movement.SettlementStatus = settlementFailed
+		movement.FailureReason = args[5]
+		message = "Settlement failed and has to be retried"
+	case settlementSettled:
+		settledNow := quantity - settled
+		if args[4] != "" {
+			settledNow, err = strconv.ParseFloat(args[4], 64)
+			if err != nil || settledNow <= 0 || settled+settledNow > quantity+0.001 {
+				return nil, chaincode.SendError(stub, "update_settlement_status", chaincode.ErrValidation, chaincode.Entities{MovementID: MovementID}, "Settled quantity must be a number greater than 0 and not more than the quantity left to settle.")
+			}
+		}
+		// Credit the receiving account with what settled
+		credited := slicePosition(movement.Security, settledNow)
+		invokeArgs := chaincode.ToChaincodeArgs("credit_security", credited.SecurityId,
+			movement.ToAccount,
+			credited.SecuritiesName,
+			credited.SecuritiesQuantity,
+			credited.SecurityType,
+			credited.CollateralForm,
+			credited.TotalValue,
+			credited.ValuePercentage,
+			credited.MTM,
+			credited.EffectivePercentage,
+			credited.EffectiveValueinUSD,
+			credited.Currency)
+		_, err = chaincode.InvokeChaincode(stub, AccountChaincode, invokeArgs)
+		if err != nil {
+			return nil, chaincode.CalledError(stub, "update_settlement_status", chaincode.Entities{DealID: movement.DealID, TransactionID: movement.TransactionID, MovementID: MovementID}, "Failed to credit "+movement.ToAccount+" from 'Account' chaincode", err)
+		}
+		// Cash counts towards interest from the day it settles
+		if isCash(movement.Security) {
+			amount := settledNow
+			if movement.Direction == "Return" {
+				amount = -amount
+			}
+			invokeArgs = chaincode.ToChaincodeArgs("record_cash_posting", movement.DealID, movement.Security.Currency, strconv.FormatFloat(amount, 'f', 2, 64))
+			_, err = chaincode.InvokeChaincode(stub, DealChaincode, invokeArgs)
+			if err != nil {
+				return nil, chaincode.CalledError(stub, "update_settlement_status", chaincode.Entities{DealID: movement.DealID, TransactionID: movement.TransactionID, MovementID: MovementID}, "Failed to record "+movement.Security.Currency+" cash posting in 'Deal' chaincode", err)
+			}
+		}
+		settled += settledNow
+		movement.SettledQuantity = strconv.FormatFloat(settled, 'f', 2, 64)
+		if settled >= quantity-0.001 {
+			movement.SettlementStatus = settlementSettled
+		}
+		message = "Settlement recorded succcessfully"
+	default:
+		return nil, chaincode.SendError(stub, "update_settlement_status", chaincode.ErrValidation, chaincode.Entities{MovementID: MovementID}, "Status must be '"+settlementMatched+"', '"+settlementSettled+"' or '"+settlementFailed+"'.")
+	}
+	err = putMovement(stub, movement)
+	if err != nil {
+		return nil, err
+	}
+
+	// The allocation of a transaction is only done once all its movements settled
+	if movement.SettlementStatus == settlementSettled {
+		unsettled, err := getMovements(stub, func(m Movements) bool {
+			return m.TransactionID == movement.TransactionID && m.SettlementStatus != settlementSettled
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(unsettled) == 0 {
+			invokeArgs := chaincode.ToChaincodeArgs("update_transaction_AllocationStatus", movement.TransactionID, "Allocation Successful")
+			_, err = chaincode.InvokeChaincode(stub, DealChaincode, invokeArgs)
+			if err != nil {
+				return nil, chaincode.CalledError(stub, "update_settlement_status", chaincode.Entities{DealID: movement.DealID, TransactionID: movement.TransactionID, MovementID: MovementID}, "Failed to update Transaction status from 'Deal' chaincode", err)
+			}
+			err = updateAllocationReportStatus(stub, movement.TransactionID, "Allocation Successful")
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	data := map[string]string{"settlementStatus": movement.SettlementStatus, "settledQuantity": movement.SettledQuantity}
+	if movement.SettlementStatus == settlementFailed {
+		data["reason"] = movement.FailureReason
+	}
+	err = chaincode.SendEvent(stub, "update_settlement_status", chaincode.Entities{DealID: movement.DealID, TransactionID: movement.TransactionID, MovementID: MovementID}, message, data)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end update_settlement_status")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// retry_settlement - instruct a failed movement again for a new intended settlement date
+// ============================================================================================================================
+func (t *ManageAllocations) retry_settlement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 2 {
+		return nil, chaincode.SendError(stub, "retry_settlement", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'MovementID' and 'IntendedSettlementDate'")
+	}
+	fmt.Println("start retry_settlement")
+	MovementID := args[0]
+	movementAsBytes, err := stub.GetState(MovementID)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "retry_settlement", chaincode.ErrUpstream, chaincode.Entities{MovementID: MovementID}, "Failed to get movement "+MovementID)
+	}
+	movement := Movements{}
+	json.Unmarshal(movementAsBytes, &movement)
+	if movement.MovementID != MovementID || movement.SettlementStatus != settlementFailed {
+		return nil, chaincode.SendError(stub, "retry_settlement", chaincode.ErrConflict, chaincode.Entities{MovementID: MovementID}, "Only a failed movement can be retried.")
+	}
+	attempts, _ := strconv.Atoi(movement.Attempts)
+	movement.Attempts = strconv.Itoa(attempts + 1)
+	movement.SettlementStatus = settlementInstructed
+	movement.IntendedSettlementDate = args[1]
+	movement.FailureReason = ""
+	err = putMovement(stub, movement)
+	if err != nil {
+		return nil, err
+	}
+	err = chaincode.SendEvent(stub, "retry_settlement", chaincode.Entities{MovementID: MovementID}, "Movement instructed again succcessfully", map[string]string{"attempts": movement.Attempts})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end retry_settlement")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// getMovements_byTransactionID - get the movements instructed for the allocation of a transaction
+// ============================================================================================================================
+func (t *ManageAllocations) getMovements_byTransactionID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("start getMovements_byTransactionID")
+	if len(args) != 1 {
+		return nil, chaincode.SendError(stub, "getMovements_byTransactionID", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'TransactionID' as an argument")
+	}
+	movements, err := getMovements(stub, func(m Movements) bool { return m.TransactionID == args[0] })
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end getMovements_byTransactionID")
+	return json.Marshal(movements)
+}
+
+// ============================================================================================================================
+// getFailedSettlements - get every movement that failed and waits for a retry
+// ============================================================================================================================
+func (t *ManageAllocations) getFailedSettlements(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("start getFailedSettlements")
+	movements, err := getMovements(stub, func(m Movements) bool { return m.SettlementStatus == settlementFailed })
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end getFailedSettlements")
+	return json.Marshal(movements)
+}
@@ -0,0 +1,127 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package allocation
+
+import (
+	"math"
+	"testing"
+)
+
+// The solution of a linear program is a vertex, the one with the highest objective
+func TestSimplexSolvesLinearProgram(t *testing.T) {
+	x := simplex([]float64{3, 2}, [][]float64{{1, 1}, {1, 3}}, []float64{4, 6})
+	if !near(x[0], 4) || !near(x[1], 0) {
+		t.Fatalf("expected x = (4, 0), got %v", x)
+	}
+	x = simplex([]float64{1, 1}, [][]float64{{2, 1}, {1, 2}}, []float64{4, 4})
+	if !near(x[0], 4.0/3) || !near(x[1], 4.0/3) {
+		t.Fatalf("expected x = (4/3, 4/3), got %v", x)
+	}
+}
+
+// Beale's program cycles with the textbook pivoting rule, Bland's rule solves it
+func TestSimplexDegenerate(t *testing.T) {
+	c := []float64{0.75, -150, 0.02, -6}
+	A := [][]float64{
+		{0.25, -60, -0.04, 9},
+		{0.5, -90, -0.02, 3},
+		{0, 0, 1, 0},
+	}
+	x := simplex(c, A, []float64{0, 0, 1})
+	if objective := 0.75*x[0] - 150*x[1] + 0.02*x[2] - 6*x[3]; !near(objective, 0.05) {
+		t.Fatalf("expected an objective of 0.05, got %v at %v", objective, x)
+	}
+	if !near(x[0], 0.04) || !near(x[2], 1) {
+		t.Fatalf("expected x = (0.04, 0, 1, 0), got %v", x)
+	}
+}
+
+// A program the constraints do not bound stops at the vertex it reached, the origin when there is no other
+func TestSimplexUnbounded(t *testing.T) {
+	x := simplex([]float64{1}, [][]float64{{-1}}, []float64{1})
+	if len(x) != 1 || !near(x[0], 0) {
+		t.Fatalf("expected the origin, got %v", x)
+	}
+}
+
+// Quantities the program gives in fractions of a unit are rounded down, the shortfall is topped up with a position
+// the concentration limits leave room for
+func TestOptimiseTopsUpRoundedQuantities(t *testing.T) {
+	calls := []openCall{testCall(1000, map[string]map[string]float64{
+		"Common Stocks": {"Concentration Limit": 100, "Priority": 1, "Valuation Percentage": 100},
+		"Cash":          {"Concentration Limit": 100, "Priority": 16, "Valuation Percentage": 100},
+	})}
+	positions := []candidate{
+		testPosition("IBM", "Common Stocks", "LB", 30, 100),
+		testPosition("USD", "Cash", "LB", 1, 100),
+	}
+	quantities := optimise(calls, positions, "LB")
+	if quantities[0][0] != 33 || !near(quantities[0][1], 10) {
+		t.Fatalf("expected 33 IBM topped up with 10 USD, got %v", quantities[0])
+	}
+}
+
+// A call the positions cannot cover is assigned what they hold and no more
+func TestOptimiseInfeasibleCall(t *testing.T) {
+	calls := []openCall{testCall(10000, map[string]map[string]float64{
+		"Common Stocks":   {"Concentration Limit": 100, "Priority": 1, "Valuation Percentage": 97},
+		"Corporate Bonds": {"Concentration Limit": 100, "Priority": 2, "Valuation Percentage": 97},
+	})}
+	positions := []candidate{
+		testPosition("IBM", "Common Stocks", "LB", 150, 20),
+		testPosition("CB-1", "Corporate Bonds", "LB", 100, 30),
+	}
+	quantities := optimise(calls, positions, "LB")
+	if quantities[0][0] != 20 || quantities[0][1] != 30 {
+		t.Fatalf("expected all 20 IBM and 30 CB-1 assigned, got %v", quantities[0])
+	}
+}
+
+// No form covers more of a call than its concentration limit, the rest comes from the costlier form
+func TestOptimiseKeepsConcentrationLimits(t *testing.T) {
+	calls := []openCall{testCall(1000, map[string]map[string]float64{
+		"Common Stocks":   {"Concentration Limit": 40, "Priority": 1, "Valuation Percentage": 100},
+		"Corporate Bonds": {"Concentration Limit": 100, "Priority": 2, "Valuation Percentage": 90},
+	})}
+	positions := []candidate{
+		testPosition("IBM", "Common Stocks", "LB", 10, 1000),
+		testPosition("CB-1", "Corporate Bonds", "LB", 10, 1000),
+	}
+	quantities := optimise(calls, positions, "LB")
+	stocks, bonds := quantities[0][0]*10, quantities[0][1]*10*0.9
+	if stocks > 400+1e-9 || stocks < 390 {
+		t.Fatalf("expected stocks up to their limit of 400, got %v", stocks)
+	}
+	if stocks+bonds < 1000 {
+		t.Fatalf("expected the call covered, got %v of stocks and %v of bonds", stocks, bonds)
+	}
+}
+
+func testCall(rqv float64, terms map[string]map[string]float64) openCall {
+	return openCall{planned: PlannedAllocation{Currency: "USD", PledgeeSegregatedAccount: "SG"}, rqv: rqv, ruleset: Ruleset{Security: terms}}
+}
+
+func testPosition(id string, form string, account string, price float64, available float64) candidate {
+	return candidate{security: Securities{SecurityId: id, AccountNumber: account, CollateralForm: form, Currency: "USD"}, price: price, available: available}
+}
+
+func near(x float64, y float64) bool {
+	return math.Abs(x-y) < 1e-6
+}
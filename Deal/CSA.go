@@ -0,0 +1,147 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package deal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+	"github.com/mukutb/TCM/validation"
+)
+
+// Number of credit support annex terms create_deal, update_deal and update_csa_terms take, in this order:
+// pledgerThreshold, pledgeeThreshold, minimumTransferAmount, independentAmount, roundingAmount,
+// roundingConvention, valuationAgent, baseCurrency and eligibleCollateral
+var csaTermCount = 9
+
+// Ways a delivery can be rounded to the rounding amount; "CallsUpReturnsDown" is the ISDA default
+var roundingConventions = []string{"CallsUpReturnsDown", "Up", "Down", "Nearest"}
+
+// setCSATerms copies the credit support annex terms into a deal, blank amounts default to zero
+func setCSATerms(deal *Deals, terms []string) {
+	amount := func(term string) string {
+		if strings.TrimSpace(term) == "" {
+			return "0"
+		}
+		return term
+	}
+	deal.PledgerThreshold = amount(terms[0])
+	deal.PledgeeThreshold = amount(terms[1])
+	deal.MinimumTransferAmount = amount(terms[2])
+	deal.IndependentAmount = amount(terms[3])
+	deal.RoundingAmount = amount(terms[4])
+	deal.RoundingConvention = terms[5]
+	if deal.RoundingConvention == "" {
+		deal.RoundingConvention = roundingConventions[0]
+	}
+	deal.ValuationAgent = terms[6]
+	deal.BaseCurrency = terms[7]
+	deal.EligibleCollateral = terms[8]
+}
+
+// keptCSATerms are the terms an update gives with the ones it leaves out taken from the deal, so they keep their stored value
+func keptCSATerms(deal Deals, terms []string) []string {
+	stored := []string{deal.PledgerThreshold, deal.PledgeeThreshold, deal.MinimumTransferAmount, deal.IndependentAmount,
+		deal.RoundingAmount, deal.RoundingConvention, deal.ValuationAgent, deal.BaseCurrency, deal.EligibleCollateral}
+	kept := append([]string{}, terms...)
+	for i, term := range kept {
+		if term == keepStored {
+			kept[i] = stored[i]
+		}
+	}
+	return kept
+}
+
+// validateCSATerms checks the credit support annex terms of a deal, in the order setCSATerms takes them
+func validateCSATerms(deal Deals) error {
+	v := validation.Validator{}
+	v.NonNegative("pledgerThreshold", deal.PledgerThreshold)
+	v.NonNegative("pledgeeThreshold", deal.PledgeeThreshold)
+	v.NonNegative("minimumTransferAmount", deal.MinimumTransferAmount)
+	v.NonNegative("independentAmount", deal.IndependentAmount)
+	v.NonNegative("roundingAmount", deal.RoundingAmount)
+	v.Required("roundingConvention", deal.RoundingConvention)
+	v.OneOf("roundingConvention", deal.RoundingConvention, roundingConventions)
+	if deal.ValuationAgent != "" && deal.ValuationAgent != deal.Pledger && deal.ValuationAgent != deal.Pledgee {
+		v.Add("valuationAgent", "must be the pledger or the pledgee of the deal, got '"+deal.ValuationAgent+"'")
+	}
+	v.Currency("baseCurrency", deal.BaseCurrency)
+	if deal.EligibleCollateral != "" {
+		for _, form := range strings.Split(deal.EligibleCollateral, ",") {
+			if strings.TrimSpace(form) == "" {
+				v.Add("eligibleCollateral", "must be a comma separated list of collateral forms, got '"+deal.EligibleCollateral+"'")
+				break
+			}
+		}
+	}
+	return v.Err()
+}
+
+// isEligibleForDeal reports whether the eligible collateral schedule of a deal accepts a collateral form, an empty schedule accepts all
+func isEligibleForDeal(deal Deals, collateralForm string) bool {
+	if deal.EligibleCollateral == "" {
+		return true
+	}
+	for _, form := range strings.Split(deal.EligibleCollateral, ",") {
+		if strings.TrimSpace(form) == collateralForm {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================================================================================================
+// update_csa_terms - replace the credit support annex terms of a deal, terms a JSON object leaves out keep their value
+// ============================================================================================================================
+func (t *ManageDeals) update_csa_terms(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != csaTermCount+1 {
+		return nil, chaincode.SendError(stub, "update_csa_terms", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'dealId' and "+strconv.Itoa(csaTermCount)+" CSA terms")
+	}
+	fmt.Println("start update_csa_terms")
+	_dealId := args[0]
+	deal := Deals{}
+	dealAsBytes, err := stub.GetState(_dealId)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "update_csa_terms", chaincode.ErrUpstream, chaincode.Entities{DealID: _dealId}, "Failed to get Deal "+_dealId)
+	}
+	json.Unmarshal(dealAsBytes, &deal)
+	if deal.DealID != _dealId {
+		return nil, chaincode.SendError(stub, "update_csa_terms", chaincode.ErrNotFound, chaincode.Entities{DealID: _dealId}, _dealId+" Not Found.")
+	}
+	setCSATerms(&deal, keptCSATerms(deal, args[1:]))
+	if err := validateCSATerms(deal); err != nil {
+		return nil, chaincode.SendInvalid(stub, "update_csa_terms", chaincode.Entities{DealID: _dealId}, err)
+	}
+	err = putDeal(stub, deal)
+	if err != nil {
+		return nil, err
+	}
+	err = chaincode.SendEvent(stub, "update_csa_terms", chaincode.Entities{DealID: _dealId}, "CSA terms updated succcessfully", nil)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end update_csa_terms")
+	return nil, nil
+}
@@ -0,0 +1,258 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package deal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // cutoff timezones are known on peers without a zoneinfo database
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+	"github.com/mukutb/TCM/validation"
+)
+
+// Prefix of the key holding the holiday calendar of a market, stored as calendarPrefix + market
+var calendarPrefix = "CAL-"
+
+// Holidays are given as dates in this layout
+var calendarDateLayout = "2006-01-02"
+
+// Deals without their own cutoff settle margin calls the same day by 18:00 UTC
+var defaultCutoffTime = "18:00"
+var defaultCutoffTimezone = "UTC"
+
+type Calendars struct {
+	chaincode.Record
+	Market   string   `json:"market"`
+	Holidays []string `json:"holidays"` // dates in calendarDateLayout, weekends are never business days
+}
+
+// ============================================================================================================================
+// set_calendar - replace the holidays of a market
+// ============================================================================================================================
+func (t *ManageDeals) set_calendar(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 2 {
+		return nil, chaincode.SendError(stub, "set_calendar", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'market' and comma separated 'holidays'")
+	}
+	fmt.Println("start set_calendar")
+	calendar := Calendars{Market: args[0], Holidays: []string{}}
+	for _, holiday := range strings.Split(args[1], ",") {
+		holiday = strings.TrimSpace(holiday)
+		if holiday == "" {
+			continue
+		}
+		if _, err = time.Parse(calendarDateLayout, holiday); err != nil {
+			return nil, chaincode.SendError(stub, "set_calendar", chaincode.ErrValidation, chaincode.Entities{Market: calendar.Market}, "Holiday "+holiday+" is not a date as YYYY-MM-DD.")
+		}
+		calendar.Holidays = append(calendar.Holidays, holiday)
+	}
+	err = chaincode.PutRecord(stub, calendarPrefix+calendar.Market, &calendar)
+	if err != nil {
+		return nil, err
+	}
+	err = chaincode.SendEvent(stub, "set_calendar", chaincode.Entities{Market: calendar.Market}, "Calendar updated succcessfully", nil)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end set_calendar")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// update_deal_cutoff - set the calendars, cutoff time, timezone and settlement days of a deal's margin calls
+// ============================================================================================================================
+func (t *ManageDeals) update_deal_cutoff(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 5 {
+		return nil, chaincode.SendError(stub, "update_deal_cutoff", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'dealId', 'calendars', 'cutoffTime', 'cutoffTimezone' and 'settlementDays'")
+	}
+	fmt.Println("start update_deal_cutoff")
+	_dealId := args[0]
+	deal := Deals{}
+	dealAsBytes, err := stub.GetState(_dealId)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "update_deal_cutoff", chaincode.ErrUpstream, chaincode.Entities{DealID: _dealId}, "Failed to get Deal "+_dealId)
+	}
+	json.Unmarshal(dealAsBytes, &deal)
+	if deal.DealID != _dealId {
+		return nil, chaincode.SendError(stub, "update_deal_cutoff", chaincode.ErrNotFound, chaincode.Entities{DealID: _dealId}, _dealId+" Not Found.")
+	}
+	deal.Calendars = args[1]
+	deal.CutoffTime = args[2]
+	deal.CutoffTimezone = args[3]
+	deal.SettlementDays = args[4]
+	invalid := ""
+	if _, _, err = cutoffClock(deal); err != nil {
+		invalid = "Cutoff time must be given as HH:MM."
+	} else if _, err = time.LoadLocation(deal.CutoffTimezone); err != nil {
+		invalid = "Cutoff timezone " + deal.CutoffTimezone + " is not known."
+	} else if days, err := strconv.Atoi(deal.SettlementDays); err != nil || days < 0 {
+		invalid = "Settlement days must be a positive whole number."
+	}
+	if invalid != "" {
+		return nil, chaincode.SendError(stub, "update_deal_cutoff", chaincode.ErrValidation, chaincode.Entities{DealID: _dealId}, invalid)
+	}
+	err = putDeal(stub, deal)
+	if err != nil {
+		return nil, err
+	}
+	err = chaincode.SendEvent(stub, "update_deal_cutoff", chaincode.Entities{DealID: _dealId}, "Deal cutoff updated succcessfully", nil)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end update_deal_cutoff")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// getCalendar_byMarket - get the holidays of a market
+// ============================================================================================================================
+func (t *ManageDeals) getCalendar_byMarket(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("start getCalendar_byMarket")
+	if len(args) != 1 {
+		return nil, chaincode.SendError(stub, "getCalendar_byMarket", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'market' as an argument")
+	}
+	calendar, err := getCalendar(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end getCalendar_byMarket")
+	return json.Marshal(calendar)
+}
+
+// ============================================================================================================================
+// getMarginCallDeadline_byTransactionID - get the time by which the collateral of a margin call has to be delivered
+// ============================================================================================================================
+func (t *ManageDeals) getMarginCallDeadline_byTransactionID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("start getMarginCallDeadline_byTransactionID")
+	if len(args) != 1 {
+		return nil, chaincode.SendError(stub, "getMarginCallDeadline_byTransactionID", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'transactionId' as an argument")
+	}
+	_transactionId := args[0]
+	transaction := Transactions{}
+	transactionAsBytes, err := stub.GetState(_transactionId)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "getMarginCallDeadline_byTransactionID", chaincode.ErrUpstream, chaincode.Entities{TransactionID: _transactionId}, "Failed to get Transaction "+_transactionId)
+	}
+	json.Unmarshal(transactionAsBytes, &transaction)
+	deal := Deals{}
+	dealAsBytes, err := stub.GetState(transaction.DealID)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "getMarginCallDeadline_byTransactionID", chaincode.ErrUpstream, chaincode.Entities{DealID: transaction.DealID}, "Failed to get Deal "+transaction.DealID)
+	}
+	json.Unmarshal(dealAsBytes, &deal)
+	if transaction.TransactionId != _transactionId || deal.DealID != transaction.DealID {
+		return nil, chaincode.SendError(stub, "getMarginCallDeadline_byTransactionID", chaincode.ErrNotFound, chaincode.Entities{TransactionID: _transactionId}, _transactionId+" not Found.")
+	}
+	deadline, err := marginCallDeadline(stub, deal, transaction.MarginCAllDate)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "getMarginCallDeadline_byTransactionID", chaincode.ErrValidation, chaincode.Entities{TransactionID: _transactionId}, err.Error())
+	}
+	fmt.Println("end getMarginCallDeadline_byTransactionID")
+	return []byte("{ \"transactionId\" : \"" + _transactionId + "\", \"deadline\" : \"" + strconv.FormatInt(deadline.Unix(), 10) + "\", \"deadlineDate\" : \"" + deadline.Format(time.RFC3339) + "\"}"), nil
+}
+
+// getCalendar reads the holidays of a market, a market without a calendar only closes at weekends
+func getCalendar(stub shim.ChaincodeStubInterface, market string) (Calendars, error) {
+	calendar := Calendars{Market: market, Holidays: []string{}}
+	calendarAsBytes, err := stub.GetState(calendarPrefix + market)
+	if err != nil {
+		return calendar, errors.New("Failed to get calendar of " + market)
+	}
+	json.Unmarshal(calendarAsBytes, &calendar)
+	return calendar, nil
+}
+
+// cutoffClock splits the cutoff time of a deal into hour and minute
+func cutoffClock(deal Deals) (int, int, error) {
+	cutoff := deal.CutoffTime
+	if cutoff == "" {
+		cutoff = defaultCutoffTime
+	}
+	clock, err := time.Parse("15:04", cutoff)
+	if err != nil {
+		return 0, 0, err
+	}
+	return clock.Hour(), clock.Minute(), nil
+}
+
+// marginCallDeadline is the cutoff of the business day the collateral of a margin call is due on.
+// A call made after the cutoff, or on a weekend or a holiday of any calendar of the deal, counts from the next business day
+func marginCallDeadline(stub shim.ChaincodeStubInterface, deal Deals, marginCallDate string) (time.Time, error) {
+	timezone := deal.CutoffTimezone
+	if timezone == "" {
+		timezone = defaultCutoffTimezone
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, errors.New("Cutoff timezone " + timezone + " is not known.")
+	}
+	// A margin call date without a time is the start of that day where the deal's cutoff is kept
+	called, err := validation.ParseDateIn(marginCallDate, location)
+	if err != nil {
+		return called, errors.New("Margin call date " + marginCallDate + " is not a date.")
+	}
+	hour, minute, err := cutoffClock(deal)
+	if err != nil {
+		return called, errors.New("Cutoff time " + deal.CutoffTime + " is not a time.")
+	}
+	settlementDays, _ := strconv.Atoi(deal.SettlementDays)
+	holidays := make(map[string]bool)
+	for _, market := range strings.Split(deal.Calendars, ",") {
+		market = strings.TrimSpace(market)
+		if market == "" {
+			continue
+		}
+		calendar, err := getCalendar(stub, market)
+		if err != nil {
+			return called, err
+		}
+		for _, holiday := range calendar.Holidays {
+			holidays[holiday] = true
+		}
+	}
+	isBusinessDay := func(day time.Time) bool {
+		return day.Weekday() != time.Saturday && day.Weekday() != time.Sunday && !holidays[day.Format(calendarDateLayout)]
+	}
+
+	called = called.In(location)
+	day := time.Date(called.Year(), called.Month(), called.Day(), hour, minute, 0, 0, location)
+	if called.After(day) || !isBusinessDay(day) {
+		day = day.AddDate(0, 0, 1)
+		for !isBusinessDay(day) {
+			day = day.AddDate(0, 0, 1)
+		}
+	}
+	for settlementDays > 0 {
+		day = day.AddDate(0, 0, 1)
+		if isBusinessDay(day) {
+			settlementDays--
+		}
+	}
+	return day, nil
+}
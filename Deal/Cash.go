@@ -0,0 +1,206 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package deal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+// Cash posted under a deal is stored under this prefix, apart from the deals and transactions keyed by their bare ids
+var cashCollateralKeyPrefix = "_cash-"
+
+// Interest on posted cash follows the ACT/360 convention
+var cashInterestDayBasis = 360.0
+
+type CashBalance struct {
+	Currency        string `json:"currency"`
+	PostedAmount    string `json:"postedAmount"`
+	AccruedInterest string `json:"accruedInterest"`
+	LastAccrualDate string `json:"lastAccrualDate"`
+}
+
+type CashCollateral struct {
+	chaincode.Record
+	DealID   string                 `json:"dealId"`
+	Balances map[string]CashBalance `json:"balances"` // keyed by currency
+}
+
+// ============================================================================================================================
+// record_cash_posting - cash was moved in (positive amount) or out (negative amount) of the segregated account of a deal
+// ============================================================================================================================
+func (t *ManageDeals) record_cash_posting(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 3 {
+		return nil, chaincode.SendError(stub, "record_cash_posting", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'dealId', 'currency' and 'amount'")
+	}
+	fmt.Println("start record_cash_posting")
+	_dealId := args[0]
+	_currency := args[1]
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "record_cash_posting", chaincode.ErrValidation, chaincode.Entities{DealID: _dealId}, "Cash amount must be a number.")
+	}
+	deal, cash, err := getCashCollateral(stub, _dealId)
+	if err != nil {
+		return nil, err
+	}
+	if deal.DealID != _dealId {
+		return nil, chaincode.SendError(stub, "record_cash_posting", chaincode.ErrNotFound, chaincode.Entities{DealID: _dealId}, _dealId+" Not Found.")
+	}
+	now, err := chaincode.TxSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+	// Interest on the previous balance is accrued before the balance changes
+	balance := accrueCashInterest(cash.Balances[_currency], deal.CashInterestRate, now)
+	balance.Currency = _currency
+	posted, _ := strconv.ParseFloat(balance.PostedAmount, 64)
+	if posted+amount < 0 {
+		return nil, chaincode.SendError(stub, "record_cash_posting", chaincode.ErrValidation, chaincode.Entities{DealID: _dealId}, "Cash returned is more than the "+_currency+" cash posted.")
+	}
+	balance.PostedAmount = strconv.FormatFloat(posted+amount, 'f', 2, 64)
+	cash.Balances[_currency] = balance
+	err = putCashCollateral(stub, cash)
+	if err != nil {
+		return nil, err
+	}
+	err = chaincode.SendEvent(stub, "record_cash_posting", chaincode.Entities{DealID: _dealId}, "Cash posting recorded succcessfully", map[string]string{"currency": _currency, "postedAmount": balance.PostedAmount})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end record_cash_posting")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// accrue_cash_interest - accrue interest on every currency of cash posted under a deal up to the time of the transaction
+// ============================================================================================================================
+func (t *ManageDeals) accrue_cash_interest(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 1 {
+		return nil, chaincode.SendError(stub, "accrue_cash_interest", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'dealId' as an argument")
+	}
+	fmt.Println("start accrue_cash_interest")
+	_dealId := args[0]
+	deal, cash, err := getCashCollateral(stub, _dealId)
+	if err != nil {
+		return nil, err
+	}
+	if deal.DealID != _dealId {
+		return nil, chaincode.SendError(stub, "accrue_cash_interest", chaincode.ErrNotFound, chaincode.Entities{DealID: _dealId}, _dealId+" Not Found.")
+	}
+	now, err := chaincode.TxSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+	for currency, balance := range cash.Balances {
+		cash.Balances[currency] = accrueCashInterest(balance, deal.CashInterestRate, now)
+	}
+	err = putCashCollateral(stub, cash)
+	if err != nil {
+		return nil, err
+	}
+	err = chaincode.SendEvent(stub, "accrue_cash_interest", chaincode.Entities{DealID: _dealId}, "Cash interest accrued succcessfully", nil)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end accrue_cash_interest")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// getCashCollateral_byDealID - get cash posted under a deal with interest accrued up to the time of the query
+// ============================================================================================================================
+func (t *ManageDeals) getCashCollateral_byDealID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("start getCashCollateral_byDealID")
+	if len(args) != 1 {
+		return nil, chaincode.SendError(stub, "getCashCollateral_byDealID", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'dealId' as an argument")
+	}
+	_dealId := args[0]
+	deal, cash, err := getCashCollateral(stub, _dealId)
+	if err != nil {
+		return nil, err
+	}
+	if deal.DealID != _dealId {
+		return nil, chaincode.SendError(stub, "getCashCollateral_byDealID", chaincode.ErrNotFound, chaincode.Entities{DealID: _dealId}, _dealId+" not Found.")
+	}
+	now, err := chaincode.TxSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+	for currency, balance := range cash.Balances {
+		cash.Balances[currency] = accrueCashInterest(balance, deal.CashInterestRate, now)
+	}
+	fmt.Println("end getCashCollateral_byDealID")
+	return json.Marshal(cash)
+}
+
+// getCashCollateral reads a deal together with the cash posted under it; a missing deal comes back empty
+func getCashCollateral(stub shim.ChaincodeStubInterface, dealId string) (Deals, CashCollateral, error) {
+	deal := Deals{}
+	cash := CashCollateral{DealID: dealId}
+	dealAsBytes, err := stub.GetState(dealId)
+	if err != nil {
+		return deal, cash, errors.New("Failed to get Deal " + dealId)
+	}
+	json.Unmarshal(dealAsBytes, &deal)
+	cashAsBytes, err := stub.GetState(cashCollateralKey(dealId))
+	if err != nil {
+		return deal, cash, errors.New("Failed to get cash collateral of " + dealId)
+	}
+	json.Unmarshal(cashAsBytes, &cash)
+	if cash.Balances == nil {
+		cash.Balances = make(map[string]CashBalance)
+	}
+	return deal, cash, nil
+}
+
+// putCashCollateral writes the cash posted under a deal
+func putCashCollateral(stub shim.ChaincodeStubInterface, cash CashCollateral) error {
+	return chaincode.PutRecord(stub, cashCollateralKey(cash.DealID), &cash)
+}
+
+// cashCollateralKey is the key the cash posted under a deal is stored under
+func cashCollateralKey(dealId string) string {
+	return cashCollateralKeyPrefix + dealId
+}
+
+// accrueCashInterest adds simple interest on the posted amount from the last accrual date up to now
+func accrueCashInterest(balance CashBalance, annualRate string, now int64) CashBalance {
+	posted, _ := strconv.ParseFloat(balance.PostedAmount, 64)
+	accrued, _ := strconv.ParseFloat(balance.AccruedInterest, 64)
+	rate, _ := strconv.ParseFloat(annualRate, 64)
+	last, err := strconv.ParseInt(balance.LastAccrualDate, 10, 64)
+	if err == nil && now > last {
+		days := float64(now-last) / (24 * 60 * 60)
+		accrued += posted * rate / 100 * days / cashInterestDayBasis
+	}
+	balance.PostedAmount = strconv.FormatFloat(posted, 'f', 2, 64)
+	balance.AccruedInterest = strconv.FormatFloat(accrued, 'f', -1, 64)
+	balance.LastAccrualDate = strconv.FormatInt(now, 10)
+	return balance
+}
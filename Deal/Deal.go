@@ -17,14 +17,16 @@ KIND, either express or implied.  See the License for the
 specific language governing permissions and limitations
 under the License.
 */
-package main
-import ("errors"
+package deal
+import (
         "fmt"
         "time"
         "strconv"
         "strings"
         "encoding/json"
-        "github.com/hyperledger/fabric/core/chaincode/shim")
+        "github.com/hyperledger/fabric-chaincode-go/shim"
+        pb "github.com/hyperledger/fabric-protos-go/peer"
+        "github.com/mukutb/TCM/chaincode")
 
 type ManageDeals struct {}
 
@@ -33,6 +35,7 @@ var DealIndexStr = "_Dealindex" //name for the key/value that will store a list
 var transactionIndexStr = "_transactionIndex" //name for the key/value that will store a list of all known transactionIds
 
 type Transactions struct {
+    chaincode.Record
     TransactionId string `json:"transactionId"`
     TransactionDate string `json:"transactionDate"`
     DealID string `json:"dealId"`
@@ -45,13 +48,30 @@ type Transactions struct {
     AllocationStatus string `json:"allocationStatus"`
     TransactionStatus string `json:"transactionStatus"`
     ComplianceStatus string `json:"complianceStatus"`
+    Direction string `json:"direction"` //"Call" when the RQV grows and the pledger delivers, "Return" when it shrinks and collateral goes back to the pledger
 }
 
 type Deals struct { // Attributes of a Deal
+    chaincode.Record
     DealID string `json:"dealId"`
     Pledger string `json:"pledger"`
     Pledgee string `json:"pledgee"`
     MaxValue string `json:"maxValue"` //Maximum Value of all the securities of each Collateral Form 
+    CashInterestRate string `json:"cashInterestRate"` //Annual interest rate in percent paid on cash posted as collateral (ACT/360)
+    IncomeTreatment string `json:"incomeTreatment"` //"PassThrough" income on pledged securities to the pledger or "Retain" it as collateral
+    PledgerThreshold string `json:"pledgerThreshold"` //Exposure the pledgee accepts uncollateralised before calling the pledger
+    PledgeeThreshold string `json:"pledgeeThreshold"` //Exposure the pledger accepts on the pledgee before it is netted off the collateral
+    MinimumTransferAmount string `json:"minimumTransferAmount"` //Smallest call or return worth moving
+    IndependentAmount string `json:"independentAmount"` //Collateral the pledger posts on top of the exposure
+    RoundingAmount string `json:"roundingAmount"` //Deliveries are rounded to a multiple of this amount
+    RoundingConvention string `json:"roundingConvention"` //"CallsUpReturnsDown", "Up", "Down" or "Nearest"
+    ValuationAgent string `json:"valuationAgent"` //Party allowed to submit exposures, anyone when empty
+    BaseCurrency string `json:"baseCurrency"` //Currency exposures and RQVs of the deal are expressed in
+    EligibleCollateral string `json:"eligibleCollateral"` //Comma separated collateral forms the deal accepts, the ruleset decides when empty
+    Calendars string `json:"calendars"` //Comma separated markets whose holidays are not business days for margin calls
+    CutoffTime string `json:"cutoffTime"` //HH:MM by which a margin call has to be met, 18:00 when empty
+    CutoffTimezone string `json:"cutoffTimezone"` //IANA timezone of the cutoff time, UTC when empty
+    SettlementDays string `json:"settlementDays"` //Business days after the margin call date the collateral is due
     TotalValueLongBoxAccount string `json:"totalValueLongBoxAccount"`
     TotalValueSegregatedAccount string `json:"totalValueSegregatedAccount"`
     IssueDate string `json:"issueDate"`
@@ -67,27 +87,13 @@ type Deals struct { // Attributes of a Deal
 }*/
 
 // ============================================================================================================================
-// Main - start the chaincode for Deal management
+// reset - reset all the things, used at instantiation and as the "init" function
 // ============================================================================================================================
-func main() {
-    err:= shim.Start(new(ManageDeals))
-    if err != nil {
-        fmt.Printf("Error starting Deal management chaincode: %s", err)
-    }
-}
-// ============================================================================================================================
-// Init - reset all the things
-// ============================================================================================================================
-func(t * ManageDeals) Init(stub shim.ChaincodeStubInterface, function string, args[] string)([] byte, error) {
+func(t * ManageDeals) reset(stub shim.ChaincodeStubInterface, args[] string)([] byte, error) {
     var msg string
     var err error
     if len(args) != 1 {
-        errMsg:= "{ \"message\" : \"Incorrect number of arguments. Expecting ' ' as an argument\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "init", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting ' ' as an argument")
     }
     // Initialize the chaincode
     msg = args[0]
@@ -102,84 +108,72 @@ func(t * ManageDeals) Init(stub shim.ChaincodeStubInterface, function string, ar
     if err != nil {
         return nil, err
     }
-    tosend:= "{ \"message\" : \"ManageDeals chaincode is deployed successfully.\", \"code\" : \"200\"}"
-    err = stub.SetEvent("evtsender", [] byte(tosend))
+    err = stub.PutState(disputeIndexStr, jsonAsBytes)
+    if err != nil {
+        return nil, err
+    }
+    err = chaincode.SendEvent(stub, "init", chaincode.Entities{}, "ManageDeals chaincode is deployed successfully.", nil)
     if err != nil {
         return nil, err
     }
     return nil, nil
 }
 // ============================================================================================================================
-// Run - Our entry Dealint for Invocations - [LEGACY] obc-peer 4/25/2016
-// ============================================================================================================================
-func(t * ManageDeals) Run(stub shim.ChaincodeStubInterface, function string, args[] string)([] byte, error) {
-    fmt.Println("run is running " + function)
-    return t.Invoke(stub, function, args)
-}
+// Init - called when the chaincode is instantiated or upgraded, an upgrade keeps the records of the ledger
 // ============================================================================================================================
-// Invoke - Our entry Dealint for Invocations
-// ============================================================================================================================
-func(t * ManageDeals) Invoke(stub shim.ChaincodeStubInterface, function string, args[] string)([] byte, error) {
-    fmt.Println("invoke is running " + function)
-    // Handle different functions
-    if function == "init" { //initialize the chaincode state, used as reset
-        return t.Init(stub, "init", args)
-    } else if function == "create_deal" { //create a new deal
-        return t.create_deal(stub, args)
-    } else if function == "update_deal" { //update a deal
-        return t.update_deal(stub, args)
-    } else if function == "create_transaction" { //create a new deal
-        return t.create_transaction(stub, args)
-    } else if function == "update_transaction" { //update a deal
-        return t.update_transaction(stub, args)
-    } else if function == "update_transaction_AllocationStatus" { //update a deal
-        return t.update_transaction_AllocationStatus(stub, args)
-    } else if function == "addTransaction_inDeal" { //add transactions to a deal
-        return t.addTransaction_inDeal(stub, args)
-    } else if function == "deleteTransactions" { //delete transactions
-        return t.deleteTransactions(stub, args)
-    } else if function == "deleteDeal" { //delete deal
-        return t.deleteDeal(stub, args)
-    }
-
-    fmt.Println("invoke did not find func: " + function)
-    errMsg:= "{ \"message\" : \"Received unknown function invocation\", \"code\" : \"503\"}"
-    err:= stub.SetEvent("errEvent", [] byte(errMsg))
+func(t * ManageDeals) Init(stub shim.ChaincodeStubInterface) pb.Response {
+    _, args := stub.GetFunctionAndParameters()
+    indexAsBytes, err := stub.GetState(DealIndexStr)
     if err != nil {
-        return nil, err
+        return chaincode.Respond(nil, err)
+    }
+    if indexAsBytes != nil {
+        return shim.Success(nil)
     }
-    return nil, nil //error
+    return chaincode.Respond(t.reset(stub, args))
 }
 // ============================================================================================================================
-// Query - Our entry Dealint for Queries
+// Invoke - Our entry point for Invocations and Queries, functions are routed by name
 // ============================================================================================================================
-func(t * ManageDeals) Query(stub shim.ChaincodeStubInterface, function string, args[] string)([] byte, error) {
-    fmt.Println("query is running " + function)
-    // Handle different functions
-    if function == "getDeal_byID" { //Read a Deal by dealId
-        return t.getDeal_byID(stub, args)
-    } else if function == "getDeal_byPledger" { //Read a Deal by Pledgee's name
-        return t.getDeal_byPledger(stub, args)
-    } else if function == "getDeal_byPledgee" { //Read a Deal by Pledgee's name
-        return t.getDeal_byPledgee(stub, args)
-    } else if function == "get_AllDeal" { //Read all Deals
-        return t.get_AllDeal(stub, args)
-    } else if function == "getTransaction_byID" { //Read all Transactions by Transaction ID
-        return t.getTransaction_byID(stub, args)
-    } else if function == "getTransactions_byDealID" { //Read all Transactions by Deal ID
-        return t.getTransactions_byDealID(stub, args)
-    } else if function == "getTransactions_byUser" { //Read all Transactions by user 
-        return t.getTransactions_byUser(stub, args)
-    } else if function == "get_AllTransactions" { //Read all Transactions
-        return t.get_AllTransactions(stub, args)
-    }
-    fmt.Println("query did not find func: " + function) //errors
-    errMsg:= "{ \"message\" : \"Received unknown function query\", \"code\" : \"503\"}"
-    err:= stub.SetEvent("errEvent", [] byte(errMsg))
-    if err != nil {
-        return nil, err
-    }
-    return nil, nil
+func(t * ManageDeals) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+    return chaincode.Route(stub, payloadArgs, map[string]chaincode.Function{
+        "init": t.reset, //initialize the chaincode state, used as reset
+        "create_deal": t.create_deal, //create a new deal
+        "update_deal": t.update_deal, //update a deal
+        "create_transaction": t.create_transaction, //create a new deal
+        "update_transaction": t.update_transaction, //update a deal
+        "update_transaction_AllocationStatus": t.update_transaction_AllocationStatus, //update a deal
+        "addTransaction_inDeal": t.addTransaction_inDeal, //add transactions to a deal
+        "deleteTransactions": t.deleteTransactions, //delete transactions
+        "deleteDeal": t.deleteDeal, //delete deal
+        "repair_indexes": t.repair_indexes, //make the deal and transaction indexes agree with the records
+        "raise_dispute": t.raise_dispute, //dispute the RQV of a transaction
+        "add_dispute_step": t.add_dispute_step, //record a resolution step on a dispute
+        "resolve_dispute": t.resolve_dispute, //close a dispute with the agreed amount
+        "record_cash_posting": t.record_cash_posting, //cash moved in or out of the segregated account of a deal
+        "accrue_cash_interest": t.accrue_cash_interest, //accrue interest on posted cash of a deal
+        "update_csa_terms": t.update_csa_terms, //replace the CSA terms of a deal
+        "set_calendar": t.set_calendar, //replace the holidays of a market
+        "update_deal_cutoff": t.update_deal_cutoff, //set calendars and cutoff of a deal's margin calls
+        "submit_exposure": t.submit_exposure, //compare exposure with collateral and raise a margin call
+        "migrate_records": t.migrate_records, //upgrade stored records to the current schema version
+        "getDeal_byID": t.getDeal_byID, //Read a Deal by dealId
+        "getDeal_byPledger": t.getDeal_byPledger, //Read a Deal by Pledgee's name
+        "getDeal_byPledgee": t.getDeal_byPledgee, //Read a Deal by Pledgee's name
+        "get_AllDeal": t.get_AllDeal, //Read all Deals
+        "getTransaction_byID": t.getTransaction_byID, //Read all Transactions by Transaction ID
+        "getTransactions_byDealID": t.getTransactions_byDealID, //Read all Transactions by Deal ID
+        "getTransactions_byUser": t.getTransactions_byUser, //Read all Transactions by user
+        "get_AllTransactions": t.get_AllTransactions, //Read all Transactions
+        "getDispute_byID": t.getDispute_byID, //Read a Dispute by disputeId
+        "getDisputes_byDealID": t.getDisputes_byDealID, //Read all Disputes of a Deal
+        "getDisputes_byCounterparty": t.getDisputes_byCounterparty, //Read all Disputes of a pledger or pledgee
+        "getCashCollateral_byDealID": t.getCashCollateral_byDealID, //Read posted cash and accrued interest of a Deal
+        "getExposure_byDealID": t.getExposure_byDealID, //Read the last exposure submitted for a Deal
+        "getCalendar_byMarket": t.getCalendar_byMarket, //Read the holidays of a market
+        "getMarginCallDeadline_byTransactionID": t.getMarginCallDeadline_byTransactionID, //Read when a margin call is due
+        "check_indexes": t.check_indexes, //Read where the deal and transaction indexes disagree with the records
+    })
 }
 // ============================================================================================================================
 // getDeal_byID - get Deal details for a specific ID from chaincode state
@@ -189,23 +183,16 @@ func(t * ManageDeals) getDeal_byID(stub shim.ChaincodeStubInterface, args[] stri
     var err error
     fmt.Println("start getDeal_byID")
     if len(args) != 1 {
-        errMsg:= "{ \"message\" : \"Incorrect number of arguments. Expecting 'DealId' as an argument\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "getDeal_byID", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'DealId' as an argument")
     }
     // set dealId
     DealId = args[0]
     valAsbytes, err:= stub.GetState(DealId) //get the DealId from chaincode state
     if err != nil {
-        errMsg:= "{ \"message\" : \"" + DealId + " not Found.\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "getDeal_byID", chaincode.ErrUpstream, chaincode.Entities{DealID: DealId}, "Failed to get Deal " + DealId)
+    }
+    if valAsbytes == nil {
+        return nil, chaincode.SendError(stub, "getDeal_byID", chaincode.ErrNotFound, chaincode.Entities{DealID: DealId}, DealId + " not Found.")
     }
     //fmt.Print("valAsbytes : ")
     //fmt.Println(valAsbytes)
@@ -220,23 +207,16 @@ func(t * ManageDeals) getTransaction_byID(stub shim.ChaincodeStubInterface, args
     var err error
     fmt.Println("start getTransaction_byID")
     if len(args) != 1 {
-        errMsg:= "{ \"message\" : \"Incorrect number of arguments. Expecting 'TransactionId' as an argument\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "getTransaction_byID", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'TransactionId' as an argument")
     }
     // set TransactionId
     TransactionId = args[0]
     valAsbytes, err:= stub.GetState(TransactionId) //get the TransactionId from chaincode state
     if err != nil {
-        errMsg:= "{ \"message\" : \"" + TransactionId + " not Found.\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "getTransaction_byID", chaincode.ErrUpstream, chaincode.Entities{TransactionID: TransactionId}, "Failed to get Transaction " + TransactionId)
+    }
+    if valAsbytes == nil {
+        return nil, chaincode.SendError(stub, "getTransaction_byID", chaincode.ErrNotFound, chaincode.Entities{TransactionID: TransactionId}, TransactionId + " not Found.")
     }
     //fmt.Print("valAsbytes : ")
     //fmt.Println(valAsbytes)
@@ -253,19 +233,14 @@ func(t * ManageDeals) getDeal_byPledger(stub shim.ChaincodeStubInterface, args[]
     fmt.Println("start getDeal_byPledger")
     var err error
     if len(args) != 1 {
-        errMsg:= "{ \"message\" : \"Incorrect number of arguments. Expecting 'pledgerName' as an argument\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "getDeal_byPledger", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'pledgerName' as an argument")
     }
     // set Pledgee's name
     pledgerName = args[0]
     //fmt.Println("pledgerName" + pledgerName)
     dealAsBytes, err:= stub.GetState(DealIndexStr)
     if err != nil {
-        return nil, errors.New("Failed to get Deal index string")
+        return nil, chaincode.SendError(stub, "getDeal_byPledger", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get Deal index string")
     }
     //fmt.Print("dealAsBytes : ")
     //fmt.Println(dealAsBytes)
@@ -280,7 +255,7 @@ func(t * ManageDeals) getDeal_byPledger(stub shim.ChaincodeStubInterface, args[]
         valueAsBytes, err:= stub.GetState(val)
         if err != nil {
             errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-            return nil, errors.New(errResp)
+            return nil, chaincode.SendError(stub, "getDeal_byPledger", chaincode.ErrUpstream, chaincode.Entities{}, errResp)
         }
         //fmt.Print("valueAsBytes : ")
         //fmt.Println(valueAsBytes)
@@ -301,12 +276,7 @@ func(t * ManageDeals) getDeal_byPledger(stub shim.ChaincodeStubInterface, args[]
     fmt.Println("jsonResp : " + jsonResp)
     if jsonResp == "{}" {
         fmt.Println("Pledger not found.")
-        jsonResp =  "{ \"message\" : \"" + pledgerName + " Not Found.\", \"code\" : \"503\"}"
-        errMsg:= "{ \"message\" : \"" + pledgerName + " Not Found.\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-        return nil, err
-        }
+        return nil, chaincode.SendError(stub, "getDeal_byPledger", chaincode.ErrNotFound, chaincode.Entities{}, pledgerName + " Not Found.")
     }
     if strings.Contains(jsonResp,"},}"){
         jsonResp = strings.Replace(jsonResp, "},}", "}}", -1)
@@ -326,19 +296,14 @@ func(t * ManageDeals) getDeal_byPledgee(stub shim.ChaincodeStubInterface, args[]
     fmt.Println("start getDeal_byPledgee")
     var err error
     if len(args) != 1 {
-        errMsg:= "{ \"message\" : \"Incorrect number of arguments. Expecting 'pledgeeName' as an argument\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "getDeal_byPledgee", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'pledgeeName' as an argument")
     }
     // set Pledgee name
     pledgeeName = args[0]
     //fmt.Println("pledgerName" + pledgeeName)
     dealAsBytes, err:= stub.GetState(DealIndexStr)
     if err != nil {
-        return nil, errors.New("Failed to get Deal index")
+        return nil, chaincode.SendError(stub, "getDeal_byPledgee", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get Deal index")
     }
     //fmt.Print("dealAsBytes : ")
     //fmt.Println(dealAsBytes)
@@ -353,7 +318,7 @@ func(t * ManageDeals) getDeal_byPledgee(stub shim.ChaincodeStubInterface, args[]
         valueAsBytes, err:= stub.GetState(val)
         if err != nil {
             errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-            return nil, errors.New(errResp)
+            return nil, chaincode.SendError(stub, "getDeal_byPledgee", chaincode.ErrUpstream, chaincode.Entities{}, errResp)
         }
         //fmt.Print("valueAsBytes : ")
         //fmt.Println(valueAsBytes)
@@ -374,12 +339,7 @@ func(t * ManageDeals) getDeal_byPledgee(stub shim.ChaincodeStubInterface, args[]
     fmt.Println("jsonResp : " + jsonResp)
     if jsonResp == "{}" {
         fmt.Println("Pledgee not found.")
-        jsonResp = jsonResp + "\"message\" : \"" + pledgeeName + " Not Found.\", \"code\" : \"503\"}"
-        errMsg:= "{ \"message\" : \"" + pledgeeName + " Not Found.\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
+        return nil, chaincode.SendError(stub, "getDeal_byPledgee", chaincode.ErrNotFound, chaincode.Entities{}, pledgeeName + " Not Found.")
     }
     if strings.Contains(jsonResp,"},}"){
         jsonResp = strings.Replace(jsonResp, "},}", "}}", -1)
@@ -397,17 +357,12 @@ func(t * ManageDeals) get_AllDeal(stub shim.ChaincodeStubInterface, args[] strin
     var dealIndex[] string
     fmt.Println("start get_AllDeal")
     var err error
-    if len(args) != 1 {
-        errMsg:= "{ \"message\" : \"Incorrect number of arguments. Expecting \" \" as an argument\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+    if len(args) > 1 {
+        return nil, chaincode.SendError(stub, "get_AllDeal", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting at most ' ' as an argument")
     }
     dealAsBytes, err:= stub.GetState(DealIndexStr)
     if err != nil {
-        return nil, errors.New("Failed to get Deal index")
+        return nil, chaincode.SendError(stub, "get_AllDeal", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get Deal index")
     }
     //fmt.Print("dealAsBytes : ")
     //fmt.Println(dealAsBytes)
@@ -420,7 +375,7 @@ func(t * ManageDeals) get_AllDeal(stub shim.ChaincodeStubInterface, args[] strin
         valueAsBytes, err:= stub.GetState(val)
         if err != nil {
             errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-            return nil, errors.New(errResp)
+            return nil, chaincode.SendError(stub, "get_AllDeal", chaincode.ErrUpstream, chaincode.Entities{}, errResp)
         }
         //fmt.Print("valueAsBytes : ")
         //fmt.Println(valueAsBytes)
@@ -447,17 +402,12 @@ func(t * ManageDeals) get_AllTransactions(stub shim.ChaincodeStubInterface, args
     var transactionIndex[] string
     fmt.Println("start get_AllTransactions")
     var err error
-    if len(args) != 1 {
-        errMsg:= "{ \"message\" : \"Incorrect number of arguments. Expecting \" \" as an argument\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+    if len(args) > 1 {
+        return nil, chaincode.SendError(stub, "get_AllTransactions", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting at most ' ' as an argument")
     }
     transactionAsBytes, err:= stub.GetState(transactionIndexStr)
     if err != nil {
-        return nil, errors.New("Failed to get Transaction index")
+        return nil, chaincode.SendError(stub, "get_AllTransactions", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get Transaction index")
     }
     //fmt.Print("transactionAsBytes : ")
     //fmt.Println(transactionAsBytes)
@@ -470,7 +420,7 @@ func(t * ManageDeals) get_AllTransactions(stub shim.ChaincodeStubInterface, args
         valueAsBytes, err:= stub.GetState(val)
         if err != nil {
             errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-            return nil, errors.New(errResp)
+            return nil, chaincode.SendError(stub, "get_AllTransactions", chaincode.ErrUpstream, chaincode.Entities{}, errResp)
         }
         //fmt.Print("valueAsBytes : ")
         //fmt.Println(valueAsBytes)
@@ -495,62 +445,55 @@ func(t * ManageDeals) get_AllTransactions(stub shim.ChaincodeStubInterface, args
 func(t * ManageDeals) update_deal(stub shim.ChaincodeStubInterface, args[] string)([] byte, error) {
     var err error
     fmt.Println("Starting Updating Deal update_deal")
-    if len(args) != 9 {
-        errMsg:= "{ \"message\" : \"Incorrect number of arguments. Expecting 9\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+    if (len(args) < 9 || len(args) > 11) && len(args) != 11 + csaTermCount {
+        return nil, chaincode.SendError(stub, "update_deal", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 9 to 11, or 11 followed by the CSA terms")
+    }
+    if err = validateDeal(args, true); err != nil {
+        return nil, chaincode.SendInvalid(stub, "update_deal", chaincode.Entities{DealID: args[0]}, err)
     }
     // set dealId
     dealId:= args[0]
     dealAsBytes, err:= stub.GetState(dealId) //get the Deal for the specified dealId from chaincode state
     if err != nil {
-        errMsg:= "{ \"message\" : \"Failed to get state for " + dealId + "\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "update_deal", chaincode.ErrUpstream, chaincode.Entities{DealID: dealId}, "Failed to get state for " + dealId)
     }
     res:= Deals {}
     json.Unmarshal(dealAsBytes, &res)
     fmt.Println(res);
     if res.DealID == dealId {
         fmt.Println("Deal found with dealId : " + dealId)
-        //build the Deal json string manually
-        deal_json:= `{` + 
-            `"dealId": "` + res.DealID + `" , ` + 
-            `"pledger": "` + res.Pledger + `" , ` + 
-            `"pledgee": "` + res.Pledgee + `" , ` + 
-            `"maxValue": "` + args[3] + `" , ` + 
-            `"totalValueLongBoxAccount": "` + args[4] + `" , ` + 
-            `"totalValueSegregatedAccount": "` + args[5] + `" , ` + 
-            `"issueDate": "` + args[6] + `" , ` + 
-            `"lastSuccessfulAllocationDate": "` + args[7] + `" ` + 
-            `"transactions": "` + args[8] + `" ` + 
-            `}`
-        fmt.Println(deal_json);
-        err = stub.PutState(dealId, [] byte(deal_json)) //store Deal with id as key
+        // cash interest rate and income treatment are optional, keep the current ones when they are not passed or left out
+        if len(args) >= 10 && args[9] != keepStored {
+            res.CashInterestRate = args[9]
+        }
+        if len(args) >= 11 && args[10] != keepStored {
+            res.IncomeTreatment = args[10]
+        }
+        if len(args) == 11 + csaTermCount {
+            setCSATerms(&res, keptCSATerms(res, args[11:]))
+            if err := validateCSATerms(res); err != nil {
+                return nil, chaincode.SendInvalid(stub, "update_deal", chaincode.Entities{DealID: dealId}, err)
+            }
+        }
+        res.MaxValue = args[3]
+        res.TotalValueLongBoxAccount = args[4]
+        res.TotalValueSegregatedAccount = args[5]
+        res.IssueDate = args[6]
+        res.LastSuccessfulAllocationDate = args[7]
+        res.Transactions = args[8]
+        err = putDeal(stub, res) //store Deal with id as key
         if err != nil {
             return nil, err
         }
 	fmt.Println(" ")
         fmt.Println("Deal updated succcessfully")
-        tosend:= "{ \"dealId\" : \"" + dealId + "\", \"message\" : \"Deal updated succcessfully\", \"code\" : \"200\"}"
-        err = stub.SetEvent("evtsender", [] byte(tosend))
+        err = chaincode.SendEvent(stub, "update_deal", chaincode.Entities{DealID: dealId}, "Deal updated succcessfully", nil)
         if err != nil {
             return nil, err
         }
         return nil, nil
     } else {
-        errMsg:= "{ \"message\" : \"" + dealId + " Not Found.\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "update_deal", chaincode.ErrNotFound, chaincode.Entities{DealID: dealId}, dealId + " Not Found.")
     }
 }
 // ============================================================================================================================
@@ -558,17 +501,15 @@ func(t * ManageDeals) update_deal(stub shim.ChaincodeStubInterface, args[] strin
 // ============================================================================================================================
 func(t * ManageDeals) create_deal(stub shim.ChaincodeStubInterface, args[] string)([] byte, error) {
     var err error
-    if len(args) != 9 {
-        errMsg:= "{ \"message\" : \"Incorrect number of arguments. Expecting 9\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+    if (len(args) < 9 || len(args) > 11) && len(args) != 11 + csaTermCount {
+        return nil, chaincode.SendError(stub, "create_deal", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 9 to 11, or 11 followed by the CSA terms")
     }
     fmt.Println("start create_deal")
+    if err = validateDeal(args, false); err != nil {
+        return nil, chaincode.SendInvalid(stub, "create_deal", chaincode.Entities{DealID: args[0]}, err)
+    }
     /*if len(args[0]) <= 0 {
-        return nil, errors.New("1st argument must be a non-empty string")
+        return nil, chaincode.SendError(stub, "create_deal", chaincode.ErrUpstream, chaincode.Entities{}, "1st argument must be a non-empty string")
     }
     */
     dealId:= args[0]
@@ -580,35 +521,55 @@ func(t * ManageDeals) create_deal(stub shim.ChaincodeStubInterface, args[] strin
     IssueDate:= args[6]
     LastSuccessfulAllocationDate:= args[7]
     Transactions:= args[8]
+    CashInterestRate:= "0"
+    if len(args) >= 10 {
+        CashInterestRate = args[9]
+    }
+    IncomeTreatment:= "PassThrough"
+    if len(args) >= 11 {
+        IncomeTreatment = args[10]
+    }
     dealAsBytes, err:= stub.GetState(dealId)
     if err != nil {
-        return nil, errors.New("Failed to get Deal dealId")
+        return nil, chaincode.SendError(stub, "create_deal", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get Deal dealId")
     }
     res:= Deals {}
     json.Unmarshal(dealAsBytes, &res)
     if res.DealID == dealId {
         //fmt.Println("This Deal arleady exists: " + dealId)
         //fmt.Println(res);
-        errMsg:= "{ \"message\" : \"This Deal already exists\", \"code\" : \"503\"}"
-        err:= stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil //all stop a Deal by this name exists
-    }
-    //build the Deal json string manually
-    deal_json:= `{` + `"dealId": "` + dealId + `" , ` + `"pledger": "` + Pledger + `" , ` + `"pledgee": "` + Pledgee + `" , ` + `"maxValue": "` + MaxValue + `" , ` + `"totalValueLongBoxAccount": "` + TotalValueLongBoxAccount + `" , ` + `"totalValueSegregatedAccount": "` + TotalValueSegregatedAccount + `" , ` + `"issueDate": "` + IssueDate + `" , ` + `"transactions": "` + Transactions + `" , ` + `"lastSuccessfulAllocationDate": "` + LastSuccessfulAllocationDate + `"  ` + `}`
-    //fmt.Println("deal_json: " + deal_json)
-    //fmt.Print("deal_json in bytes array: ")
-    fmt.Println(deal_json);
-    err = stub.PutState(dealId, [] byte(deal_json)) //store Deal with dealId as key
+        return nil, chaincode.SendError(stub, "create_deal", chaincode.ErrConflict, chaincode.Entities{DealID: dealId}, "This Deal already exists")
+    }
+    res = Deals {
+        DealID: dealId,
+        Pledger: Pledger,
+        Pledgee: Pledgee,
+        MaxValue: MaxValue,
+        CashInterestRate: CashInterestRate,
+        IncomeTreatment: IncomeTreatment,
+        TotalValueLongBoxAccount: TotalValueLongBoxAccount,
+        TotalValueSegregatedAccount: TotalValueSegregatedAccount,
+        IssueDate: IssueDate,
+        LastSuccessfulAllocationDate: LastSuccessfulAllocationDate,
+        Transactions: Transactions,
+    }
+    // CSA terms are optional, a deal without them calls for the full exposure in any amount
+    csaTerms:= make([]string, csaTermCount)
+    if len(args) == 11 + csaTermCount {
+        csaTerms = args[11:]
+    }
+    setCSATerms(&res, csaTerms)
+    if err := validateCSATerms(res); err != nil {
+        return nil, chaincode.SendInvalid(stub, "create_deal", chaincode.Entities{DealID: dealId}, err)
+    }
+    err = putDeal(stub, res) //store Deal with dealId as key
     if err != nil {
         return nil, err
     }
     //get the Deal index
     dealIndexAsBytes, err:= stub.GetState(DealIndexStr)
     if err != nil {
-        return nil, errors.New("Failed to get Deal index")
+        return nil, chaincode.SendError(stub, "create_deal", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get Deal index")
     }
     var dealIndex[] string
     //fmt.Print("dealIndexAsBytes: ")
@@ -626,8 +587,7 @@ func(t * ManageDeals) create_deal(stub shim.ChaincodeStubInterface, args[] strin
     if err != nil {
         return nil, err
     }
-    tosend:= "{ \"dealId\" : \"" + dealId + "\", \"message\" : \"Deal created succcessfully\", \"code\" : \"200\"}"
-    err = stub.SetEvent("evtsender", [] byte(tosend))
+    err = chaincode.SendEvent(stub, "create_deal", chaincode.Entities{DealID: dealId}, "Deal created succcessfully", nil)
     if err != nil {
         return nil, err
     }
@@ -643,23 +603,13 @@ func(t * ManageDeals) getTransactions_byDealID(stub shim.ChaincodeStubInterface,
     var _tempJson Transactions
     fmt.Println("start getTransactions_byDealID")
     if len(args) != 1 {
-        errMsg:= "{ \"message\" : \"Incorrect number of arguments. Expecting 'dealId' as an argument\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "getTransactions_byDealID", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'dealId' as an argument")
     }
     // set dealId
     dealId = args[0];
     dealAsBytes, err:= stub.GetState(dealId) //get the dealId from chaincode state
     if err != nil {
-        errMsg:= "{ \"message\" : \"" + dealId + " not Found.\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "getTransactions_byDealID", chaincode.ErrNotFound, chaincode.Entities{DealID: dealId}, dealId + " not Found.")
     }
     var dealIndex Deals
     json.Unmarshal(dealAsBytes, &dealIndex) //un stringify it aka JSON.parse()
@@ -676,7 +626,7 @@ func(t * ManageDeals) getTransactions_byDealID(stub shim.ChaincodeStubInterface,
         valueAsBytes, err:= stub.GetState(_transactionSplit[i])
         if err != nil {
             errResp := "{\"Error\":\"Failed to get state for " + _transactionSplit[i] + "\"}"
-            return nil, errors.New(errResp)
+            return nil, chaincode.SendError(stub, "getTransactions_byDealID", chaincode.ErrUpstream, chaincode.Entities{}, errResp)
         }
         json.Unmarshal(valueAsBytes, &_tempJson)
         fmt.Print("valueAsBytes : ")
@@ -689,12 +639,7 @@ func(t * ManageDeals) getTransactions_byDealID(stub shim.ChaincodeStubInterface,
     jsonResp = jsonResp + "]"
     if jsonResp == "[]" {
         fmt.Println("Transactions not found.")
-        jsonResp =  "{ \"message\" : \" No transactions found.\", \"code\" : \"503\"}"
-        errMsg:= "{ \"message\" : \" No transactions found.\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-        return nil, err
-        }
+        return nil, chaincode.SendError(stub, "getTransactions_byDealID", chaincode.ErrNotFound, chaincode.Entities{}, " No transactions found.")
     }
     fmt.Print("jsonResp: ")
     fmt.Println(jsonResp)
@@ -712,12 +657,7 @@ func(t * ManageDeals) getTransactions_byUser(stub shim.ChaincodeStubInterface, a
     fmt.Println("start getTransactions_byUser")
     var err error
     if len(args) != 2 {
-        errMsg:= "{ \"message\" : \"Incorrect number of arguments. Expecting 'user' and 'role' as an argument\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "getTransactions_byUser", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'user' and 'role' as an argument")
     }
     // set user
     _user := args[0]
@@ -726,7 +666,7 @@ func(t * ManageDeals) getTransactions_byUser(stub shim.ChaincodeStubInterface, a
     //fmt.Println("user" + _user)
     dealIndexAsBytes, err:= stub.GetState(DealIndexStr)
     if err != nil {
-        return nil, errors.New("Failed to get transaction index string")
+        return nil, chaincode.SendError(stub, "getTransactions_byUser", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get transaction index string")
     }
     json.Unmarshal(dealIndexAsBytes, &dealIndex) //un stringify it aka JSON.parse()
     fmt.Print("dealIndex : ")
@@ -737,7 +677,7 @@ func(t * ManageDeals) getTransactions_byUser(stub shim.ChaincodeStubInterface, a
         dealAsBytes, err:= stub.GetState(val)
         if err != nil {
             errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-            return nil, errors.New(errResp)
+            return nil, chaincode.SendError(stub, "getTransactions_byUser", chaincode.ErrUpstream, chaincode.Entities{}, errResp)
         }
         //fmt.Print("dealAsBytes : ")
         //fmt.Println(dealAsBytes)
@@ -745,11 +685,7 @@ func(t * ManageDeals) getTransactions_byUser(stub shim.ChaincodeStubInterface, a
         fmt.Print("valIndex: ")
         fmt.Print(valIndex)
         if valIndex.Transactions == "" || valIndex.Transactions == " "{
-            errMsg:= "{ \"message\" : \"Transactions Not Found.\", \"code\" : \"503\"}"
-            err = stub.SetEvent("errEvent", [] byte(errMsg))
-            if err != nil {
-            return nil, err
-            }
+            return nil, chaincode.SendError(stub, "getTransactions_byUser", chaincode.ErrNotFound, chaincode.Entities{}, "Transactions Not Found.")
         }else {
             _transactionSplit:= strings.Split(valIndex.Transactions, ",")
             fmt.Print("_transactionSplit: ")
@@ -759,7 +695,7 @@ func(t * ManageDeals) getTransactions_byUser(stub shim.ChaincodeStubInterface, a
                 valueAsBytes, err:= stub.GetState(_transactionSplit[i])
                 if err != nil {
                     errResp := "{\"Error\":\"Failed to get state for " + _transactionSplit[i] + "\"}"
-                    return nil, errors.New(errResp)
+                    return nil, chaincode.SendError(stub, "getTransactions_byUser", chaincode.ErrUpstream, chaincode.Entities{}, errResp)
                 }
                 json.Unmarshal(valueAsBytes, &_tempJson)
                 fmt.Print("_tempJson : ")
@@ -792,12 +728,7 @@ func(t * ManageDeals) getTransactions_byUser(stub shim.ChaincodeStubInterface, a
     fmt.Println("jsonResp : " + jsonResp)
     if jsonResp == "[]" {
         fmt.Println("User not found.")
-        jsonResp =  "{ \"message\" : \"" + _user + " Not Found.\", \"code\" : \"503\"}"
-        errMsg:= "{ \"message\" : \"" + _user + " Not Found.\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-        return nil, err
-        }
+        return nil, chaincode.SendError(stub, "getTransactions_byUser", chaincode.ErrNotFound, chaincode.Entities{}, _user + " Not Found.")
     }
     if strings.Contains(jsonResp,"},}"){
         jsonResp = strings.Replace(jsonResp, "},}", "}}", -1)
@@ -818,12 +749,7 @@ func(t * ManageDeals) addTransaction_inDeal(stub shim.ChaincodeStubInterface, ar
     var err error
     fmt.Println("addTransaction_inDeal")
     if len(args) != 2{
-        errMsg:= "{ \"message\" : \"Incorrect number of arguments. Expecting 2\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "addTransaction_inDeal", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 2")
     }
     // set dealId
     dealId:= args[0]
@@ -836,17 +762,12 @@ func(t * ManageDeals) addTransaction_inDeal(stub shim.ChaincodeStubInterface, ar
     /*_newMarginCallDate,err := strconv.Atoi(_marginCallDate)
     if err != nil {
         fmt.Sprintf("Error while converting string '_marginCallDate' to int : %s", err.Error())
-        return nil, errors.New("Error while converting string '_marginCallDate' to int ")
+        return nil, chaincode.SendError(stub, "addTransaction_inDeal", chaincode.ErrUpstream, chaincode.Entities{}, "Error while converting string '_marginCallDate' to int ")
     }*/
 
     dealAsBytes, err:= stub.GetState(dealId) //get the Deal for the specified dealId from chaincode state
     if err != nil {
-        errMsg:= "{ \"dealId\" : \"" + dealId + "\",\"message\" : \"Failed to get DealID\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "addTransaction_inDeal", chaincode.ErrUpstream, chaincode.Entities{DealID: dealId}, "Failed to get DealID")
     }
     res:= Deals {}
     //_tempJson := Transactions{}
@@ -861,21 +782,11 @@ func(t * ManageDeals) addTransaction_inDeal(stub shim.ChaincodeStubInterface, ar
             fmt.Println("_transactionSplit[i]: " + _transactionSplit[i])
             if _transactionSplit[i] == _transactionId {
                 fmt.Println("Transaction already exists.")
-                errMsg:= "{ \"TransactionId\" : \"" + _transactionId + "\",\"message\" : \" Transaction already exists.\", \"code\" : \"503\"}"
-                err = stub.SetEvent("errEvent", [] byte(errMsg))
-                if err != nil {
-                    return nil, err
-                }
-                return nil,nil
+                return nil, chaincode.SendError(stub, "addTransaction_inDeal", chaincode.ErrConflict, chaincode.Entities{TransactionID: _transactionId}, " Transaction already exists.")
             }
         }
     } else {
-        errMsg:= "{ \"message\" : \"" + dealId + " Not Found.\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "addTransaction_inDeal", chaincode.ErrNotFound, chaincode.Entities{DealID: dealId}, dealId + " Not Found.")
     }
     if res.Transactions == " " || res.Transactions == "" {
         res.Transactions = _transactionId;
@@ -883,25 +794,11 @@ func(t * ManageDeals) addTransaction_inDeal(stub shim.ChaincodeStubInterface, ar
         res.Transactions = res.Transactions+ "," + _transactionId;
     }
     fmt.Println(res.Transactions);
-    //build the Deal json string manually
-    deal_json:= `{` + 
-    `"dealId": "` + res.DealID + `" , ` + 
-    `"pledger": "` + res.Pledger + `" , ` + 
-    `"pledgee": "` + res.Pledgee + `" , ` + 
-    `"maxValue": "` + res.MaxValue + `" , ` + 
-    `"totalValueLongBoxAccount": "` + res.TotalValueLongBoxAccount + `" , ` + 
-    `"totalValueSegregatedAccount": "` + res.TotalValueSegregatedAccount + `" , ` + 
-    `"issueDate": "` + res.IssueDate + `" , ` + 
-    `"transactions": "` + res.Transactions + `" , ` + 
-    `"lastSuccessfulAllocationDate": "` + res.LastSuccessfulAllocationDate + `" ` + 
-    `}`
-    fmt.Println(deal_json);
-    err = stub.PutState(dealId, [] byte(deal_json)) //store Deal with id as key
+    err = putDeal(stub, res) //store Deal with id as key
     if err != nil {
     return nil, err
     }
-    tosend:= "{ \"dealId\" : \"" + dealId + "\", \"message\" : \"Transaction added succcessfully\", \"code\" : \"200\"}"
-    err = stub.SetEvent("evtsender", [] byte(tosend))
+    err = chaincode.SendEvent(stub, "addTransaction_inDeal", chaincode.Entities{DealID: dealId}, "Transaction added succcessfully", nil)
     if err != nil {
         return nil, err
     }
@@ -910,65 +807,42 @@ func(t * ManageDeals) addTransaction_inDeal(stub shim.ChaincodeStubInterface, ar
     return nil, nil
 }
 // ============================================================================================================================
-// Delete - remove deal from chain
+// Delete - remove deal, its transactions, the records kept for it and its disputes from chain
 // ============================================================================================================================
 func (t *ManageDeals) deleteDeal(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 'dealId' as an argument\", \"code\" : \"503\"}"
-		err := stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "deleteDeal", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'dealId' as an argument")
 	}
 	fmt.Println("Deal remove")
 	// set dealId
 	dealId := args[0]
-	err := stub.DelState(dealId)						//remove the Deal from chaincode
-	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to delete state\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
-	}
-
-	//get the Deal index
-	dealAsBytes, err := stub.GetState(DealIndexStr)
+	dealAsBytes, err := stub.GetState(dealId)
 	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to get Deal index\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "deleteDeal", chaincode.ErrUpstream, chaincode.Entities{DealID: dealId}, "Failed to get Deal " + dealId)
 	}
 	res := Deals{}
 	json.Unmarshal(dealAsBytes, &res)								//un stringify it aka JSON.parse()
-	_TransactionsSplit := strings.Split(res.Transactions, ",")
-	fmt.Print("_TransactionsSplit: " )
-	fmt.Println(_TransactionsSplit)
-	for i:=0;i<len(_TransactionsSplit);{
-		fmt.Println("_TransactionsSplit[i]: " + _TransactionsSplit[i])
-		err := stub.DelState(_TransactionsSplit[i])													//remove the key from chaincode state
-		if err != nil {
-			errMsg := "{ \"transactions\" : \"" + _TransactionsSplit[i] + "\", \"message\" : \"Failed to delete state\", \"code\" : \"503\"}"
-			err = stub.SetEvent("errEvent", []byte(errMsg))
-			if err != nil {
-				return nil, err
-			} 
-			return nil, nil
-		}
-		_TransactionsSplit = append(_TransactionsSplit[:i], _TransactionsSplit[i+1:]...)			//remove it
-		fmt.Println(_TransactionsSplit)
-		for x:= range _TransactionsSplit{											//debug prints...
-			fmt.Println(string(x) + " - " + _TransactionsSplit[x])
-		}
+	if res.DealID != dealId {
+		return nil, chaincode.SendError(stub, "deleteDeal", chaincode.ErrNotFound, chaincode.Entities{DealID: dealId}, dealId + " Not Found.")
+	}
+	err = deleteDealTransactions(stub, res)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "deleteDeal", chaincode.ErrUpstream, chaincode.Entities{DealID: dealId}, "Failed to delete transactions: " + err.Error())
+	}
+	err = deleteDealRecords(stub, dealId)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "deleteDeal", chaincode.ErrUpstream, chaincode.Entities{DealID: dealId}, "Failed to delete exposure, cash and disputes: " + err.Error())
+	}
+	err = stub.DelState(dealId)						//remove the Deal from chaincode
+	if err != nil {
+		return nil, chaincode.SendError(stub, "deleteDeal", chaincode.ErrUpstream, chaincode.Entities{DealID: dealId}, "Failed to delete state")
+	}
+	err = removeFromIndex(stub, DealIndexStr, dealId)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "deleteDeal", chaincode.ErrUpstream, chaincode.Entities{DealID: dealId}, "Failed to update Deal index")
 	}
 
-	tosend := "{ \"dealID\" : \""+dealId+"\", \"message\" : \"Deal and its Transactions deleted succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	err = chaincode.SendEvent(stub, "deleteDeal", chaincode.Entities{DealID: dealId}, "Deal and its Transactions deleted succcessfully", nil)
 	if err != nil {
 		return nil, err
 	} 
@@ -977,70 +851,39 @@ func (t *ManageDeals) deleteDeal(stub shim.ChaincodeStubInterface, args []string
 	return nil, nil
 }
 // ============================================================================================================================
-// Delete - remove transactions from chain
+// Delete - remove the transactions of a deal from chain, the deal stays without transactions
 // ============================================================================================================================
 func (t *ManageDeals) deleteTransactions(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	if len(args) != 1 {
-		errMsg := "{ \"message\" : \"Incorrect number of arguments. Expecting 'dealId' as an argument\", \"code\" : \"503\"}"
-		err := stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "deleteTransactions", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'dealId' as an argument")
 	}
 	// set dealId
 	dealId := args[0]
-	fmt.Println("Should deal be deleted before checking")
-	err := stub.DelState(dealId)						//remove the Deal from chaincode
+	dealAsBytes, err := stub.GetState(dealId)
 	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to delete state\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
-	}
-
-	//get the Deal index
-	dealAsBytes, err := stub.GetState(DealIndexStr)
-	if err != nil {
-		errMsg := "{ \"message\" : \"Failed to get Deal index\", \"code\" : \"503\"}"
-		err = stub.SetEvent("errEvent", []byte(errMsg))
-		if err != nil {
-			return nil, err
-		} 
-		return nil, nil
+		return nil, chaincode.SendError(stub, "deleteTransactions", chaincode.ErrUpstream, chaincode.Entities{DealID: dealId}, "Failed to get Deal " + dealId)
 	}
 	res := Deals{}
 	json.Unmarshal(dealAsBytes, &res)								//un stringify it aka JSON.parse()
-	_TransactionsSplit := strings.Split(res.Transactions, ",")
-	fmt.Print("_TransactionsSplit: " )
-	fmt.Println(_TransactionsSplit)
-	for i:=0;i<len(_TransactionsSplit);{
-		fmt.Println("_TransactionsSplit[i]: " + _TransactionsSplit[i])
-		err := stub.DelState(_TransactionsSplit[i])													//remove the key from chaincode state
-		if err != nil {
-			errMsg := "{ \"transactions\" : \"" + _TransactionsSplit[i] + "\", \"message\" : \"Failed to delete state\", \"code\" : \"503\"}"
-			err = stub.SetEvent("errEvent", []byte(errMsg))
-			if err != nil {
-				return nil, err
-			} 
-			return nil, nil
-		}
-		_TransactionsSplit = append(_TransactionsSplit[:i], _TransactionsSplit[i+1:]...)			//remove it
-		fmt.Println(_TransactionsSplit)
-		for x:= range _TransactionsSplit{											//debug prints...
-			fmt.Println(string(x) + " - " + _TransactionsSplit[x])
-		}
+	if res.DealID != dealId {
+		return nil, chaincode.SendError(stub, "deleteTransactions", chaincode.ErrNotFound, chaincode.Entities{DealID: dealId}, dealId + " Not Found.")
+	}
+	err = deleteDealTransactions(stub, res)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "deleteTransactions", chaincode.ErrUpstream, chaincode.Entities{DealID: dealId}, "Failed to delete transactions: " + err.Error())
+	}
+	res.Transactions = ""
+	err = putDeal(stub, res)
+	if err != nil {
+		return nil, err
 	}
 
-	tosend := "{ \"dealID\" : \""+dealId+"\", \"message\" : \"Deal and its Transactions deleted succcessfully\", \"code\" : \"200\"}"
-	err = stub.SetEvent("evtsender", []byte(tosend))
+	err = chaincode.SendEvent(stub, "deleteTransactions", chaincode.Entities{DealID: dealId}, "Transactions of the Deal deleted succcessfully", nil)
 	if err != nil {
 		return nil, err
 	} 
 
-	fmt.Println("Deal and its Transactions deleted succcessfully")
+	fmt.Println("Transactions of the Deal deleted succcessfully")
 	return nil, nil
 }
 // ============================================================================================================================
@@ -1050,25 +893,17 @@ func(t * ManageDeals) update_transaction(stub shim.ChaincodeStubInterface, args[
     var err error
     fmt.Println(" update_transaction")
     if len(args) != 12 {
-        errMsg:= "{ \"message\" : \"Incorrect number of arguments. Expecting 12\", \"code\" : \"503\"}"
-	fmt.Println(errMsg)
-	err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+	return nil, chaincode.SendError(stub, "update_transaction", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 12")
+    }
+    if err = validateTransactionUpdate(args); err != nil {
+        return nil, chaincode.SendInvalid(stub, "update_transaction", chaincode.Entities{TransactionID: args[0]}, err)
     }
     // set _transactionId
     _transactionId:= args[0]
     fmt.Println(args)
 	transAsBytes, err:= stub.GetState(_transactionId) //get the Transaction for the specified _transactionId from chaincode state
     if err != nil {
-        errMsg:= "{ \"message\" : \"Failed to get state for " + _transactionId + "\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "update_transaction", chaincode.ErrUpstream, chaincode.Entities{TransactionID: _transactionId}, "Failed to get state for " + _transactionId)
     }
     
     res := Transactions {}
@@ -1078,25 +913,18 @@ func(t * ManageDeals) update_transaction(stub shim.ChaincodeStubInterface, args[
         fmt.Println("Transaction found with _transactionId : " + _transactionId)
         //fmt.Println(res);
         
-        //build the Transaction json string manually
-        transaction_json := `{` + 
-            `"transactionId": "` + res.TransactionId + `" , ` + 
-            `"transactionDate": "` + args[1] + `" , ` + 
-            `"dealId": "` + args[2] + `" , ` + 
-            `"pledger": "` + args[3] + `" , ` + 
-            `"pledgee": "` + args[4] + `" , ` + 
-            `"rqv": "` + args[5] + `" , ` +
-            `"currency": "` + args[6] + `" , ` + 
-            `"currencyConversionRate": ` + args[7] + ` , ` +  
-            `"marginCAllDate": "` + args[8] + `" , ` + 
-            `"allocationStatus": "` + args[9] + `" , ` + 
-            `"transactionStatus": "` + args[10] + `" , ` +
-            `"complianceStatus": "` + args[11] + `" ` + 
-        `}`
-	fmt.Println("")      
-	fmt.Println("Transaction JSON")    
-        fmt.Println(transaction_json)
-        err = stub.PutState(_transactionId, [] byte(transaction_json)) //store Deal with id as key
+        res.TransactionDate = args[1]
+        res.DealID = args[2]
+        res.Pledger = args[3]
+        res.Pledgee = args[4]
+        res.RQV = args[5]
+        res.Currency = args[6]
+        res.CurrencyConversionRate = strings.Trim(args[7], `"`) //taken with or without the quotes callers used to add
+        res.MarginCAllDate = args[8]
+        res.AllocationStatus = args[9]
+        res.TransactionStatus = args[10]
+        res.ComplianceStatus = args[11]
+        err = chaincode.PutRecord(stub, _transactionId, &res) //store Deal with id as key
         if err != nil {
             return nil, err
         }
@@ -1104,12 +932,7 @@ func(t * ManageDeals) update_transaction(stub shim.ChaincodeStubInterface, args[
 		_dealId := args[2]
      	dealAsBytes, err:= stub.GetState(_dealId) //get the Deal for the specified dealId from chaincode state
 	    if err != nil {
-	        errMsg:= "{ \"message\" : \"Failed to get state for " + _dealId + "\", \"code\" : \"503\"}"
-	        err = stub.SetEvent("errEvent", [] byte(errMsg))
-	        if err != nil {
-	            return nil, err
-	        }
-	        return nil,nil
+	        return nil, chaincode.SendError(stub, "update_transaction", chaincode.ErrUpstream, chaincode.Entities{DealID: _dealId}, "Failed to get state for " + _dealId)
 	    }
 	    json.Unmarshal(dealAsBytes, &res_Deal)
         var allocationDate int64
@@ -1118,38 +941,19 @@ func(t * ManageDeals) update_transaction(stub shim.ChaincodeStubInterface, args[
 	    } else {
 		    allocationDate = 0000000
 	    }
-		    //build the Deal json string manually
-	    _allocationDate := strconv.FormatInt(allocationDate,10)
-        deal_json := `{` + 
-            `"dealId": "` + res_Deal.DealID + `" , ` + 
-            `"pledger": "` + res_Deal.Pledger + `" , ` + 
-            `"pledgee": "` + res_Deal.Pledgee + `" , ` + 
-            `"maxValue": "` + res_Deal.MaxValue + `" , ` + 
-            `"totalValueLongBoxAccount": "` + res_Deal.TotalValueLongBoxAccount + `" , ` + 
-            `"totalValueSegregatedAccount": "` + res_Deal.TotalValueSegregatedAccount + `" , ` +
-            `"issueDate": "` + res_Deal.IssueDate + `" , ` + 
-	    `"lastSuccessfulAllocationDate": "` + _allocationDate + `" , ` +  
-            `"transactions": "` + res_Deal.Transactions + `" ` + 
-        `}`
-        fmt.Println(deal_json)
-        err = stub.PutState(_dealId, [] byte(deal_json)) //store Deal with id as key
+        res_Deal.LastSuccessfulAllocationDate = strconv.FormatInt(allocationDate,10)
+        err = putDeal(stub, res_Deal) //store Deal with id as key
         if err != nil {
             return nil, err
         }
 
-        tosend:= "{ \"transactionId\" : \"" + _transactionId + "\", \"message\" : \"Transaction updated succcessfully\", \"code\" : \"200\"}"
-        err = stub.SetEvent("evtsender", [] byte(tosend))
+        err = chaincode.SendEvent(stub, "update_transaction", chaincode.Entities{TransactionID: _transactionId}, "Transaction updated succcessfully", nil)
         if err != nil {
             return nil, err
         }
         fmt.Println("Transaction updated succcessfully")
     } else {
-        errMsg:= "{ \"message\" : \"" + _transactionId + " Not Found.\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "update_transaction", chaincode.ErrNotFound, chaincode.Entities{TransactionID: _transactionId}, _transactionId + " Not Found.")
     }
     return nil, nil
 }
@@ -1160,23 +964,13 @@ func(t * ManageDeals) update_transaction_AllocationStatus(stub shim.ChaincodeStu
     var err error
     fmt.Println(" update_transaction_AllocationStatus")
     if len(args) != 2 {
-        errMsg:= "{ \"message\" : \"Incorrect number of arguments. Expecting 2\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "update_transaction_AllocationStatus", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 2")
     }
     // set _transactionId
     _transactionId:= args[0]
     transAsBytes, err:= stub.GetState(_transactionId) //get the Deal for the specified dealId from chaincode state
     if err != nil {
-        errMsg:= "{ \"message\" : \"Failed to get state for " + _transactionId + "\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "update_transaction_AllocationStatus", chaincode.ErrUpstream, chaincode.Entities{TransactionID: _transactionId}, "Failed to get state for " + _transactionId)
     }
 
     _allocationStatus := args[1];
@@ -1189,39 +983,18 @@ func(t * ManageDeals) update_transaction_AllocationStatus(stub shim.ChaincodeStu
     if res.TransactionId == _transactionId {
         fmt.Println("Transaction found with _transactionId : " + _transactionId)
         //fmt.Println(res);
-        //build the transaction json string manually
-        transaction_json := `{` + 
-            `"transactionId": "` + res.TransactionId + `" , ` + 
-            `"transactionDate": "` + res.TransactionDate + `" , ` + 
-            `"dealId": "` + res.DealID + `" , ` + 
-            `"pledger": "` + res.Pledger + `" , ` + 
-            `"pledgee": "` + res.Pledgee + `" , ` + 
-            `"rqv": "` + res.RQV + `" , ` + 
-            `"currency": "` + res.Currency + `" , ` + 
-            `"currencyConversionRate": "` + res.CurrencyConversionRate + `" , ` + 
-            `"marginCAllDate": "` + res.MarginCAllDate + `" , ` + 
-            `"allocationStatus": "` + _allocationStatus + `" , ` + 
-            `"transactionStatus": "` + res.TransactionStatus + `" , ` + 
-            `"complianceStatus": "` + res.ComplianceStatus + `" ` + 
-        `}`
-        fmt.Println(transaction_json);
-        err = stub.PutState(_transactionId, [] byte(transaction_json)) //store Deal with id as key
+        res.AllocationStatus = _allocationStatus
+        err = chaincode.PutRecord(stub, _transactionId, &res) //store Deal with id as key
         if err != nil {
             return nil, err
         }
-        tosend:= "{ \"transactionId\" : \"" + _transactionId + "\", \"message\" : \"Transaction updated succcessfully\", \"code\" : \"200\"}"
-        err = stub.SetEvent("evtsender", [] byte(tosend))
+        err = chaincode.SendEvent(stub, "update_transaction_AllocationStatus", chaincode.Entities{TransactionID: _transactionId}, "Transaction updated succcessfully", nil)
         if err != nil {
             return nil, err
         }
         fmt.Println("update_transaction_AllocationStatus")
     } else {
-        errMsg:= "{ \"message\" : \"" + _transactionId + " Not Found.\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+        return nil, chaincode.SendError(stub, "update_transaction_AllocationStatus", chaincode.ErrNotFound, chaincode.Entities{TransactionID: _transactionId}, _transactionId + " Not Found.")
     }
     return nil, nil
 }
@@ -1231,60 +1004,55 @@ func(t * ManageDeals) update_transaction_AllocationStatus(stub shim.ChaincodeStu
 func(t * ManageDeals) create_transaction(stub shim.ChaincodeStubInterface, args[] string)([] byte, error) {
     var err error
     var _allocationStatus string
-    if len(args) != 9 {
-        errMsg:= "{ \"message\" : \"Incorrect number of arguments. Expecting 9\", \"code\" : \"503\"}"
-        err = stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil
+    if len(args) != 9 && len(args) != 10 {
+        return nil, chaincode.SendError(stub, "create_transaction", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 9 or 10")
     }
     fmt.Println("start create_transaction")
+    if err = validateTransaction(args); err != nil {
+        return nil, chaincode.SendInvalid(stub, "create_transaction", chaincode.Entities{TransactionID: args[0]}, err)
+    }
     _transactionId:= args[0]
     _transactionStatus:= args[8];
+    // direction is optional, margin calls are deliveries from the pledger unless told otherwise
+    _direction:= "Call"
+    if len(args) == 10 {
+        _direction = args[9]
+    }
     res:= Transactions {}
     dealAsBytes, err:= stub.GetState(_transactionId)
     json.Unmarshal(dealAsBytes, &res)
     if res.TransactionId == _transactionId {
         fmt.Println("This Transaction already exists")
-        errMsg:= "{ \"transactionId\" : \"" + _transactionId + "\", \"message\" : \"This Transaction already exists\", \"code\" : \"503\"}"
-        err:= stub.SetEvent("errEvent", [] byte(errMsg))
-        if err != nil {
-            return nil, err
-        }
-        return nil,nil //all stop a Deal by this name exists
+        return nil, chaincode.SendError(stub, "create_transaction", chaincode.ErrConflict, chaincode.Entities{TransactionID: _transactionId}, "This Transaction already exists")
     }else{
         if _transactionStatus == "Matched" {
             _allocationStatus = "Ready for Allocation"
         } else if _transactionStatus == "Unmatched" {
             _allocationStatus = "Deal Unmatched. Can't be allocated"
         }
-        //build the transaction json string manually
-        transaction_json := `{` + 
-            `"transactionId": "` + args[0] + `" , ` +
-            `"transactionDate": "` + args[1] + `" , ` + 
-            `"dealId": "` + args[2] + `" , ` + 
-            `"pledger": "` + args[3] + `" , ` + 
-            `"pledgee": "` + args[4] + `" , ` + 
-            `"rqv": "` + args[5] + `" , ` + 
-            `"currency": "` + args[6] + `" , ` + 
-            `"currencyConversionRate": "` + " " + `" , ` + 
-            `"marginCAllDate": "` + args[7] + `" , ` + 
-            `"allocationStatus": "` + _allocationStatus + `" , ` + 
-            `"transactionStatus": "` + args[8] + `" , ` +
-            `"complianceStatus": "` + "NA" + `" ` +
-        `}`
-        fmt.Println("transaction_json: " + transaction_json)
-        //fmt.Print("transaction_json in bytes array: ")
-        //fmt.Println([]byte(transaction_json))
-        err = stub.PutState(_transactionId, [] byte(transaction_json)) //store Deal with dealId as key
+        res = Transactions {
+            TransactionId: args[0],
+            TransactionDate: args[1],
+            DealID: args[2],
+            Pledger: args[3],
+            Pledgee: args[4],
+            RQV: args[5],
+            Currency: args[6],
+            CurrencyConversionRate: " ",
+            MarginCAllDate: args[7],
+            AllocationStatus: _allocationStatus,
+            TransactionStatus: args[8],
+            ComplianceStatus: "NA",
+            Direction: _direction,
+        }
+        err = chaincode.PutRecord(stub, _transactionId, &res) //store Deal with dealId as key
         if err != nil {
             return nil, err
         }
          //get the Transaction index
         transactionIndexAsBytes, err:= stub.GetState(transactionIndexStr)
         if err != nil {
-            return nil, errors.New("Failed to get Transaction index")
+            return nil, chaincode.SendError(stub, "create_transaction", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get Transaction index")
         }
         var transactionIndex[] string
         //fmt.Print("transactionIndexAsBytes: ")
@@ -1302,8 +1070,7 @@ func(t * ManageDeals) create_transaction(stub shim.ChaincodeStubInterface, args[
         if err != nil {
             return nil, err
         }
-        tosend:= "{ \"transactionId\" : \"" + args[0] + "\", \"message\" : \"Transaction created succcessfully\", \"code\" : \"200\"}"
-        err = stub.SetEvent("evtsender", [] byte(tosend))
+        err = chaincode.SendEvent(stub, "create_transaction", chaincode.Entities{TransactionID: args[0]}, "Transaction created succcessfully", nil)
         if err != nil {
             return nil, err
         }
@@ -1315,3 +1082,9 @@ func(t * ManageDeals) create_transaction(stub shim.ChaincodeStubInterface, args[
     }
     return nil, nil
 }
+// ============================================================================================================================
+// putDeal - store a Deal into chaincode state with dealId as key
+// ============================================================================================================================
+func putDeal(stub shim.ChaincodeStubInterface, deal Deals) error {
+    return chaincode.PutRecord(stub, deal.DealID, &deal)
+}
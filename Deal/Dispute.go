@@ -0,0 +1,474 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package deal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+var disputeIndexStr = "_disputeIndex" //name for the key/value that will store a list of all known disputeIds
+
+// Disputes are stored under this prefix, apart from the deals and transactions keyed by their bare ids
+var disputeKeyPrefix = "_dispute-"
+
+// Allocation statuses of a transaction whose collateral has not started moving, only those can be disputed
+var disputableStatuses = map[string]bool{
+	"Ready for Allocation":                   true,
+	"Pending due to insufficient collateral": true,
+	"Below minimum transfer amount":          true,
+}
+
+// Suffixes of the transactions created on behalf of a dispute
+var undisputedTransactionSuffix = "-UND" // carries the undisputed portion of the RQV
+var agreedTransactionSuffix = "-AGR"     // carries the agreed amount above the undisputed portion
+
+type ResolutionStep struct {
+	StepDate       string `json:"stepDate"`
+	Party          string `json:"party"`
+	Action         string `json:"action"`
+	ProposedAmount string `json:"proposedAmount"`
+	Comment        string `json:"comment"`
+}
+
+type Disputes struct {
+	chaincode.Record
+	DisputeID               string           `json:"disputeId"`
+	TransactionID           string           `json:"transactionId"`
+	DealID                  string           `json:"dealId"`
+	Pledger                 string           `json:"pledger"`
+	Pledgee                 string           `json:"pledgee"`
+	RaisedBy                string           `json:"raisedBy"`
+	Currency                string           `json:"currency"`
+	RQV                     string           `json:"rqv"`
+	DisputedAmount          string           `json:"disputedAmount"`
+	UndisputedAmount        string           `json:"undisputedAmount"`
+	Reason                  string           `json:"reason"`
+	Status                  string           `json:"status"`
+	RaisedDate              string           `json:"raisedDate"`
+	ResolvedDate            string           `json:"resolvedDate"`
+	AgreedAmount            string           `json:"agreedAmount"`
+	UndisputedTransactionID string           `json:"undisputedTransactionId"`
+	AgreedTransactionID     string           `json:"agreedTransactionId"`
+	ResolutionSteps         []ResolutionStep `json:"resolutionSteps"`
+	AgeInDays               string           `json:"ageInDays,omitempty"` //computed when the dispute is read, never stored
+}
+
+// ============================================================================================================================
+// raise_dispute - record a pledger's disagreement with the RQV of a transaction and release the undisputed portion
+// ============================================================================================================================
+func (t *ManageDeals) raise_dispute(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 5 {
+		return nil, chaincode.SendError(stub, "raise_dispute", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'disputeId', 'transactionId', 'raisedBy', 'disputedAmount' and 'reason'")
+	}
+	fmt.Println("start raise_dispute")
+	_disputeId := args[0]
+	_transactionId := args[1]
+	_raisedBy := args[2]
+	_reason := args[4]
+
+	res, err := getDispute(stub, _disputeId)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "raise_dispute", chaincode.ErrUpstream, chaincode.Entities{DisputeID: _disputeId}, err.Error())
+	}
+	if res.DisputeID == _disputeId {
+		return nil, chaincode.SendError(stub, "raise_dispute", chaincode.ErrConflict, chaincode.Entities{DisputeID: _disputeId}, "This Dispute already exists")
+	}
+
+	transAsBytes, err := stub.GetState(_transactionId)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "raise_dispute", chaincode.ErrUpstream, chaincode.Entities{TransactionID: _transactionId}, "Failed to get Transaction "+_transactionId)
+	}
+	transaction := Transactions{}
+	json.Unmarshal(transAsBytes, &transaction)
+	if transaction.TransactionId != _transactionId {
+		return nil, chaincode.SendError(stub, "raise_dispute", chaincode.ErrNotFound, chaincode.Entities{TransactionID: _transactionId}, _transactionId+" Not Found.")
+	}
+	if !disputableStatuses[transaction.AllocationStatus] {
+		return nil, chaincode.SendError(stub, "raise_dispute", chaincode.ErrConflict, chaincode.Entities{TransactionID: _transactionId}, "Transaction with allocation status '"+transaction.AllocationStatus+"' can't be disputed.")
+	}
+	dealAsBytes, err := stub.GetState(transaction.DealID)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "raise_dispute", chaincode.ErrUpstream, chaincode.Entities{DealID: transaction.DealID}, "Failed to get Deal "+transaction.DealID)
+	}
+	deal := Deals{}
+	json.Unmarshal(dealAsBytes, &deal)
+	if deal.DealID != transaction.DealID {
+		return nil, chaincode.SendError(stub, "raise_dispute", chaincode.ErrNotFound, chaincode.Entities{DealID: transaction.DealID}, transaction.DealID+" Not Found.")
+	}
+	if _raisedBy != deal.Pledger && _raisedBy != deal.Pledgee {
+		return nil, chaincode.SendError(stub, "raise_dispute", chaincode.ErrValidation, chaincode.Entities{DealID: deal.DealID}, "A dispute can only be raised by the pledger or the pledgee of "+deal.DealID+".")
+	}
+
+	rqv, err := strconv.ParseFloat(transaction.RQV, 64)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "raise_dispute", chaincode.ErrValidation, chaincode.Entities{TransactionID: _transactionId}, "RQV of the transaction is not a number.")
+	}
+	disputedAmount, err := strconv.ParseFloat(args[3], 64)
+	if err != nil || disputedAmount <= 0 || disputedAmount > rqv {
+		return nil, chaincode.SendError(stub, "raise_dispute", chaincode.ErrValidation, chaincode.Entities{DisputeID: _disputeId}, "Disputed amount must be a number greater than 0 and not more than the RQV.")
+	}
+	undisputedAmount := rqv - disputedAmount
+	now, err := chaincode.TxSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	res.DisputeID = _disputeId
+	res.TransactionID = _transactionId
+	res.DealID = transaction.DealID
+	res.Pledger = transaction.Pledger
+	res.Pledgee = transaction.Pledgee
+	res.RaisedBy = _raisedBy
+	res.Currency = transaction.Currency
+	res.RQV = transaction.RQV
+	res.DisputedAmount = strconv.FormatFloat(disputedAmount, 'f', 2, 64)
+	res.UndisputedAmount = strconv.FormatFloat(undisputedAmount, 'f', 2, 64)
+	res.Reason = _reason
+	res.Status = "Open"
+	res.RaisedDate = strconv.FormatInt(now, 10)
+	res.ResolutionSteps = []ResolutionStep{}
+
+	// Hold the original margin call while the dispute is open
+	_, err = t.update_transaction_AllocationStatus(stub, []string{_transactionId, "Disputed"})
+	if err != nil {
+		return nil, err
+	}
+
+	// The undisputed portion is allocated straight away through its own transaction
+	if undisputedAmount > 0 {
+		res.UndisputedTransactionID = _transactionId + undisputedTransactionSuffix
+		_, err = t.create_transaction(stub, []string{
+			res.UndisputedTransactionID,
+			res.RaisedDate,
+			transaction.DealID,
+			transaction.Pledger,
+			transaction.Pledgee,
+			res.UndisputedAmount,
+			transaction.Currency,
+			transaction.MarginCAllDate,
+			"Matched"})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = putDispute(stub, res)
+	if err != nil {
+		return nil, err
+	}
+	//get the Dispute index
+	disputeIndexAsBytes, err := stub.GetState(disputeIndexStr)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "raise_dispute", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get Dispute index")
+	}
+	var disputeIndex []string
+	json.Unmarshal(disputeIndexAsBytes, &disputeIndex) //un stringify it aka JSON.parse()
+	disputeIndex = append(disputeIndex, _disputeId)    //add disputeId to index list
+	jsonAsBytes, _ := json.Marshal(disputeIndex)
+	err = stub.PutState(disputeIndexStr, jsonAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = chaincode.SendEvent(stub, "raise_dispute", chaincode.Entities{DisputeID: _disputeId, TransactionID: _transactionId}, "Dispute raised succcessfully", map[string]string{"undisputedTransactionId": res.UndisputedTransactionID})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end raise_dispute")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// add_dispute_step - record a step taken by either party towards resolving a dispute
+// ============================================================================================================================
+func (t *ManageDeals) add_dispute_step(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 5 {
+		return nil, chaincode.SendError(stub, "add_dispute_step", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'disputeId', 'party', 'action', 'proposedAmount' and 'comment'")
+	}
+	fmt.Println("start add_dispute_step")
+	_disputeId := args[0]
+	res, err := getDispute(stub, _disputeId)
+	if err != nil {
+		return nil, err
+	}
+	if res.DisputeID != _disputeId {
+		return nil, chaincode.SendError(stub, "add_dispute_step", chaincode.ErrNotFound, chaincode.Entities{DisputeID: _disputeId}, _disputeId+" Not Found.")
+	}
+	if res.Status == "Resolved" {
+		return nil, chaincode.SendError(stub, "add_dispute_step", chaincode.ErrConflict, chaincode.Entities{DisputeID: _disputeId}, "Dispute is already resolved.")
+	}
+	if args[1] != res.Pledger && args[1] != res.Pledgee {
+		return nil, chaincode.SendError(stub, "add_dispute_step", chaincode.ErrValidation, chaincode.Entities{DisputeID: _disputeId}, args[1]+" is not a party to this dispute.")
+	}
+	now, err := chaincode.TxSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+	step := ResolutionStep{
+		StepDate: strconv.FormatInt(now, 10),
+		Party:    args[1],
+		Action:   args[2],
+		Comment:  args[4],
+	}
+	if args[3] != "" && args[3] != " " {
+		proposedAmount, err := strconv.ParseFloat(args[3], 64)
+		if err != nil || proposedAmount < 0 {
+			return nil, chaincode.SendError(stub, "add_dispute_step", chaincode.ErrValidation, chaincode.Entities{DisputeID: _disputeId}, "Proposed amount must be a positive number.")
+		}
+		step.ProposedAmount = strconv.FormatFloat(proposedAmount, 'f', 2, 64)
+	}
+	res.ResolutionSteps = append(res.ResolutionSteps, step)
+	res.Status = "Under Negotiation"
+	err = putDispute(stub, res)
+	if err != nil {
+		return nil, err
+	}
+	err = chaincode.SendEvent(stub, "add_dispute_step", chaincode.Entities{DisputeID: _disputeId}, "Dispute step recorded succcessfully", nil)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end add_dispute_step")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// resolve_dispute - close a dispute with the final agreed amount and release anything owed above the undisputed portion
+// ============================================================================================================================
+func (t *ManageDeals) resolve_dispute(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 3 {
+		return nil, chaincode.SendError(stub, "resolve_dispute", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'disputeId', 'agreedAmount' and 'resolvedBy'")
+	}
+	fmt.Println("start resolve_dispute")
+	_disputeId := args[0]
+	res, err := getDispute(stub, _disputeId)
+	if err != nil {
+		return nil, err
+	}
+	if res.DisputeID != _disputeId {
+		return nil, chaincode.SendError(stub, "resolve_dispute", chaincode.ErrNotFound, chaincode.Entities{DisputeID: _disputeId}, _disputeId+" Not Found.")
+	}
+	if res.Status == "Resolved" {
+		return nil, chaincode.SendError(stub, "resolve_dispute", chaincode.ErrConflict, chaincode.Entities{DisputeID: _disputeId}, "Dispute is already resolved.")
+	}
+	rqv, _ := strconv.ParseFloat(res.RQV, 64)
+	undisputedAmount, _ := strconv.ParseFloat(res.UndisputedAmount, 64)
+	agreedAmount, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || agreedAmount < 0 || agreedAmount > rqv {
+		return nil, chaincode.SendError(stub, "resolve_dispute", chaincode.ErrValidation, chaincode.Entities{DisputeID: _disputeId}, "Agreed amount must be a positive number not more than the RQV.")
+	}
+	// The undisputed portion was released for allocation when the dispute was raised, nothing returns it
+	if agreedAmount < undisputedAmount-0.005 {
+		return nil, chaincode.SendError(stub, "resolve_dispute", chaincode.ErrValidation, chaincode.Entities{DisputeID: _disputeId}, "Agreed amount must not be less than the undisputed amount of "+res.UndisputedAmount+" already called.")
+	}
+	now, err := chaincode.TxSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+	res.AgreedAmount = strconv.FormatFloat(agreedAmount, 'f', 2, 64)
+	res.Status = "Resolved"
+	res.ResolvedDate = strconv.FormatInt(now, 10)
+	res.ResolutionSteps = append(res.ResolutionSteps, ResolutionStep{
+		StepDate:       res.ResolvedDate,
+		Party:          args[2],
+		Action:         "Resolved",
+		ProposedAmount: res.AgreedAmount,
+	})
+
+	// Anything agreed above the undisputed portion still has to be delivered
+	if agreedAmount > undisputedAmount {
+		transAsBytes, err := stub.GetState(res.TransactionID)
+		if err != nil {
+			return nil, chaincode.SendError(stub, "resolve_dispute", chaincode.ErrUpstream, chaincode.Entities{TransactionID: res.TransactionID}, "Failed to get Transaction "+res.TransactionID)
+		}
+		transaction := Transactions{}
+		json.Unmarshal(transAsBytes, &transaction)
+		res.AgreedTransactionID = res.TransactionID + agreedTransactionSuffix
+		_, err = t.create_transaction(stub, []string{
+			res.AgreedTransactionID,
+			res.ResolvedDate,
+			res.DealID,
+			res.Pledger,
+			res.Pledgee,
+			strconv.FormatFloat(agreedAmount-undisputedAmount, 'f', 2, 64),
+			res.Currency,
+			transaction.MarginCAllDate,
+			"Matched"})
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = t.update_transaction_AllocationStatus(stub, []string{res.TransactionID, "Dispute Resolved"})
+	if err != nil {
+		return nil, err
+	}
+	err = putDispute(stub, res)
+	if err != nil {
+		return nil, err
+	}
+	err = chaincode.SendEvent(stub, "resolve_dispute", chaincode.Entities{DisputeID: _disputeId}, "Dispute resolved succcessfully", map[string]string{"agreedAmount": res.AgreedAmount, "agreedTransactionId": res.AgreedTransactionID})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end resolve_dispute")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// getDispute_byID - get Dispute details for a specific ID from chaincode state
+// ============================================================================================================================
+func (t *ManageDeals) getDispute_byID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("start getDispute_byID")
+	if len(args) != 1 {
+		return nil, chaincode.SendError(stub, "getDispute_byID", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'disputeId' as an argument")
+	}
+	_disputeId := args[0]
+	res, err := getDispute(stub, _disputeId)
+	if err != nil {
+		return nil, err
+	}
+	if res.DisputeID != _disputeId {
+		return nil, chaincode.SendError(stub, "getDispute_byID", chaincode.ErrNotFound, chaincode.Entities{DisputeID: _disputeId}, _disputeId+" not Found.")
+	}
+	now, err := chaincode.TxSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+	res.AgeInDays = disputeAge(res, now)
+	fmt.Println("end getDispute_byID")
+	return json.Marshal(res)
+}
+
+// ============================================================================================================================
+// getDisputes_byDealID - get all Disputes raised against transactions of a Deal
+// ============================================================================================================================
+func (t *ManageDeals) getDisputes_byDealID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("start getDisputes_byDealID")
+	if len(args) != 1 {
+		return nil, chaincode.SendError(stub, "getDisputes_byDealID", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'dealId' as an argument")
+	}
+	_dealId := args[0]
+	disputes, err := filterDisputes(stub, func(d Disputes) bool {
+		return d.DealID == _dealId
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(disputes) == 0 {
+		return nil, chaincode.SendError(stub, "getDisputes_byDealID", chaincode.ErrNotFound, chaincode.Entities{DealID: _dealId}, "No disputes found.")
+	}
+	fmt.Println("end getDisputes_byDealID")
+	return json.Marshal(disputes)
+}
+
+// ============================================================================================================================
+// getDisputes_byCounterparty - get all Disputes where the given name is the pledger or the pledgee
+// ============================================================================================================================
+func (t *ManageDeals) getDisputes_byCounterparty(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("start getDisputes_byCounterparty")
+	if len(args) != 1 {
+		return nil, chaincode.SendError(stub, "getDisputes_byCounterparty", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'counterparty' as an argument")
+	}
+	_counterparty := args[0]
+	disputes, err := filterDisputes(stub, func(d Disputes) bool {
+		return d.Pledger == _counterparty || d.Pledgee == _counterparty
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(disputes) == 0 {
+		return nil, chaincode.SendError(stub, "getDisputes_byCounterparty", chaincode.ErrNotFound, chaincode.Entities{}, "No disputes found for "+_counterparty+".")
+	}
+	fmt.Println("end getDisputes_byCounterparty")
+	return json.Marshal(disputes)
+}
+
+// disputeKey is the key a dispute is stored under
+func disputeKey(disputeId string) string {
+	return disputeKeyPrefix + disputeId
+}
+
+// getDispute reads a dispute from state; a missing dispute comes back empty
+func getDispute(stub shim.ChaincodeStubInterface, disputeId string) (Disputes, error) {
+	res := Disputes{}
+	disputeAsBytes, err := stub.GetState(disputeKey(disputeId))
+	if err != nil {
+		return res, errors.New("Failed to get Dispute " + disputeId)
+	}
+	json.Unmarshal(disputeAsBytes, &res)
+	return res, nil
+}
+
+// putDispute writes a dispute to state under its key
+func putDispute(stub shim.ChaincodeStubInterface, dispute Disputes) error {
+	dispute.AgeInDays = ""
+	return chaincode.PutRecord(stub, disputeKey(dispute.DisputeID), &dispute)
+}
+
+// filterDisputes walks the dispute index and returns the disputes accepted by keep, with their aging filled in
+func filterDisputes(stub shim.ChaincodeStubInterface, keep func(Disputes) bool) ([]Disputes, error) {
+	var disputeIndex []string
+	disputes := []Disputes{}
+	disputeIndexAsBytes, err := stub.GetState(disputeIndexStr)
+	if err != nil {
+		return nil, errors.New("Failed to get Dispute index")
+	}
+	json.Unmarshal(disputeIndexAsBytes, &disputeIndex) //un stringify it aka JSON.parse()
+	now, err := chaincode.TxSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+	for i, val := range disputeIndex {
+		fmt.Println(strconv.Itoa(i) + " - looking at " + val + " for disputes")
+		res, err := getDispute(stub, val)
+		if err != nil {
+			return nil, err
+		}
+		if res.DisputeID == val && keep(res) {
+			res.AgeInDays = disputeAge(res, now)
+			disputes = append(disputes, res)
+		}
+	}
+	return disputes, nil
+}
+
+// disputeAge is the number of whole days a dispute has been open at now, up to its resolution if resolved
+func disputeAge(dispute Disputes, now int64) string {
+	raised, err := strconv.ParseInt(dispute.RaisedDate, 10, 64)
+	if err != nil {
+		return "0"
+	}
+	until := now
+	if resolved, err := strconv.ParseInt(dispute.ResolvedDate, 10, 64); err == nil {
+		until = resolved
+	}
+	return strconv.FormatInt((until-raised)/(24*60*60), 10)
+}
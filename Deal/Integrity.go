@@ -0,0 +1,320 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package deal
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+// IntegrityReport is how the deal and transaction indexes and the transaction lists of deals disagree with the records
+type IntegrityReport struct {
+	Consistent               bool                `json:"consistent"`
+	DanglingDealIndex        []string            `json:"danglingDealIndex"`        // in _Dealindex without a deal
+	DanglingTransactionIndex []string            `json:"danglingTransactionIndex"` // in _transactionIndex without a transaction
+	UnindexedDeals           []string            `json:"unindexedDeals"`           // deals _Dealindex does not list
+	UnindexedTransactions    []string            `json:"unindexedTransactions"`    // transactions _transactionIndex does not list
+	UnlinkedTransactions     []string            `json:"unlinkedTransactions"`     // transactions no deal lists
+	OrphanedTransactions     []string            `json:"orphanedTransactions"`     // unlinked transactions whose deal does not exist
+	MissingTransactions      map[string][]string `json:"missingTransactions"`      // per deal, the transactions it lists without a record
+	Repaired                 bool                `json:"repaired"`
+}
+
+// ledgerIndexes are the indexes as stored and every deal and transaction in the world state by key
+type ledgerIndexes struct {
+	dealIndex        []string
+	transactionIndex []string
+	deals            map[string]Deals
+	transactions     map[string]Transactions
+}
+
+// transactionIds are the transactions a deal lists
+func transactionIds(deal Deals) []string {
+	ids := []string{}
+	for _, id := range strings.Split(deal.Transactions, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// readLedgerIndexes reads the indexes and every deal and transaction. A record is a deal or a transaction when it is kept
+// under its own dealId or transactionId, records kept per deal or per transaction are under a suffix
+func readLedgerIndexes(stub shim.ChaincodeStubInterface) (ledgerIndexes, error) {
+	ledger := ledgerIndexes{deals: make(map[string]Deals), transactions: make(map[string]Transactions)}
+	for indexStr, index := range map[string]*[]string{DealIndexStr: &ledger.dealIndex, transactionIndexStr: &ledger.transactionIndex} {
+		indexAsBytes, err := stub.GetState(indexStr)
+		if err != nil {
+			return ledger, err
+		}
+		json.Unmarshal(indexAsBytes, index)
+	}
+	records, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return ledger, err
+	}
+	defer records.Close()
+	for records.HasNext() {
+		kv, err := records.Next()
+		if err != nil {
+			return ledger, err
+		}
+		transaction := Transactions{}
+		if json.Unmarshal(kv.Value, &transaction) != nil {
+			continue
+		}
+		if transaction.TransactionId != "" && kv.Key == transaction.TransactionId {
+			ledger.transactions[kv.Key] = transaction
+			continue
+		}
+		deal := Deals{}
+		json.Unmarshal(kv.Value, &deal)
+		if deal.DealID != "" && kv.Key == deal.DealID {
+			ledger.deals[kv.Key] = deal
+		}
+	}
+	return ledger, nil
+}
+
+// checkIndexes compares the indexes and transaction lists of the ledger with its records
+func checkIndexes(ledger ledgerIndexes) IntegrityReport {
+	report := IntegrityReport{UnlinkedTransactions: []string{}, OrphanedTransactions: []string{}, MissingTransactions: make(map[string][]string)}
+	dealIds := []string{}
+	for id := range ledger.deals {
+		dealIds = append(dealIds, id)
+	}
+	sort.Strings(dealIds)
+	ids := []string{}
+	for id := range ledger.transactions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	report.DanglingDealIndex, report.UnindexedDeals = compareIndex(ledger.dealIndex, dealIds)
+	report.DanglingTransactionIndex, report.UnindexedTransactions = compareIndex(ledger.transactionIndex, ids)
+
+	linked := make(map[string]bool)
+	for _, dealId := range dealIds {
+		for _, id := range transactionIds(ledger.deals[dealId]) {
+			if _, ok := ledger.transactions[id]; !ok {
+				report.MissingTransactions[dealId] = append(report.MissingTransactions[dealId], id)
+			}
+			linked[id] = true
+		}
+	}
+	for _, id := range ids {
+		if !linked[id] {
+			report.UnlinkedTransactions = append(report.UnlinkedTransactions, id)
+			if _, ok := ledger.deals[ledger.transactions[id].DealID]; !ok {
+				report.OrphanedTransactions = append(report.OrphanedTransactions, id)
+			}
+		}
+	}
+	report.Consistent = len(report.DanglingDealIndex)+len(report.DanglingTransactionIndex)+len(report.UnindexedDeals)+
+		len(report.UnindexedTransactions)+len(report.UnlinkedTransactions)+len(report.MissingTransactions) == 0
+	return report
+}
+
+// compareIndex returns the keys an index lists without a record and the keys of records it does not list
+func compareIndex(index []string, keys []string) ([]string, []string) {
+	exists := make(map[string]bool)
+	for _, key := range keys {
+		exists[key] = true
+	}
+	dangling, unindexed := []string{}, []string{}
+	indexed := make(map[string]bool)
+	for _, key := range index {
+		if !exists[key] {
+			dangling = append(dangling, key)
+		}
+		indexed[key] = true
+	}
+	for _, key := range keys {
+		if !indexed[key] {
+			unindexed = append(unindexed, key)
+		}
+	}
+	return dangling, unindexed
+}
+
+// rebuildIndex keeps the keys of an index that still have a record, once and in their order, followed by those it missed
+func rebuildIndex(index []string, exists func(string) bool, unindexed []string) []string {
+	rebuilt := []string{}
+	seen := make(map[string]bool)
+	for _, key := range append(append([]string{}, index...), unindexed...) {
+		if exists(key) && !seen[key] {
+			rebuilt = append(rebuilt, key)
+			seen[key] = true
+		}
+	}
+	return rebuilt
+}
+
+// removeFromIndex drops keys from the index stored under indexStr
+func removeFromIndex(stub shim.ChaincodeStubInterface, indexStr string, keys ...string) error {
+	indexAsBytes, err := stub.GetState(indexStr)
+	if err != nil {
+		return err
+	}
+	var index []string
+	json.Unmarshal(indexAsBytes, &index)
+	removed := make(map[string]bool)
+	for _, key := range keys {
+		removed[key] = true
+	}
+	kept := []string{}
+	for _, key := range index {
+		if !removed[key] {
+			kept = append(kept, key)
+		}
+	}
+	jsonAsBytes, _ := json.Marshal(kept)
+	return stub.PutState(indexStr, jsonAsBytes)
+}
+
+// deleteDealRecords deletes the records kept per deal and the disputes raised against its transactions
+func deleteDealRecords(stub shim.ChaincodeStubInterface, dealId string) error {
+	for _, key := range []string{exposureKey(dealId), cashCollateralKey(dealId)} {
+		if err := stub.DelState(key); err != nil {
+			return err
+		}
+	}
+	disputes, err := filterDisputes(stub, func(d Disputes) bool {
+		return d.DealID == dealId
+	})
+	if err != nil {
+		return err
+	}
+	ids := []string{}
+	for _, dispute := range disputes {
+		if err = stub.DelState(disputeKey(dispute.DisputeID)); err != nil {
+			return err
+		}
+		ids = append(ids, dispute.DisputeID)
+	}
+	return removeFromIndex(stub, disputeIndexStr, ids...)
+}
+
+// deleteDealTransactions deletes the transactions a deal lists and takes them out of the transaction index
+func deleteDealTransactions(stub shim.ChaincodeStubInterface, deal Deals) error {
+	ids := transactionIds(deal)
+	for _, id := range ids {
+		if err := stub.DelState(id); err != nil {
+			return err
+		}
+	}
+	return removeFromIndex(stub, transactionIndexStr, ids...)
+}
+
+// ============================================================================================================================
+// check_indexes - report index entries without a record, records missing from their index, transactions no deal lists
+// and deals listing transactions that do not exist
+// ============================================================================================================================
+func (t *ManageDeals) check_indexes(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("start check_indexes")
+	ledger, err := readLedgerIndexes(stub)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "check_indexes", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to read deals and transactions: "+err.Error())
+	}
+	fmt.Println("end check_indexes")
+	return json.Marshal(checkIndexes(ledger))
+}
+
+// ============================================================================================================================
+// repair_indexes - make the indexes and the transaction lists of deals agree with the records. Index entries and listed
+// transactions without a record are dropped, records are indexed and a transaction no deal lists is linked to its deal.
+// A transaction whose deal no longer exists is kept and reported as orphaned, for its deal to be restored or the transaction
+// to be deleted by hand. The event of repair_indexes keeps what was found
+// ============================================================================================================================
+func (t *ManageDeals) repair_indexes(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("start repair_indexes")
+	ledger, err := readLedgerIndexes(stub)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "repair_indexes", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to read deals and transactions: "+err.Error())
+	}
+	report := checkIndexes(ledger)
+	if report.Consistent {
+		fmt.Println("end repair_indexes: indexes are consistent")
+		return json.Marshal(report)
+	}
+
+	changed := make(map[string]bool)
+	for _, id := range report.UnlinkedTransactions {
+		deal, ok := ledger.deals[ledger.transactions[id].DealID]
+		if !ok {
+			continue
+		}
+		deal.Transactions = strings.Join(append(transactionIds(deal), id), ",")
+		ledger.deals[deal.DealID] = deal
+		changed[deal.DealID] = true
+	}
+	for dealId := range report.MissingTransactions {
+		deal := ledger.deals[dealId]
+		kept := []string{}
+		for _, id := range transactionIds(deal) {
+			if _, ok := ledger.transactions[id]; ok {
+				kept = append(kept, id)
+			}
+		}
+		deal.Transactions = strings.Join(kept, ",")
+		ledger.deals[dealId] = deal
+		changed[dealId] = true
+	}
+	for dealId := range changed {
+		err = putDeal(stub, ledger.deals[dealId])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dealIndex := rebuildIndex(ledger.dealIndex, func(key string) bool {
+		_, ok := ledger.deals[key]
+		return ok
+	}, report.UnindexedDeals)
+	transactionIndex := rebuildIndex(ledger.transactionIndex, func(key string) bool {
+		_, ok := ledger.transactions[key]
+		return ok
+	}, report.UnindexedTransactions)
+	for indexStr, index := range map[string][]string{DealIndexStr: dealIndex, transactionIndexStr: transactionIndex} {
+		jsonAsBytes, _ := json.Marshal(index)
+		err = stub.PutState(indexStr, jsonAsBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	report.Repaired = true
+
+	message := "Indexes repaired, " + strconv.Itoa(len(changed)) + " deals relinked to their transactions"
+	if len(report.OrphanedTransactions) > 0 {
+		message += ", " + strconv.Itoa(len(report.OrphanedTransactions)) + " transactions without a deal kept"
+	}
+	err = chaincode.SendEvent(stub, "repair_indexes", chaincode.Entities{}, message, report)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end repair_indexes: " + message)
+	return json.Marshal(report)
+}
@@ -0,0 +1,298 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package deal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+// The last exposure submitted for a deal is stored under this prefix, apart from the deals and transactions keyed by their bare ids
+var exposureKeyPrefix = "_exposure-"
+
+// Service the exchange rates of a base currency are fetched from, as <ExchangeRateAPI>/latest?base=<currency>
+var ExchangeRateAPI = "http://api.fixer.io"
+
+// Allocation statuses of margin calls that are raised but not picked up by the allocation yet
+var openCallStatuses = []string{"Ready for Allocation", "Pending due to insufficient collateral"}
+
+// Securities is the position record kept by the Account chaincode; only the valuation is read here
+type Securities struct {
+	SecurityId       string `json:"securityId"`
+	AccountNumber    string `json:"accountNumber"`
+	SecurityQuantity string `json:"securityQuantity"`
+	CollateralForm   string `json:"collateralForm"`
+	TotalValue       string `json:"totalValue"`
+	Currency         string `json:"currency"`
+}
+
+type Exposures struct {
+	chaincode.Record
+	DealID              string `json:"dealId"`
+	Exposure            string `json:"exposure"`
+	Currency            string `json:"currency"`
+	CollateralValue     string `json:"collateralValue"`
+	CreditSupportAmount string `json:"creditSupportAmount"`
+	Delivery            string `json:"delivery"`      // signed, positive is a call and negative a return
+	RQV                 string `json:"rqv"`           // collateral value the segregated account has to hold after the call
+	TransactionID       string `json:"transactionId"` // empty when the delivery stayed under the minimum transfer amount
+	SubmittedDate       string `json:"submittedDate"`
+}
+
+// ============================================================================================================================
+// submit_exposure - compare the exposure of a deal with the valued collateral in its segregated account
+// and raise a margin call or return for the difference. The allocation rebalances the segregated account
+// to the RQV of a transaction, so the call carries the new required value and not the difference alone.
+// 'submittedBy' is only needed when the deal names a valuation agent
+// ============================================================================================================================
+func (t *ManageDeals) submit_exposure(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	if len(args) != 5 && len(args) != 6 {
+		return nil, chaincode.SendError(stub, "submit_exposure", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'dealId', 'exposure', 'currency', 'accountChaincode', 'segregatedAccount' and optionally 'submittedBy'")
+	}
+	fmt.Println("start submit_exposure")
+	_dealId := args[0]
+	_currency := args[2]
+	_accountChaincode := args[3]
+	_segregatedAccount := args[4]
+	exposure, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "submit_exposure", chaincode.ErrValidation, chaincode.Entities{DealID: _dealId}, "Exposure must be a number.")
+	}
+	deal := Deals{}
+	dealAsBytes, err := stub.GetState(_dealId)
+	if err != nil {
+		return nil, chaincode.SendError(stub, "submit_exposure", chaincode.ErrUpstream, chaincode.Entities{DealID: _dealId}, "Failed to get Deal "+_dealId)
+	}
+	json.Unmarshal(dealAsBytes, &deal)
+	if deal.DealID != _dealId {
+		return nil, chaincode.SendError(stub, "submit_exposure", chaincode.ErrNotFound, chaincode.Entities{DealID: _dealId}, _dealId+" Not Found.")
+	}
+	_submittedBy := ""
+	if len(args) == 6 {
+		_submittedBy = args[5]
+	}
+	if deal.ValuationAgent != "" && _submittedBy != deal.ValuationAgent {
+		return nil, chaincode.SendError(stub, "submit_exposure", chaincode.ErrValidation, chaincode.Entities{DealID: _dealId}, "Exposures of this deal are submitted by the valuation agent "+deal.ValuationAgent+".")
+	}
+	if _currency == "" {
+		_currency = deal.BaseCurrency
+	}
+	if deal.BaseCurrency != "" && _currency != deal.BaseCurrency {
+		return nil, chaincode.SendError(stub, "submit_exposure", chaincode.ErrValidation, chaincode.Entities{DealID: _dealId}, "Exposure must be in the base currency "+deal.BaseCurrency+" of the deal.")
+	}
+
+	// Valued collateral already held in the segregated account
+	queryArgs := chaincode.ToChaincodeArgs("getSecurities_byAccount", _segregatedAccount)
+	securitiesAsBytes, err := chaincode.InvokeChaincode(stub, _accountChaincode, queryArgs)
+	if err != nil {
+		return nil, chaincode.CalledError(stub, "submit_exposure", chaincode.Entities{AccountNumber: _segregatedAccount}, "Failed to get securities of "+_segregatedAccount, err)
+	}
+	var securities []Securities
+	json.Unmarshal(securitiesAsBytes, &securities)
+	// Positions are valued in their own currency, the exposure is in the base currency of the deal
+	var rates map[string]float64
+	collateralValue := 0.0
+	for _, security := range securities {
+		// collateral outside the eligible collateral schedule of the deal does not count towards the credit support
+		if !isEligibleForDeal(deal, security.CollateralForm) {
+			continue
+		}
+		value, _ := strconv.ParseFloat(security.TotalValue, 64)
+		if value != 0 && _currency != "" && security.Currency != "" && security.Currency != _currency {
+			if rates == nil {
+				rates, err = exchangeRates(_currency)
+				if err != nil {
+					return nil, chaincode.SendError(stub, "submit_exposure", chaincode.ErrUpstream, chaincode.Entities{DealID: _dealId}, "Unable to fetch Currency Exchange Rates of "+_currency+".")
+				}
+			}
+			if rates[security.Currency] <= 0 {
+				return nil, chaincode.SendError(stub, "submit_exposure", chaincode.ErrUpstream, chaincode.Entities{DealID: _dealId, AccountNumber: _segregatedAccount}, "No exchange rate from "+security.Currency+" to "+_currency+".")
+			}
+			value /= rates[security.Currency]
+		}
+		collateralValue += value
+	}
+
+	creditSupportAmount := creditSupport(deal, exposure)
+	delivery := roundDelivery(creditSupportAmount-collateralValue, deal.RoundingAmount, deal.RoundingConvention)
+	rqv := math.Max(collateralValue+delivery, 0)
+
+	now, err := chaincode.TxSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+	record := Exposures{
+		DealID:              _dealId,
+		Exposure:            strconv.FormatFloat(exposure, 'f', 2, 64),
+		Currency:            _currency,
+		CollateralValue:     strconv.FormatFloat(collateralValue, 'f', 2, 64),
+		CreditSupportAmount: strconv.FormatFloat(creditSupportAmount, 'f', 2, 64),
+		Delivery:            strconv.FormatFloat(delivery, 'f', 2, 64),
+		RQV:                 strconv.FormatFloat(rqv, 'f', 2, 64),
+		SubmittedDate:       strconv.FormatInt(now, 10),
+	}
+
+	minimumTransferAmount, _ := strconv.ParseFloat(deal.MinimumTransferAmount, 64)
+	if delivery != 0 && math.Abs(delivery) >= minimumTransferAmount {
+		direction := "Call"
+		if delivery < 0 {
+			direction = "Return"
+		}
+		// A call that has not been allocated yet is replaced by the new one
+		err = supersedeOpenCalls(stub, deal)
+		if err != nil {
+			return nil, err
+		}
+		// Keyed by the transaction, two exposures submitted within the same second raise two calls
+		record.TransactionID = _dealId + "-MC-" + stub.GetTxID()
+		_, err = t.create_transaction(stub, []string{record.TransactionID, strconv.FormatInt(now, 10), _dealId, deal.Pledger, deal.Pledgee, record.RQV, _currency, strconv.FormatInt(now, 10), "Matched", direction})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = chaincode.PutRecord(stub, exposureKey(_dealId), &record)
+	if err != nil {
+		return nil, err
+	}
+	err = chaincode.SendEvent(stub, "submit_exposure", chaincode.Entities{DealID: _dealId, TransactionID: record.TransactionID}, "Exposure submitted succcessfully", map[string]string{"delivery": record.Delivery})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end submit_exposure")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// getExposure_byDealID - get the last exposure submitted for a deal
+// ============================================================================================================================
+func (t *ManageDeals) getExposure_byDealID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	fmt.Println("start getExposure_byDealID")
+	if len(args) != 1 {
+		return nil, chaincode.SendError(stub, "getExposure_byDealID", chaincode.ErrValidation, chaincode.Entities{}, "Incorrect number of arguments. Expecting 'dealId' as an argument")
+	}
+	_dealId := args[0]
+	exposureAsBytes, err := stub.GetState(exposureKey(_dealId))
+	if err != nil {
+		return nil, chaincode.SendError(stub, "getExposure_byDealID", chaincode.ErrUpstream, chaincode.Entities{DealID: _dealId}, "Failed to get exposure of "+_dealId)
+	}
+	if exposureAsBytes == nil {
+		return nil, chaincode.SendError(stub, "getExposure_byDealID", chaincode.ErrNotFound, chaincode.Entities{DealID: _dealId}, "No exposure submitted.")
+	}
+	fmt.Println("end getExposure_byDealID")
+	return exposureAsBytes, nil
+}
+
+// supersedeOpenCalls marks the calls of a deal that are still waiting for allocation as superseded
+func supersedeOpenCalls(stub shim.ChaincodeStubInterface, deal Deals) error {
+	if strings.TrimSpace(deal.Transactions) == "" {
+		return nil
+	}
+	for _, transactionId := range strings.Split(deal.Transactions, ",") {
+		transactionAsBytes, err := stub.GetState(transactionId)
+		if err != nil {
+			return errors.New("Failed to get Transaction " + transactionId)
+		}
+		transaction := Transactions{}
+		json.Unmarshal(transactionAsBytes, &transaction)
+		for _, status := range openCallStatuses {
+			if transaction.AllocationStatus == status {
+				transaction.AllocationStatus = "Superseded"
+				err = chaincode.PutRecord(stub, transactionId, &transaction)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// creditSupport is the collateral value the pledger has to hold for an exposure of the pledgee:
+// the independent amount plus the exposure above the pledger threshold, less what the pledgee owes above its own threshold
+func creditSupport(deal Deals, exposure float64) float64 {
+	pledgerThreshold, _ := strconv.ParseFloat(deal.PledgerThreshold, 64)
+	pledgeeThreshold, _ := strconv.ParseFloat(deal.PledgeeThreshold, 64)
+	independentAmount, _ := strconv.ParseFloat(deal.IndependentAmount, 64)
+	owedByPledger := math.Max(exposure-pledgerThreshold, 0)
+	owedByPledgee := math.Max(-exposure-pledgeeThreshold, 0)
+	return math.Max(independentAmount+owedByPledger-owedByPledgee, 0)
+}
+
+// roundDelivery rounds a delivery to a multiple of the rounding amount, cents when none is set.
+// By default calls are rounded up and returns down, so the rounding never leaves the pledgee short
+func roundDelivery(delivery float64, roundingAmount string, convention string) float64 {
+	rounding, _ := strconv.ParseFloat(roundingAmount, 64)
+	if rounding <= 0 {
+		rounding = 0.01
+	}
+	// conventions round the amount moved, whichever way it goes
+	units := math.Abs(delivery) / rounding
+	switch convention {
+	case "Up":
+		units = math.Ceil(units - 1e-9)
+	case "Down":
+		units = math.Floor(units + 1e-9)
+	case "Nearest":
+		units = math.Floor(units + 0.5)
+	default:
+		if delivery > 0 {
+			units = math.Ceil(units - 1e-9)
+		} else {
+			units = math.Floor(units + 1e-9)
+		}
+	}
+	if delivery < 0 {
+		units = -units
+	}
+	return units * rounding
+}
+
+// exposureKey is the key the last exposure submitted for a deal is stored under
+func exposureKey(dealId string) string {
+	return exposureKeyPrefix + dealId
+}
+
+// exchangeRates fetches the units of each currency one unit of the base currency buys
+func exchangeRates(base string) (map[string]float64, error) {
+	resp, err := http.Get(ExchangeRateAPI + "/latest?base=" + base)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %s", ExchangeRateAPI, resp.Status)
+	}
+	var conversion struct {
+		Rates map[string]float64 `json:"rates"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&conversion)
+	return conversion.Rates, err
+}
@@ -0,0 +1,181 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package deal
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+	"github.com/mukutb/TCM/validation"
+)
+
+// Field is a named argument of a function, the schema of a function lists them in the order of its positional arguments
+type Field struct {
+	Name string
+	// Optional starts a group of fields that can be left out together with every field after it.
+	// Fields of a group that is given but not filled in take their Default
+	Optional bool
+	Default  string
+}
+
+// payloadArgs turns a single JSON object argument into the positional arguments of the function.
+// Any other call, and functions without a schema, keep their arguments as they are
+func payloadArgs(stub shim.ChaincodeStubInterface, function string, args []string) ([]string, error) {
+	fields, ok := schemas[function]
+	if !ok || len(args) != 1 || !strings.HasPrefix(strings.TrimSpace(args[0]), "{") {
+		return args, nil
+	}
+	payload := make(map[string]json.RawMessage)
+	if err := json.Unmarshal([]byte(args[0]), &payload); err != nil {
+		return nil, chaincode.SendError(stub, function, chaincode.ErrValidation, chaincode.Entities{}, "Payload is not a JSON object: "+err.Error())
+	}
+	known := make(map[string]bool)
+	for _, field := range fields {
+		known[field.Name] = true
+	}
+	unknown := []string{}
+	for name := range payload {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	v := validation.Validator{}
+
+	// Everything up to the first optional group is required, the groups are passed up to the last one given
+	required, given := len(fields), 0
+	for i, field := range fields {
+		if field.Optional && required == len(fields) {
+			required = i
+		}
+		if value, ok := payload[field.Name]; ok && string(value) != "null" {
+			given = i + 1
+		}
+	}
+	if given < required {
+		given = required
+	}
+	for given < len(fields) && given > required && !fields[given].Optional {
+		given++
+	}
+	positional := []string{}
+	for i, field := range fields[:given] {
+		value, ok := payload[field.Name]
+		if !ok || string(value) == "null" {
+			if i < required {
+				v.Add(field.Name, "is required")
+			}
+			positional = append(positional, field.Default)
+			continue
+		}
+		// Strings are passed unquoted, numbers, booleans, objects and arrays as their JSON text
+		var text string
+		if json.Unmarshal(value, &text) != nil {
+			text = string(value)
+		}
+		positional = append(positional, text)
+	}
+	for _, name := range unknown {
+		v.Add(name, "is not a field of "+function)
+	}
+	if err := v.Err(); err != nil {
+		return nil, chaincode.SendInvalid(stub, function, chaincode.Entities{}, err)
+	}
+	return positional, nil
+}
+
+// dealFields are the fields of create_deal and update_deal, the credit support annex terms are given all or none
+var dealFields = append([]Field{{Name: "dealId"}, {Name: "pledger"}, {Name: "pledgee"}, {Name: "maxValue"},
+	{Name: "totalValueLongBoxAccount"}, {Name: "totalValueSegregatedAccount"}, {Name: "issueDate"},
+	{Name: "lastSuccessfulAllocationDate"}, {Name: "transactions"},
+	{Name: "cashInterestRate", Optional: true, Default: "0"}, {Name: "incomeTreatment", Optional: true, Default: "PassThrough"}},
+	csaFields(true)...)
+
+// keepStored is the argument an update is given for an optional field its JSON object leaves out, the update keeps the
+// stored value of the field. A field given as an empty string is set empty
+const keepStored = "\x00keep"
+
+// dealUpdateFields are dealFields with the optional ones kept when they are left out
+var dealUpdateFields = keepingStored(dealFields)
+
+func keepingStored(fields []Field) []Field {
+	updated := append([]Field{}, fields...)
+	for i := range updated {
+		updated[i].Default = keepStored
+	}
+	return updated
+}
+
+// csaFields are the credit support annex terms in the order setCSATerms takes them.
+// Blank amounts default to zero, an update keeps the terms its JSON object leaves out
+func csaFields(optional bool) []Field {
+	return []Field{{Name: "pledgerThreshold", Optional: optional}, {Name: "pledgeeThreshold"}, {Name: "minimumTransferAmount"},
+		{Name: "independentAmount"}, {Name: "roundingAmount"}, {Name: "roundingConvention"}, {Name: "valuationAgent"},
+		{Name: "baseCurrency"}, {Name: "eligibleCollateral"}}
+}
+
+// schemas are the named arguments functions accept as a single JSON object
+var schemas = map[string][]Field{
+	"create_deal": dealFields,
+	"update_deal": dealUpdateFields,
+	"create_transaction": {{Name: "transactionId"}, {Name: "transactionDate"}, {Name: "dealId"}, {Name: "pledger"},
+		{Name: "pledgee"}, {Name: "rqv"}, {Name: "currency"}, {Name: "marginCAllDate"}, {Name: "transactionStatus"},
+		{Name: "direction", Optional: true, Default: "Call"}},
+	"update_transaction": {{Name: "transactionId"}, {Name: "transactionDate"}, {Name: "dealId"}, {Name: "pledger"},
+		{Name: "pledgee"}, {Name: "rqv"}, {Name: "currency"}, {Name: "currencyConversionRate"}, {Name: "marginCAllDate"},
+		{Name: "allocationStatus"}, {Name: "transactionStatus"}, {Name: "complianceStatus"}},
+	"update_transaction_AllocationStatus": {{Name: "transactionId"}, {Name: "allocationStatus"}},
+	"addTransaction_inDeal":               {{Name: "dealId"}, {Name: "transactionId"}},
+	"deleteTransactions":                  {{Name: "dealId"}},
+	"deleteDeal":                          {{Name: "dealId"}},
+	"repair_indexes":                      {},
+	"check_indexes":                       {},
+	"raise_dispute": {{Name: "disputeId"}, {Name: "transactionId"}, {Name: "raisedBy"}, {Name: "disputedAmount"},
+		{Name: "reason"}},
+	"add_dispute_step":     {{Name: "disputeId"}, {Name: "party"}, {Name: "action"}, {Name: "proposedAmount"}, {Name: "comment"}},
+	"resolve_dispute":      {{Name: "disputeId"}, {Name: "agreedAmount"}, {Name: "party"}},
+	"record_cash_posting":  {{Name: "dealId"}, {Name: "currency"}, {Name: "amount"}},
+	"accrue_cash_interest": {{Name: "dealId"}},
+	"update_csa_terms":     append([]Field{{Name: "dealId"}}, keepingStored(csaFields(true))...),
+	"set_calendar":         {{Name: "market"}, {Name: "holidays"}},
+	"update_deal_cutoff": {{Name: "dealId"}, {Name: "calendars"}, {Name: "cutoffTime"}, {Name: "cutoffTimezone"},
+		{Name: "settlementDays"}},
+	"submit_exposure": {{Name: "dealId"}, {Name: "exposure"}, {Name: "currency"}, {Name: "accountChaincode"},
+		{Name: "segregatedAccount"}, {Name: "submittedBy", Optional: true}},
+	"getDeal_byID":                          {{Name: "dealId"}},
+	"getDeal_byPledger":                     {{Name: "pledger"}},
+	"getDeal_byPledgee":                     {{Name: "pledgee"}},
+	"get_AllDeal":                           {},
+	"getTransaction_byID":                   {{Name: "transactionId"}},
+	"getTransactions_byDealID":              {{Name: "dealId"}},
+	"getTransactions_byUser":                {{Name: "user"}, {Name: "role"}},
+	"get_AllTransactions":                   {},
+	"getDispute_byID":                       {{Name: "disputeId"}},
+	"getDisputes_byDealID":                  {{Name: "dealId"}},
+	"getDisputes_byCounterparty":            {{Name: "counterparty"}},
+	"getCashCollateral_byDealID":            {{Name: "dealId"}},
+	"getExposure_byDealID":                  {{Name: "dealId"}},
+	"getCalendar_byMarket":                  {{Name: "market"}},
+	"getMarginCallDeadline_byTransactionID": {{Name: "transactionId"}},
+	"migrate_records":                       {},
+}
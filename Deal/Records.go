@@ -0,0 +1,71 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package deal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+// ============================================================================================================================
+// migrate_records - upgrade the deals, transactions, disputes and the records kept per deal in place to the canonical format
+// ============================================================================================================================
+func (t *ManageDeals) migrate_records(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("start migrate_records")
+	result := chaincode.NewMigrationResult()
+	indexes := []string{DealIndexStr, transactionIndexStr, disputeIndexStr}
+	records := []func() chaincode.Versioned{
+		func() chaincode.Versioned { return &Deals{} },
+		func() chaincode.Versioned { return &Transactions{} },
+		func() chaincode.Versioned { return &Disputes{} },
+	}
+	for i, indexStr := range indexes {
+		indexAsBytes, err := stub.GetState(indexStr)
+		if err != nil {
+			return nil, chaincode.SendError(stub, "migrate_records", chaincode.ErrUpstream, chaincode.Entities{}, "Failed to get "+indexStr)
+		}
+		var index []string
+		json.Unmarshal(indexAsBytes, &index)
+		for _, key := range index {
+			if indexStr == disputeIndexStr {
+				key = disputeKey(key)
+			}
+			chaincode.MigrateRecord(stub, key, records[i](), result)
+			if indexStr != DealIndexStr {
+				continue
+			}
+			chaincode.MigrateRecord(stub, cashCollateralKey(key), &CashCollateral{}, result)
+			chaincode.MigrateRecord(stub, exposureKey(key), &Exposures{}, result)
+			deal := Deals{}
+			dealAsBytes, _ := stub.GetState(key)
+			json.Unmarshal(dealAsBytes, &deal)
+			for _, market := range strings.Split(deal.Calendars, ",") {
+				if market = strings.TrimSpace(market); market != "" && !chaincode.IsMigrated(result, calendarPrefix+market) {
+					chaincode.MigrateRecord(stub, calendarPrefix+market, &Calendars{}, result)
+				}
+			}
+		}
+	}
+	return chaincode.MigrationDone(stub, result)
+}
@@ -0,0 +1,93 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package deal
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mukutb/TCM/validation"
+)
+
+// Ways income on pledged securities can be treated, "PassThrough" is the default
+var incomeTreatments = []string{"PassThrough", "Retain"}
+
+// Directions of a margin call, "Call" is the default
+var directions = []string{"Call", "Return"}
+
+// validateDeal checks the fields create_deal and update_deal take, the CSA terms are left to validateCSATerms.
+// An update keeps the cash interest rate and income treatment its JSON object leaves out, they are only checked when given
+func validateDeal(args []string, update bool) error {
+	v := validation.Validator{}
+	v.Required("dealId", args[0])
+	v.Required("pledger", args[1])
+	v.Required("pledgee", args[2])
+	v.NonNegative("maxValue", args[3])
+	v.Number("totalValueLongBoxAccount", args[4])
+	v.Number("totalValueSegregatedAccount", args[5])
+	v.Date("issueDate", args[6])
+	v.Date("lastSuccessfulAllocationDate", args[7])
+	if len(args) >= 10 && !(update && args[9] == keepStored) && v.Required("cashInterestRate", args[9]) {
+		v.Number("cashInterestRate", args[9])
+	}
+	if len(args) >= 11 && !(update && args[10] == keepStored) && v.Required("incomeTreatment", args[10]) {
+		v.OneOf("incomeTreatment", args[10], incomeTreatments)
+	}
+	return v.Err()
+}
+
+// validateTransaction checks the fields create_transaction takes
+func validateTransaction(args []string) error {
+	v := validation.Validator{}
+	validateTransactionFields(&v, args[:7])
+	v.Date("marginCAllDate", args[7])
+	if len(args) == 10 {
+		v.OneOf("direction", args[9], directions)
+	}
+	return v.Err()
+}
+
+// validateTransactionUpdate checks the fields update_transaction takes
+func validateTransactionUpdate(args []string) error {
+	v := validation.Validator{}
+	validateTransactionFields(&v, args[:7])
+	// Allocation records the exchange rates it converted with, as the JSON the rate service returned
+	if rate := strings.Trim(args[7], `"`); strings.HasPrefix(strings.TrimSpace(rate), "{") {
+		if !json.Valid([]byte(rate)) {
+			v.Add("currencyConversionRate", "must be a number or a JSON object of exchange rates")
+		}
+	} else {
+		v.NonNegative("currencyConversionRate", rate)
+	}
+	v.Date("marginCAllDate", args[8])
+	return v.Err()
+}
+
+// validateTransactionFields checks the fields create_transaction and update_transaction both start with
+func validateTransactionFields(v *validation.Validator, args []string) {
+	v.Required("transactionId", args[0])
+	v.Date("transactionDate", args[1])
+	v.Required("dealId", args[2])
+	v.Required("pledger", args[3])
+	v.Required("pledgee", args[4])
+	v.Required("rqv", args[5])
+	v.NonNegative("rqv", args[5])
+	v.Currency("currency", args[6])
+}
@@ -0,0 +1,188 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package batch runs the allocation of every transaction ready for allocation. The Allocation chaincode plans the run,
+// picking the transactions, ordering them by margin call date and resolving the accounts of their deals, and each planned
+// transaction is allocated with start_allocation in a transaction of its own: an allocation reads what the one before it
+// committed and one that fails rolls back alone while the run goes on. An optimised run first assigns the collateral of
+// each pledger to all of its open calls at once and reserves it, the allocations then follow that assignment
+package batch
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mukutb/TCM/Allocation"
+	"github.com/mukutb/TCM/chaincode"
+)
+
+// Allocation statuses a run counts, the other statuses are only reported by transaction
+const (
+	allocationSuccessful   = "Allocation Successful"
+	pendingSettlement      = "Pending settlement"
+	insufficientCollateral = "Pending due to insufficient collateral"
+)
+
+// Statuses of a run besides the allocation statuses
+const (
+	Failed  = "Failed"  // start_allocation failed, the transaction is still ready for allocation
+	Unknown = "Unknown" // allocated but its status could not be read
+)
+
+// Ledger calls the chaincodes of a channel by name: Invoke submits a transaction and waits for it to commit, Query only
+// evaluates it. A run does not read the payload of an invocation, it queries what it needs once the transaction committed
+type Ledger interface {
+	Invoke(chaincode string, args ...string) ([]byte, error)
+	Query(chaincode string, args ...string) ([]byte, error)
+}
+
+// Options are the chaincodes a run calls, the API the allocations fetch rules and prices from and the filters of the run
+type Options struct {
+	DealChaincode       string
+	AccountChaincode    string
+	AllocationChaincode string
+	APIIP               string
+	DealID              string // only transactions of this deal when set
+	Pledger             string // only transactions of this pledger when set
+	Optimise            bool   // reserve the collateral of each pledger for its open calls together before allocating
+}
+
+// TransactionRun is the outcome of allocating a planned transaction
+type TransactionRun struct {
+	allocation.PlannedAllocation
+	Status string `json:"status"`          // allocation status the transaction ended in, Failed when start_allocation failed
+	Error  string `json:"error,omitempty"` // why start_allocation failed
+}
+
+// RunSummary is the outcome of a run, the transactions in the order they were allocated
+type RunSummary struct {
+	DealID              string                          `json:"dealId,omitempty"`
+	Pledger             string                          `json:"pledger,omitempty"`
+	Selected            int                             `json:"selected"`  // transactions ready for allocation
+	Allocated           int                             `json:"allocated"` // moved to the segregated account, settled or waiting for settlement
+	Pending             int                             `json:"pending"`   // not enough eligible collateral
+	Failed              int                             `json:"failed"`
+	Skipped             int                             `json:"skipped"`  // deal or accounts could not be resolved
+	Statuses            map[string]int                  `json:"statuses"` // transactions by the status they ended in
+	Transactions        []TransactionRun                `json:"transactions"`
+	SkippedTransactions []allocation.SkippedAllocation  `json:"skippedTransactions"`
+	Optimisations       []allocation.OptimisationResult `json:"optimisations,omitempty"` // collateral reserved for the calls of each pledger
+}
+
+// Add counts the outcome of a transaction
+func (s *RunSummary) Add(run TransactionRun) {
+	switch run.Status {
+	case allocationSuccessful, pendingSettlement:
+		s.Allocated++
+	case insufficientCollateral:
+		s.Pending++
+	case Failed:
+		s.Failed++
+	}
+	s.Statuses[run.Status]++
+	s.Transactions = append(s.Transactions, run)
+}
+
+// Plan asks the Allocation chaincode for the transactions a run allocates
+func Plan(ledger Ledger, options Options) (allocation.AllocationPlan, error) {
+	plan := allocation.AllocationPlan{}
+	planAsBytes, err := ledger.Query(options.AllocationChaincode, "plan_allocations",
+		options.DealChaincode, options.AccountChaincode, options.DealID, options.Pledger)
+	if err != nil {
+		return plan, err
+	}
+	if err = json.Unmarshal(planAsBytes, &plan); err != nil {
+		return plan, fmt.Errorf("plan of the allocations is not readable: %v", err)
+	}
+	return plan, nil
+}
+
+// optimise assigns the collateral of each pledger of a plan to all of its open calls and reserves it, in a transaction
+// of its own for each pledger
+func optimise(ledger Ledger, options Options, plan allocation.AllocationPlan) ([]allocation.OptimisationResult, error) {
+	var results []allocation.OptimisationResult
+	optimised := make(map[string]bool)
+	for _, planned := range plan.Planned {
+		if optimised[planned.Pledger] {
+			continue
+		}
+		optimised[planned.Pledger] = true
+		resultId, err := chaincode.NewResultID()
+		if err == nil {
+			_, err = ledger.Invoke(options.AllocationChaincode, "optimise_allocations",
+				options.DealChaincode, options.AccountChaincode, options.APIIP, planned.Pledger, "true", resultId)
+		}
+		if err != nil {
+			return results, fmt.Errorf("optimising the calls of %s failed: %v", planned.Pledger, err)
+		}
+		resultAsBytes, err := ledger.Query(options.AllocationChaincode, "getResult_byID", resultId)
+		if err != nil {
+			return results, fmt.Errorf("optimisation of the calls of %s is not readable: %v", planned.Pledger, err)
+		}
+		var result allocation.OptimisationResult
+		if err = json.Unmarshal(resultAsBytes, &result); err != nil {
+			return results, fmt.Errorf("optimisation of the calls of %s is not readable: %v", planned.Pledger, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Run allocates the planned transactions one after the other. Only a failing plan or optimisation stops it, a failing
+// allocation is reported and the next one is allocated
+func Run(ledger Ledger, options Options) (RunSummary, error) {
+	summary := RunSummary{DealID: options.DealID, Pledger: options.Pledger, Statuses: map[string]int{},
+		Transactions: []TransactionRun{}}
+	plan, err := Plan(ledger, options)
+	if err != nil {
+		return summary, err
+	}
+	summary.Selected = len(plan.Planned) + len(plan.Skipped)
+	summary.Skipped = len(plan.Skipped)
+	summary.SkippedTransactions = plan.Skipped
+	if options.Optimise {
+		if summary.Optimisations, err = optimise(ledger, options, plan); err != nil {
+			return summary, err
+		}
+	}
+	for _, planned := range plan.Planned {
+		run := TransactionRun{PlannedAllocation: planned}
+		args := planned.Args(options.DealChaincode, options.AccountChaincode, options.APIIP)
+		if _, err := ledger.Invoke(options.AllocationChaincode, args...); err != nil {
+			run.Status, run.Error = Failed, err.Error()
+		} else {
+			run.Status, run.Error = status(ledger, options.DealChaincode, planned.TransactionID)
+		}
+		summary.Add(run)
+	}
+	return summary, nil
+}
+
+// status is the allocation status of a transaction after its allocation, the error when it cannot be read
+func status(ledger Ledger, dealChaincode string, transactionId string) (string, string) {
+	transactionAsBytes, err := ledger.Query(dealChaincode, "getTransaction_byID", transactionId)
+	if err != nil {
+		return Unknown, "allocated but the status is not readable: " + err.Error()
+	}
+	var transaction allocation.Transactions
+	if err = json.Unmarshal(transactionAsBytes, &transaction); err != nil {
+		return Unknown, "allocated but the status is not readable: " + err.Error()
+	}
+	return transaction.AllocationStatus, ""
+}
@@ -0,0 +1,117 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/mukutb/TCM/batch"
+)
+
+// ============================================================================================================================
+// Main - allocate every transaction ready for allocation, the earliest margin call first, and print the run summary as JSON.
+//
+//	batch -invoke "peer chaincode invoke -C <channel> --waitForEvent ..." -query "peer chaincode query -C <channel> ..." \
+//	      -api <host:port> [-deal <dealId>] [-pledger <pledger>] [-plan]
+//
+// The chaincodes are called with -n and their names, -c carries the arguments. With -plan the transactions the run
+// would allocate are printed and nothing is allocated. The exit status is 1 when an allocation failed
+// ============================================================================================================================
+func main() {
+	options := batch.Options{}
+	flag.StringVar(&options.DealChaincode, "deal-chaincode", "Deal", "name of the Deal chaincode")
+	flag.StringVar(&options.AccountChaincode, "account-chaincode", "Account", "name of the Account chaincode")
+	flag.StringVar(&options.AllocationChaincode, "allocation-chaincode", "Allocation", "name of the Allocation chaincode")
+	flag.StringVar(&options.APIIP, "api", "", "host of the API the allocations fetch rulesets and market rates from")
+	flag.StringVar(&options.DealID, "deal", "", "only allocate transactions of this deal")
+	flag.StringVar(&options.Pledger, "pledger", "", "only allocate transactions of this pledger")
+	invoke := flag.String("invoke", "", "command that invokes a chaincode and waits for the transaction to commit")
+	query := flag.String("query", "", "command that queries a chaincode")
+	flag.BoolVar(&options.Optimise, "optimise", false, "reserve the collateral of each pledger for all of its open calls together before allocating")
+	planOnly := flag.Bool("plan", false, "print the transactions the run would allocate without allocating them")
+	flag.Parse()
+	if *query == "" || (!*planOnly && (*invoke == "" || options.APIIP == "")) || flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	ledger := peer{invoke: strings.Fields(*invoke), query: strings.Fields(*query)}
+
+	if *planOnly {
+		plan, err := batch.Plan(ledger, options)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		planAsBytes, _ := json.MarshalIndent(plan, "", "  ")
+		fmt.Println(string(planAsBytes))
+		return
+	}
+	summary, err := batch.Run(ledger, options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	fmt.Fprintf(os.Stderr, "%d selected: %d allocated, %d pending, %d failed, %d skipped\n",
+		summary.Selected, summary.Allocated, summary.Pending, summary.Failed, summary.Skipped)
+	summaryAsBytes, _ := json.MarshalIndent(summary, "", "  ")
+	fmt.Println(string(summaryAsBytes))
+	if summary.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// peer calls the chaincodes through the peer command line
+type peer struct {
+	invoke []string
+	query  []string
+}
+
+// Invoke runs the invoke command, what it prints is only reported when it fails. Peer logs the payload rather than
+// printing it, a run queries the results it needs
+func (p peer) Invoke(chaincode string, args ...string) ([]byte, error) {
+	output, err := command(p.invoke, chaincode, args).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil, nil
+}
+
+// Query returns what the query command prints on its standard output, the payload as it is
+func (p peer) Query(chaincode string, args ...string) ([]byte, error) {
+	cmd := command(p.query, chaincode, args)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return []byte(strings.TrimSpace(string(output))), nil
+}
+
+// command calls a chaincode by name with the peer command, the arguments are passed with -c
+func command(peer []string, chaincode string, args []string) *exec.Cmd {
+	ctorAsBytes, _ := json.Marshal(map[string][]string{"Args": args})
+	return exec.Command(peer[0], append(peer[1:], "-n", chaincode, "-c", string(ctorAsBytes))...)
+}
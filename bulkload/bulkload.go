@@ -0,0 +1,213 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package bulkload reads the accounts and holdings of an onboarding file, checks them offline the way the Account
+// chaincode does and loads them with bulk_load in chunks. A file is CSV with a header row naming the fields of
+// create_account or add_security, or JSON Lines with an object of those fields per line. A "record" field says whether
+// a row is an account or a security, rows without it are securities when they have a securityId
+package bulkload
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mukutb/TCM/Account"
+	"github.com/mukutb/TCM/chaincode"
+	"github.com/mukutb/TCM/validation"
+)
+
+// Invoker calls a function of the Account chaincode with args and returns its payload. Load submits bulk_load with an
+// invoking one, waiting for the transaction to commit, and reads its result back with getResult_byID with a querying one
+type Invoker func(args ...string) ([]byte, error)
+
+// Read reads the rows of a .csv or a .jsonl file
+func Read(path string) ([]account.BulkRow, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return ReadCSV(file)
+	case ".jsonl", ".ndjson":
+		return ReadJSONLines(file)
+	}
+	return nil, errors.New(path + " is neither .csv nor .jsonl")
+}
+
+// ReadCSV reads rows from CSV with a header row, each row is numbered by the line it starts on
+func ReadCSV(r io.Reader) ([]account.BulkRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %v", err)
+	}
+	rows := []account.BulkRow{}
+	for {
+		values, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		fields := make(map[string]string)
+		for i, name := range header {
+			if i < len(values) {
+				fields[strings.TrimSpace(name)] = values[i]
+			}
+		}
+		rows = append(rows, newRow(line, fields))
+	}
+}
+
+// ReadJSONLines reads a row from each line that is not blank, numbers and booleans are taken as their JSON text
+func ReadJSONLines(r io.Reader) ([]account.BulkRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	rows := []account.BulkRow{}
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		object := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(text, &object); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		fields := make(map[string]string)
+		for name, value := range object {
+			var text string
+			if json.Unmarshal(value, &text) != nil {
+				text = string(value)
+			}
+			fields[name] = text
+		}
+		rows = append(rows, newRow(line, fields))
+	}
+	return rows, scanner.Err()
+}
+
+func newRow(line int, fields map[string]string) account.BulkRow {
+	record := strings.ToLower(strings.TrimSpace(fields["record"]))
+	delete(fields, "record")
+	if record == "" {
+		record = account.BulkAccount
+		if strings.TrimSpace(fields["securityId"]) != "" {
+			record = account.BulkSecurity
+		}
+	}
+	return account.BulkRow{Row: line, Record: record, Fields: fields}
+}
+
+// Check validates rows offline, it returns the rows that are valid and the outcome of those that are not
+func Check(rows []account.BulkRow) ([]account.BulkRow, account.BulkLoadResult) {
+	valid := []account.BulkRow{}
+	result := account.BulkLoadResult{Rows: []account.BulkRowResult{}}
+	for _, row := range rows {
+		err := row.Validate()
+		if err == nil {
+			valid = append(valid, row)
+			continue
+		}
+		result.Add(failed(row, err))
+	}
+	return valid, result
+}
+
+// Chunks splits rows into chunks of at most size rows, accounts before securities so no holding is loaded before its account
+func Chunks(rows []account.BulkRow, size int) [][]account.BulkRow {
+	ordered := append([]account.BulkRow{}, rows...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Record == account.BulkAccount && ordered[j].Record != account.BulkAccount
+	})
+	chunks := [][]account.BulkRow{}
+	for size > 0 && len(ordered) > 0 {
+		n := size
+		if n > len(ordered) {
+			n = len(ordered)
+		}
+		chunks = append(chunks, ordered[:n])
+		ordered = ordered[n:]
+	}
+	return chunks
+}
+
+// Load checks rows and loads the valid ones with bulk_load in chunks of size rows, one invocation per chunk.
+// Every row of a chunk whose invocation fails is reported failed, the other chunks are still loaded.
+// The outcomes are in the order of the rows
+func Load(rows []account.BulkRow, size int, invoke Invoker, query Invoker) account.BulkLoadResult {
+	valid, result := Check(rows)
+	for _, chunk := range Chunks(valid, size) {
+		payload, err := loadChunk(chunk, invoke, query)
+		loaded := account.BulkLoadResult{}
+		if err == nil {
+			err = json.Unmarshal(payload, &loaded)
+		}
+		if err == nil && len(loaded.Rows) != len(chunk) {
+			err = fmt.Errorf("bulk_load reported %d rows of %d", len(loaded.Rows), len(chunk))
+		}
+		if err != nil {
+			for _, row := range chunk {
+				result.Add(failed(row, err))
+			}
+			continue
+		}
+		for _, row := range loaded.Rows {
+			result.Add(row)
+		}
+	}
+	sort.SliceStable(result.Rows, func(i, j int) bool {
+		return result.Rows[i].Row < result.Rows[j].Row
+	})
+	return result
+}
+
+// loadChunk invokes bulk_load with a chunk under a new result id and queries the result kept under it
+func loadChunk(chunk []account.BulkRow, invoke Invoker, query Invoker) ([]byte, error) {
+	resultId, err := chaincode.NewResultID()
+	if err != nil {
+		return nil, err
+	}
+	rowsAsBytes, _ := json.Marshal(chunk)
+	if _, err := invoke("bulk_load", string(rowsAsBytes), resultId); err != nil {
+		return nil, err
+	}
+	return query("getResult_byID", resultId)
+}
+
+func failed(row account.BulkRow, err error) account.BulkRowResult {
+	outcome := account.BulkRowResult{Row: row.Row, Record: row.Record, Key: row.Key(), Status: account.BulkFailed, Error: err.Error()}
+	if fields, ok := err.(validation.Errors); ok {
+		outcome.Fields = fields
+	}
+	return outcome
+}
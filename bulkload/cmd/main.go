@@ -0,0 +1,110 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/mukutb/TCM/Account"
+	"github.com/mukutb/TCM/bulkload"
+)
+
+// ============================================================================================================================
+// Main - check onboarding files of accounts and holdings and, with -peer, load them into the Account chaincode.
+//
+//	bulkload [-chunk 100] [-peer "peer chaincode invoke -C <channel> -n <Account chaincode> --waitForEvent ..." \
+//	         -query "peer chaincode query -C <channel> -n <Account chaincode> ..."] file...
+//
+// Without -peer the files are only checked. The result of each bulk_load is queried with getResult_byID once it committed. The outcome of every row is printed as JSON by file, the exit status is 1 when a row failed
+// ============================================================================================================================
+func main() {
+	chunk := flag.Int("chunk", 100, "rows per bulk_load invocation")
+	peer := flag.String("peer", "", "command that invokes the Account chaincode and waits for the transaction to commit, the arguments of bulk_load are passed to it with -c")
+	query := flag.String("query", "", "command that queries the Account chaincode, required with -peer")
+	flag.Parse()
+	if flag.NArg() == 0 || *chunk <= 0 || (*peer != "") != (*query != "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Files are loaded one after the other, so holdings can be in a file after the file of their accounts
+	results := make(map[string]account.BulkLoadResult)
+	failed := false
+	for _, path := range flag.Args() {
+		rows, err := bulkload.Read(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		var result account.BulkLoadResult
+		if *peer == "" {
+			var valid []account.BulkRow
+			valid, result = bulkload.Check(rows)
+			fmt.Fprintf(os.Stderr, "%s: %d rows valid, %d failed\n", path, len(valid), result.Failed)
+		} else {
+			result = bulkload.Load(rows, *chunk, peerInvoker(strings.Fields(*peer)), peerQuerier(strings.Fields(*query)))
+			fmt.Fprintf(os.Stderr, "%s: %d created, %d updated, %d unchanged, %d failed\n", path,
+				result.Created, result.Updated, result.Unchanged, result.Failed)
+		}
+		results[path] = result
+		failed = failed || result.Failed > 0
+	}
+	resultsAsBytes, _ := json.MarshalIndent(results, "", "  ")
+	fmt.Println(string(resultsAsBytes))
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// peerInvoker runs the invoke command once per invocation, what it prints is only reported when it fails
+func peerInvoker(peer []string) bulkload.Invoker {
+	return func(args ...string) ([]byte, error) {
+		output, err := command(peer, args).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+		}
+		return nil, nil
+	}
+}
+
+// peerQuerier runs the query command and returns what it prints on its standard output, the payload as it is
+func peerQuerier(peer []string) bulkload.Invoker {
+	return func(args ...string) ([]byte, error) {
+		cmd := command(peer, args)
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return []byte(strings.TrimSpace(string(output))), nil
+	}
+}
+
+// command calls the Account chaincode with the peer command, the arguments are passed with -c
+func command(peer []string, args []string) *exec.Cmd {
+	ctorAsBytes, _ := json.Marshal(map[string][]string{"Args": args})
+	return exec.Command(peer[0], append(peer[1:], "-c", string(ctorAsBytes))...)
+}
@@ -0,0 +1,87 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Function is a function a chaincode can be invoked with, it gets the arguments that follow the function name
+type Function func(stub shim.ChaincodeStubInterface, args []string) ([]byte, error)
+
+// ParseArgs turns the arguments a function was invoked with into its positional arguments
+type ParseArgs func(stub shim.ChaincodeStubInterface, function string, args []string) ([]string, error)
+
+// Route calls the function an invocation names, as the contract API routes a transaction to the method of its contract.
+// The current shim sends queries through Invoke as well, so functions holds every function of the chaincode
+func Route(stub shim.ChaincodeStubInterface, parse ParseArgs, functions map[string]Function) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	fmt.Println("invoke is running " + function)
+	call, ok := functions[function]
+	if !ok {
+		fmt.Println("invoke did not find func: " + function)
+		return Respond(nil, SendError(stub, function, ErrValidation, Entities{}, "Received unknown function invocation"))
+	}
+	args, err := parse(stub, function, args)
+	if err != nil {
+		return Respond(nil, err)
+	}
+	return Respond(call(stub, args))
+}
+
+// Respond turns what a function returned into the response of the peer, a failure carries the errEvent payload as its message
+func Respond(payload []byte, err error) pb.Response {
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
+
+// ToChaincodeArgs turns a function name and its arguments into the arguments of InvokeChaincode
+func ToChaincodeArgs(args ...string) [][]byte {
+	chaincodeArgs := make([][]byte, len(args))
+	for i, arg := range args {
+		chaincodeArgs[i] = []byte(arg)
+	}
+	return chaincodeArgs
+}
+
+// InvokeChaincode calls a function of another chaincode on the channel of this transaction and returns its payload.
+// Reads and writes of the called function are part of this transaction, a failure returns the message of the called chaincode
+func InvokeChaincode(stub shim.ChaincodeStubInterface, chaincodeName string, args [][]byte) ([]byte, error) {
+	response := stub.InvokeChaincode(chaincodeName, args, stub.GetChannelID())
+	if response.Status != shim.OK {
+		return nil, errors.New(response.Message)
+	}
+	return response.Payload, nil
+}
+
+// TxSeconds is the time of the transaction in unix seconds, every peer endorsing it sees the same one
+func TxSeconds(stub shim.ChaincodeStubInterface) (int64, error) {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return 0, err
+	}
+	return txTimestamp.Seconds, nil
+}
@@ -0,0 +1,157 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package chaincode holds what the Account, Deal and Allocation chaincodes share: the events they send,
+// the errors they fail with, how they store their records and how an invocation is routed to a function.
+//
+// Every function of the chaincodes sends an evtsender event when it succeeds and an errEvent when it fails,
+// with the same Event payload, so listeners read the events of all chaincodes the same way.
+package chaincode
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/validation"
+)
+
+// Version of the events on evtsender and errEvent, listeners should check it before reading the rest
+var eventSchemaVersion = "1.0"
+
+// ErrorCode is the kind of failure an errEvent reports. Status is what listeners get as "code"
+type ErrorCode struct {
+	Name   string
+	Status string
+}
+
+// Failures an errEvent can report
+var (
+	ErrValidation = ErrorCode{"VALIDATION", "400"}           // arguments missing or malformed
+	ErrNotFound   = ErrorCode{"NOT_FOUND", "404"}            // an entity the function needs does not exist
+	ErrConflict   = ErrorCode{"CONFLICT", "409"}             // the entity exists but is in a state that does not allow the function
+	ErrUpstream   = ErrorCode{"UPSTREAM_UNAVAILABLE", "503"} // the ledger, another chaincode or an external API failed
+	ErrInvariant  = ErrorCode{"INVARIANT_VIOLATION", "500"}  // the function would leave the ledger breaking an invariant
+)
+
+// Entities are the ids an event is about
+type Entities struct {
+	DealID        string `json:"dealId,omitempty"`
+	TransactionID string `json:"transactionId,omitempty"`
+	AccountNumber string `json:"accountNumber,omitempty"`
+	SecurityID    string `json:"securityId,omitempty"`
+	MovementID    string `json:"movementId,omitempty"`
+	DisputeID     string `json:"disputeId,omitempty"`
+	EventID       string `json:"eventId,omitempty"`
+	Market        string `json:"market,omitempty"`
+}
+
+// Event is the payload of every evtsender and errEvent event.
+// Type is the function that sent it and CorrelationID the transaction that invoked the function
+type Event struct {
+	Type          string            `json:"type"`
+	SchemaVersion string            `json:"schemaVersion"`
+	Code          string            `json:"code"`
+	ErrorCode     string            `json:"errorCode,omitempty"`
+	Message       string            `json:"message"`
+	Entities      Entities          `json:"entities"`
+	Fields        validation.Errors `json:"fields,omitempty"` // what is wrong with each field when the arguments failed validation
+	CorrelationID string            `json:"correlationId"`
+	Data          interface{}       `json:"data,omitempty"`
+}
+
+// SendEvent sends an evtsender event for a function that succeeded, data is anything the function returns besides the ids
+func SendEvent(stub shim.ChaincodeStubInterface, eventType string, entities Entities, message string, data interface{}) error {
+	return setEvent(stub, "evtsender", Event{Type: eventType, Code: "200", Message: message, Entities: entities, Data: data})
+}
+
+// ChaincodeError is the error of a function that failed, its message is the errEvent event so clients get the same payload either way
+type ChaincodeError struct {
+	Event Event
+}
+
+func (e *ChaincodeError) Error() string {
+	eventAsBytes, _ := json.Marshal(e.Event)
+	return string(eventAsBytes)
+}
+
+// SendError sends an errEvent event for a function that failed and returns the failure as the error the function returns
+func SendError(stub shim.ChaincodeStubInterface, eventType string, code ErrorCode, entities Entities, message string) error {
+	return Fail(stub, Event{Type: eventType, Code: code.Status, ErrorCode: code.Name, Message: message, Entities: entities})
+}
+
+// SendInvalid sends the errEvent of a function whose arguments failed validation, listing what is wrong with each field
+func SendInvalid(stub shim.ChaincodeStubInterface, eventType string, entities Entities, err error) error {
+	event := Event{Type: eventType, Code: ErrValidation.Status, ErrorCode: ErrValidation.Name, Message: "Invalid arguments: " + err.Error(), Entities: entities}
+	if fields, ok := err.(validation.Errors); ok {
+		event.Fields = fields
+	}
+	return Fail(stub, event)
+}
+
+// Fail sends an errEvent of a function that failed with a payload of its own and returns it as the error of the function
+func Fail(stub shim.ChaincodeStubInterface, event Event) error {
+	event = stamp(stub, event)
+	setEvent(stub, "errEvent", event)
+	return &ChaincodeError{event}
+}
+
+// CalledError is the error of a function because a function of another chaincode it called failed.
+// The code of the called function is kept, so a missing deal is not reported as an unavailable chaincode
+func CalledError(stub shim.ChaincodeStubInterface, eventType string, entities Entities, message string, err error) error {
+	if called, ok := calledEvent(err); ok {
+		event := Event{Type: eventType, Code: called.Code, ErrorCode: called.ErrorCode, Message: message + ": " + called.Message, Entities: entities, Fields: called.Fields}
+		return Fail(stub, event)
+	}
+	return SendError(stub, eventType, ErrUpstream, entities, message+": "+err.Error())
+}
+
+// calledEvent reads the errEvent payload out of the error of a called function, the shim may have put text before it
+func calledEvent(err error) (Event, bool) {
+	called := Event{}
+	message := err.Error()
+	if start := strings.Index(message, "{"); start >= 0 {
+		json.Unmarshal([]byte(message[start:]), &called)
+	}
+	return called, called.ErrorCode != ""
+}
+
+// IsErrorCode tells whether a function, of this chaincode or a called one, failed with the code
+func IsErrorCode(err error, code ErrorCode) bool {
+	if err == nil {
+		return false
+	}
+	called, ok := calledEvent(err)
+	return ok && called.ErrorCode == code.Name
+}
+
+func setEvent(stub shim.ChaincodeStubInterface, name string, event Event) error {
+	eventAsBytes, err := json.Marshal(stamp(stub, event))
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(name, eventAsBytes)
+}
+
+// stamp sets the schema version and correlation id of an event
+func stamp(stub shim.ChaincodeStubInterface, event Event) Event {
+	event.SchemaVersion = eventSchemaVersion
+	event.CorrelationID = stub.GetTxID()
+	return event
+}
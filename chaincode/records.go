@@ -0,0 +1,210 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Version of the records the chaincodes write. Records without one were concatenated by hand before records had versions
+var recordSchemaVersion = "2"
+
+// legacyFields are names fields were written with before records were serialised from structs, with their canonical names
+var legacyFields = map[string]string{
+	"totalvalue":            "totalValue",
+	"effectiveValueChanged": "effectiveValueinUSD",
+}
+
+// Record is embedded in every struct stored on the ledger
+type Record struct {
+	SchemaVersion string `json:"schemaVersion"`
+}
+
+func (r *Record) setSchemaVersion() {
+	r.SchemaVersion = recordSchemaVersion
+}
+
+// Versioned is a pointer to a struct that embeds Record
+type Versioned interface {
+	setSchemaVersion()
+}
+
+// PutRecord stores a record under key, serialised from its struct and stamped with the schema version
+func PutRecord(stub shim.ChaincodeStubInterface, key string, record Versioned) error {
+	record.setSchemaVersion()
+	recordAsBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, recordAsBytes)
+}
+
+// MigrationResult is what migrate_records did with each key it looked at
+type MigrationResult struct {
+	Migrated []string          `json:"migrated"`
+	Current  []string          `json:"current"` // already at recordSchemaVersion
+	Failed   map[string]string `json:"failed"`  // why the record could not be read
+}
+
+// NewMigrationResult is the result of a migration that has not looked at any key yet
+func NewMigrationResult() *MigrationResult {
+	return &MigrationResult{Migrated: []string{}, Current: []string{}, Failed: make(map[string]string)}
+}
+
+// MigrateRecord upgrades the record stored under key in place to the canonical format.
+// record is a pointer to an empty struct of the kind stored there, keys that hold nothing are skipped
+func MigrateRecord(stub shim.ChaincodeStubInterface, key string, record Versioned, result *MigrationResult) {
+	recordAsBytes, err := stub.GetState(key)
+	if err != nil {
+		result.Failed[key] = err.Error()
+		return
+	}
+	if len(recordAsBytes) == 0 {
+		return
+	}
+	version := Record{}
+	if json.Unmarshal(recordAsBytes, &version) == nil && version.SchemaVersion == recordSchemaVersion {
+		result.Current = append(result.Current, key)
+		return
+	}
+	fields := make(map[string]interface{})
+	if json.Unmarshal(recordAsBytes, &fields) != nil {
+		// A value with a quote in it broke the hand written JSON, read it back by its field names
+		fields, err = repairLegacyRecord(recordAsBytes, record)
+		if err != nil {
+			result.Failed[key] = err.Error()
+			return
+		}
+	}
+	canonicalAsBytes, _ := json.Marshal(canonicalFields(fields))
+	if err = json.Unmarshal(canonicalAsBytes, record); err != nil {
+		result.Failed[key] = err.Error()
+		return
+	}
+	if err = PutRecord(stub, key, record); err != nil {
+		result.Failed[key] = err.Error()
+		return
+	}
+	result.Migrated = append(result.Migrated, key)
+}
+
+// canonicalFields renames the legacy fields of a record, at any depth
+func canonicalFields(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		fields := make(map[string]interface{})
+		for name, field := range value {
+			if canonical, ok := legacyFields[name]; ok {
+				name = canonical
+			}
+			fields[name] = canonicalFields(field)
+		}
+		return fields
+	case []interface{}:
+		for i := range value {
+			value[i] = canonicalFields(value[i])
+		}
+	}
+	return value
+}
+
+// repairLegacyRecord reads a flat record written by hand as `{"name": "value" , ...}` whose values were not escaped.
+// Each value runs from its field name to the next field name the struct or legacyFields knows
+func repairLegacyRecord(recordAsBytes []byte, record Versioned) (map[string]interface{}, error) {
+	text := strings.TrimSpace(string(recordAsBytes))
+	if !strings.HasPrefix(text, "{") || !strings.HasSuffix(text, "}") {
+		return nil, errors.New("record is not a JSON object")
+	}
+	text = text[1 : len(text)-1]
+	names := []string{}
+	for name := range legacyFields {
+		names = append(names, name)
+	}
+	recordType := reflect.TypeOf(record).Elem()
+	for i := 0; i < recordType.NumField(); i++ {
+		if name := strings.Split(recordType.Field(i).Tag.Get("json"), ",")[0]; name != "" {
+			names = append(names, name)
+		}
+	}
+	type found struct {
+		name         string
+		start, value int
+	}
+	starts := []found{}
+	for _, name := range names {
+		opener := `"` + name + `":`
+		if start := strings.Index(text, opener); start >= 0 {
+			starts = append(starts, found{name, start, start + len(opener)})
+		}
+	}
+	if len(starts) == 0 {
+		return nil, errors.New("record has none of the fields of its kind")
+	}
+	fields := make(map[string]interface{})
+	for _, field := range starts {
+		end := len(text)
+		for _, next := range starts {
+			if next.start > field.start && next.start < end {
+				end = next.start
+			}
+		}
+		value := strings.TrimSpace(text[field.value:end])
+		value = strings.TrimSpace(strings.TrimSuffix(value, ","))
+		if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			value = value[1 : len(value)-1]
+		}
+		fields[field.name] = value
+	}
+	return fields, nil
+}
+
+// IsMigrated tells whether migrate_records already looked at a key kept by several records, such as a calendar
+func IsMigrated(result *MigrationResult, key string) bool {
+	for _, keys := range [][]string{result.Migrated, result.Current} {
+		for _, done := range keys {
+			if done == key {
+				return true
+			}
+		}
+	}
+	_, failed := result.Failed[key]
+	return failed
+}
+
+// MigrationDone sends the event of migrate_records and returns what it did
+func MigrationDone(stub shim.ChaincodeStubInterface, result *MigrationResult) ([]byte, error) {
+	message := strconv.Itoa(len(result.Migrated)) + " records migrated to schema version " + recordSchemaVersion
+	if len(result.Failed) > 0 {
+		message += ", " + strconv.Itoa(len(result.Failed)) + " could not be read"
+	}
+	err := SendEvent(stub, "migrate_records", Entities{}, message, result)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end migrate_records: " + message)
+	return json.Marshal(result)
+}
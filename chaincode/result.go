@@ -0,0 +1,64 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Results are payloads of functions kept on the ledger under an id the client chose. The peer command line prints the
+// payload of an invocation only in its log, a client passes an id instead and queries the result once the transaction committed
+var resultKeyPrefix = "_result-"
+
+// PutResult keeps the payload of a function under the id its client chose, nothing is kept without an id
+func PutResult(stub shim.ChaincodeStubInterface, resultId string, payload []byte) error {
+	if resultId == "" {
+		return nil
+	}
+	return stub.PutState(resultKeyPrefix+resultId, payload)
+}
+
+// GetResult is the function clients query the result kept under an id with
+func GetResult(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, SendError(stub, "getResult_byID", ErrValidation, Entities{}, "Incorrect number of arguments. Expecting 'resultId' as an argument")
+	}
+	resultAsBytes, err := stub.GetState(resultKeyPrefix + args[0])
+	if err != nil {
+		return nil, SendError(stub, "getResult_byID", ErrUpstream, Entities{}, "Failed to get result "+args[0])
+	}
+	if resultAsBytes == nil {
+		return nil, SendError(stub, "getResult_byID", ErrNotFound, Entities{}, "No result "+args[0]+".")
+	}
+	return resultAsBytes, nil
+}
+
+// NewResultID is a random id for a client to have the result of an invocation kept under.
+// It is for clients only, the peers endorsing a transaction would each draw another one
+func NewResultID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
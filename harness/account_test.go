@@ -0,0 +1,141 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package harness
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+
+	"github.com/mukutb/TCM/Account"
+	cc "github.com/mukutb/TCM/chaincode"
+)
+
+// Reconciliation reports an account whose security list and total value drifted from its position records
+// and changes nothing, with repair it rebuilds them and the next reconciliation finds nothing
+func TestReconcileAccounts(t *testing.T) {
+	tcm := newTCM(t)
+	tcm.PutState(AccountChaincode, "LB-1-GOLD", []byte(JSON(account.Securities{SecurityId: "GOLD", AccountNumber: "LB-1",
+		SecurityQuantity: "10", CollateralForm: "Commodities", TotalValue: "500"})))
+	tcm.PutState(AccountChaincode, "XX-9-IBM", []byte(JSON(account.Securities{SecurityId: "IBM", AccountNumber: "XX-9",
+		SecurityQuantity: "1", TotalValue: "150"})))
+	record := make(map[string]interface{})
+	json.Unmarshal(tcm.GetState(AccountChaincode, "LB-1"), &record)
+	record["securities"] = record["securities"].(string) + ",LB-1-IBM,LB-1-GONE"
+	tcm.PutState(AccountChaincode, "LB-1", []byte(JSON(record)))
+	before := string(tcm.GetState(AccountChaincode, "LB-1"))
+
+	result := reconcile(t, tcm, "false")
+	if result.AccountsChecked != 2 || len(result.Accounts) != 1 {
+		t.Fatalf("expected LB-1 alone to drift, got %+v", result)
+	}
+	drift := result.Accounts[0]
+	if drift.AccountNumber != "LB-1" || drift.Repaired || !equal(drift.DuplicateKeys, "LB-1-IBM") ||
+		!equal(drift.MissingKeys, "LB-1-GONE") || !equal(drift.OrphanedKeys, "LB-1-GOLD") || drift.PositionsValue != "500.00" {
+		t.Fatalf("unexpected drift of LB-1: %+v", drift)
+	}
+	if !equal(result.UnknownAccounts["XX-9"], "XX-9-IBM") {
+		t.Fatalf("expected the position of XX-9 to be reported, got %+v", result.UnknownAccounts)
+	}
+	if after := string(tcm.GetState(AccountChaincode, "LB-1")); after != before {
+		t.Fatalf("expected reconciliation without repair to leave LB-1 as it was, got %s", after)
+	}
+
+	if result := reconcile(t, tcm, "true"); len(result.Accounts) != 1 || !result.Accounts[0].Repaired {
+		t.Fatalf("expected LB-1 to be repaired, got %+v", result)
+	}
+	var repaired map[string]account.Accounts
+	json.Unmarshal(mustQuery(t, tcm, AccountChaincode, "getAccount_byNumber", "LB-1"), &repaired)
+	if repaired["LB-1"].Securities != "LB-1-IBM,LB-1-CB-1,LB-1-USD,LB-1-GOLD" || repaired["LB-1"].TotalValue != "500.00" {
+		t.Fatalf("unexpected repaired LB-1: %+v", repaired["LB-1"])
+	}
+	if result := reconcile(t, tcm, "false"); len(result.Accounts) != 0 {
+		t.Fatalf("expected nothing to drift after repair, got %+v", result.Accounts)
+	}
+}
+
+// Adding a position again replaces it in the total value of the account, deleting it takes its value out
+func TestSecurityChangesKeepAccountInLine(t *testing.T) {
+	tcm := newTCM(t)
+	for _, position := range [][]string{{"IBM", "500", "75000"}, {"IBM", "400", "60000"}, {"CB-2", "10", "1000"}} {
+		mustInvoke(t, tcm, AccountChaincode, "add_security", position[0], "LB-1", position[0], position[1], "Common Stocks",
+			"Common Stocks", position[2], "0", "150", "0", "0", "USD")
+	}
+	mustInvoke(t, tcm, AccountChaincode, "delete_security", "CB-2", "LB-1")
+	if result := reconcile(t, tcm, "false"); len(result.Accounts) != 0 {
+		t.Fatalf("expected accounts to stay in line with their positions, got %+v", result.Accounts)
+	}
+	var accounts map[string]account.Accounts
+	json.Unmarshal(mustQuery(t, tcm, AccountChaincode, "getAccount_byNumber", "LB-1"), &accounts)
+	if accounts["LB-1"].Securities != "LB-1-IBM,LB-1-CB-1,LB-1-USD" || accounts["LB-1"].TotalValue != "60000" {
+		t.Fatalf("unexpected LB-1: %+v", accounts["LB-1"])
+	}
+}
+
+func reconcile(t *testing.T, tcm *TCM, repair string) account.ReconciliationResult {
+	t.Helper()
+	var result account.ReconciliationResult
+	json.Unmarshal(mustInvoke(t, tcm, AccountChaincode, "reconcile_accounts", JSON(map[string]string{"repair": repair})), &result)
+	return result
+}
+
+func equal(keys []string, expected ...string) bool {
+	if len(keys) != len(expected) {
+		return false
+	}
+	for i := range keys {
+		if keys[i] != expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// An account without securities answers with an empty list and an unknown account is not found
+func TestSecuritiesOfEmptyAndUnknownAccounts(t *testing.T) {
+	tcm := newTCM(t)
+	if securities := string(mustQuery(t, tcm, AccountChaincode, "getSecurities_byAccount", "SG-1")); securities != "[]" {
+		t.Fatalf("expected no securities in the segregated account, got %s", securities)
+	}
+	if response := tcm.Query(AccountChaincode, "getSecurities_byAccount", "SG-X"); response.Status == shim.OK || !strings.Contains(response.Message, "NOT_FOUND") {
+		t.Fatalf("expected an unknown account to be not found, got %d %s", response.Status, response.Message)
+	}
+}
+
+// Cash reserved for a transaction cannot be withdrawn, and withdraw_cash reports under its own name
+func TestWithdrawCashKeepsReservedCash(t *testing.T) {
+	tcm := newTCM(t)
+	mustInvoke(t, tcm, AccountChaincode, "deposit_cash", "LB-1", "EUR", "1000")
+	mustInvoke(t, tcm, AccountChaincode, "reserve_securities", "T-1", "LB-1", `{"CASH-EUR":"600"}`, "1490097600")
+	if response := tcm.Invoke(AccountChaincode, "withdraw_cash", "LB-1", "EUR", "500"); response.Status == shim.OK {
+		t.Fatal("expected withdrawing reserved cash to fail")
+	}
+	mustInvoke(t, tcm, AccountChaincode, "withdraw_cash", "LB-1", "EUR", "400")
+	var event cc.Event
+	json.Unmarshal(tcm.Events[len(tcm.Events)-1].Payload, &event)
+	if event.Type != "withdraw_cash" {
+		t.Fatalf("expected the withdrawal to send a withdraw_cash event, got %s", event.Type)
+	}
+	if eur := availability(t, tcm, "LB-1")["CASH-EUR"]; eur.Quantity != "600.00" || eur.Available != "0.00" {
+		t.Fatalf("expected the 600 EUR left reserved, got %+v", eur)
+	}
+}
@@ -0,0 +1,120 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package harness
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/mukutb/TCM/Allocation"
+)
+
+// API is a local stand-in for the services start_allocation calls: the private ruleset at
+// /securityRuleset/<pledger>/<pledgee> and prices at /MarketData/<securityId> of its apiIp argument,
+// and the exchange rates at /latest?base=<currency>
+type API struct {
+	server   *httptest.Server
+	mu       sync.Mutex
+	rulesets map[string]allocation.Ruleset
+	prices   map[string]string
+	rates    map[string]map[string]float64
+}
+
+// NewAPI starts the API on a local port
+func NewAPI() *API {
+	api := &API{
+		rulesets: make(map[string]allocation.Ruleset),
+		prices:   make(map[string]string),
+		rates:    make(map[string]map[string]float64),
+	}
+	api.server = httptest.NewServer(http.HandlerFunc(api.serve))
+	return api
+}
+
+// Host is the host and port of the API, the apiIp argument of start_allocation
+func (api *API) Host() string {
+	return strings.TrimPrefix(api.server.URL, "http://")
+}
+
+// URL is the base URL of the API
+func (api *API) URL() string {
+	return api.server.URL
+}
+
+// Close stops the API
+func (api *API) Close() {
+	api.server.Close()
+}
+
+// SetRuleset sets the private ruleset of a pledger and pledgee
+func (api *API) SetRuleset(pledger string, pledgee string, ruleset allocation.Ruleset) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.rulesets[pledger+"/"+pledgee] = ruleset
+}
+
+// SetPrice sets the market price of a security
+func (api *API) SetPrice(securityID string, price string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.prices[securityID] = price
+}
+
+// SetRates sets the exchange rates of a base currency, the units of each currency one unit of the base buys
+func (api *API) SetRates(base string, rates map[string]float64) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.rates[base] = rates
+}
+
+func (api *API) serve(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(path) == 3 && path[0] == "securityRuleset":
+		ruleset, ok := api.rulesets[path[1]+"/"+path[2]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(ruleset)
+	case len(path) == 2 && path[0] == "MarketData":
+		price, ok := api.prices[path[1]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode([]string{price})
+	case len(path) == 1 && path[0] == "latest":
+		base := r.URL.Query().Get("base")
+		rates, ok := api.rates[base]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(allocation.CurrencyConversion{Base: base, Date: "2017-03-20", Rates: rates})
+	default:
+		http.NotFound(w, r)
+	}
+}
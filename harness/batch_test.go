@@ -0,0 +1,110 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package harness
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/mukutb/TCM/batch"
+)
+
+// A run allocates the ready transactions by margin call date with the accounts of their deals, a failing allocation
+// does not stop it and transactions whose accounts cannot be resolved are skipped
+func TestBatchAllocationRun(t *testing.T) {
+	tcm := newTCM(t)
+	mustInvoke(t, tcm, DealChaincode, "create_deal", JSON(map[string]string{"dealId": "D-3", "pledger": "PledgerE", "pledgee": "PledgeeF",
+		"maxValue": "1000000", "totalValueLongBoxAccount": "0", "totalValueSegregatedAccount": "0", "issueDate": "2017-03-01",
+		"lastSuccessfulAllocationDate": "2017-03-01", "transactions": ""}))
+	later := createTransaction(t, tcm, "T-LATER", "D-1", "PledgerA", "PledgeeB", "1490097600", "Matched")
+	earlier := createTransaction(t, tcm, "T-EARLIER", "D-1", "PledgerA", "PledgeeB", "1490011200", "Matched")
+	createTransaction(t, tcm, "T-UNMATCHED", "D-1", "PledgerA", "PledgeeB", "1490011200", "Unmatched")
+	unresolved := createTransaction(t, tcm, "T-UNRESOLVED", "D-3", "PledgerE", "PledgeeF", "1490011200", "Matched")
+
+	summary := runAllocations(t, tcm, batch.Options{})
+	if summary.Selected != 3 || summary.Allocated != 1 || summary.Failed != 1 || summary.Skipped != 1 {
+		t.Fatalf("expected one allocated, one failed and one skipped transaction, got %+v", summary)
+	}
+	// The earlier call is allocated first, the later one then finds the movements of the deal waiting for settlement
+	runs := summary.Transactions
+	if runs[0].TransactionID != earlier || runs[0].Status != "Pending settlement" ||
+		runs[0].PledgerLongboxAccount != "LB-1" || runs[0].PledgeeSegregatedAccount != "SG-1" {
+		t.Errorf("expected %s allocated from LB-1 to SG-1 first, got %+v", earlier, runs[0])
+	}
+	if runs[1].TransactionID != later || runs[1].Status != batch.Failed || runs[1].Error == "" {
+		t.Errorf("expected %s to fail, got %+v", later, runs[1])
+	}
+	if status := transactionStatus(t, tcm, later); status != "Ready for Allocation" {
+		t.Errorf("expected the failed allocation to leave %s ready, got %q", later, status)
+	}
+	if skipped := summary.SkippedTransactions; skipped[0].TransactionID != unresolved || skipped[0].Reason != "no longbox account of PledgerE" {
+		t.Errorf("expected %s skipped for want of a longbox account, got %+v", unresolved, skipped)
+	}
+
+	summary = runAllocations(t, tcm, batch.Options{Pledger: "PledgerE"})
+	if summary.Selected != 1 || summary.Skipped != 1 || len(summary.Transactions) != 0 {
+		t.Fatalf("expected only the transaction of PledgerE, got %+v", summary)
+	}
+	summary = runAllocations(t, tcm, batch.Options{DealID: "D-9"})
+	if summary.Selected != 0 {
+		t.Fatalf("expected no transactions of an unknown deal, got %+v", summary)
+	}
+}
+
+// createTransaction creates a transaction of 50000 on a deal, matched transactions are ready for allocation
+func createTransaction(t *testing.T, tcm *TCM, id string, dealId string, pledger string, pledgee string, marginCallDate string, status string) string {
+	t.Helper()
+	mustInvoke(t, tcm, DealChaincode, "create_transaction", id, "1490000000", dealId, pledger, pledgee, "50000", "USD",
+		marginCallDate, status, "Call")
+	return id
+}
+
+func runAllocations(t *testing.T, tcm *TCM, options batch.Options) batch.RunSummary {
+	t.Helper()
+	options.DealChaincode, options.AccountChaincode, options.AllocationChaincode = DealChaincode, AccountChaincode, AllocationChaincode
+	options.APIIP = tcm.API.Host()
+	summary, err := batch.Run(ledger{tcm}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return summary
+}
+
+// ledger calls the chaincodes of the harness
+type ledger struct {
+	tcm *TCM
+}
+
+func (l ledger) Invoke(chaincode string, args ...string) ([]byte, error) {
+	return payload(l.tcm.Invoke(chaincode, args...))
+}
+
+func (l ledger) Query(chaincode string, args ...string) ([]byte, error) {
+	return payload(l.tcm.Query(chaincode, args...))
+}
+
+func payload(response pb.Response) ([]byte, error) {
+	if response.Status != shim.OK {
+		return nil, errors.New(response.Message)
+	}
+	return response.Payload, nil
+}
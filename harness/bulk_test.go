@@ -0,0 +1,84 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package harness
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/mukutb/TCM/Account"
+	"github.com/mukutb/TCM/bulkload"
+)
+
+// Onboarding files load their valid rows in chunks and report every row, loading them again changes nothing
+func TestBulkLoad(t *testing.T) {
+	tcm := newTCM(t)
+	expected := map[string]map[int]string{
+		"testdata/bulk/accounts.csv":   {2: account.BulkCreated, 3: account.BulkCreated, 4: account.BulkFailed},
+		"testdata/bulk/holdings.jsonl": {1: account.BulkCreated, 2: account.BulkCreated, 4: account.BulkFailed, 5: account.BulkFailed},
+	}
+	for _, file := range []string{"testdata/bulk/accounts.csv", "testdata/bulk/holdings.jsonl"} {
+		result := bulkLoad(t, tcm, file)
+		for _, row := range result.Rows {
+			if row.Status != expected[file][row.Row] {
+				t.Errorf("%s row %d: expected %s, got %+v", file, row.Row, expected[file][row.Row], row)
+			}
+		}
+		if len(result.Rows) != len(expected[file]) {
+			t.Errorf("%s: expected %d rows, got %+v", file, len(expected[file]), result.Rows)
+		}
+	}
+	var accounts map[string]account.Accounts
+	json.Unmarshal(mustQuery(t, tcm, AccountChaincode, "getAccount_byNumber", "LB-7"), &accounts)
+	if accounts["LB-7"].Securities != "LB-7-IBM,LB-7-CB-1" || accounts["LB-7"].TotalValue != "35000.00" {
+		t.Fatalf("unexpected LB-7 after bulk load: %+v", accounts["LB-7"])
+	}
+
+	for file, rows := range expected {
+		result := bulkLoad(t, tcm, file)
+		if result.Created+result.Updated != 0 || result.Unchanged != len(rows)-result.Failed {
+			t.Errorf("%s: expected loading again to change nothing, got %+v", file, result)
+		}
+	}
+	if result := reconcile(t, tcm, "false"); len(result.Accounts) != 0 {
+		t.Fatalf("expected bulk loaded accounts in line with their positions, got %+v", result.Accounts)
+	}
+}
+
+func bulkLoad(t *testing.T, tcm *TCM, file string) account.BulkLoadResult {
+	t.Helper()
+	rows, err := bulkload.Read(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoker := func(call func(string, ...string) pb.Response) bulkload.Invoker {
+		return func(args ...string) ([]byte, error) {
+			response := call(AccountChaincode, args...)
+			if response.Status != shim.OK {
+				return nil, errors.New(response.Message)
+			}
+			return response.Payload, nil
+		}
+	}
+	return bulkload.Load(rows, 2, invoker(tcm.Invoke), invoker(tcm.Query))
+}
@@ -0,0 +1,93 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package harness
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mukutb/TCM/Allocation"
+)
+
+// A corporate action calls the deal again only when it leaves the segregated account short of the requirement,
+// retained income counts towards it in the currency of the deal
+func TestCorporateActionCallsShortfallOnly(t *testing.T) {
+	tcm := newTCM(t)
+	createTransaction(t, tcm, "T-1", "D-1", "PledgerA", "PledgeeB", "2017-03-20", "Allocation Successful")
+	holdSecurity(t, tcm, "SG-1", "CB-1", "Corporate Bonds", "200", "100", "19400", "USD")
+	holdSecurity(t, tcm, "SG-1", "USD", "Cash", "31000", "1", "31000", "USD")
+
+	// A coupon paid through leaves the collateral as it was
+	tcm.Now = tcm.Now.Add(time.Hour)
+	processCorporateAction(t, tcm, "D-1", "SG-1", "E-1", "Coupon", "CB-1", "2", "")
+	coupon := getCorporateAction(t, tcm, "E-1", "D-1")
+	if coupon.MarginCallID != "" || coupon.Shortfall != "0.00" || coupon.CollateralValue != "50400.00" || coupon.ProcessedDate != "1490014800" {
+		t.Fatalf("expected no call after the coupon, got %+v", coupon)
+	}
+
+	// Redeeming half the bonds takes 9700 of the 50400 held, 9300 short of the 50000 required
+	processCorporateAction(t, tcm, "D-1", "SG-1", "E-2", "Redemption", "CB-1", "100", "0.5")
+	redemption := getCorporateAction(t, tcm, "E-2", "D-1")
+	if redemption.MarginCallID != "CA-E-2-D-1" || redemption.Shortfall != "9300.00" || redemption.Currency != "USD" {
+		t.Fatalf("expected the redemption to call the shortfall, got %+v", redemption)
+	}
+	call := getTransaction(t, tcm, "CA-E-2-D-1")
+	if call.RQV != "50000.00" || call.Currency != "USD" || call.MarginCAllDate != "1490014800" {
+		t.Fatalf("expected a call for the requirement of the deal, got %+v", call)
+	}
+
+	// Income retained in another currency is converted before it is compared with the requirement
+	mustInvoke(t, tcm, DealChaincode, "create_deal", JSON(map[string]string{"dealId": "D-2", "pledger": "PledgerA", "pledgee": "PledgeeB",
+		"maxValue": "1000000", "totalValueLongBoxAccount": "0", "totalValueSegregatedAccount": "0", "issueDate": "2017-03-01",
+		"lastSuccessfulAllocationDate": "2017-03-01", "transactions": "", "incomeTreatment": "Retain"}))
+	mustInvoke(t, tcm, AccountChaincode, "create_account", JSON(map[string]string{"accountId": "SG-2", "accountName": "PledgeeB",
+		"accountNumber": "SG-2", "accountType": "Segregated", "totalValue": "0", "currency": "USD", "pledger": "PledgerA", "securities": ""}))
+	createTransaction(t, tcm, "T-2", "D-2", "PledgerA", "PledgeeB", "2017-03-20", "Allocation Successful")
+	holdSecurity(t, tcm, "SG-2", "GILT", "Corporate Bonds", "100", "81", "10000", "GBP")
+	holdSecurity(t, tcm, "SG-2", "USD", "Cash", "40000", "1", "40000", "USD")
+	processCorporateAction(t, tcm, "D-2", "SG-2", "E-3", "Redemption", "GILT", "81", "1")
+	retained := getCorporateAction(t, tcm, "E-3", "D-2")
+	if retained.MarginCallID != "" || retained.CollateralValue != "50000.00" {
+		t.Fatalf("expected the retained 8100 GBP to replace the 10000 USD redeemed, got %+v", retained)
+	}
+}
+
+// holdSecurity puts a position with the value its allocation gave it in an account
+func holdSecurity(t *testing.T, tcm *TCM, account string, id string, form string, quantity string, price string, value string, currency string) {
+	mustInvoke(t, tcm, AccountChaincode, "add_security", JSON(map[string]string{"securityId": id, "accountNumber": account,
+		"securityName": id, "securityQuantity": quantity, "securityType": form, "collateralForm": form, "totalValue": value,
+		"valuePercentage": "0", "mtm": price, "effectivePercentage": "0", "effectiveValueinUSD": value, "currency": currency}))
+}
+
+func processCorporateAction(t *testing.T, tcm *TCM, dealId string, segregated string, eventId string, eventType string, securityId string, rate string, ratio string) {
+	t.Helper()
+	mustInvoke(t, tcm, AllocationChaincode, "process_corporate_action", DealChaincode, AccountChaincode, dealId, "LB-1", segregated,
+		eventId, eventType, securityId, rate, ratio, "", "")
+}
+
+func getCorporateAction(t *testing.T, tcm *TCM, eventId string, dealId string) allocation.CorporateActions {
+	t.Helper()
+	var corporateAction allocation.CorporateActions
+	if err := json.Unmarshal(tcm.GetState(AllocationChaincode, "CA-"+eventId+"-"+dealId), &corporateAction); err != nil {
+		t.Fatal(err)
+	}
+	return corporateAction
+}
@@ -0,0 +1,322 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package harness
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/Deal"
+)
+
+// Deleting a deal deletes its transactions, its exposure and its disputes and takes them out of their indexes
+func TestDeleteDealKeepsIndexesConsistent(t *testing.T) {
+	tcm := newTCM(t)
+	mustInvoke(t, tcm, DealChaincode, "submit_exposure", "D-1", "50000", "USD", AccountChaincode, "SG-1")
+	id := dealTransactions(t, tcm, "D-1")[0]
+	mustInvoke(t, tcm, DealChaincode, "raise_dispute", "DSP-1", id, "PledgerA", "20000", "exposure too high")
+
+	mustInvoke(t, tcm, DealChaincode, "deleteDeal", "D-1")
+	if tcm.GetState(DealChaincode, "D-1") != nil || tcm.GetState(DealChaincode, id) != nil {
+		t.Fatal("expected the deal and its transaction to be deleted")
+	}
+	if tcm.GetState(DealChaincode, "_exposure-D-1") != nil || tcm.GetState(DealChaincode, "_dispute-DSP-1") != nil {
+		t.Fatal("expected the exposure and the dispute of the deal to be deleted")
+	}
+	if index := string(tcm.GetState(DealChaincode, "_disputeIndex")); index != "[]" {
+		t.Fatalf("expected the dispute out of its index, got %s", index)
+	}
+	if report := checkIndexes(t, tcm); !report.Consistent {
+		t.Fatalf("expected consistent indexes after deleting the deal, got %+v", report)
+	}
+}
+
+// Exposures submitted within the same second raise calls of their own, the later one superseding the earlier
+func TestExposuresInTheSameSecondRaiseSeparateCalls(t *testing.T) {
+	tcm := newTCM(t)
+	mustInvoke(t, tcm, DealChaincode, "submit_exposure", "D-1", "50000", "USD", AccountChaincode, "SG-1")
+	mustInvoke(t, tcm, DealChaincode, "submit_exposure", "D-1", "60000", "USD", AccountChaincode, "SG-1")
+	ids := dealTransactions(t, tcm, "D-1")
+	if len(ids) != 2 || ids[0] == ids[1] {
+		t.Fatalf("expected two calls, got %v", ids)
+	}
+	first, second := getTransaction(t, tcm, ids[0]), getTransaction(t, tcm, ids[1])
+	if first.AllocationStatus != "Superseded" || second.RQV != "60000.00" || second.TransactionDate != "1490011200" {
+		t.Fatalf("expected the second call to supersede the first, got %+v and %+v", first, second)
+	}
+}
+
+// A margin call date without a time is the start of that day in the timezone of the deal's cutoff
+func TestMarginCallDeadlineInCutoffTimezone(t *testing.T) {
+	tcm := newTCM(t)
+	mustInvoke(t, tcm, DealChaincode, "update_deal_cutoff", "D-1", "", "08:00", "Asia/Tokyo", "0")
+	createTransaction(t, tcm, "T-1", "D-1", "PledgerA", "PledgeeB", "2017-03-21", "Matched")
+	var deadline map[string]string
+	json.Unmarshal(mustQuery(t, tcm, DealChaincode, "getMarginCallDeadline_byTransactionID", "T-1"), &deadline)
+	if deadline["deadlineDate"] != "2017-03-21T08:00:00+09:00" {
+		t.Fatalf("expected the call due by the cutoff of its own day, got %+v", deadline)
+	}
+}
+
+// Invalid CSA terms are refused field by field in the order the terms are given
+func TestInvalidCSATerms(t *testing.T) {
+	tcm := newTCM(t)
+	response := tcm.Invoke(DealChaincode, "update_csa_terms", "D-1", "-1", "0", "0", "0", "0", "Sideways", "", "usd", "")
+	if response.Status == shim.OK {
+		t.Fatal("expected the CSA terms to be refused")
+	}
+	expected := "pledgerThreshold: must not be negative, got '-1'; roundingConvention: must be one of 'CallsUpReturnsDown', 'Up', 'Down', 'Nearest', got 'Sideways'; baseCurrency: must be an ISO 4217 currency code, got 'usd'"
+	if !strings.Contains(response.Message, expected) {
+		t.Fatalf("expected %q, got %q", expected, response.Message)
+	}
+}
+
+// Getting a deal or a transaction that does not exist fails as not found instead of returning nothing
+func TestGetMissingDealAndTransaction(t *testing.T) {
+	tcm := newTCM(t)
+	for _, function := range []string{"getDeal_byID", "getTransaction_byID"} {
+		response := tcm.Query(DealChaincode, function, "NONE")
+		if response.Status == shim.OK || !strings.Contains(response.Message, "NOT_FOUND") {
+			t.Fatalf("expected %s of a missing key to fail as not found, got %d %q", function, response.Status, response.Message)
+		}
+	}
+}
+
+// An update of a deal keeps the stored value of every optional field it leaves out or blank
+func TestUpdateDealKeepsFieldsLeftOut(t *testing.T) {
+	tcm := newTCM(t)
+	fields := map[string]string{"dealId": "D-1", "pledger": "PledgerA", "pledgee": "PledgeeB", "maxValue": "1000000",
+		"totalValueLongBoxAccount": "0", "totalValueSegregatedAccount": "0", "issueDate": "2017-03-01",
+		"lastSuccessfulAllocationDate": "2017-03-01", "transactions": ""}
+	mustInvoke(t, tcm, DealChaincode, "update_csa_terms", "D-1", "0", "0", "500", "0", "10", "Up", "PledgeeB", "EUR", "Cash")
+	fields["cashInterestRate"] = "2.5"
+	fields["incomeTreatment"] = "Retain"
+	mustInvoke(t, tcm, DealChaincode, "update_deal", JSON(fields))
+
+	delete(fields, "cashInterestRate")
+	delete(fields, "incomeTreatment")
+	fields["minimumTransferAmount"] = "1000"
+	mustInvoke(t, tcm, DealChaincode, "update_deal", JSON(fields))
+	var kept deal.Deals
+	json.Unmarshal(mustQuery(t, tcm, DealChaincode, "getDeal_byID", "D-1"), &kept)
+	if kept.CashInterestRate != "2.5" || kept.IncomeTreatment != "Retain" || kept.MinimumTransferAmount != "1000" ||
+		kept.RoundingAmount != "10" || kept.RoundingConvention != "Up" || kept.BaseCurrency != "EUR" || kept.EligibleCollateral != "Cash" {
+		t.Fatalf("expected the update to change the minimum transfer amount only, got %+v", kept)
+	}
+}
+
+// An optional term given empty is cleared, unlike one the JSON object leaves out
+func TestUpdateCSATermsClearsEmptyTerms(t *testing.T) {
+	tcm := newTCM(t)
+	mustInvoke(t, tcm, DealChaincode, "update_csa_terms", "D-1", "0", "0", "500", "0", "10", "Up", "PledgeeB", "EUR", "Cash")
+	mustInvoke(t, tcm, DealChaincode, "update_csa_terms", JSON(map[string]string{"dealId": "D-1", "valuationAgent": ""}))
+	var cleared deal.Deals
+	json.Unmarshal(mustQuery(t, tcm, DealChaincode, "getDeal_byID", "D-1"), &cleared)
+	if cleared.ValuationAgent != "" || cleared.MinimumTransferAmount != "500" || cleared.BaseCurrency != "EUR" || cleared.EligibleCollateral != "Cash" {
+		t.Fatalf("expected only the valuation agent cleared, got %+v", cleared)
+	}
+	fields := map[string]string{"dealId": "D-1", "pledger": "PledgerA", "pledgee": "PledgeeB", "maxValue": "1000000",
+		"totalValueLongBoxAccount": "0", "totalValueSegregatedAccount": "0", "issueDate": "2017-03-01",
+		"lastSuccessfulAllocationDate": "2017-03-01", "transactions": "", "eligibleCollateral": ""}
+	mustInvoke(t, tcm, DealChaincode, "update_deal", JSON(fields))
+	json.Unmarshal(mustQuery(t, tcm, DealChaincode, "getDeal_byID", "D-1"), &cleared)
+	if cleared.EligibleCollateral != "" || cleared.RoundingConvention != "Up" {
+		t.Fatalf("expected the eligible collateral cleared by update_deal, got %+v", cleared)
+	}
+}
+
+// Deleting the transactions of a deal keeps the deal
+func TestDeleteTransactionsKeepsDeal(t *testing.T) {
+	tcm := newTCM(t)
+	mustInvoke(t, tcm, DealChaincode, "submit_exposure", "D-1", "50000", "USD", AccountChaincode, "SG-1")
+	id := dealTransactions(t, tcm, "D-1")[0]
+
+	mustInvoke(t, tcm, DealChaincode, "deleteTransactions", "D-1")
+	if tcm.GetState(DealChaincode, id) != nil {
+		t.Fatalf("expected %s to be deleted", id)
+	}
+	var kept deal.Deals
+	json.Unmarshal(mustQuery(t, tcm, DealChaincode, "getDeal_byID", "D-1"), &kept)
+	if kept.DealID != "D-1" || kept.Transactions != "" {
+		t.Fatalf("expected D-1 to stay without transactions, got %+v", kept)
+	}
+	if report := checkIndexes(t, tcm); !report.Consistent {
+		t.Fatalf("expected consistent indexes after deleting the transactions, got %+v", report)
+	}
+}
+
+// The check reports each kind of inconsistency and the repair resolves them, except a transaction whose deal is gone
+func TestRepairIndexes(t *testing.T) {
+	tcm := newTCM(t)
+	tcm.PutState(DealChaincode, deal.DealIndexStr, []byte(`["D-1","D-GONE"]`))
+	tcm.PutState(DealChaincode, "T-ORPHAN", []byte(JSON(deal.Transactions{TransactionId: "T-ORPHAN", DealID: "D-GONE"})))
+	tcm.PutState(DealChaincode, "T-LOOSE", []byte(JSON(deal.Transactions{TransactionId: "T-LOOSE", DealID: "D-1"})))
+	record := make(map[string]interface{})
+	json.Unmarshal(tcm.GetState(DealChaincode, "D-1"), &record)
+	record["transactions"] = "T-MISSING"
+	tcm.PutState(DealChaincode, "D-1", []byte(JSON(record)))
+
+	report := checkIndexes(t, tcm)
+	if report.Consistent || !equal(report.DanglingDealIndex, "D-GONE") || !equal(report.UnindexedTransactions, "T-LOOSE", "T-ORPHAN") ||
+		!equal(report.UnlinkedTransactions, "T-LOOSE", "T-ORPHAN") || !equal(report.OrphanedTransactions, "T-ORPHAN") ||
+		!equal(report.MissingTransactions["D-1"], "T-MISSING") {
+		t.Fatalf("unexpected integrity report: %+v", report)
+	}
+
+	var repaired deal.IntegrityReport
+	json.Unmarshal(mustInvoke(t, tcm, DealChaincode, "repair_indexes"), &repaired)
+	if !repaired.Repaired || !equal(repaired.OrphanedTransactions, "T-ORPHAN") {
+		t.Fatalf("expected the indexes to be repaired and T-ORPHAN reported, got %+v", repaired)
+	}
+	// The transaction of a deleted deal is kept and indexed, it is all the check still reports
+	report = checkIndexes(t, tcm)
+	if len(report.DanglingDealIndex)+len(report.DanglingTransactionIndex)+len(report.UnindexedDeals)+len(report.UnindexedTransactions)+
+		len(report.MissingTransactions) != 0 || !equal(report.UnlinkedTransactions, "T-ORPHAN") || !equal(report.OrphanedTransactions, "T-ORPHAN") {
+		t.Fatalf("expected only T-ORPHAN left after repair, got %+v", report)
+	}
+	if tcm.GetState(DealChaincode, "T-ORPHAN") == nil {
+		t.Fatal("expected the transaction of a deleted deal to be kept")
+	}
+	if ids := dealTransactions(t, tcm, "D-1"); !equal(ids, "T-LOOSE") {
+		t.Fatalf("expected D-1 to list T-LOOSE alone, got %v", ids)
+	}
+}
+
+// A dispute is kept apart from the deal of the same id, dated by its transactions and cannot be settled below the
+// undisputed amount already called
+func TestDisputeLifecycle(t *testing.T) {
+	tcm := newTCM(t)
+	createTransaction(t, tcm, "T-1", "D-1", "PledgerA", "PledgeeB", "1490011200", "Matched")
+	mustInvoke(t, tcm, DealChaincode, "raise_dispute", "D-1", "T-1", "PledgerA", "20000", "exposure too high")
+
+	var kept deal.Deals
+	json.Unmarshal(mustQuery(t, tcm, DealChaincode, "getDeal_byID", "D-1"), &kept)
+	if kept.DealID != "D-1" || kept.Pledger != "PledgerA" {
+		t.Fatalf("expected the dispute to leave deal D-1 alone, got %+v", kept)
+	}
+	if undisputed := getTransaction(t, tcm, "T-1-UND"); undisputed.RQV != "30000.00" {
+		t.Fatalf("expected the undisputed 30000 called by T-1-UND, got %+v", undisputed)
+	}
+
+	if response := tcm.Invoke(DealChaincode, "resolve_dispute", "D-1", "25000", "PledgeeB"); response.Status == shim.OK {
+		t.Fatal("expected an agreed amount below the undisputed amount to be rejected")
+	}
+	tcm.Now = tcm.Now.Add(72 * time.Hour)
+	if dispute := getDispute(t, tcm, "D-1"); dispute.RaisedDate != "1490011200" || dispute.AgeInDays != "3" {
+		t.Fatalf("expected the dispute raised at the first transaction and 3 days old, got %+v", dispute)
+	}
+	mustInvoke(t, tcm, DealChaincode, "resolve_dispute", "D-1", "40000", "PledgeeB")
+	dispute := getDispute(t, tcm, "D-1")
+	if dispute.Status != "Resolved" || dispute.ResolvedDate != "1490270400" || dispute.AgreedTransactionID != "T-1-AGR" {
+		t.Fatalf("expected the dispute resolved at the last transaction, got %+v", dispute)
+	}
+	if agreed := getTransaction(t, tcm, "T-1-AGR"); agreed.RQV != "10000.00" {
+		t.Fatalf("expected the 10000 agreed above the undisputed amount called by T-1-AGR, got %+v", agreed)
+	}
+}
+
+// Only the pledger or the pledgee of the deal can dispute a call, and only before its collateral starts moving
+func TestDisputeOnlyBeforeAllocation(t *testing.T) {
+	tcm := newTCM(t)
+	createTransaction(t, tcm, "T-1", "D-1", "PledgerA", "PledgeeB", "1490011200", "Matched")
+	if response := tcm.Invoke(DealChaincode, "raise_dispute", "DSP-1", "T-1", "PledgerZ", "20000", "exposure too high"); response.Status == shim.OK {
+		t.Fatal("expected a dispute raised by a party outside the deal to be rejected")
+	}
+	for _, status := range []string{"Pending settlement", "Allocation Successful", "Dispute Resolved", "Disputed"} {
+		mustInvoke(t, tcm, DealChaincode, "update_transaction_AllocationStatus", "T-1", status)
+		if response := tcm.Invoke(DealChaincode, "raise_dispute", "DSP-1", "T-1", "PledgerA", "20000", "exposure too high"); response.Status == shim.OK {
+			t.Fatalf("expected a transaction %q not to be disputed", status)
+		}
+	}
+	mustInvoke(t, tcm, DealChaincode, "update_transaction_AllocationStatus", "T-1", "Pending due to insufficient collateral")
+	mustInvoke(t, tcm, DealChaincode, "raise_dispute", "DSP-1", "T-1", "PledgeeB", "20000", "exposure too high")
+	if dispute := getDispute(t, tcm, "DSP-1"); dispute.RaisedBy != "PledgeeB" || dispute.Status != "Open" {
+		t.Fatalf("expected the dispute of PledgeeB open, got %+v", dispute)
+	}
+}
+
+// Interest on posted cash accrues by the timestamps of the transactions, so every peer stores the same amount
+func TestCashInterestAccruesByTransactionTime(t *testing.T) {
+	tcm := newTCM(t)
+	mustInvoke(t, tcm, DealChaincode, "create_deal", JSON(map[string]string{"dealId": "D-CASH", "pledger": "PledgerA", "pledgee": "PledgeeB",
+		"maxValue": "1000000", "totalValueLongBoxAccount": "0", "totalValueSegregatedAccount": "0", "issueDate": "2017-03-01",
+		"lastSuccessfulAllocationDate": "2017-03-01", "transactions": "", "cashInterestRate": "5"}))
+	mustInvoke(t, tcm, DealChaincode, "record_cash_posting", "D-CASH", "USD", "100000")
+	tcm.Now = tcm.Now.Add(36 * 24 * time.Hour)
+	mustInvoke(t, tcm, DealChaincode, "accrue_cash_interest", "D-CASH")
+
+	var cash deal.CashCollateral
+	json.Unmarshal(tcm.GetState(DealChaincode, "_cash-D-CASH"), &cash)
+	if usd := cash.Balances["USD"]; usd.AccruedInterest != "500" || usd.LastAccrualDate != "1493121600" {
+		t.Fatalf("expected 500 accrued over 36 days up to the accrual, got %+v", usd)
+	}
+}
+
+func getDispute(t *testing.T, tcm *TCM, id string) deal.Disputes {
+	t.Helper()
+	var dispute deal.Disputes
+	json.Unmarshal(mustQuery(t, tcm, DealChaincode, "getDispute_byID", id), &dispute)
+	return dispute
+}
+
+func getTransaction(t *testing.T, tcm *TCM, id string) deal.Transactions {
+	t.Helper()
+	var transaction deal.Transactions
+	json.Unmarshal(mustQuery(t, tcm, DealChaincode, "getTransaction_byID", id), &transaction)
+	return transaction
+}
+
+func checkIndexes(t *testing.T, tcm *TCM) deal.IntegrityReport {
+	t.Helper()
+	var report deal.IntegrityReport
+	json.Unmarshal(mustQuery(t, tcm, DealChaincode, "check_indexes"), &report)
+	return report
+}
+
+// dealTransactions are the ids of the transactions a deal lists
+func dealTransactions(t *testing.T, tcm *TCM, dealId string) []string {
+	t.Helper()
+	response := tcm.Query(DealChaincode, "getTransactions_byDealID", dealId)
+	if response.Status != shim.OK {
+		t.Fatalf("getTransactions_byDealID failed: %s", response.Message)
+	}
+	var transactions []deal.Transactions
+	json.Unmarshal(response.Payload, &transactions)
+	ids := []string{}
+	for _, transaction := range transactions {
+		ids = append(ids, transaction.TransactionId)
+	}
+	return ids
+}
+
+// Collateral held in another currency counts towards the credit support at its value in the deal's currency
+func TestExposureValuesCollateralInDealCurrency(t *testing.T) {
+	tcm := newTCM(t)
+	mustInvoke(t, tcm, AccountChaincode, "deposit_cash", "SG-1", "EUR", "9300")
+	mustInvoke(t, tcm, DealChaincode, "submit_exposure", "D-1", "50000", "USD", AccountChaincode, "SG-1")
+	var exposure deal.Exposures
+	json.Unmarshal(mustQuery(t, tcm, DealChaincode, "getExposure_byDealID", "D-1"), &exposure)
+	if exposure.CollateralValue != "10000.00" || exposure.Delivery != "40000.00" {
+		t.Fatalf("expected the 9300 EUR held to count as 10000 USD, got %+v", exposure)
+	}
+}
@@ -0,0 +1,319 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package harness
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/Allocation"
+)
+
+// newTCM deploys the chaincodes with a pledger longbox account holding stocks, bonds and cash,
+// an empty segregated account of the pledgee and a deal between them
+func newTCM(t *testing.T) *TCM {
+	tcm, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tcm.Close)
+	tcm.API.SetRuleset("PledgerA", "PledgeeB", allocation.Ruleset{
+		Security: map[string]map[string]float64{
+			"Common Stocks":   {"Concentration Limit": 40, "Priority": 1, "Valuation Percentage": 97},
+			"Corporate Bonds": {"Concentration Limit": 30, "Priority": 2, "Valuation Percentage": 97},
+			"Cash":            {"Concentration Limit": 100, "Priority": 16, "Valuation Percentage": 100},
+		},
+		BaseCurrency:     "USD",
+		EligibleCurrency: []string{"USD"},
+		Version:          "1",
+	})
+	tcm.API.SetPrice("IBM", "150")
+	tcm.API.SetPrice("CB-1", "100")
+	tcm.API.SetRates("USD", map[string]float64{"EUR": 0.93, "GBP": 0.81})
+
+	mustInvoke(t, tcm, AccountChaincode, "create_account", JSON(map[string]string{"accountId": "LB-1", "accountName": "PledgerA",
+		"accountNumber": "LB-1", "accountType": "Longbox", "totalValue": "0", "currency": "USD", "pledger": "PledgerA", "securities": ""}))
+	mustInvoke(t, tcm, AccountChaincode, "create_account", JSON(map[string]string{"accountId": "SG-1", "accountName": "PledgeeB",
+		"accountNumber": "SG-1", "accountType": "Segregated", "totalValue": "0", "currency": "USD", "pledger": "PledgerA", "securities": ""}))
+	addSecurity(t, tcm, "LB-1", "IBM", "Common Stocks", "1000", "150")
+	addSecurity(t, tcm, "LB-1", "CB-1", "Corporate Bonds", "1000", "100")
+	addSecurity(t, tcm, "LB-1", "USD", "Cash", "100000", "1")
+
+	mustInvoke(t, tcm, DealChaincode, "create_deal", JSON(map[string]string{"dealId": "D-1", "pledger": "PledgerA", "pledgee": "PledgeeB",
+		"maxValue": "1000000", "totalValueLongBoxAccount": "0", "totalValueSegregatedAccount": "0", "issueDate": "2017-03-01",
+		"lastSuccessfulAllocationDate": "2017-03-01", "transactions": ""}))
+	return tcm
+}
+
+func addSecurity(t *testing.T, tcm *TCM, account string, id string, form string, quantity string, price string) {
+	mustInvoke(t, tcm, AccountChaincode, "add_security", JSON(map[string]string{"securityId": id, "accountNumber": account,
+		"securityName": id, "securityQuantity": quantity, "securityType": form, "collateralForm": form, "totalValue": "0",
+		"valuePercentage": "0", "mtm": price, "effectivePercentage": "0", "effectiveValueinUSD": "0", "currency": "USD"}))
+}
+
+func mustInvoke(t *testing.T, tcm *TCM, chaincode string, args ...string) []byte {
+	t.Helper()
+	response := tcm.Invoke(chaincode, args...)
+	if response.Status != shim.OK {
+		t.Fatalf("%s %s failed: %s", chaincode, args[0], response.Message)
+	}
+	return response.Payload
+}
+
+func mustQuery(t *testing.T, tcm *TCM, chaincode string, args ...string) []byte {
+	t.Helper()
+	response := tcm.Query(chaincode, args...)
+	if response.Status != shim.OK {
+		t.Fatalf("%s %s failed: %s", chaincode, args[0], response.Message)
+	}
+	return response.Payload
+}
+
+// An exposure raises a margin call, allocation moves collateral from the longbox to the segregated account
+// and the call is successful once every movement settles
+func TestMarginCallAllocation(t *testing.T) {
+	tcm := newTCM(t)
+	mustInvoke(t, tcm, DealChaincode, "submit_exposure", "D-1", "50000", "USD", AccountChaincode, "SG-1")
+	var transactions []allocation.Transactions
+	json.Unmarshal(mustQuery(t, tcm, DealChaincode, "getTransactions_byDealID", "D-1"), &transactions)
+	if len(transactions) != 1 || transactions[0].RQV != "50000.00" {
+		t.Fatalf("expected the exposure to raise a margin call of 50000.00, got %+v", transactions)
+	}
+	id := transactions[0].TransactionId
+
+	mustInvoke(t, tcm, AllocationChaincode, "start_allocation", JSON(map[string]string{"dealChaincode": DealChaincode,
+		"accountChaincode": AccountChaincode, "apiIp": tcm.API.Host(), "dealId": "D-1", "transactionId": id,
+		"pledgerLongboxAccount": "LB-1", "pledgeeSegregatedAccount": "SG-1", "marginCallTimestamp": "1490011200"}))
+	var movements []allocation.Movements
+	json.Unmarshal(mustQuery(t, tcm, AllocationChaincode, "getMovements_byTransactionID", id), &movements)
+	if len(movements) == 0 {
+		t.Fatal("expected allocation to instruct movements")
+	}
+	if status := transactionStatus(t, tcm, id); status != "Pending settlement" {
+		t.Fatalf("expected the call to wait for settlement, got %q", status)
+	}
+
+	for _, movement := range movements {
+		mustInvoke(t, tcm, AllocationChaincode, "update_settlement_status", AccountChaincode, DealChaincode, movement.MovementID,
+			"Settled", movement.Quantity, "")
+	}
+	if status := transactionStatus(t, tcm, id); status != "Allocation Successful" {
+		t.Fatalf("expected the call to succeed once settled, got %q", status)
+	}
+	var held []allocation.Securities
+	json.Unmarshal(mustQuery(t, tcm, AccountChaincode, "getSecurities_byAccount", "SG-1"), &held)
+	value := 0.0
+	for _, security := range held {
+		securityValue, _ := strconv.ParseFloat(security.TotalValue, 64)
+		value += securityValue
+	}
+	if value < 50000 {
+		t.Fatalf("expected the segregated account to hold at least the RQV, got %.2f", value)
+	}
+}
+
+// Allocating a transaction again instructs movements of its own and keeps those of the earlier allocation
+func TestReallocationKeepsEarlierMovements(t *testing.T) {
+	tcm := newTCM(t)
+	createTransaction(t, tcm, "T-1", "D-1", "PledgerA", "PledgeeB", "2017-03-20", "Matched")
+	startAllocation(t, tcm, "D-1", "T-1", "SG-1")
+	first := movementIDs(t, tcm, "T-1")
+	if len(first) == 0 {
+		t.Fatal("expected the allocation to instruct movements")
+	}
+	var movements []allocation.Movements
+	json.Unmarshal(mustQuery(t, tcm, AllocationChaincode, "getMovements_byTransactionID", "T-1"), &movements)
+	for _, movement := range movements {
+		mustInvoke(t, tcm, AllocationChaincode, "update_settlement_status", AccountChaincode, DealChaincode, movement.MovementID,
+			"Settled", movement.Quantity, "")
+	}
+	setRQV(t, tcm, "T-1", "80000")
+	startAllocation(t, tcm, "D-1", "T-1", "SG-1")
+	second := movementIDs(t, tcm, "T-1")
+	for id := range first {
+		if !second[id] {
+			t.Fatalf("expected movement %s of the first allocation to be kept, got %v", id, second)
+		}
+	}
+	if len(second) == len(first) {
+		t.Fatalf("expected the second allocation to add movements, got %v", second)
+	}
+	var index []string
+	json.Unmarshal(tcm.GetState(AllocationChaincode, "_movementIndex"), &index)
+	if len(index) != len(second) {
+		t.Fatalf("expected every movement indexed once, got %v", index)
+	}
+}
+
+// movementIDs are the ids of the movements of a transaction, failing on a movement listed twice
+func movementIDs(t *testing.T, tcm *TCM, id string) map[string]bool {
+	t.Helper()
+	var movements []allocation.Movements
+	json.Unmarshal(mustQuery(t, tcm, AllocationChaincode, "getMovements_byTransactionID", id), &movements)
+	ids := make(map[string]bool)
+	for _, movement := range movements {
+		if ids[movement.MovementID] {
+			t.Fatalf("movement %s listed twice", movement.MovementID)
+		}
+		ids[movement.MovementID] = true
+	}
+	return ids
+}
+
+// A failing transaction leaves the world state of every chaincode it called as it was
+func TestFailedTransactionIsNotCommitted(t *testing.T) {
+	tcm := newTCM(t)
+	mustInvoke(t, tcm, DealChaincode, "submit_exposure", "D-1", "50000", "USD", AccountChaincode, "SG-1")
+	var transactions []allocation.Transactions
+	json.Unmarshal(mustQuery(t, tcm, DealChaincode, "getTransactions_byDealID", "D-1"), &transactions)
+	id := transactions[0].TransactionId
+	before := transactionStatus(t, tcm, id)
+
+	// Allocation marks the call in progress in Deal before it finds the API unreachable
+	response := tcm.Invoke(AllocationChaincode, "start_allocation", DealChaincode, AccountChaincode, "127.0.0.1:1", "D-1", id,
+		"LB-1", "SG-1", "1490011200")
+	if response.Status == shim.OK {
+		t.Fatal("expected allocation to fail without the API")
+	}
+	if status := transactionStatus(t, tcm, id); status != before {
+		t.Fatalf("expected the failed allocation to leave the status %q, got %q", before, status)
+	}
+}
+
+// The invariants of an allocation hold after it and can be checked again on demand,
+// a total value that drifted from the positions of its account is reported
+func TestCheckAllocation(t *testing.T) {
+	tcm := newTCM(t)
+	id := allocate(t, tcm)
+	if result := checkAllocation(t, tcm, id); !result.Passed {
+		t.Fatalf("expected the allocation to keep its invariants, got %+v", result.Violations)
+	}
+
+	setTotalValue(t, tcm, "SG-1", "1")
+	result := checkAllocation(t, tcm, id)
+	if result.Passed || len(result.Violations) != 1 || result.Violations[0].Invariant != "accountTotal" || result.Violations[0].Subject != "SG-1" {
+		t.Fatalf("expected the total value of SG-1 to be reported, got %+v", result)
+	}
+}
+
+// Conservation is checked against the positions the accounts hold, not against the report of the allocation
+func TestCheckAllocationReadsAccounts(t *testing.T) {
+	tcm := newTCM(t)
+	id := allocate(t, tcm)
+	record := make(map[string]interface{})
+	json.Unmarshal(tcm.GetState(AccountChaincode, "LB-1-IBM"), &record)
+	record["securityQuantity"] = "10000"
+	tcm.PutState(AccountChaincode, "LB-1-IBM", []byte(JSON(record)))
+
+	result := checkAllocation(t, tcm, id)
+	if result.Passed || len(result.Violations) != 1 || result.Violations[0].Invariant != "conservation" || result.Violations[0].Subject != "IBM" {
+		t.Fatalf("expected the IBM held by LB-1 to break conservation, got %+v", result)
+	}
+}
+
+// An allocation that would break an invariant is aborted with the violations and leaves the ledger as it was
+func TestInvariantViolationAbortsAllocation(t *testing.T) {
+	tcm := newTCM(t)
+	mustInvoke(t, tcm, DealChaincode, "submit_exposure", "D-1", "50000", "USD", AccountChaincode, "SG-1")
+	var transactions []allocation.Transactions
+	json.Unmarshal(mustQuery(t, tcm, DealChaincode, "getTransactions_byDealID", "D-1"), &transactions)
+	id := transactions[0].TransactionId
+	before := transactionStatus(t, tcm, id)
+	setTotalValue(t, tcm, "LB-1", "1")
+
+	response := tcm.Invoke(AllocationChaincode, "start_allocation", DealChaincode, AccountChaincode, tcm.API.Host(), "D-1", id,
+		"LB-1", "SG-1", "1490011200")
+	if response.Status == shim.OK || !strings.Contains(response.Message, "INVARIANT_VIOLATION") {
+		t.Fatalf("expected allocation to be aborted for an invariant violation, got %d %s", response.Status, response.Message)
+	}
+	if status := transactionStatus(t, tcm, id); status != before {
+		t.Fatalf("expected the aborted allocation to leave the status %q, got %q", before, status)
+	}
+	if report := tcm.Query(AllocationChaincode, "getAllocationReport_byTransactionID", id); report.Status == shim.OK {
+		t.Fatal("expected the aborted allocation to store no report")
+	}
+}
+
+// A call that moves nothing still stores its report, with the status it was left in and both accounts as they were
+func TestUnallocatedCallsStoreReport(t *testing.T) {
+	tcm := newTCM(t)
+	createTransaction(t, tcm, "T-SHORT", "D-1", "PledgerA", "PledgeeB", "1490011200", "Matched")
+	setRQV(t, tcm, "T-SHORT", "10000000")
+	startAllocation(t, tcm, "D-1", "T-SHORT", "SG-1")
+	report := allocationReport(t, tcm, "T-SHORT")
+	if report.AllocationStatus != "Pending due to insufficient collateral" || len(report.Movements) != 0 ||
+		len(report.PledgerLongboxSecurities) != len(report.PledgerLongboxHoldings) {
+		t.Fatalf("expected a pending report keeping the longbox as it was, got %+v", report)
+	}
+
+	mustInvoke(t, tcm, DealChaincode, "update_csa_terms", "D-1", "0", "0", "100000", "0", "0", "", "", "USD", "")
+	createTransaction(t, tcm, "T-SMALL", "D-1", "PledgerA", "PledgeeB", "1490011200", "Matched")
+	startAllocation(t, tcm, "D-1", "T-SMALL", "SG-1")
+	if report := allocationReport(t, tcm, "T-SMALL"); report.AllocationStatus != "Below minimum transfer amount" || len(report.Movements) != 0 {
+		t.Fatalf("expected a below minimum transfer report, got %+v", report)
+	}
+}
+
+func allocationReport(t *testing.T, tcm *TCM, id string) allocation.AllocationReport {
+	t.Helper()
+	var report allocation.AllocationReport
+	json.Unmarshal(mustQuery(t, tcm, AllocationChaincode, "getAllocationReport_byTransactionID", id), &report)
+	return report
+}
+
+// allocate raises a margin call of 50000 on D-1 and allocates it, the id of its transaction is returned
+func allocate(t *testing.T, tcm *TCM) string {
+	t.Helper()
+	mustInvoke(t, tcm, DealChaincode, "submit_exposure", "D-1", "50000", "USD", AccountChaincode, "SG-1")
+	var transactions []allocation.Transactions
+	json.Unmarshal(mustQuery(t, tcm, DealChaincode, "getTransactions_byDealID", "D-1"), &transactions)
+	id := transactions[0].TransactionId
+	mustInvoke(t, tcm, AllocationChaincode, "start_allocation", DealChaincode, AccountChaincode, tcm.API.Host(), "D-1", id,
+		"LB-1", "SG-1", "1490011200")
+	return id
+}
+
+func checkAllocation(t *testing.T, tcm *TCM, id string) allocation.InvariantReport {
+	t.Helper()
+	var result allocation.InvariantReport
+	json.Unmarshal(mustQuery(t, tcm, AllocationChaincode, "check_allocation", AccountChaincode, id), &result)
+	return result
+}
+
+// setTotalValue overwrites the total value of an account in the world state, behind the back of its chaincode
+func setTotalValue(t *testing.T, tcm *TCM, account string, totalValue string) {
+	t.Helper()
+	record := make(map[string]interface{})
+	if err := json.Unmarshal(tcm.GetState(AccountChaincode, account), &record); err != nil {
+		t.Fatal(err)
+	}
+	record["totalValue"] = totalValue
+	tcm.PutState(AccountChaincode, account, []byte(JSON(record)))
+}
+
+func transactionStatus(t *testing.T, tcm *TCM, id string) string {
+	t.Helper()
+	var transaction allocation.Transactions
+	json.Unmarshal(mustQuery(t, tcm, DealChaincode, "getTransaction_byID", id), &transaction)
+	return transaction.AllocationStatus
+}
@@ -0,0 +1,160 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package harness
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mukutb/TCM/iso20022"
+)
+
+var (
+	isoTransaction = iso20022.Transaction{TransactionId: "T-1", TransactionDate: "1490000000", DealID: "D-1", Pledger: "PledgerA",
+		Pledgee: "BANKGB2LXXX", RQV: "50000", Currency: "USD", MarginCAllDate: "1490011200", Direction: "Call"}
+	isoDeal = iso20022.Deal{DealID: "D-1", Pledger: "PledgerA", Pledgee: "BANKGB2LXXX", BaseCurrency: "USD"}
+)
+
+// Exported messages are the golden documents of testdata/iso20022 and import into the invocations that record them
+func TestMarginCallMessages(t *testing.T) {
+	request, err := iso20022.MarginCallRequestFromTransaction(isoTransaction, isoDeal, "SG-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "colr003.xml", request)
+	invocation, err := iso20022.Import(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"T-1", "1490011200", "D-1", "PledgerA", "BANKGB2LXXX", "50000.00", "USD", "1490011200", "Matched", "Call"}
+	if invocation.Chaincode != "Deal" || invocation.Function != "create_transaction" || !reflect.DeepEqual(invocation.Args, expected) {
+		t.Fatalf("expected the request to create T-1, got %+v", invocation)
+	}
+
+	response, err := iso20022.MarginCallResponseFromTransaction(isoTransaction, "30000", "Valuation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "colr004.xml", response)
+	invocation, err = iso20022.Import(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"T-1-DSP", "T-1", "PledgerA", "20000.00", "Valuation"}
+	if invocation.Function != "raise_dispute" || !reflect.DeepEqual(invocation.Args, expected) {
+		t.Fatalf("expected the response to dispute 20000.00, got %+v", invocation)
+	}
+}
+
+// A request of a counterparty identifying the parties by BIC and by proprietary identification imports as well
+func TestImportMarginCallRequestOfCounterparty(t *testing.T) {
+	invocation, err := iso20022.Import(readTestdata(t, "colr003-counterparty.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"MC-77", "1490097600", "CSA-9", "PLEDGER-42", "CPTYUS33", "125000.50", "EUR", "1490097600", "Matched", "Return"}
+	if !reflect.DeepEqual(invocation.Args, expected) {
+		t.Fatalf("expected %v, got %v", expected, invocation.Args)
+	}
+
+	if _, err := iso20022.Import([]byte(`<Document xmlns="urn:iso:std:iso:20022:tech:xsd:colr.003.001.03"><MrgnCallReq/></Document>`)); err == nil {
+		t.Fatal("expected a message definition the chaincodes do not know to be refused")
+	}
+}
+
+// The collateral and exposure report of an allocation reads back as it was written
+func TestCollateralAndExposureReport(t *testing.T) {
+	report := iso20022.AllocationReport{DealID: "D-1", TransactionID: "T-1", MarginCallDate: "1490011200", Pledgee: "BANKGB2LXXX",
+		Pledger: "PledgerA", PledgeeSegregatedAccount: "SG-1", RQV: "50000", Currency: "USD", AllocationStatus: "Pending settlement",
+		ComplianceStatus: "Compliant", PledgeeSegregatedSecurities: []iso20022.Security{
+			{SecurityId: "IBM", SecuritiesName: "IBM", SecuritiesQuantity: "137.00", CollateralForm: "Common Stocks", TotalValue: "19933.50", MTM: "150", Currency: "USD"},
+			{SecurityId: "USD", SecuritiesName: "USD", SecuritiesQuantity: "30066.50", CollateralForm: "Cash", TotalValue: "30066.50", MTM: "1", Currency: "USD"},
+		}}
+	document, err := iso20022.CollateralAndExposureReportFromAllocation(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "colr016.xml", document)
+	parsed, err := iso20022.ParseCollateralAndExposureReport(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Obligation.PartyA.Name() != "BANKGB2LXXX" || parsed.Obligation.PartyB.Name() != "PledgerA" ||
+		parsed.Collateral.CollateralValue.Value != "50000.00" || len(parsed.Collateral.Securities) != 1 || len(parsed.Collateral.Cash) != 1 {
+		t.Fatalf("expected the report to read back, got %+v", parsed)
+	}
+}
+
+// A substitution request is answered with the parties swapped and the reference it was sent with
+func TestSubstitutionMessages(t *testing.T) {
+	returned := []iso20022.Security{{SecurityId: "IBM", SecuritiesName: "IBM", SecuritiesQuantity: "100", CollateralForm: "Common Stocks", TotalValue: "14550", Currency: "USD"}}
+	delivered := []iso20022.Security{{SecurityId: "CB-1", SecuritiesName: "CB-1", SecuritiesQuantity: "150", CollateralForm: "Corporate Bonds", TotalValue: "14550", Currency: "USD"}}
+	document, err := iso20022.SubstitutionRequestFromPositions(isoTransaction, "SG-1", "SUB-1", returned, delivered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "colr019.xml", document)
+	request, err := iso20022.ParseSubstitutionRequest(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Obligation.PartyA.Name() != "PledgerA" || len(request.Returned) != 1 || request.Delivered[0].SecurityID != "CB-1" {
+		t.Fatalf("expected the request to read back, got %+v", request)
+	}
+
+	document, err = iso20022.SubstitutionResponseTo(request, false, "Concentration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "colr020.xml", document)
+	response, err := iso20022.ParseSubstitutionResponse(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Accepted() || response.Reference != "SUB-1" || response.Obligation.PartyA.Name() != "BANKGB2LXXX" || response.Reason != "Concentration" {
+		t.Fatalf("expected a rejection of SUB-1 from the pledgee, got %+v", response)
+	}
+}
+
+// golden compares a document with its file in testdata/iso20022, -update rewrites the file
+func golden(t *testing.T, name string, document []byte) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(filepath.Join("testdata", "iso20022", name), document, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if expected := readTestdata(t, name); !bytes.Equal(document, expected) {
+		t.Fatalf("%s differs from the golden message:\n%s", name, document)
+	}
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "iso20022", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
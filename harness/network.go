@@ -0,0 +1,332 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package harness runs the chaincodes of this repository in memory, without a peer, so their functions can be
+// exercised end to end with go test.
+package harness
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Network is a channel with chaincodes deployed on it, each with its own world state.
+// A transaction commits its writes to every chaincode it reached only when the invoked function succeeds
+type Network struct {
+	ChannelID string
+	// Now is the timestamp of the transactions that follow
+	Now time.Time
+	// Events are the chaincode events of committed transactions in the order they were set.
+	// A peer only delivers the last event of the invoked chaincode, the harness keeps all of them
+	Events     []Event
+	chaincodes map[string]*chaincode
+	txCount    int
+}
+
+// Event is a chaincode event set during a transaction
+type Event struct {
+	TxID      string
+	Chaincode string
+	Name      string
+	Payload   []byte
+}
+
+type chaincode struct {
+	cc    shim.Chaincode
+	state map[string][]byte
+}
+
+// transaction holds the writes and events of a transaction until it is committed, a nil value deletes its key
+type transaction struct {
+	id        string
+	timestamp time.Time
+	writes    map[string]map[string][]byte
+	events    []Event
+}
+
+// NewNetwork returns a network without chaincodes on the given channel
+func NewNetwork(channelID string) *Network {
+	return &Network{
+		ChannelID:  channelID,
+		Now:        time.Date(2017, time.March, 20, 12, 0, 0, 0, time.UTC),
+		chaincodes: make(map[string]*chaincode),
+	}
+}
+
+// Deploy installs a chaincode under a name and calls its Init with the arguments, as instantiation does
+func (n *Network) Deploy(name string, cc shim.Chaincode, args ...string) pb.Response {
+	n.chaincodes[name] = &chaincode{cc: cc, state: make(map[string][]byte)}
+	tx := n.begin()
+	response := cc.Init(n.stub(tx, name, args))
+	if response.Status < shim.ERROR {
+		n.commit(tx)
+	}
+	return response
+}
+
+// Invoke submits a transaction calling a function of a chaincode, the first argument is the function name
+func (n *Network) Invoke(name string, args ...string) pb.Response {
+	tx := n.begin()
+	response := n.call(tx, name, toBytes(args))
+	if response.Status < shim.ERROR {
+		n.commit(tx)
+	}
+	return response
+}
+
+// Query calls a function of a chaincode and discards what it wrote, as evaluating a transaction does
+func (n *Network) Query(name string, args ...string) pb.Response {
+	return n.call(n.begin(), name, toBytes(args))
+}
+
+// GetState returns the committed value of a key in the world state of a chaincode
+func (n *Network) GetState(name string, key string) []byte {
+	if c, ok := n.chaincodes[name]; ok {
+		return c.state[key]
+	}
+	return nil
+}
+
+// PutState writes a value straight into the world state of a chaincode, for records no function creates
+func (n *Network) PutState(name string, key string, value []byte) {
+	if c, ok := n.chaincodes[name]; ok {
+		c.state[key] = value
+	}
+}
+
+// Keys returns the keys in the world state of a chaincode in order
+func (n *Network) Keys(name string) []string {
+	keys := []string{}
+	if c, ok := n.chaincodes[name]; ok {
+		for key := range c.state {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (n *Network) begin() *transaction {
+	n.txCount++
+	return &transaction{
+		id:        "tx" + strconv.Itoa(n.txCount),
+		timestamp: n.Now,
+		writes:    make(map[string]map[string][]byte),
+	}
+}
+
+func (n *Network) commit(tx *transaction) {
+	for name, writes := range tx.writes {
+		state := n.chaincodes[name].state
+		for key, value := range writes {
+			if value == nil {
+				delete(state, key)
+			} else {
+				state[key] = value
+			}
+		}
+	}
+	n.Events = append(n.Events, tx.events...)
+}
+
+func (n *Network) call(tx *transaction, name string, args [][]byte) pb.Response {
+	c, ok := n.chaincodes[name]
+	if !ok {
+		return shim.Error("chaincode " + name + " is not deployed")
+	}
+	return c.cc.Invoke(&Stub{network: n, tx: tx, name: name, args: args})
+}
+
+func (n *Network) stub(tx *transaction, name string, args []string) *Stub {
+	return &Stub{network: n, tx: tx, name: name, args: toBytes(args)}
+}
+
+func toBytes(args []string) [][]byte {
+	bytes := make([][]byte, len(args))
+	for i, arg := range args {
+		bytes[i] = []byte(arg)
+	}
+	return bytes
+}
+
+// Stub is the stub a chaincode gets for a call in a transaction of the network.
+// Reads see the writes made earlier in the same transaction; functions of the stub that the chaincodes
+// of this repository do not use are left out and panic when called
+type Stub struct {
+	shim.ChaincodeStubInterface
+	network *Network
+	tx      *transaction
+	name    string
+	args    [][]byte
+}
+
+// GetArgs returns the arguments of the call, the function name first
+func (s *Stub) GetArgs() [][]byte {
+	return s.args
+}
+
+// GetStringArgs returns the arguments of the call as strings, the function name first
+func (s *Stub) GetStringArgs() []string {
+	args := make([]string, len(s.args))
+	for i, arg := range s.args {
+		args[i] = string(arg)
+	}
+	return args
+}
+
+// GetFunctionAndParameters returns the function name and the arguments that follow it
+func (s *Stub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+// GetTxID returns the id of the transaction
+func (s *Stub) GetTxID() string {
+	return s.tx.id
+}
+
+// GetChannelID returns the channel of the network
+func (s *Stub) GetChannelID() string {
+	return s.network.ChannelID
+}
+
+// GetTxTimestamp returns the timestamp of the transaction
+func (s *Stub) GetTxTimestamp() (*timestamppb.Timestamp, error) {
+	return timestamppb.New(s.tx.timestamp), nil
+}
+
+// InvokeChaincode calls a function of another chaincode of the network within the same transaction
+func (s *Stub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) pb.Response {
+	if channel != "" && channel != s.network.ChannelID {
+		return shim.Error("channel " + channel + " is not joined")
+	}
+	return s.network.call(s.tx, chaincodeName, args)
+}
+
+// GetState returns the value of a key, as written earlier in the transaction or else as committed
+func (s *Stub) GetState(key string) ([]byte, error) {
+	if value, ok := s.tx.writes[s.name][key]; ok {
+		return copyBytes(value), nil
+	}
+	return copyBytes(s.network.chaincodes[s.name].state[key]), nil
+}
+
+// PutState writes a value for the transaction, an empty value deletes the key as it does on a peer
+func (s *Stub) PutState(key string, value []byte) error {
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+	s.write(key, copyBytes(value))
+	return nil
+}
+
+// DelState deletes a key for the transaction
+func (s *Stub) DelState(key string) error {
+	s.write(key, nil)
+	return nil
+}
+
+// GetStateByRange iterates in order over the keys from startKey up to but not including endKey,
+// an empty endKey has no upper bound
+func (s *Stub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	seen := make(map[string]bool)
+	results := []*queryresult.KV{}
+	add := func(key string) {
+		if seen[key] || key < startKey || (endKey != "" && key >= endKey) {
+			return
+		}
+		seen[key] = true
+		if value, _ := s.GetState(key); value != nil {
+			results = append(results, &queryresult.KV{Namespace: s.name, Key: key, Value: value})
+		}
+	}
+	for key := range s.tx.writes[s.name] {
+		add(key)
+	}
+	for key := range s.network.chaincodes[s.name].state {
+		add(key)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Key < results[j].Key })
+	return &iterator{results: results}, nil
+}
+
+// SetEvent records an event for the transaction
+func (s *Stub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("event name can not be empty string")
+	}
+	s.tx.events = append(s.tx.events, Event{TxID: s.tx.id, Chaincode: s.name, Name: name, Payload: copyBytes(payload)})
+	return nil
+}
+
+func (s *Stub) write(key string, value []byte) {
+	if s.tx.writes[s.name] == nil {
+		s.tx.writes[s.name] = make(map[string][]byte)
+	}
+	s.tx.writes[s.name][key] = value
+}
+
+func copyBytes(value []byte) []byte {
+	if len(value) == 0 {
+		return nil
+	}
+	return append([]byte{}, value...)
+}
+
+type iterator struct {
+	shim.StateQueryIteratorInterface
+	results []*queryresult.KV
+}
+
+func (i *iterator) HasNext() bool {
+	return len(i.results) > 0
+}
+
+func (i *iterator) Next() (*queryresult.KV, error) {
+	if len(i.results) == 0 {
+		return nil, errors.New("no more results")
+	}
+	kv := i.results[0]
+	i.results = i.results[1:]
+	return kv, nil
+}
+
+func (i *iterator) Close() error {
+	return nil
+}
+
+// JSON marshals a value into the single JSON object argument functions accept
+func JSON(v interface{}) string {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(payload)
+}
@@ -0,0 +1,151 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package harness
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mukutb/TCM/Account"
+	"github.com/mukutb/TCM/Allocation"
+	"github.com/mukutb/TCM/batch"
+)
+
+// Allocated one at a time the earlier call of D-1 takes stocks the later call of D-STK, which accepts nothing else,
+// cannot do without. Optimised together D-1 is covered with bonds and cash first and both calls are allocated
+func TestOptimisedAllocationCoversAllCalls(t *testing.T) {
+	tcm := newOptimiserTCM(t)
+	summary := runAllocations(t, tcm, batch.Options{})
+	if summary.Allocated != 1 || summary.Pending != 1 || summary.Transactions[1].TransactionID != "T-STK" {
+		t.Fatalf("expected T-STK left short by the allocation of T-ANY, got %+v", summary)
+	}
+
+	tcm = newOptimiserTCM(t)
+	result := optimiseAllocations(t, tcm, "false")
+	if result.CallsCovered != 2 || len(result.Calls) != 2 || result.PledgerLongboxAccount != "LB-1" {
+		t.Fatalf("expected both calls covered from LB-1, got %+v", result)
+	}
+	stocks := result.Calls[1]
+	if stocks.TransactionID != "T-STK" || stocks.Shortfall != "0.00" || len(stocks.Collateral) != 1 ||
+		stocks.Collateral[0].SecurityID != "IBM" || stocks.Collateral[0].Quantity != "688.00" {
+		t.Fatalf("expected T-STK covered with 688 IBM, got %+v", stocks)
+	}
+	if ibm, ok := result.Unassigned["IBM"]; ok {
+		t.Errorf("expected every IBM assigned, got %s left", ibm)
+	}
+	if reserved := availability(t, tcm, "LB-1")["IBM"].Reserved; reserved != "0.00" {
+		t.Fatalf("expected nothing reserved without 'reserve', got %s", reserved)
+	}
+
+	summary = runAllocations(t, tcm, batch.Options{Optimise: true})
+	if summary.Allocated != 2 || len(summary.Optimisations) != 1 || !summary.Optimisations[0].Reserved {
+		t.Fatalf("expected both calls allocated after reserving, got %+v", summary)
+	}
+	// Each allocation checked its invariants as it ran, the accounts can still vouch for the last one
+	if result := checkAllocation(t, tcm, "T-STK"); !result.Passed {
+		t.Fatalf("expected the allocation of T-STK to keep its invariants, got %+v", result.Violations)
+	}
+	for _, id := range []string{"T-ANY", "T-STK"} {
+		var reservation account.Reservations
+		json.Unmarshal(tcm.GetState(AccountChaincode, "_reservation-"+id+"-LB-1"), &reservation)
+		if reservation.Status != "Committed" {
+			t.Errorf("expected the reservation of %s committed by its allocation, got %+v", id, reservation)
+		}
+	}
+	// Each call moved exactly the collateral it was assigned
+	for _, call := range summary.Optimisations[0].Calls {
+		var movements []allocation.Movements
+		json.Unmarshal(mustQuery(t, tcm, AllocationChaincode, "getMovements_byTransactionID", call.TransactionID), &movements)
+		moved := make(map[string]string)
+		for _, movement := range movements {
+			moved[movement.Security.SecurityId] = movement.Security.SecuritiesQuantity
+		}
+		if len(moved) != len(call.Collateral) {
+			t.Errorf("expected %s to move %+v, got %v", call.TransactionID, call.Collateral, moved)
+		}
+		for _, collateral := range call.Collateral {
+			if moved[collateral.SecurityID] != collateral.Quantity {
+				t.Errorf("expected %s to move %s %s as assigned, got %v", call.TransactionID, collateral.Quantity, collateral.SecurityID, moved)
+			}
+		}
+	}
+}
+
+// A call the longbox cannot cover reserves nothing, the covered call reserves only the collateral it is assigned
+func TestOptimiserReservesOnlyCoveredCalls(t *testing.T) {
+	tcm := newOptimiserTCM(t)
+	setRQV(t, tcm, "T-ANY", "100000")
+	setRQV(t, tcm, "T-STK", "200000")
+	result := optimiseAllocations(t, tcm, "true")
+	if result.CallsCovered != 1 || len(result.Calls) != 2 {
+		t.Fatalf("expected only T-ANY covered, got %+v", result)
+	}
+	for _, call := range result.Calls {
+		var reservation account.Reservations
+		json.Unmarshal(tcm.GetState(AccountChaincode, "_reservation-"+call.TransactionID+"-LB-1"), &reservation)
+		switch call.TransactionID {
+		case "T-STK":
+			if call.Shortfall == "0.00" || call.ExpiresAt != "" || reservation.Status != "Released" {
+				t.Errorf("expected nothing reserved for T-STK, got %+v and %+v", call, reservation)
+			}
+		case "T-ANY":
+			if reservation.Status != "Reserved" || len(reservation.Quantities) != len(call.Collateral) {
+				t.Errorf("expected the collateral of T-ANY reserved, got %+v for %+v", reservation, call.Collateral)
+			}
+			for _, collateral := range call.Collateral {
+				if collateral.AccountNumber == "LB-1" && reservation.Quantities[collateral.SecurityID] != collateral.Quantity {
+					t.Errorf("expected %s %s reserved for T-ANY, got %+v", collateral.Quantity, collateral.SecurityID, reservation.Quantities)
+				}
+			}
+		}
+	}
+}
+
+// newOptimiserTCM adds D-STK, a deal of PledgerA with PledgeeC that only accepts stocks, to the fixture. T-ANY calls
+// 200000 on D-1 and T-STK 100104, 688 IBM at their effective value, on D-STK a day later. LB-1 holds enough stocks
+// for both only if D-1 takes few
+func newOptimiserTCM(t *testing.T) *TCM {
+	tcm := newTCM(t)
+	tcm.API.SetRuleset("PledgerA", "PledgeeC", allocation.Ruleset{
+		Security: map[string]map[string]float64{
+			"Common Stocks": {"Concentration Limit": 100, "Priority": 1, "Valuation Percentage": 97},
+		},
+		BaseCurrency: "USD",
+		Version:      "1",
+	})
+	mustInvoke(t, tcm, DealChaincode, "create_deal", JSON(map[string]string{"dealId": "D-STK", "pledger": "PledgerA", "pledgee": "PledgeeC",
+		"maxValue": "1000000", "totalValueLongBoxAccount": "0", "totalValueSegregatedAccount": "0", "issueDate": "2017-03-01",
+		"lastSuccessfulAllocationDate": "2017-03-01", "transactions": "", "eligibleCollateral": "Common Stocks"}))
+	mustInvoke(t, tcm, AccountChaincode, "create_account", JSON(map[string]string{"accountId": "SG-2", "accountName": "PledgeeC",
+		"accountNumber": "SG-2", "accountType": "Segregated", "totalValue": "0", "currency": "USD", "pledger": "PledgerA", "securities": ""}))
+	createTransaction(t, tcm, "T-ANY", "D-1", "PledgerA", "PledgeeB", "1490011200", "Matched")
+	setRQV(t, tcm, "T-ANY", "200000")
+	createTransaction(t, tcm, "T-STK", "D-STK", "PledgerA", "PledgeeC", "1490097600", "Matched")
+	setRQV(t, tcm, "T-STK", "100104")
+	return tcm
+}
+
+func optimiseAllocations(t *testing.T, tcm *TCM, reserve string) allocation.OptimisationResult {
+	t.Helper()
+	var result allocation.OptimisationResult
+	json.Unmarshal(mustInvoke(t, tcm, AllocationChaincode, "optimise_allocations", DealChaincode, AccountChaincode,
+		tcm.API.Host(), "PledgerA", reserve), &result)
+	return result
+}
@@ -0,0 +1,167 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package harness
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mukutb/TCM/Account"
+	"github.com/mukutb/TCM/Allocation"
+)
+
+// A call short of collateral reserves nothing, so the call of another deal on the same longbox can still allocate the
+// collateral it could not use. An allocation reserves the quantities it plans to deliver and commits the reservation
+// once the movements are instructed
+func TestReservationsPreventDoubleAllocation(t *testing.T) {
+	tcm := newTCM(t)
+	tcm.API.SetRuleset("PledgerA", "PledgeeB", allocation.Ruleset{
+		Security: map[string]map[string]float64{
+			"Common Stocks":   {"Concentration Limit": 100, "Priority": 1, "Valuation Percentage": 97},
+			"Corporate Bonds": {"Concentration Limit": 100, "Priority": 2, "Valuation Percentage": 97},
+			"Cash":            {"Concentration Limit": 100, "Priority": 16, "Valuation Percentage": 100},
+		},
+		BaseCurrency:     "USD",
+		EligibleCurrency: []string{"USD"},
+		Version:          "1",
+	})
+	for _, id := range []string{"D-STK", "D-STK2"} {
+		mustInvoke(t, tcm, DealChaincode, "create_deal", JSON(map[string]string{"dealId": id, "pledger": "PledgerA", "pledgee": "PledgeeB",
+			"maxValue": "1000000", "totalValueLongBoxAccount": "0", "totalValueSegregatedAccount": "0", "issueDate": "2017-03-01",
+			"lastSuccessfulAllocationDate": "2017-03-01", "transactions": "", "eligibleCollateral": "Common Stocks"}))
+	}
+	mustInvoke(t, tcm, AccountChaincode, "create_account", JSON(map[string]string{"accountId": "SG-3", "accountName": "PledgeeB",
+		"accountNumber": "SG-3", "accountType": "Segregated", "totalValue": "0", "currency": "USD", "pledger": "PledgerA", "securities": ""}))
+
+	// 174600 is 1200 IBM at their effective value, the longbox holds 1000 so the call waits and reserves none of them
+	createTransaction(t, tcm, "T-STK", "D-STK", "PledgerA", "PledgeeB", "1490011200", "Matched")
+	mustInvoke(t, tcm, DealChaincode, "update_transaction_AllocationStatus", "T-STK", "Ready for Allocation")
+	setRQV(t, tcm, "T-STK", "174600")
+	startAllocation(t, tcm, "D-STK", "T-STK", "SG-1")
+	if status := transactionStatus(t, tcm, "T-STK"); status != "Pending due to insufficient collateral" {
+		t.Fatalf("expected T-STK to wait for collateral, got %q", status)
+	}
+	if reserved := availability(t, tcm, "LB-1")["IBM"].Reserved; reserved != "0.00" {
+		t.Fatalf("expected nothing reserved for T-STK, got %s", reserved)
+	}
+
+	// 14550 is 100 IBM, the call of D-STK2 is allocated the stocks T-STK could not use
+	createTransaction(t, tcm, "T-STK2", "D-STK2", "PledgerA", "PledgeeB", "1490011200", "Matched")
+	mustInvoke(t, tcm, DealChaincode, "update_transaction_AllocationStatus", "T-STK2", "Ready for Allocation")
+	setRQV(t, tcm, "T-STK2", "14550")
+	startAllocation(t, tcm, "D-STK2", "T-STK2", "SG-3")
+	if status := transactionStatus(t, tcm, "T-STK2"); status != "Pending settlement" {
+		t.Fatalf("expected T-STK2 allocated, got %q", status)
+	}
+	var movements []allocation.Movements
+	json.Unmarshal(mustQuery(t, tcm, AllocationChaincode, "getMovements_byTransactionID", "T-STK2"), &movements)
+	if len(movements) != 1 || movements[0].Security.SecurityId != "IBM" || movements[0].Security.SecuritiesQuantity != "100.00" {
+		t.Fatalf("expected 100 IBM allocated to T-STK2, got %+v", movements)
+	}
+	assertCommitted(t, tcm, "T-STK2", map[string]string{"IBM": "100.00"})
+
+	// Once the longbox holds enough stocks T-STK plans, reserves and commits the 1200 IBM it is allocated
+	addSecurity(t, tcm, "LB-1", "IBM", "Common Stocks", "2000", "150")
+	startAllocation(t, tcm, "D-STK", "T-STK", "SG-1")
+	if status := transactionStatus(t, tcm, "T-STK"); status != "Pending settlement" {
+		t.Fatalf("expected T-STK allocated, got %q", status)
+	}
+	assertCommitted(t, tcm, "T-STK", map[string]string{"IBM": "1200.00"})
+	if ibm := availability(t, tcm, "LB-1")["IBM"]; ibm.Reserved != "0.00" {
+		t.Fatalf("expected the committed reservations to hold nothing back, got %+v", ibm)
+	}
+	if result := checkAllocation(t, tcm, "T-STK"); !result.Passed {
+		t.Fatalf("expected the allocation of T-STK to keep its invariants, got %+v", result.Violations)
+	}
+}
+
+// An abandoned reservation stops holding quantity back once it expires and expire_reservations closes it
+func TestReservationsExpire(t *testing.T) {
+	tcm := newTCM(t)
+	mustInvoke(t, tcm, AccountChaincode, "reserve_securities", "T-1", "LB-1", `{"IBM":"400","CB-1":"0"}`, "1490097600")
+	if ibm := availability(t, tcm, "LB-1")["IBM"]; ibm.Reserved != "400.00" || ibm.Available != "600.00" {
+		t.Fatalf("expected 400 IBM reserved, got %+v", ibm)
+	}
+	if ibm := availabilityFor(t, tcm, "LB-1", "T-1")["IBM"]; ibm.Available != "1000.00" {
+		t.Fatalf("expected the reservation of T-1 available to T-1, got %+v", ibm)
+	}
+	if closed := mustInvoke(t, tcm, AccountChaincode, "expire_reservations"); string(closed) != "[]" {
+		t.Fatalf("expected nothing to expire yet, got %s", closed)
+	}
+
+	tcm.Now = tcm.Now.Add(48 * time.Hour)
+	if ibm := availability(t, tcm, "LB-1")["IBM"]; ibm.Reserved != "0.00" {
+		t.Fatalf("expected the expired reservation to hold nothing back, got %+v", ibm)
+	}
+	var closed []account.Reservations
+	json.Unmarshal(mustInvoke(t, tcm, AccountChaincode, "expire_reservations"), &closed)
+	if len(closed) != 1 || closed[0].Status != "Expired" || closed[0].Quantities["IBM"] != "400.00" {
+		t.Fatalf("expected the reservation of T-1 expired, got %+v", closed)
+	}
+}
+
+func startAllocation(t *testing.T, tcm *TCM, dealId string, id string, segregated string) {
+	t.Helper()
+	mustInvoke(t, tcm, AllocationChaincode, "start_allocation", DealChaincode, AccountChaincode, tcm.API.Host(), dealId, id,
+		"LB-1", segregated, "1490011200")
+}
+
+// setRQV overwrites the RQV of a transaction in the world state
+func setRQV(t *testing.T, tcm *TCM, id string, rqv string) {
+	t.Helper()
+	record := make(map[string]interface{})
+	if err := json.Unmarshal(tcm.GetState(DealChaincode, id), &record); err != nil {
+		t.Fatal(err)
+	}
+	record["rqv"] = rqv
+	tcm.PutState(DealChaincode, id, []byte(JSON(record)))
+}
+
+func availability(t *testing.T, tcm *TCM, accountNumber string) map[string]account.PositionAvailability {
+	t.Helper()
+	return availabilityFor(t, tcm, accountNumber, "")
+}
+
+func availabilityFor(t *testing.T, tcm *TCM, accountNumber string, transactionId string) map[string]account.PositionAvailability {
+	t.Helper()
+	var positions []account.PositionAvailability
+	json.Unmarshal(mustQuery(t, tcm, AccountChaincode, "getAvailability_byAccount", accountNumber, transactionId), &positions)
+	bySecurity := make(map[string]account.PositionAvailability)
+	for _, position := range positions {
+		bySecurity[position.SecurityID] = position
+	}
+	return bySecurity
+}
+
+// assertCommitted checks the reservation of a transaction on LB-1 was committed with the quantities it planned
+func assertCommitted(t *testing.T, tcm *TCM, id string, quantities map[string]string) {
+	t.Helper()
+	var reservation account.Reservations
+	json.Unmarshal(tcm.GetState(AccountChaincode, "_reservation-"+id+"-LB-1"), &reservation)
+	if reservation.Status != "Committed" || len(reservation.Quantities) != len(quantities) {
+		t.Fatalf("expected the reservation of %s committed with %v, got %+v", id, quantities, reservation)
+	}
+	for securityId, quantity := range quantities {
+		if reservation.Quantities[securityId] != quantity {
+			t.Fatalf("expected %s %s committed for %s, got %+v", quantity, securityId, id, reservation.Quantities)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package harness
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mukutb/TCM/Allocation"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected results of the allocation scenarios and the golden messages with the actual ones")
+
+// Scenario is a fixture of testdata/allocation: what the API serves, the holdings of the accounts of a deal and
+// a margin call on it, with the result start_allocation is expected to give
+type Scenario struct {
+	Description string                        `json:"description"`
+	Ruleset     allocation.Ruleset            `json:"ruleset"`
+	Rates       map[string]map[string]float64 `json:"rates"`
+	Prices      map[string]string             `json:"prices"`
+	// Deal are fields of create_deal on top of those every scenario uses, such as the CSA terms
+	Deal        map[string]string `json:"deal,omitempty"`
+	Transaction struct {
+		RQV       string `json:"rqv"`
+		Currency  string `json:"currency"`
+		Direction string `json:"direction,omitempty"`
+	} `json:"transaction"`
+	Longbox    []Holding `json:"longbox"`
+	Segregated []Holding `json:"segregated"`
+	Expected   Outcome   `json:"expected"`
+}
+
+// Holding is a position in an account, its mtm is the price of the API when not given
+type Holding struct {
+	SecurityID     string `json:"securityId"`
+	CollateralForm string `json:"collateralForm"`
+	Quantity       string `json:"quantity"`
+	Currency       string `json:"currency"`
+	MTM            string `json:"mtm,omitempty"`
+}
+
+// Outcome is the result of an allocation: the status of the call, the movements it instructed and the
+// quantities left in each account once all of them settled
+type Outcome struct {
+	AllocationStatus string            `json:"allocationStatus"`
+	ComplianceStatus string            `json:"complianceStatus"`
+	Movements        []Moved           `json:"movements"`
+	Longbox          map[string]string `json:"longbox"`
+	Segregated       map[string]string `json:"segregated"`
+}
+
+// Moved is the quantity of a security a movement delivers
+type Moved struct {
+	SecurityID string `json:"securityId"`
+	Direction  string `json:"direction"`
+	Quantity   string `json:"quantity"`
+}
+
+const scenarioTransaction = "D-1-MC-1"
+
+// TestAllocationScenarios runs every fixture of testdata/allocation and compares the outcome with the expected one.
+// With -update the fixtures are rewritten with the actual outcome, so a change to the algorithm shows as their diff
+func TestAllocationScenarios(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "allocation", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no scenarios in testdata/allocation")
+	}
+	for _, file := range files {
+		file := file
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".json"), func(t *testing.T) {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var scenario Scenario
+			if err := json.Unmarshal(content, &scenario); err != nil {
+				t.Fatalf("%s: %v", file, err)
+			}
+			actual := runScenario(t, scenario)
+			if *update {
+				scenario.Expected = actual
+				content, err := json.MarshalIndent(scenario, "", "  ")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(file, append(content, '\n'), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			for _, difference := range compareOutcomes(scenario.Expected, actual) {
+				t.Error(difference)
+			}
+		})
+	}
+}
+
+// runScenario allocates the margin call of a scenario on a new TCM and settles every movement it instructed
+func runScenario(t *testing.T, scenario Scenario) Outcome {
+	tcm, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcm.Close()
+	tcm.API.SetRuleset("PledgerA", "PledgeeB", scenario.Ruleset)
+	for base, rates := range scenario.Rates {
+		tcm.API.SetRates(base, rates)
+	}
+	for id, price := range scenario.Prices {
+		tcm.API.SetPrice(id, price)
+	}
+
+	for _, account := range []string{"LB-1", "SG-1"} {
+		mustInvoke(t, tcm, AccountChaincode, "create_account", JSON(map[string]string{"accountId": account, "accountName": account,
+			"accountNumber": account, "accountType": "", "totalValue": "0", "currency": scenario.Transaction.Currency,
+			"pledger": "PledgerA", "securities": ""}))
+	}
+	for account, holdings := range map[string][]Holding{"LB-1": scenario.Longbox, "SG-1": scenario.Segregated} {
+		for _, holding := range holdings {
+			mtm := holding.MTM
+			if mtm == "" {
+				mtm = scenario.Prices[holding.SecurityID]
+			}
+			mustInvoke(t, tcm, AccountChaincode, "add_security", JSON(map[string]string{"securityId": holding.SecurityID,
+				"accountNumber": account, "securityName": holding.SecurityID, "securityQuantity": holding.Quantity,
+				"securityType": holding.CollateralForm, "collateralForm": holding.CollateralForm, "totalValue": "0",
+				"valuePercentage": "0", "mtm": mtm, "effectivePercentage": "0", "effectiveValueinUSD": "0",
+				"currency": holding.Currency}))
+		}
+	}
+	deal := map[string]string{"dealId": "D-1", "pledger": "PledgerA", "pledgee": "PledgeeB", "maxValue": "0",
+		"totalValueLongBoxAccount": "0", "totalValueSegregatedAccount": "0", "issueDate": "2017-03-01",
+		"lastSuccessfulAllocationDate": "2017-03-01", "transactions": ""}
+	for field, value := range scenario.Deal {
+		deal[field] = value
+	}
+	mustInvoke(t, tcm, DealChaincode, "create_deal", JSON(deal))
+	direction := scenario.Transaction.Direction
+	if direction == "" {
+		direction = "Call"
+	}
+	mustInvoke(t, tcm, DealChaincode, "create_transaction", scenarioTransaction, "1490011200", "D-1", "PledgerA", "PledgeeB",
+		scenario.Transaction.RQV, scenario.Transaction.Currency, "1490011200", "Matched", direction)
+
+	mustInvoke(t, tcm, AllocationChaincode, "start_allocation", DealChaincode, AccountChaincode, tcm.API.Host(), "D-1",
+		scenarioTransaction, "LB-1", "SG-1", "1490011200")
+	var movements []allocation.Movements
+	json.Unmarshal(mustQuery(t, tcm, AllocationChaincode, "getMovements_byTransactionID", scenarioTransaction), &movements)
+	outcome := Outcome{Movements: []Moved{}}
+	for _, movement := range movements {
+		outcome.Movements = append(outcome.Movements, Moved{SecurityID: movement.Security.SecurityId,
+			Direction: movement.Direction, Quantity: movement.Quantity})
+		mustInvoke(t, tcm, AllocationChaincode, "update_settlement_status", AccountChaincode, DealChaincode, movement.MovementID,
+			"Settled", movement.Quantity, "")
+	}
+
+	var transaction allocation.Transactions
+	json.Unmarshal(mustQuery(t, tcm, DealChaincode, "getTransaction_byID", scenarioTransaction), &transaction)
+	outcome.AllocationStatus = transaction.AllocationStatus
+	outcome.ComplianceStatus = transaction.ComplianceStatus
+	outcome.Longbox = balances(t, tcm, "LB-1")
+	outcome.Segregated = balances(t, tcm, "SG-1")
+	return outcome
+}
+
+// balances are the quantities an account holds by security
+func balances(t *testing.T, tcm *TCM, account string) map[string]string {
+	var held []allocation.Securities
+	json.Unmarshal(mustQuery(t, tcm, AccountChaincode, "getSecurities_byAccount", account), &held)
+	quantities := make(map[string]float64)
+	for _, security := range held {
+		quantity, _ := strconv.ParseFloat(security.SecuritiesQuantity, 64)
+		quantities[security.SecurityId] += quantity
+	}
+	balances := make(map[string]string)
+	for id, quantity := range quantities {
+		balances[id] = strconv.FormatFloat(quantity, 'f', 2, 64)
+	}
+	return balances
+}
+
+// compareOutcomes lists every way the actual outcome differs from the expected one
+func compareOutcomes(expected Outcome, actual Outcome) []string {
+	differences := []string{}
+	if expected.AllocationStatus != actual.AllocationStatus {
+		differences = append(differences, fmt.Sprintf("allocationStatus: expected %q, got %q", expected.AllocationStatus, actual.AllocationStatus))
+	}
+	if expected.ComplianceStatus != actual.ComplianceStatus {
+		differences = append(differences, fmt.Sprintf("complianceStatus: expected %q, got %q", expected.ComplianceStatus, actual.ComplianceStatus))
+	}
+	differences = append(differences, compareQuantities("movements", movedQuantities(expected.Movements), movedQuantities(actual.Movements))...)
+	differences = append(differences, compareQuantities("longbox", expected.Longbox, actual.Longbox)...)
+	differences = append(differences, compareQuantities("segregated", expected.Segregated, actual.Segregated)...)
+	return differences
+}
+
+func movedQuantities(movements []Moved) map[string]string {
+	quantities := make(map[string]string)
+	for _, movement := range movements {
+		quantities[movement.Direction+" "+movement.SecurityID] = movement.Quantity
+	}
+	return quantities
+}
+
+func compareQuantities(name string, expected map[string]string, actual map[string]string) []string {
+	keys := []string{}
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	for key := range actual {
+		if _, ok := expected[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	differences := []string{}
+	for _, key := range keys {
+		want, wanted := expected[key]
+		got, gotten := actual[key]
+		switch {
+		case !wanted:
+			differences = append(differences, fmt.Sprintf("%s %s: not expected, got %s", name, key, got))
+		case !gotten:
+			differences = append(differences, fmt.Sprintf("%s %s: expected %s, got none", name, key, want))
+		case want != got:
+			differences = append(differences, fmt.Sprintf("%s %s: expected %s, got %s", name, key, want, got))
+		}
+	}
+	return differences
+}
@@ -0,0 +1,149 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package harness
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mukutb/TCM/swift"
+)
+
+// Statements of testdata/swift are read with the account of their GENL block, sub-safekeeping accounts
+// only group the instruments
+func TestParseMT535(t *testing.T) {
+	tests := []struct {
+		file     string
+		account  string
+		holdings []swift.Holding
+		err      string
+	}{
+		{file: "mt535-subsafe.txt", account: "LB-1", holdings: []swift.Holding{
+			{SecurityId: "US4592001014", Description: "INTERNATIONAL BUSINESS MACHINES", QuantityType: "UNIT", Quantity: 1000, Price: 150, Currency: "USD", Value: 150000},
+			{SecurityId: "US912828V988", Description: "US TREASURY 2.25 15/02/2027", QuantityType: "FAMT", Quantity: 500000, Price: 98.5, Currency: "USD", Value: 492500},
+		}},
+		{file: "mt535-no-account.txt", err: "statement has no 97A::SAFE account"},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			statement, err := swift.ParseMT535(readSwift(t, test.file))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if statement.Account != test.account || !reflect.DeepEqual(statement.Holdings, test.holdings) {
+				t.Fatalf("expected %s holding %+v, got %+v", test.account, test.holdings, statement)
+			}
+		})
+	}
+}
+
+func TestParseMT536(t *testing.T) {
+	tests := []struct {
+		file      string
+		account   string
+		toDate    string
+		movements []swift.Movement
+	}{
+		{file: "mt536-subsafe.txt", account: "SG-1", toDate: "20170320", movements: []swift.Movement{
+			{SecurityId: "US4592001014", Description: "INTERNATIONAL BUSINESS MACHINES", Reference: "T1-IBM-CALL", QuantityType: "UNIT", Quantity: 137, Receive: true, SettlementDate: "20170321"},
+			{SecurityId: "US4592001014", Description: "INTERNATIONAL BUSINESS MACHINES", Reference: "T0-IBM-RTRN", QuantityType: "UNIT", Quantity: 37, SettlementDate: "20170320"},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			statement, err := swift.ParseMT536(readSwift(t, test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if statement.Account != test.account || statement.ToDate != test.toDate || !reflect.DeepEqual(statement.Movements, test.movements) {
+				t.Fatalf("expected %s moving %+v, got %+v", test.account, test.movements, statement)
+			}
+		})
+	}
+}
+
+// A statement is reconciled against the ledger and loaded with the invocations that bring the ledger in line
+func TestReconcileMT535(t *testing.T) {
+	statement, err := swift.ParseMT535(readSwift(t, "mt535-subsafe.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger := []swift.Position{
+		{SecurityId: "US4592001014", AccountNumber: "LB-1", SecurityQuantity: "900", CollateralForm: "Common Stocks"},
+		{SecurityId: "GB0002634946", AccountNumber: "LB-1", SecurityQuantity: "50", CollateralForm: "Common Stocks"},
+	}
+	report := statement.Reconcile(ledger)
+	statuses := []string{}
+	for _, reconciliationBreak := range report.Breaks {
+		statuses = append(statuses, reconciliationBreak.SecurityId+" "+reconciliationBreak.Status)
+	}
+	expected := []string{"GB0002634946 Missing in statement", "US4592001014 Quantity break", "US912828V988 Missing in ledger"}
+	if report.Account != "LB-1" || !reflect.DeepEqual(statuses, expected) {
+		t.Fatalf("expected breaks %v, got %+v", expected, report)
+	}
+
+	invocations := swift.LoadInvocations(statement.Positions("LB-1", map[string][2]string{"US912828V988": {"Bond", "US Treasury Bonds"}}, ledger), ledger)
+	functions := []string{}
+	for _, invocation := range invocations {
+		functions = append(functions, invocation.Function+" "+invocation.Args[0]+" "+invocation.Args[3])
+	}
+	expected = []string{"update_security US4592001014 1000.00", "add_security US912828V988 500000.00"}
+	if !reflect.DeepEqual(functions, expected) {
+		t.Fatalf("expected %v, got %v", expected, functions)
+	}
+}
+
+// The tri-party instruction of an allocation carries the RQV of its margin call
+func TestMT527(t *testing.T) {
+	message, err := swift.MT527(swift.AllocationResult{DealID: "D-1", TransactionID: "T-1", MarginCallDate: "1490011200",
+		Pledgee: "PledgeeB", Pledger: "PledgerA", RQV: "50000.5", Currency: "usd"},
+		swift.TriPartyInstruction{Sender: "BANKGB2L", Receiver: "TRPYBEBBXXX", Reference: "REF-1", InstructionType: "INIT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"{1:F01BANKGB2LXXXX0000000000}{2:I527TRPYBEBBXXXXN}{4:\r\n", ":98A::EXRQ//20170320\r\n",
+		":95Q::PTYA//PledgerA\r\n", ":19A::TRAA//USD50000,5\r\n", "\r\n-}"} {
+		if !strings.Contains(message, field) {
+			t.Fatalf("expected %q in\n%s", field, message)
+		}
+	}
+	fields, err := swift.Fields(message)
+	if err != nil || len(fields) != 20 {
+		t.Fatalf("expected the instruction to read back as 20 fields, got %d %v", len(fields), err)
+	}
+}
+
+func readSwift(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "swift", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
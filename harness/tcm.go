@@ -0,0 +1,76 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package harness
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/mukutb/TCM/Account"
+	"github.com/mukutb/TCM/Allocation"
+	"github.com/mukutb/TCM/Deal"
+)
+
+// Names the chaincodes are deployed under, the chaincode arguments of cross-chaincode functions
+const (
+	AccountChaincode    = "Account"
+	DealChaincode       = "Deal"
+	AllocationChaincode = "Allocation"
+)
+
+// TCM is a network with the Account, Deal and Allocation chaincodes deployed together and the API allocation calls.
+// Allocation and Deal read the exchange rates from the API of the TCM created last, so TCMs do not run in parallel
+type TCM struct {
+	*Network
+	API *API
+}
+
+// New deploys the three chaincodes on a new network and starts the API
+func New() (*TCM, error) {
+	tcm := &TCM{Network: NewNetwork("tcm"), API: NewAPI()}
+	allocation.ExchangeRateAPI = tcm.API.URL()
+	deal.ExchangeRateAPI = tcm.API.URL()
+	if err := tcm.deploy(AccountChaincode, new(account.ManageAccounts)); err != nil {
+		tcm.Close()
+		return nil, err
+	}
+	if err := tcm.deploy(DealChaincode, new(deal.ManageDeals)); err != nil {
+		tcm.Close()
+		return nil, err
+	}
+	if err := tcm.deploy(AllocationChaincode, new(allocation.ManageAllocations)); err != nil {
+		tcm.Close()
+		return nil, err
+	}
+	return tcm, nil
+}
+
+// Close stops the API
+func (tcm *TCM) Close() {
+	tcm.API.Close()
+}
+
+func (tcm *TCM) deploy(name string, cc shim.Chaincode) error {
+	response := tcm.Deploy(name, cc, "init", " ")
+	if response.Status != shim.OK {
+		return errors.New("deploying " + name + ": " + response.Message)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package harness
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/mukutb/TCM/Allocation"
+	"github.com/mukutb/TCM/validation"
+)
+
+// The collateral forms the chaincodes accept are those of the public rule set of the Allocation chaincode
+func TestCollateralFormsMatchPublicRuleSet(t *testing.T) {
+	forms := []string{}
+	for form := range allocation.SecurityJSON {
+		forms = append(forms, form)
+	}
+	accepted := append([]string{}, validation.CollateralForms...)
+	sort.Strings(forms)
+	sort.Strings(accepted)
+	if !reflect.DeepEqual(forms, accepted) {
+		t.Fatalf("expected the collateral forms %v, got %v", forms, accepted)
+	}
+}
@@ -0,0 +1,128 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package iso20022
+
+import (
+	"encoding/xml"
+	"errors"
+)
+
+// Message definition of the margin call request
+var marginCallRequestMessage = "colr.003.001.04"
+
+// MarginCallRequestDocument is a colr.003 margin call request
+type MarginCallRequestDocument struct {
+	XMLName xml.Name          `xml:"Document"`
+	Xmlns   string            `xml:"xmlns,attr"`
+	Request MarginCallRequest `xml:"MrgnCallReq"`
+}
+
+type MarginCallRequest struct {
+	TransactionID string           `xml:"TxId"`
+	Obligation    Obligation       `xml:"Oblgtn"`
+	Agreement     Agreement        `xml:"Agrmt"`
+	Result        MarginCallResult `xml:"MrgnCallRslt"`
+}
+
+// MarginCallResult carries the amount called, as a delivery from party B or a return to it
+type MarginCallResult struct {
+	Delivery *Amount `xml:"DlvrMrgnAmt,omitempty"`
+	Return   *Amount `xml:"RtrMrgnAmt,omitempty"`
+}
+
+// MarginCallRequestFromTransaction builds the colr.003 request of a margin call; party A is the pledgee calling party B, the pledger
+func MarginCallRequestFromTransaction(transaction Transaction, deal Deal, segregatedAccount string) ([]byte, error) {
+	err := required("transactionId", transaction.TransactionId, "dealId", transaction.DealID, "pledger", transaction.Pledger, "pledgee", transaction.Pledgee, "currency", transaction.Currency)
+	if err != nil {
+		return nil, err
+	}
+	valuationDate, err := isoDateTime(transaction.MarginCAllDate)
+	if err != nil {
+		return nil, err
+	}
+	value, err := amountValue(transaction.RQV)
+	if err != nil {
+		return nil, err
+	}
+	result := MarginCallResult{}
+	if transaction.Direction == "Return" {
+		result.Return = &Amount{Currency: transaction.Currency, Value: value}
+	} else {
+		result.Delivery = &Amount{Currency: transaction.Currency, Value: value}
+	}
+	return marshal(MarginCallRequestDocument{
+		Xmlns: namespace(marginCallRequestMessage),
+		Request: MarginCallRequest{
+			TransactionID: transaction.TransactionId,
+			Obligation: Obligation{
+				PartyA:            party(transaction.Pledgee),
+				PartyB:            party(transaction.Pledger),
+				CollateralAccount: accountID(segregatedAccount),
+				ValuationDate:     valuationDate,
+			},
+			Agreement: Agreement{AgreementID: transaction.DealID, BaseCurrency: deal.BaseCurrency},
+			Result:    result,
+		},
+	})
+}
+
+// ParseMarginCallRequest reads a colr.003 margin call request
+func ParseMarginCallRequest(data []byte) (MarginCallRequest, error) {
+	document := MarginCallRequestDocument{}
+	err := xml.Unmarshal(data, &document)
+	if err != nil {
+		return document.Request, err
+	}
+	if document.Xmlns != namespace(marginCallRequestMessage) {
+		return document.Request, errors.New("not a " + marginCallRequestMessage + " document: " + document.Xmlns)
+	}
+	return document.Request, nil
+}
+
+// CreateTransaction is the create_transaction invocation of the Deal chaincode that records a margin call request
+func (request MarginCallRequest) CreateTransaction() (Invocation, error) {
+	err := required("TxId", request.TransactionID, "PtyA", request.Obligation.PartyA.Name(), "PtyB", request.Obligation.PartyB.Name(), "AgrmtId", request.Agreement.AgreementID)
+	if err != nil {
+		return Invocation{}, err
+	}
+	direction := "Call"
+	amount := request.Result.Delivery
+	if amount == nil {
+		direction = "Return"
+		amount = request.Result.Return
+	}
+	if amount == nil {
+		return Invocation{}, errors.New("margin call result has no amount")
+	}
+	rqv, err := amountValue(amount.Value)
+	if err != nil {
+		return Invocation{}, err
+	}
+	marginCallDate, err := unixDate(request.Obligation.ValuationDate)
+	if err != nil {
+		return Invocation{}, err
+	}
+	return Invocation{
+		Chaincode: "Deal",
+		Function:  "create_transaction",
+		Args: []string{request.TransactionID, marginCallDate, request.Agreement.AgreementID,
+			request.Obligation.PartyB.Name(), request.Obligation.PartyA.Name(), rqv, amount.Currency, marginCallDate, "Matched", direction},
+	}, nil
+}
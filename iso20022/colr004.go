@@ -0,0 +1,157 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package iso20022
+
+import (
+	"encoding/xml"
+	"errors"
+	"strconv"
+)
+
+// Message definition of the margin call response
+var marginCallResponseMessage = "colr.004.001.04"
+
+// Response types of a margin call response
+var (
+	responseFullAgreement    = "FULL"
+	responsePartialAgreement = "PART"
+	responseDisputed         = "DISP"
+)
+
+// MarginCallResponseDocument is a colr.004 margin call response
+type MarginCallResponseDocument struct {
+	XMLName  xml.Name           `xml:"Document"`
+	Xmlns    string             `xml:"xmlns,attr"`
+	Response MarginCallResponse `xml:"MrgnCallRspn"`
+}
+
+type MarginCallResponse struct {
+	TransactionID string          `xml:"TxId"`
+	Obligation    Obligation      `xml:"Oblgtn"`
+	Agreement     Agreement       `xml:"Agrmt"`
+	Details       ResponseDetails `xml:"RspnDtls"`
+}
+
+// ResponseDetails tells how much of the amount called party B agrees to
+type ResponseDetails struct {
+	ResponseType string `xml:"RspnTp"`
+	CalledAmount Amount `xml:"CallAmt"`
+	AgreedAmount Amount `xml:"AgrdAmt"`
+	Reason       string `xml:"DsptRsn,omitempty"`
+}
+
+// MarginCallResponseFromTransaction builds the colr.004 response of the pledger to a margin call,
+// agreeing to 'agreedAmount' of the RQV and giving 'reason' for the rest
+func MarginCallResponseFromTransaction(transaction Transaction, agreedAmount string, reason string) ([]byte, error) {
+	err := required("transactionId", transaction.TransactionId, "dealId", transaction.DealID, "currency", transaction.Currency)
+	if err != nil {
+		return nil, err
+	}
+	valuationDate, err := isoDateTime(transaction.MarginCAllDate)
+	if err != nil {
+		return nil, err
+	}
+	called, err := amountValue(transaction.RQV)
+	if err != nil {
+		return nil, err
+	}
+	agreed, err := amountValue(agreedAmount)
+	if err != nil {
+		return nil, err
+	}
+	calledValue, _ := strconv.ParseFloat(called, 64)
+	agreedValue, _ := strconv.ParseFloat(agreed, 64)
+	if agreedValue > calledValue {
+		return nil, errors.New("agreed amount " + agreed + " is more than the amount called " + called)
+	}
+	responseType := responsePartialAgreement
+	if agreedValue == calledValue {
+		responseType = responseFullAgreement
+		reason = ""
+	} else if agreedValue == 0 {
+		responseType = responseDisputed
+	}
+	return marshal(MarginCallResponseDocument{
+		Xmlns: namespace(marginCallResponseMessage),
+		Response: MarginCallResponse{
+			TransactionID: transaction.TransactionId,
+			Obligation: Obligation{
+				PartyA:        party(transaction.Pledgee),
+				PartyB:        party(transaction.Pledger),
+				ValuationDate: valuationDate,
+			},
+			Agreement: Agreement{AgreementID: transaction.DealID},
+			Details: ResponseDetails{
+				ResponseType: responseType,
+				CalledAmount: Amount{Currency: transaction.Currency, Value: called},
+				AgreedAmount: Amount{Currency: transaction.Currency, Value: agreed},
+				Reason:       reason,
+			},
+		},
+	})
+}
+
+// ParseMarginCallResponse reads a colr.004 margin call response
+func ParseMarginCallResponse(data []byte) (MarginCallResponse, error) {
+	document := MarginCallResponseDocument{}
+	err := xml.Unmarshal(data, &document)
+	if err != nil {
+		return document.Response, err
+	}
+	if document.Xmlns != namespace(marginCallResponseMessage) {
+		return document.Response, errors.New("not a " + marginCallResponseMessage + " document: " + document.Xmlns)
+	}
+	return document.Response, nil
+}
+
+// RaiseDispute is the raise_dispute invocation of the Deal chaincode for the part of the call party B does not agree to.
+// A response in full agreement has nothing to dispute
+func (response MarginCallResponse) RaiseDispute() (Invocation, error) {
+	err := required("TxId", response.TransactionID, "PtyB", response.Obligation.PartyB.Name(), "RspnTp", response.Details.ResponseType)
+	if err != nil {
+		return Invocation{}, err
+	}
+	if response.Details.ResponseType == responseFullAgreement {
+		return Invocation{}, errors.New("margin call " + response.TransactionID + " is agreed in full")
+	}
+	called, err := amountValue(response.Details.CalledAmount.Value)
+	if err != nil {
+		return Invocation{}, err
+	}
+	agreed, err := amountValue(response.Details.AgreedAmount.Value)
+	if err != nil {
+		return Invocation{}, err
+	}
+	calledValue, _ := strconv.ParseFloat(called, 64)
+	agreedValue, _ := strconv.ParseFloat(agreed, 64)
+	if agreedValue >= calledValue {
+		return Invocation{}, errors.New("margin call " + response.TransactionID + " has no disputed amount")
+	}
+	reason := response.Details.Reason
+	if reason == "" {
+		reason = response.Details.ResponseType
+	}
+	return Invocation{
+		Chaincode: "Deal",
+		Function:  "raise_dispute",
+		Args: []string{response.TransactionID + "-DSP", response.TransactionID, response.Obligation.PartyB.Name(),
+			strconv.FormatFloat(calledValue-agreedValue, 'f', 2, 64), reason},
+	}, nil
+}
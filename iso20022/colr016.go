@@ -0,0 +1,143 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package iso20022
+
+import (
+	"encoding/xml"
+	"errors"
+	"strconv"
+)
+
+// Message definition of the collateral and exposure report
+var collateralAndExposureReportMessage = "colr.016.001.01"
+
+// Collateral form of cash positions in the Account chaincode
+var cashCollateralForm = "Cash"
+
+// CollateralAndExposureReportDocument is a colr.016 collateral and exposure report
+type CollateralAndExposureReportDocument struct {
+	XMLName xml.Name                    `xml:"Document"`
+	Xmlns   string                      `xml:"xmlns,attr"`
+	Report  CollateralAndExposureReport `xml:"CollAndXpsrRpt"`
+}
+
+type CollateralAndExposureReport struct {
+	ReportID   string           `xml:"RptParams>RptId"`
+	ReportDate string           `xml:"RptParams>RptDtTm"`
+	Obligation Obligation       `xml:"Oblgtn"`
+	Agreement  Agreement        `xml:"Agrmt"`
+	Collateral CollateralReport `xml:"CollRpt"`
+}
+
+// CollateralReport is the exposure of a margin call and the collateral allocated against it, valued in the exposure currency
+type CollateralReport struct {
+	Exposure         Amount                 `xml:"XpsdAmt"`
+	CollateralValue  Amount                 `xml:"CollVal"`
+	Status           string                 `xml:"Sts"`
+	ComplianceStatus string                 `xml:"CmplcSts,omitempty"`
+	Securities       []SecuritiesCollateral `xml:"SctiesColl"`
+	Cash             []CashCollateral       `xml:"CshColl"`
+}
+
+type SecuritiesCollateral struct {
+	SecurityID     string `xml:"SctyId>OthrId>Id"`
+	Description    string `xml:"SctyId>Desc,omitempty"`
+	CollateralForm string `xml:"CollTp,omitempty"`
+	Quantity       string `xml:"Qty>Unit"`
+	MarketValue    Amount `xml:"MktVal"`
+}
+
+type CashCollateral struct {
+	Amount Amount `xml:"CshAmt"`
+}
+
+// CollateralAndExposureReportFromAllocation builds the colr.016 report of an allocation outcome
+func CollateralAndExposureReportFromAllocation(report AllocationReport) ([]byte, error) {
+	err := required("Transaction ID", report.TransactionID, "Deal ID", report.DealID, "Pledger", report.Pledger, "Pledgee", report.Pledgee, "Currency", report.Currency, "Allocation Status", report.AllocationStatus)
+	if err != nil {
+		return nil, err
+	}
+	reportDate, err := isoDateTime(report.MarginCallDate)
+	if err != nil {
+		return nil, err
+	}
+	exposure, err := amountValue(report.RQV)
+	if err != nil {
+		return nil, err
+	}
+	collateral := CollateralReport{
+		Exposure:         Amount{Currency: report.Currency, Value: exposure},
+		Status:           report.AllocationStatus,
+		ComplianceStatus: report.ComplianceStatus,
+	}
+	collateralValue := 0.0
+	for _, security := range report.PledgeeSegregatedSecurities {
+		value, err := amountValue(security.TotalValue)
+		if err != nil {
+			return nil, errors.New(security.SecurityId + ": " + err.Error())
+		}
+		amount, _ := strconv.ParseFloat(value, 64)
+		collateralValue += amount
+		if security.CollateralForm == cashCollateralForm {
+			cash, err := amountValue(security.SecuritiesQuantity)
+			if err != nil {
+				return nil, errors.New(security.SecurityId + ": " + err.Error())
+			}
+			collateral.Cash = append(collateral.Cash, CashCollateral{Amount: Amount{Currency: security.Currency, Value: cash}})
+			continue
+		}
+		collateral.Securities = append(collateral.Securities, SecuritiesCollateral{
+			SecurityID:     security.SecurityId,
+			Description:    security.SecuritiesName,
+			CollateralForm: security.CollateralForm,
+			Quantity:       security.SecuritiesQuantity,
+			MarketValue:    Amount{Currency: report.Currency, Value: value},
+		})
+	}
+	collateral.CollateralValue = Amount{Currency: report.Currency, Value: strconv.FormatFloat(collateralValue, 'f', 2, 64)}
+	return marshal(CollateralAndExposureReportDocument{
+		Xmlns: namespace(collateralAndExposureReportMessage),
+		Report: CollateralAndExposureReport{
+			ReportID:   report.TransactionID,
+			ReportDate: reportDate,
+			Obligation: Obligation{
+				PartyA:            party(report.Pledgee),
+				PartyB:            party(report.Pledger),
+				CollateralAccount: accountID(report.PledgeeSegregatedAccount),
+				ValuationDate:     reportDate,
+			},
+			Agreement:  Agreement{AgreementID: report.DealID},
+			Collateral: collateral,
+		},
+	})
+}
+
+// ParseCollateralAndExposureReport reads a colr.016 collateral and exposure report
+func ParseCollateralAndExposureReport(data []byte) (CollateralAndExposureReport, error) {
+	document := CollateralAndExposureReportDocument{}
+	err := xml.Unmarshal(data, &document)
+	if err != nil {
+		return document.Report, err
+	}
+	if document.Xmlns != namespace(collateralAndExposureReportMessage) {
+		return document.Report, errors.New("not a " + collateralAndExposureReportMessage + " document: " + document.Xmlns)
+	}
+	return document.Report, nil
+}
@@ -0,0 +1,189 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package iso20022
+
+import (
+	"encoding/xml"
+	"errors"
+)
+
+// Message definitions of the collateral substitution request and response
+var (
+	substitutionRequestMessage  = "colr.019.001.01"
+	substitutionResponseMessage = "colr.020.001.01"
+)
+
+// Response types of a substitution response
+var (
+	substitutionAccepted = "ACCT"
+	substitutionRejected = "RJCT"
+)
+
+// SubstitutionRequestDocument is a colr.019 collateral substitution request
+type SubstitutionRequestDocument struct {
+	XMLName xml.Name            `xml:"Document"`
+	Xmlns   string              `xml:"xmlns,attr"`
+	Request SubstitutionRequest `xml:"CollSbstitnReq"`
+}
+
+// SubstitutionRequest asks to take back collateral held against a margin call and deliver other collateral instead
+type SubstitutionRequest struct {
+	TransactionID string                 `xml:"TxId"`
+	Obligation    Obligation             `xml:"Oblgtn"`
+	Agreement     Agreement              `xml:"Agrmt"`
+	Reference     string                 `xml:"SbstitnReq>SbstitnRef"`
+	Returned      []SecuritiesCollateral `xml:"SbstitnReq>CollSbstitnRtr>SctiesColl"`
+	Delivered     []SecuritiesCollateral `xml:"SbstitnReq>NewCollAllcn>SctiesColl"`
+}
+
+// SubstitutionResponseDocument is a colr.020 collateral substitution response
+type SubstitutionResponseDocument struct {
+	XMLName  xml.Name             `xml:"Document"`
+	Xmlns    string               `xml:"xmlns,attr"`
+	Response SubstitutionResponse `xml:"CollSbstitnRspn"`
+}
+
+type SubstitutionResponse struct {
+	TransactionID string     `xml:"TxId"`
+	Obligation    Obligation `xml:"Oblgtn"`
+	Agreement     Agreement  `xml:"Agrmt"`
+	Reference     string     `xml:"RspnDtls>SbstitnRef"`
+	ResponseType  string     `xml:"RspnDtls>RspnTp"`
+	Reason        string     `xml:"RspnDtls>RjctnRsn,omitempty"`
+}
+
+// substitutedCollateral lists positions as securities collateral valued in their own currency
+func substitutedCollateral(positions []Security) ([]SecuritiesCollateral, error) {
+	collateral := []SecuritiesCollateral{}
+	for _, security := range positions {
+		err := required("securityId", security.SecurityId, "securityQuantity", security.SecuritiesQuantity, "currency", security.Currency)
+		if err != nil {
+			return nil, err
+		}
+		value, err := amountValue(security.TotalValue)
+		if err != nil {
+			return nil, errors.New(security.SecurityId + ": " + err.Error())
+		}
+		collateral = append(collateral, SecuritiesCollateral{
+			SecurityID:     security.SecurityId,
+			Description:    security.SecuritiesName,
+			CollateralForm: security.CollateralForm,
+			Quantity:       security.SecuritiesQuantity,
+			MarketValue:    Amount{Currency: security.Currency, Value: value},
+		})
+	}
+	return collateral, nil
+}
+
+// SubstitutionRequestFromPositions builds the colr.019 request of the pledger to swap 'returned' positions of the
+// segregated account against 'delivered' positions of its longbox
+func SubstitutionRequestFromPositions(transaction Transaction, segregatedAccount string, reference string, returned []Security, delivered []Security) ([]byte, error) {
+	err := required("transactionId", transaction.TransactionId, "dealId", transaction.DealID, "reference", reference)
+	if err != nil {
+		return nil, err
+	}
+	if len(returned) == 0 || len(delivered) == 0 {
+		return nil, errors.New("a substitution needs collateral returned and collateral delivered")
+	}
+	valuationDate, err := isoDateTime(transaction.MarginCAllDate)
+	if err != nil {
+		return nil, err
+	}
+	returnedCollateral, err := substitutedCollateral(returned)
+	if err != nil {
+		return nil, err
+	}
+	deliveredCollateral, err := substitutedCollateral(delivered)
+	if err != nil {
+		return nil, err
+	}
+	return marshal(SubstitutionRequestDocument{
+		Xmlns: namespace(substitutionRequestMessage),
+		Request: SubstitutionRequest{
+			TransactionID: transaction.TransactionId,
+			Obligation: Obligation{
+				PartyA:            party(transaction.Pledger),
+				PartyB:            party(transaction.Pledgee),
+				CollateralAccount: accountID(segregatedAccount),
+				ValuationDate:     valuationDate,
+			},
+			Agreement: Agreement{AgreementID: transaction.DealID},
+			Reference: reference,
+			Returned:  returnedCollateral,
+			Delivered: deliveredCollateral,
+		},
+	})
+}
+
+// ParseSubstitutionRequest reads a colr.019 collateral substitution request
+func ParseSubstitutionRequest(data []byte) (SubstitutionRequest, error) {
+	document := SubstitutionRequestDocument{}
+	err := xml.Unmarshal(data, &document)
+	if err != nil {
+		return document.Request, err
+	}
+	if document.Xmlns != namespace(substitutionRequestMessage) {
+		return document.Request, errors.New("not a " + substitutionRequestMessage + " document: " + document.Xmlns)
+	}
+	return document.Request, nil
+}
+
+// SubstitutionResponseTo builds the colr.020 answer of the pledgee to a substitution request, 'reason' is only sent on a rejection
+func SubstitutionResponseTo(request SubstitutionRequest, accepted bool, reason string) ([]byte, error) {
+	err := required("TxId", request.TransactionID, "SbstitnRef", request.Reference)
+	if err != nil {
+		return nil, err
+	}
+	response := SubstitutionResponse{
+		TransactionID: request.TransactionID,
+		Obligation: Obligation{
+			PartyA:            request.Obligation.PartyB,
+			PartyB:            request.Obligation.PartyA,
+			CollateralAccount: request.Obligation.CollateralAccount,
+			ValuationDate:     request.Obligation.ValuationDate,
+		},
+		Agreement:    request.Agreement,
+		Reference:    request.Reference,
+		ResponseType: substitutionAccepted,
+	}
+	if !accepted {
+		response.ResponseType = substitutionRejected
+		response.Reason = reason
+	}
+	return marshal(SubstitutionResponseDocument{Xmlns: namespace(substitutionResponseMessage), Response: response})
+}
+
+// ParseSubstitutionResponse reads a colr.020 collateral substitution response
+func ParseSubstitutionResponse(data []byte) (SubstitutionResponse, error) {
+	document := SubstitutionResponseDocument{}
+	err := xml.Unmarshal(data, &document)
+	if err != nil {
+		return document.Response, err
+	}
+	if document.Xmlns != namespace(substitutionResponseMessage) {
+		return document.Response, errors.New("not a " + substitutionResponseMessage + " document: " + document.Xmlns)
+	}
+	return document.Response, nil
+}
+
+// Accepted reports whether the pledgee agreed to the substitution
+func (response SubstitutionResponse) Accepted() bool {
+	return response.ResponseType == substitutionAccepted
+}
@@ -0,0 +1,71 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package iso20022
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+)
+
+// MessageDefinition reads the colr message definition of a document from its namespace, such as "colr.003.001.04"
+func MessageDefinition(data []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", errors.New("no Document element found")
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			if start.Name.Local != "Document" {
+				return "", errors.New("root element is " + start.Name.Local + ", not Document")
+			}
+			prefix := namespace("")
+			if len(start.Name.Space) <= len(prefix) || start.Name.Space[:len(prefix)] != prefix {
+				return "", errors.New("namespace " + start.Name.Space + " is not an ISO 20022 message")
+			}
+			return start.Name.Space[len(prefix):], nil
+		}
+	}
+}
+
+// Import turns a received message into the chaincode invocation that records it: a margin call request
+// becomes a create_transaction and a margin call response disputing part of the call a raise_dispute
+func Import(data []byte) (Invocation, error) {
+	message, err := MessageDefinition(data)
+	if err != nil {
+		return Invocation{}, err
+	}
+	switch message {
+	case marginCallRequestMessage:
+		request, err := ParseMarginCallRequest(data)
+		if err != nil {
+			return Invocation{}, err
+		}
+		return request.CreateTransaction()
+	case marginCallResponseMessage:
+		response, err := ParseMarginCallResponse(data)
+		if err != nil {
+			return Invocation{}, err
+		}
+		return response.RaiseDispute()
+	}
+	return Invocation{}, errors.New(message + " is not imported into the chaincodes")
+}
@@ -0,0 +1,236 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package iso20022 converts between the collateral records of the TCM chaincodes and the ISO 20022
+// collateral management (colr) messages exchanged with agent counterparties:
+//
+//	colr.003 margin call request
+//	colr.004 margin call response
+//	colr.016 collateral and exposure report
+//	colr.019 collateral substitution request
+//	colr.020 collateral substitution response
+//
+// Only the elements the chaincodes can fill or consume are modelled. Imported messages are turned into
+// chaincode invocations, exported records are marshalled as XML documents in the message namespace.
+package iso20022
+
+import (
+	"encoding/xml"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transaction mirrors the margin call transaction kept by the Deal chaincode
+type Transaction struct {
+	TransactionId     string `json:"transactionId"`
+	TransactionDate   string `json:"transactionDate"`
+	DealID            string `json:"dealId"`
+	Pledger           string `json:"pledger"`
+	Pledgee           string `json:"pledgee"`
+	RQV               string `json:"rqv"`
+	Currency          string `json:"currency"`
+	MarginCAllDate    string `json:"marginCAllDate"`
+	AllocationStatus  string `json:"allocationStatus"`
+	TransactionStatus string `json:"transactionStatus"`
+	ComplianceStatus  string `json:"complianceStatus"`
+	Direction         string `json:"direction"`
+}
+
+// Deal mirrors the deal terms kept by the Deal chaincode that the messages carry
+type Deal struct {
+	DealID         string `json:"dealId"`
+	Pledger        string `json:"pledger"`
+	Pledgee        string `json:"pledgee"`
+	BaseCurrency   string `json:"baseCurrency"`
+	ValuationAgent string `json:"valuationAgent"`
+}
+
+// Security mirrors a position of the Account chaincode as it appears in the allocation report
+type Security struct {
+	SecurityId         string `json:"securityId"`
+	SecuritiesName     string `json:"securityName"`
+	SecuritiesQuantity string `json:"securityQuantity"`
+	CollateralForm     string `json:"collateralForm"`
+	TotalValue         string `json:"totalValue"`
+	MTM                string `json:"mtm"`
+	Currency           string `json:"currency"`
+}
+
+// AllocationReport mirrors the report the Allocation chaincode stores when an allocation completes, and sends as data of its start_allocation event
+type AllocationReport struct {
+	DealID                      string     `json:"Deal ID"`
+	TransactionID               string     `json:"Transaction ID"`
+	MarginCallDate              string     `json:"Margin Call Date"`
+	Pledgee                     string     `json:"Pledgee"`
+	Pledger                     string     `json:"Pledger"`
+	PledgerLongboxAccount       string     `json:"Pledger Longbox Account"`
+	PledgeeSegregatedAccount    string     `json:"Pledgee Segregated Account"`
+	RQV                         string     `json:"RQV"`
+	Currency                    string     `json:"Currency"`
+	PledgeeSegregatedSecurities []Security `json:"Pledgee Segregated Securities"`
+	AllocationStatus            string     `json:"Allocation Status"`
+	ComplianceStatus            string     `json:"Compliance Status"`
+}
+
+// Invocation is a chaincode function with its arguments
+type Invocation struct {
+	Chaincode string
+	Function  string
+	Args      []string
+}
+
+// Amount is an active currency amount, <Amt Ccy="USD">100.00</Amt>
+type Amount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// Obligation identifies the parties, collateral account and valuation date a message is about
+type Obligation struct {
+	PartyA            Party      `xml:"PtyA"`
+	PartyB            Party      `xml:"PtyB"`
+	CollateralAccount *AccountID `xml:"CollAcctId,omitempty"`
+	ValuationDate     string     `xml:"ValtnDt>DtTm"`
+}
+
+// Party is a party of the obligation, identified by exactly one of the choices of PartyIdentification
+type Party struct {
+	ID PartyIdentification `xml:"Id"`
+}
+
+// PartyIdentification is a BIC, a proprietary identification or a name and address
+type PartyIdentification struct {
+	AnyBIC         string                 `xml:"AnyBIC,omitempty"`
+	ProprietaryID  *GenericIdentification `xml:"PrtryId,omitempty"`
+	NameAndAddress *NameAndAddress        `xml:"NmAndAdr,omitempty"`
+}
+
+// GenericIdentification is an identification issued under a proprietary scheme
+type GenericIdentification struct {
+	ID     string `xml:"Id"`
+	Issuer string `xml:"Issr"`
+}
+
+// NameAndAddress names a party, the chaincodes keep no address
+type NameAndAddress struct {
+	Name string `xml:"Nm"`
+}
+
+// The parties of the chaincodes that are BICs are sent as such, the others by name
+var bicPattern = regexp.MustCompile(`^[A-Z]{6}[A-Z2-9][A-NP-Z0-9]([A-Z0-9]{3})?$`)
+
+// party identifies a pledger or pledgee of the chaincodes
+func party(name string) Party {
+	if bicPattern.MatchString(name) {
+		return Party{ID: PartyIdentification{AnyBIC: name}}
+	}
+	return Party{ID: PartyIdentification{NameAndAddress: &NameAndAddress{Name: name}}}
+}
+
+// Name is the pledger or pledgee a party identifies in the chaincodes: its BIC, proprietary identification or name
+func (p Party) Name() string {
+	switch {
+	case p.ID.AnyBIC != "":
+		return strings.TrimSpace(p.ID.AnyBIC)
+	case p.ID.ProprietaryID != nil:
+		return strings.TrimSpace(p.ID.ProprietaryID.ID)
+	case p.ID.NameAndAddress != nil:
+		return strings.TrimSpace(p.ID.NameAndAddress.Name)
+	}
+	return ""
+}
+
+// AccountID identifies a collateral account
+type AccountID struct {
+	ID string `xml:"Id"`
+}
+
+// accountID is the identification of an account, none for an empty account number
+func accountID(accountNumber string) *AccountID {
+	if accountNumber == "" {
+		return nil
+	}
+	return &AccountID{ID: accountNumber}
+}
+
+// Agreement identifies the collateral agreement, the deal in the chaincodes
+type Agreement struct {
+	AgreementID  string `xml:"AgrmtDtls>AgrmtId"`
+	BaseCurrency string `xml:"AgrmtDtls>BaseCcy,omitempty"`
+}
+
+// namespace of a colr message definition
+func namespace(message string) string {
+	return "urn:iso:std:iso:20022:tech:xsd:" + message
+}
+
+// marshal writes a message document with the XML declaration
+func marshal(document interface{}) ([]byte, error) {
+	body, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// isoDateTime turns a chaincode date given as unix seconds, unix milliseconds or RFC 3339 into an ISODateTime
+func isoDateTime(date string) (string, error) {
+	date = strings.Trim(date, "\" ")
+	if seconds, err := strconv.ParseInt(date, 10, 64); err == nil {
+		if seconds > 1e11 {
+			return time.Unix(0, seconds*int64(time.Millisecond)).UTC().Format(time.RFC3339), nil
+		}
+		return time.Unix(seconds, 0).UTC().Format(time.RFC3339), nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, date); err == nil {
+		return parsed.UTC().Format(time.RFC3339), nil
+	}
+	return "", errors.New("date " + date + " is neither unix time nor RFC 3339")
+}
+
+// unixDate turns an ISODateTime into the unix seconds the chaincodes store
+func unixDate(dateTime string) (string, error) {
+	parsed, err := time.Parse(time.RFC3339, strings.TrimSpace(dateTime))
+	if err != nil {
+		return "", errors.New("date " + dateTime + " is not an ISODateTime")
+	}
+	return strconv.FormatInt(parsed.Unix(), 10), nil
+}
+
+// amountValue checks an amount is a positive decimal and formats it with two decimals
+func amountValue(value string) (string, error) {
+	amount, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || amount < 0 {
+		return "", errors.New("amount " + value + " is not a positive decimal")
+	}
+	return strconv.FormatFloat(amount, 'f', 2, 64), nil
+}
+
+// required reports the first empty field of name and value pairs
+func required(fields ...string) error {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if strings.TrimSpace(fields[i+1]) == "" {
+			return errors.New(fields[i] + " is required")
+		}
+	}
+	return nil
+}
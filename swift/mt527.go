@@ -0,0 +1,140 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package swift
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AllocationResult mirrors the report the Allocation chaincode sends when an allocation completes
+type AllocationResult struct {
+	DealID           string `json:"Deal ID"`
+	TransactionID    string `json:"Transaction ID"`
+	MarginCallDate   string `json:"Margin Call Date"`
+	Pledgee          string `json:"Pledgee"`
+	Pledger          string `json:"Pledger"`
+	RQV              string `json:"RQV"`
+	Currency         string `json:"Currency"`
+	AllocationStatus string `json:"Allocation Status"`
+}
+
+// TriPartyInstruction is how an MT527 is sent on to the tri-party agent
+type TriPartyInstruction struct {
+	Sender          string // BIC of the sender
+	Receiver        string // BIC of the tri-party agent
+	Reference       string // 20C::SEME, unique per message
+	InstructionType string // 22H::CINT, INIT to open the exposure, PADJ to change it or TERM to close it
+	ExecutionDate   string // 98A::EXRQ, YYYYMMDD; the margin call date when empty
+	Provider        bool   // 22H::REPR, whether the sender gives (PROV) or takes (RECE) the collateral
+}
+
+// MT527 writes the tri-party collateral instruction asking the agent to collateralise the RQV of an allocation
+func MT527(result AllocationResult, instruction TriPartyInstruction) (string, error) {
+	required := [][2]string{{"Sender", instruction.Sender}, {"Receiver", instruction.Receiver}, {"Reference", instruction.Reference}, {"Transaction ID", result.TransactionID}, {"Currency", result.Currency}}
+	for _, field := range required {
+		if strings.TrimSpace(field[1]) == "" {
+			return "", errors.New(field[0] + " is required")
+		}
+	}
+	if len(instruction.Reference) > 16 || len(result.TransactionID) > 16 {
+		return "", errors.New("references are at most 16 characters")
+	}
+	switch instruction.InstructionType {
+	case "INIT", "PADJ", "TERM":
+	default:
+		return "", errors.New("instruction type must be INIT, PADJ or TERM")
+	}
+	rqv, err := strconv.ParseFloat(result.RQV, 64)
+	if err != nil || rqv < 0 {
+		return "", errors.New("RQV " + result.RQV + " is not a positive amount")
+	}
+	tradeDate, err := unixToMTDate(result.MarginCallDate)
+	if err != nil {
+		return "", err
+	}
+	executionDate := instruction.ExecutionDate
+	if executionDate == "" {
+		executionDate = tradeDate
+	}
+	if _, err = mtDate(executionDate); err != nil {
+		return "", err
+	}
+	role := "RECE"
+	if instruction.Provider {
+		role = "PROV"
+	}
+
+	lines := []string{
+		":16R:GENL",
+		":28E:1/ONLY",
+		":20C::SEME//" + instruction.Reference,
+		":20C::CLCI//" + result.TransactionID,
+		":23G:NEWM",
+		":98A::EXRQ//" + executionDate,
+		":22H::CINT//" + instruction.InstructionType,
+		":22H::REPR//" + role,
+		":16R:COLLPRTY",
+		":95Q::PTYA//" + truncate(result.Pledger, 35),
+		":16S:COLLPRTY",
+		":16R:COLLPRTY",
+		":95Q::PTYB//" + truncate(result.Pledgee, 35),
+		":16S:COLLPRTY",
+		":16S:GENL",
+		":16R:DEALTRAN",
+		":20C::CTRC//" + truncate(result.DealID, 16),
+		":98A::TRAD//" + tradeDate,
+		":19A::TRAA//" + strings.ToUpper(result.Currency) + mtDecimal(rqv),
+		":16S:DEALTRAN",
+	}
+	return "{1:F01" + padBIC(instruction.Sender) + "0000000000}{2:I527" + padBIC(instruction.Receiver) + "N}{4:\r\n" +
+		strings.Join(lines, "\r\n") + "\r\n-}", nil
+}
+
+// unixToMTDate turns a chaincode date in unix seconds or milliseconds into YYYYMMDD
+func unixToMTDate(date string) (string, error) {
+	seconds, err := strconv.ParseInt(strings.Trim(date, "\" "), 10, 64)
+	if err != nil {
+		return "", errors.New("margin call date " + date + " is not unix time")
+	}
+	if seconds > 1e11 {
+		seconds = seconds / 1000
+	}
+	return time.Unix(seconds, 0).UTC().Format("20060102"), nil
+}
+
+// padBIC turns a BIC into the 12 characters of a logical terminal address, the terminal code goes after the first 8
+func padBIC(bic string) string {
+	bic = strings.ToUpper(bic)
+	if len(bic) == 11 {
+		return bic[:8] + "X" + bic[8:]
+	}
+	return (bic + "XXXXXXXXXXXX")[:12]
+}
+
+// truncate cuts a value to the length an MT field allows
+func truncate(value string, length int) string {
+	if len(value) > length {
+		return value[:length]
+	}
+	return value
+}
@@ -0,0 +1,150 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package swift
+
+import (
+	"errors"
+	"strconv"
+)
+
+// HoldingsStatement is an MT535 statement of holdings of a safekeeping account
+type HoldingsStatement struct {
+	Reference     string // 20C::SEME
+	StatementDate string // 98A::STAT, YYYYMMDD
+	Account       string // 97A::SAFE
+	Holdings      []Holding
+}
+
+// Holding is a financial instrument of an MT535 statement
+type Holding struct {
+	SecurityId   string  // ISIN of 35B
+	Description  string  // description lines of 35B
+	QuantityType string  // UNIT or FAMT
+	Quantity     float64 // 93B::AGGR
+	Price        float64 // 90A or 90B::MRKT
+	Currency     string  // currency of 19A::HOLD
+	Value        float64 // 19A::HOLD
+}
+
+// ParseMT535 reads an MT535 statement of holdings
+func ParseMT535(message string) (HoldingsStatement, error) {
+	statement := HoldingsStatement{}
+	fields, err := Fields(message)
+	if err != nil {
+		return statement, err
+	}
+	var holding *Holding
+	blocks := []string{} // sequences the field is in, innermost last
+	for _, field := range fields {
+		switch field.Tag {
+		case "16R":
+			blocks = append(blocks, field.Value)
+			if field.Value == "FIN" {
+				holding = &Holding{}
+			}
+		case "16S":
+			if len(blocks) > 0 {
+				blocks = blocks[:len(blocks)-1]
+			}
+			if field.Value == "FIN" && holding != nil {
+				if holding.SecurityId == "" {
+					return statement, errors.New("financial instrument without 35B identification")
+				}
+				statement.Holdings = append(statement.Holdings, *holding)
+				holding = nil
+			}
+		case "20C":
+			if qualifier, data := qualified(field.Value); qualifier == "SEME" {
+				statement.Reference = data
+			}
+		case "98A":
+			if qualifier, data := qualified(field.Value); qualifier == "STAT" {
+				statement.StatementDate, err = mtDate(data)
+			}
+		case "97A":
+			// a sub-safekeeping block names its own 97A::SAFE, the statement is of the account of the GENL block
+			if qualifier, data := qualified(field.Value); qualifier == "SAFE" && len(blocks) > 0 && blocks[len(blocks)-1] == "GENL" {
+				statement.Account = data
+			}
+		case "35B":
+			if holding != nil {
+				holding.SecurityId, holding.Description = security(field.Value)
+			}
+		case "93B":
+			if qualifier, data := qualified(field.Value); qualifier == "AGGR" && holding != nil {
+				holding.QuantityType, holding.Quantity, err = quantity(data)
+			}
+		case "90A", "90B":
+			if qualifier, data := qualified(field.Value); qualifier == "MRKT" && holding != nil {
+				// 90A::MRKT//PRCT/101,5 or 90B::MRKT//ACTU/USD101,5
+				priceType, price, priceErr := quantity(data)
+				if field.Tag == "90B" {
+					_, holding.Price, err = currencyAmount(data[len(priceType)+1:])
+				} else {
+					holding.Price, err = price, priceErr
+				}
+			}
+		case "19A":
+			if qualifier, data := qualified(field.Value); qualifier == "HOLD" && holding != nil {
+				holding.Currency, holding.Value, err = currencyAmount(data)
+			}
+		}
+		if err != nil {
+			return statement, errors.New(":" + field.Tag + ":" + field.Value + ": " + err.Error())
+		}
+	}
+	if statement.Account == "" {
+		return statement, errors.New("statement has no 97A::SAFE account")
+	}
+	return statement, nil
+}
+
+// Positions are the holdings of the statement as positions of an account. Statements do not say which collateral form
+// a security is; 'classification' gives security type and collateral form by ISIN, positions it does not know
+// keep those of the ledger position
+func (statement HoldingsStatement) Positions(accountNumber string, classification map[string][2]string, ledger []Position) []Position {
+	known := make(map[string]Position)
+	for _, position := range ledger {
+		known[position.SecurityId] = position
+	}
+	positions := []Position{}
+	for _, holding := range statement.Holdings {
+		position := known[holding.SecurityId]
+		position.SecurityId = holding.SecurityId
+		position.AccountNumber = accountNumber
+		if holding.Description != "" {
+			position.SecurityName = holding.Description
+		}
+		if class, ok := classification[holding.SecurityId]; ok {
+			position.SecurityType = class[0]
+			position.CollateralForm = class[1]
+		}
+		position.SecurityQuantity = strconv.FormatFloat(holding.Quantity, 'f', 2, 64)
+		position.TotalValue = strconv.FormatFloat(holding.Value, 'f', 2, 64)
+		if holding.Price != 0 {
+			position.MTM = strconv.FormatFloat(holding.Price, 'f', -1, 64)
+		}
+		if holding.Currency != "" {
+			position.Currency = holding.Currency
+		}
+		positions = append(positions, position)
+	}
+	return positions
+}
@@ -0,0 +1,154 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package swift
+
+import (
+	"errors"
+	"strconv"
+)
+
+// TransactionsStatement is an MT536 statement of the settled transactions of a safekeeping account
+type TransactionsStatement struct {
+	Reference string // 20C::SEME
+	FromDate  string // 69A::STAT, YYYYMMDD
+	ToDate    string // 69A::STAT, YYYYMMDD
+	Account   string // 97A::SAFE
+	Movements []Movement
+}
+
+// Movement is a settled transaction of an MT536 statement
+type Movement struct {
+	SecurityId     string  // ISIN of 35B
+	Description    string  // description lines of 35B
+	Reference      string  // 20C::RELA of the linkages
+	QuantityType   string  // UNIT or FAMT
+	Quantity       float64 // 36B::PSTA
+	Receive        bool    // 22H::REDE//RECE, a delivery otherwise
+	SettlementDate string  // 98A::ESET, YYYYMMDD
+}
+
+// ParseMT536 reads an MT536 statement of transactions
+func ParseMT536(message string) (TransactionsStatement, error) {
+	statement := TransactionsStatement{}
+	fields, err := Fields(message)
+	if err != nil {
+		return statement, err
+	}
+	securityId, description := "", ""
+	var movement *Movement
+	blocks := []string{} // sequences the field is in, innermost last
+	for _, field := range fields {
+		switch field.Tag {
+		case "16R":
+			blocks = append(blocks, field.Value)
+			if field.Value == "TRAN" {
+				movement = &Movement{SecurityId: securityId, Description: description}
+			}
+		case "16S":
+			if len(blocks) > 0 {
+				blocks = blocks[:len(blocks)-1]
+			}
+			if field.Value == "TRAN" && movement != nil {
+				if movement.SecurityId == "" || movement.QuantityType == "" {
+					return statement, errors.New("transaction without 35B identification or 36B::PSTA quantity")
+				}
+				statement.Movements = append(statement.Movements, *movement)
+				movement = nil
+			}
+			if field.Value == "FIN" {
+				securityId, description = "", ""
+			}
+		case "20C":
+			qualifier, data := qualified(field.Value)
+			if qualifier == "SEME" && movement == nil {
+				statement.Reference = data
+			} else if qualifier == "RELA" && movement != nil {
+				movement.Reference = data
+			}
+		case "69A":
+			// 69A::STAT//20170701/20170731
+			if qualifier, data := qualified(field.Value); qualifier == "STAT" && len(data) == 17 {
+				statement.FromDate, err = mtDate(data[:8])
+				if err == nil {
+					statement.ToDate, err = mtDate(data[9:])
+				}
+			}
+		case "97A":
+			// a sub-safekeeping block names its own 97A::SAFE, the statement is of the account of the GENL block
+			if qualifier, data := qualified(field.Value); qualifier == "SAFE" && len(blocks) > 0 && blocks[len(blocks)-1] == "GENL" {
+				statement.Account = data
+			}
+		case "35B":
+			securityId, description = security(field.Value)
+		case "36B":
+			if qualifier, data := qualified(field.Value); qualifier == "PSTA" && movement != nil {
+				movement.QuantityType, movement.Quantity, err = quantity(data)
+			}
+		case "22H":
+			if qualifier, data := qualified(field.Value); qualifier == "REDE" && movement != nil {
+				movement.Receive = data == "RECE"
+			}
+		case "98A":
+			if qualifier, data := qualified(field.Value); qualifier == "ESET" && movement != nil {
+				movement.SettlementDate, err = mtDate(data)
+			}
+		}
+		if err != nil {
+			return statement, errors.New(":" + field.Tag + ":" + field.Value + ": " + err.Error())
+		}
+	}
+	if statement.Account == "" {
+		return statement, errors.New("statement has no 97A::SAFE account")
+	}
+	return statement, nil
+}
+
+// Positions are the positions of an account after the settled transactions of the statement are applied to the ledger.
+// Value is kept at the ledger's price per unit; a security the ledger does not hold gets no value until it is priced
+func (statement TransactionsStatement) Positions(accountNumber string, ledger []Position) []Position {
+	positions := []Position{}
+	index := make(map[string]int)
+	for _, position := range ledger {
+		index[position.SecurityId] = len(positions)
+		positions = append(positions, position)
+	}
+	for _, movement := range statement.Movements {
+		i, ok := index[movement.SecurityId]
+		if !ok {
+			i = len(positions)
+			index[movement.SecurityId] = i
+			positions = append(positions, Position{SecurityId: movement.SecurityId, AccountNumber: accountNumber, SecurityName: movement.Description, SecurityQuantity: "0", TotalValue: "0"})
+		}
+		held, _ := strconv.ParseFloat(positions[i].SecurityQuantity, 64)
+		value, _ := strconv.ParseFloat(positions[i].TotalValue, 64)
+		price := 0.0
+		if held != 0 {
+			price = value / held
+		}
+		if movement.Receive {
+			held += movement.Quantity
+		} else {
+			held -= movement.Quantity
+		}
+		positions[i].SecurityQuantity = strconv.FormatFloat(held, 'f', 2, 64)
+		positions[i].TotalValue = strconv.FormatFloat(held*price, 'f', 2, 64)
+	}
+	return positions
+}
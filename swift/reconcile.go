@@ -0,0 +1,178 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package swift
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Reconciliation statuses of a security
+var (
+	reconciliationMatched   = "Matched"
+	reconciliationBreak     = "Quantity break"
+	missingInLedger         = "Missing in ledger"
+	missingInStatement      = "Missing in statement"
+	deliveredMoreThanHeld   = "Delivered more than held"
+	reconciliationTolerance = 0.005
+)
+
+// ReconciliationReport lists where a statement and the ledger positions of an account disagree
+type ReconciliationReport struct {
+	Account            string  `json:"account"`
+	StatementReference string  `json:"statementReference"`
+	StatementDate      string  `json:"statementDate"`
+	Matched            int     `json:"matched"`
+	Breaks             []Break `json:"breaks"`
+}
+
+// Break is a security whose quantity in the statement is not the quantity in the ledger
+type Break struct {
+	SecurityId        string `json:"securityId"`
+	StatementQuantity string `json:"statementQuantity"`
+	LedgerQuantity    string `json:"ledgerQuantity"`
+	Difference        string `json:"difference"` // statement less ledger
+	Status            string `json:"status"`
+}
+
+// quantities sums positions per security
+func quantities(positions []Position) map[string]float64 {
+	sums := make(map[string]float64)
+	for _, position := range positions {
+		held, _ := strconv.ParseFloat(position.SecurityQuantity, 64)
+		sums[position.SecurityId] += held
+	}
+	return sums
+}
+
+// Reconcile compares the holdings of the statement with the ledger positions of the account
+func (statement HoldingsStatement) Reconcile(ledger []Position) ReconciliationReport {
+	report := ReconciliationReport{Account: statement.Account, StatementReference: statement.Reference, StatementDate: statement.StatementDate, Breaks: []Break{}}
+	held := quantities(ledger)
+	stated := make(map[string]float64)
+	for _, holding := range statement.Holdings {
+		stated[holding.SecurityId] += holding.Quantity
+	}
+	securities := []string{}
+	for securityId := range held {
+		securities = append(securities, securityId)
+	}
+	for securityId := range stated {
+		if _, ok := held[securityId]; !ok {
+			securities = append(securities, securityId)
+		}
+	}
+	sort.Strings(securities)
+	for _, securityId := range securities {
+		statementQuantity, inStatement := stated[securityId]
+		ledgerQuantity, inLedger := held[securityId]
+		status := reconciliationBreak
+		if !inLedger {
+			status = missingInLedger
+		} else if !inStatement {
+			status = missingInStatement
+		} else if math.Abs(statementQuantity-ledgerQuantity) < reconciliationTolerance {
+			report.Matched++
+			continue
+		}
+		report.Breaks = append(report.Breaks, newBreak(securityId, statementQuantity, ledgerQuantity, status))
+	}
+	return report
+}
+
+// Reconcile checks the settled transactions of the statement can be applied to the ledger positions of the account:
+// every delivered security has to be held in at least that quantity and every received security known to the ledger
+func (statement TransactionsStatement) Reconcile(ledger []Position) ReconciliationReport {
+	report := ReconciliationReport{Account: statement.Account, StatementReference: statement.Reference, StatementDate: statement.ToDate, Breaks: []Break{}}
+	held := quantities(ledger)
+	net := make(map[string]float64)
+	securities := []string{}
+	for _, movement := range statement.Movements {
+		if _, ok := net[movement.SecurityId]; !ok {
+			securities = append(securities, movement.SecurityId)
+		}
+		if movement.Receive {
+			net[movement.SecurityId] += movement.Quantity
+		} else {
+			net[movement.SecurityId] -= movement.Quantity
+		}
+	}
+	sort.Strings(securities)
+	for _, securityId := range securities {
+		ledgerQuantity, inLedger := held[securityId]
+		switch {
+		case !inLedger:
+			report.Breaks = append(report.Breaks, newBreak(securityId, net[securityId], 0, missingInLedger))
+		case ledgerQuantity+net[securityId] < -reconciliationTolerance:
+			report.Breaks = append(report.Breaks, newBreak(securityId, net[securityId], ledgerQuantity, deliveredMoreThanHeld))
+		default:
+			report.Matched++
+		}
+	}
+	return report
+}
+
+func newBreak(securityId string, statementQuantity float64, ledgerQuantity float64, status string) Break {
+	return Break{
+		SecurityId:        securityId,
+		StatementQuantity: strconv.FormatFloat(statementQuantity, 'f', 2, 64),
+		LedgerQuantity:    strconv.FormatFloat(ledgerQuantity, 'f', 2, 64),
+		Difference:        strconv.FormatFloat(statementQuantity-ledgerQuantity, 'f', 2, 64),
+		Status:            status,
+	}
+}
+
+// LoadInvocations are the Account chaincode invocations that bring the ledger positions to the given positions:
+// add_security for a security the ledger does not hold, update_security for one it does and delete_security
+// for one that is no longer held. Positions the ledger holds but that are not given are left alone
+func LoadInvocations(positions []Position, ledger []Position) []Invocation {
+	held := quantities(ledger)
+	invocations := []Invocation{}
+	for _, position := range positions {
+		quantity, _ := strconv.ParseFloat(position.SecurityQuantity, 64)
+		_, inLedger := held[position.SecurityId]
+		if quantity < reconciliationTolerance {
+			if inLedger {
+				invocations = append(invocations, Invocation{Chaincode: "Account", Function: "delete_security", Args: []string{position.SecurityId, position.AccountNumber}})
+			}
+			continue
+		}
+		function := "add_security"
+		if inLedger {
+			function = "update_security"
+		}
+		invocations = append(invocations, Invocation{Chaincode: "Account", Function: function, Args: []string{
+			position.SecurityId,
+			position.AccountNumber,
+			position.SecurityName,
+			position.SecurityQuantity,
+			position.SecurityType,
+			position.CollateralForm,
+			position.TotalValue,
+			position.ValuePercentage,
+			position.MTM,
+			position.EffectivePercentage,
+			position.EffectiveValueinUSD,
+			position.Currency,
+		}})
+	}
+	return invocations
+}
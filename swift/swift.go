@@ -0,0 +1,187 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package swift reads custody statements in SWIFT MT format and writes tri-party collateral instructions:
+//
+//	MT535 statement of holdings, turned into the positions of an account
+//	MT536 statement of transactions, applied to the positions of an account
+//	MT527 tri-party collateral instruction, written from an allocation result
+//
+// Statements are reconciled against the positions the Account chaincode holds, and the positions
+// they lead to are loaded through add_security and update_security invocations.
+package swift
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Position mirrors a Security of the Account chaincode
+type Position struct {
+	SecurityId          string `json:"securityId"`
+	AccountNumber       string `json:"accountNumber"`
+	SecurityName        string `json:"securityName"`
+	SecurityQuantity    string `json:"securityQuantity"`
+	SecurityType        string `json:"securityType"`
+	CollateralForm      string `json:"collateralForm"`
+	TotalValue          string `json:"totalValue"`
+	ValuePercentage     string `json:"valuePercentage"`
+	MTM                 string `json:"mtm"`
+	EffectivePercentage string `json:"effectivePercentage"`
+	EffectiveValueinUSD string `json:"effectiveValueinUSD"`
+	Currency            string `json:"currency"`
+}
+
+// Invocation is a chaincode function with its arguments
+type Invocation struct {
+	Chaincode string
+	Function  string
+	Args      []string
+}
+
+// Field is a field of the text block of an MT message, such as 16R with value GENL
+type Field struct {
+	Tag   string
+	Value string
+}
+
+// start of a field in the text block, :16R:GENL or :93B::AGGR//UNIT/100,
+var fieldStart = regexp.MustCompile(`^:([0-9]{2}[A-Z]?):(.*)$`)
+
+// Fields reads the fields of the text block {4: ... -} of an MT message; a message without blocks is read as a text block
+func Fields(message string) ([]Field, error) {
+	message = strings.Replace(message, "\r\n", "\n", -1)
+	if start := strings.Index(message, "{4:"); start >= 0 {
+		end := strings.Index(message[start:], "\n-}")
+		if end < 0 {
+			return nil, errors.New("text block is not closed with -}")
+		}
+		message = message[start+3 : start+end]
+	}
+	fields := []Field{}
+	for _, line := range strings.Split(message, "\n") {
+		if strings.TrimSpace(line) == "" || line == "-" {
+			continue
+		}
+		if match := fieldStart.FindStringSubmatch(line); match != nil {
+			fields = append(fields, Field{Tag: match[1], Value: match[2]})
+			continue
+		}
+		if len(fields) == 0 {
+			return nil, errors.New("text block does not start with a field: " + line)
+		}
+		// continuation line of a multi-line field such as 35B
+		fields[len(fields)-1].Value += "\n" + line
+	}
+	return fields, nil
+}
+
+// qualified splits a generic field value :QUAL//data or :QUAL/ISSR/data into qualifier and data
+func qualified(value string) (string, string) {
+	value = strings.TrimPrefix(value, ":")
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	data := parts[1]
+	if strings.HasPrefix(data, "/") {
+		return parts[0], data[1:]
+	}
+	// data source scheme given, QUAL/ISSR/data
+	if i := strings.Index(data, "/"); i >= 0 {
+		return parts[0], data[i+1:]
+	}
+	return parts[0], data
+}
+
+// decimal reads an MT decimal, 1000, or 101,5
+func decimal(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || strings.Count(value, ",") != 1 {
+		return 0, errors.New("amount " + value + " is not an MT decimal")
+	}
+	return strconv.ParseFloat(strings.Replace(value, ",", ".", 1)+"0", 64)
+}
+
+// mtDecimal writes an MT decimal with a comma and no trailing zeros
+func mtDecimal(value float64) string {
+	text := strconv.FormatFloat(value, 'f', 2, 64)
+	text = strings.TrimRight(strings.TrimRight(text, "0"), ".")
+	if strings.Contains(text, ".") {
+		return strings.Replace(text, ".", ",", 1)
+	}
+	return text + ","
+}
+
+// quantity reads the balance of a 93B or 36B field, UNIT/100, or FAMT/1000000,
+func quantity(data string) (string, float64, error) {
+	parts := strings.SplitN(data, "/", 2)
+	if len(parts) != 2 {
+		return "", 0, errors.New("quantity " + data + " has no type")
+	}
+	sign := 1.0
+	amount := parts[1]
+	if strings.HasPrefix(amount, "N") {
+		sign = -1
+		amount = amount[1:]
+	}
+	value, err := decimal(amount)
+	return parts[0], sign * value, err
+}
+
+// currencyAmount reads a currency and amount, USD101500, or NUSD10,
+func currencyAmount(data string) (string, float64, error) {
+	sign := 1.0
+	if strings.HasPrefix(data, "N") {
+		sign = -1
+		data = data[1:]
+	}
+	if len(data) < 4 {
+		return "", 0, errors.New("amount " + data + " has no currency")
+	}
+	value, err := decimal(data[3:])
+	return data[:3], sign * value, err
+}
+
+// security reads a 35B identification, ISIN US0378331005 followed by description lines
+func security(value string) (string, string) {
+	lines := strings.Split(value, "\n")
+	id := strings.TrimSpace(lines[0])
+	if strings.HasPrefix(id, "ISIN ") {
+		id = strings.TrimSpace(id[5:])
+	}
+	description := []string{}
+	for _, line := range lines[1:] {
+		description = append(description, strings.TrimSpace(line))
+	}
+	return id, strings.Join(description, " ")
+}
+
+// mtDate reads a 98A date, YYYYMMDD
+func mtDate(data string) (string, error) {
+	if len(data) != 8 {
+		return "", errors.New("date " + data + " is not YYYYMMDD")
+	}
+	if _, err := strconv.Atoi(data); err != nil {
+		return "", errors.New("date " + data + " is not YYYYMMDD")
+	}
+	return data, nil
+}
@@ -0,0 +1,49 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package validation
+
+import "strings"
+
+// CollateralForms are the collateral forms of the public rule set, SecurityJSON of the Allocation chaincode.
+// It is a static copy so Account and Deal can check collateral forms without depending on Allocation,
+// a form added to the public rule set has to be added here too. The harness checks that both agree
+var CollateralForms = []string{"Common Stocks", "Corporate Bonds", "Sovereign Bonds", "US Treasury Bills", "US Treasury Bonds",
+	"US Treasury Notes", "Gilt", "Federal Agency Bonds", "Global Bonds", "Preferred Shares", "Convertible Bonds", "Revenue Bonds",
+	"Medium Term Note", "Short Term Investments", "Builder Bonds", "Cash"}
+
+// currencies are the active ISO 4217 currency codes
+var currencies = strings.Fields(`
+	AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BHD BIF BMD BND BOB BRL BSD BTN BWP BYN BZD
+	CAD CDF CHF CLP CNY COP CRC CUP CVE CZK DJF DKK DOP DZD EGP ERN ETB EUR FJD FKP GBP GEL GHS GIP GMD
+	GNF GTQ GYD HKD HNL HTG HUF IDR ILS INR IQD IRR ISK JMD JOD JPY KES KGS KHR KMF KPW KRW KWD KYD KZT
+	LAK LBP LKR LRD LSL LYD MAD MDL MGA MKD MMK MNT MOP MRU MUR MVR MWK MXN MYR MZN NAD NGN NIO NOK NPR
+	NZD OMR PAB PEN PGK PHP PKR PLN PYG QAR RON RSD RUB RWF SAR SBD SCR SDG SEK SGD SHP SLE SOS SRD SSP
+	STN SVC SYP SZL THB TJS TMT TND TOP TRY TTD TWD TZS UAH UGX USD UYU UZS VES VND VUV WST XAF XCD XOF
+	XPF YER ZAR ZMW ZWL`)
+
+// IsCurrency tells whether a code is an active ISO 4217 currency code, upper case as the standard writes it
+func IsCurrency(code string) bool {
+	for _, currency := range currencies {
+		if code == currency {
+			return true
+		}
+	}
+	return false
+}
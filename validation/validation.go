@@ -0,0 +1,167 @@
+/*/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package validation checks the fields the TCM chaincodes are invoked with, so bad data is refused
+// with a message for each field instead of surfacing as a zero deep inside an allocation.
+//
+// A Validator collects what is wrong with every field it is given and Err returns it as Errors,
+// which the chaincodes put in the "fields" of their errEvent. Blank values, empty or a single space
+// as positional callers send for fields they leave out, only fail Required.
+package validation
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldError is what is wrong with one field of an invocation
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors are the field errors of an invocation in the order the fields were checked
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldError := range e {
+		messages[i] = fieldError.Field + ": " + fieldError.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validator collects the field errors of an invocation, its zero value is ready to use
+type Validator struct {
+	errors Errors
+}
+
+// Add records what is wrong with a field, for checks the Validator does not have
+func (v *Validator) Add(field, message string) {
+	v.errors = append(v.errors, FieldError{field, message})
+}
+
+// Err is nil when every field checked out and the Errors otherwise
+func (v *Validator) Err() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return v.errors
+}
+
+// Required checks that a field is not blank
+func (v *Validator) Required(field, value string) bool {
+	if isBlank(value) {
+		v.Add(field, "is required")
+		return false
+	}
+	return true
+}
+
+// Number checks that a field is a number
+func (v *Validator) Number(field, value string) bool {
+	if isBlank(value) {
+		return true
+	}
+	if _, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+		v.Add(field, "must be a number, got '"+value+"'")
+		return false
+	}
+	return true
+}
+
+// NonNegative checks that a field is a number that is zero or more, as quantities, amounts and rates are
+func (v *Validator) NonNegative(field, value string) bool {
+	if !v.Number(field, value) || isBlank(value) {
+		return false
+	}
+	if number, _ := strconv.ParseFloat(strings.TrimSpace(value), 64); number < 0 {
+		v.Add(field, "must not be negative, got '"+value+"'")
+		return false
+	}
+	return true
+}
+
+// Currency checks that a field is an ISO 4217 currency code
+func (v *Validator) Currency(field, value string) bool {
+	if isBlank(value) {
+		return true
+	}
+	if !IsCurrency(value) {
+		v.Add(field, "must be an ISO 4217 currency code, got '"+value+"'")
+		return false
+	}
+	return true
+}
+
+// Date checks that a field is a date: Unix seconds or milliseconds, RFC 3339 or YYYY-MM-DD
+func (v *Validator) Date(field, value string) bool {
+	if isBlank(value) {
+		return true
+	}
+	if _, err := ParseDate(value); err != nil {
+		v.Add(field, "must be a date as Unix seconds or milliseconds, RFC 3339 or YYYY-MM-DD, got '"+value+"'")
+		return false
+	}
+	return true
+}
+
+// OneOf checks that a field is one of the allowed values
+func (v *Validator) OneOf(field, value string, allowed []string) bool {
+	if isBlank(value) {
+		return true
+	}
+	for _, candidate := range allowed {
+		if value == candidate {
+			return true
+		}
+	}
+	v.Add(field, "must be one of '"+strings.Join(allowed, "', '")+"', got '"+value+"'")
+	return false
+}
+
+// CollateralForm checks that a field is a collateral form of the rule set
+func (v *Validator) CollateralForm(field, value string) bool {
+	return v.OneOf(field, value, CollateralForms)
+}
+
+// ParseDate reads a date the way margin call dates are given: Unix seconds or milliseconds, RFC 3339 or YYYY-MM-DD
+func ParseDate(value string) (time.Time, error) {
+	return ParseDateIn(value, time.UTC)
+}
+
+// ParseDateIn reads a date as ParseDate does, a YYYY-MM-DD date is the start of that day in location
+func ParseDateIn(value string, location *time.Location) (time.Time, error) {
+	value = strings.Trim(value, "\" ")
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds > 1e11 {
+			return time.Unix(0, seconds*int64(time.Millisecond)), nil
+		}
+		return time.Unix(seconds, 0), nil
+	}
+	if date, err := time.Parse(time.RFC3339, value); err == nil {
+		return date, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, location)
+}
+
+func isBlank(value string) bool {
+	return strings.TrimSpace(value) == ""
+}